@@ -0,0 +1,131 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "log/slog"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "text/template"
+    "time"
+)
+
+// AccessLogEntry describes one served request, for ACCESS_LOG_FORMAT
+// templates such as
+//
+//	{{.RemoteHost}} {{.Method}} {{.Path}} {{.Status}} {{.LatencyMS}}ms
+//
+// Path leaves out the query string, as it can carry tokens.
+type AccessLogEntry struct {
+    Time       time.Time
+    Method     string
+    Path       string
+    Proto      string
+    Status     int
+    Bytes      int64
+    LatencyMS  float64
+    RemoteAddr string
+    RemoteHost string
+    Referer    string
+    UserAgent  string
+    RequestID  string
+    // SampleRate is N when ACCESS_LOG_SAMPLE logs 1 in N such requests.
+    SampleRate int
+}
+
+// accessLogger writes the entry of each request in the ACCESS_LOG_FORMAT:
+//
+//   - json (the default): a "request" entry in the application log, in its
+//     LOG_FORMAT on stderr;
+//   - common or combined: the Apache/NGINX formats, one line on stdout;
+//   - a text/template over AccessLogEntry, one line on stdout.
+//
+// Lines on stdout are also kept for support bundles.
+type accessLogger struct {
+    mu   sync.Mutex
+    out  io.Writer
+    tmpl *template.Template
+    buf  bytes.Buffer
+}
+
+const (
+    commonLogFormat   = `{{.RemoteHost}} - - [{{clfTime .Time}}] "{{.Method}} {{.Path}} {{.Proto}}" {{.Status}} {{clfBytes .Bytes}}`
+    combinedLogFormat = commonLogFormat + ` "{{or .Referer "-"}}" "{{or .UserAgent "-"}}"`
+)
+
+// accessLog is replaced when a reload changes ACCESS_LOG_FORMAT.
+var accessLog atomic.Pointer[accessLogger]
+
+func init() {
+    reloadableSetting(func() error {
+        format := getenv("ACCESS_LOG_FORMAT")
+        l, err := newAccessLogger(format, io.MultiWriter(os.Stdout, recentLogs))
+        if err != nil {
+            return invalidSetting("ACCESS_LOG_FORMAT", format, err)
+        }
+        accessLog.Store(l)
+        return nil
+    }, "ACCESS_LOG_FORMAT")
+}
+
+func newAccessLogger(format string, out io.Writer) (*accessLogger, error) {
+    switch strings.ToLower(format) {
+    case "", "json":
+        return &accessLogger{}, nil
+    case "common":
+        format = commonLogFormat
+    case "combined":
+        format = combinedLogFormat
+    }
+    tmpl, err := template.New("access_log").Funcs(template.FuncMap{
+        "clfTime": func(t time.Time) string { return t.Format("02/Jan/2006:15:04:05 -0700") },
+        "clfBytes": func(n int64) string {
+            if n == 0 {
+                return "-"
+            }
+            return strconv.FormatInt(n, 10)
+        },
+    }).Parse(format)
+    if err != nil {
+        return nil, err
+    }
+    return &accessLogger{out: out, tmpl: tmpl}, nil
+}
+
+func (l *accessLogger) log(ctx context.Context, e *AccessLogEntry) {
+    if l.tmpl == nil {
+        attrs := []slog.Attr{
+            slog.String("method", e.Method),
+            slog.String("path", e.Path),
+            slog.Int("status", e.Status),
+            slog.Float64("latency_ms", e.LatencyMS),
+            slog.String("remote_addr", e.RemoteAddr),
+        }
+        if e.SampleRate > 1 {
+            attrs = append(attrs, slog.Int("sample_rate", e.SampleRate))
+        }
+        slog.LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+        return
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.buf.Reset()
+    if err := l.tmpl.Execute(&l.buf, e); err != nil {
+        slog.ErrorContext(ctx, "Failed to format access log entry", "error", err)
+        return
+    }
+    l.buf.WriteByte('\n')
+    l.out.Write(l.buf.Bytes())
+}
+
+func remoteHost(addr string) string {
+    if host, _, err := net.SplitHostPort(addr); err == nil {
+        return host
+    }
+    return addr
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "log/slog"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+    entry := &AccessLogEntry{
+        Time:       time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC),
+        Method:     "GET",
+        Path:       "/users/1",
+        Proto:      "HTTP/1.1",
+        Status:     200,
+        Bytes:      512,
+        LatencyMS:  1.5,
+        RemoteAddr: "10.0.0.1:5555",
+        RemoteHost: "10.0.0.1",
+        UserAgent:  "curl/8.0",
+        RequestID:  "abc",
+    }
+    for _, c := range []struct {
+        format, want string
+    }{
+        {"common", `10.0.0.1 - - [04/Mar/2025:05:06:07 +0000] "GET /users/1 HTTP/1.1" 200 512` + "\n"},
+        {"combined", `10.0.0.1 - - [04/Mar/2025:05:06:07 +0000] "GET /users/1 HTTP/1.1" 200 512 "-" "curl/8.0"` + "\n"},
+        {"{{.RequestID}} {{.Status}} {{.LatencyMS}}ms", "abc 200 1.5ms\n"},
+    } {
+        var out bytes.Buffer
+        l, err := newAccessLogger(c.format, &out)
+        if err != nil {
+            t.Fatalf("%s: %v", c.format, err)
+        }
+        l.log(context.Background(), entry)
+        if out.String() != c.want {
+            t.Errorf("%s: got %q, want %q", c.format, out.String(), c.want)
+        }
+    }
+
+    if _, err := newAccessLogger("{{.Nope", &bytes.Buffer{}); err == nil {
+        t.Error("an invalid template was accepted")
+    }
+}
+
+func TestSlowRequest(t *testing.T) {
+    var logged bytes.Buffer
+    defer func(old *slog.Logger) { slog.SetDefault(old) }(slog.Default())
+    slog.SetDefault(slog.New(slog.NewJSONHandler(&logged, nil)))
+    defer slowRequestThreshold.set(slowRequestThreshold.get())
+    slowRequestThreshold.set(100 * time.Millisecond)
+
+    counter := slowRequestsTotal.WithLabelValues("GET", "/slow/{id}")
+    before := testutil.ToFloat64(counter)
+    entry := &AccessLogEntry{Method: "GET", Path: "/slow/1", Status: 200}
+    logSlowRequest(context.Background(), entry, "/slow/{id}", 0, 50*time.Millisecond)
+    if logged.Len() != 0 || testutil.ToFloat64(counter) != before {
+        t.Fatalf("fast request reported as slow: %s", logged.String())
+    }
+    logSlowRequest(context.Background(), entry, "/slow/{id}", 0, 150*time.Millisecond)
+    if !strings.Contains(logged.String(), `"msg":"Slow request"`) || testutil.ToFloat64(counter) != before+1 {
+        t.Fatalf("slow request not reported: %s", logged.String())
+    }
+}
+
+func TestLogSampler(t *testing.T) {
+    s, err := newLogSampler("/health=3, /users/{id:[0-9]{1,9}}=2, /bad=x, /zero=0")
+    if len(s.routes) != 2 || err == nil || !strings.Contains(err.Error(), "/bad=x") || !strings.Contains(err.Error(), "/zero=0") {
+        t.Fatalf("parsed %d routes, want the 2 valid ones and the others as errors: %v", len(s.routes), err)
+    }
+    var kept []bool
+    for i := 0; i < 6; i++ {
+        keep, rate := s.sample("/health", 200)
+        if rate != 3 {
+            t.Fatalf("rate %d, want 3", rate)
+        }
+        kept = append(kept, keep)
+    }
+    if fmt.Sprint(kept) != "[true false false true false false]" {
+        t.Errorf("kept %v", kept)
+    }
+    for _, status := range []int{404, 500, 503} {
+        if keep, rate := s.sample("/health", status); !keep || rate != 1 {
+            t.Errorf("status %d sampled out", status)
+        }
+    }
+    if keep, _ := s.sample("/users/{id:[0-9]{1,9}}", 200); !keep {
+        t.Error("first request to a sampled route dropped")
+    }
+    if keep, rate := s.sample("/users", 200); !keep || rate != 1 {
+        t.Error("unlisted route sampled")
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "os"
+    "strings"
+
+    "golang.org/x/crypto/acme"
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// With ACME_DOMAINS set to the comma-separated host names of the server,
+// certificates for them are obtained from Let's Encrypt (or the ACME CA at
+// ACME_DIRECTORY_URL, e.g. its staging one) on first use and renewed before
+// they expire, for a single container at the edge. They are kept in
+// ACME_CACHE_DIR (default /var/lib/user-api/acme), which should be a volume
+// so restarts do not run into the CA's rate limits; ACME_EMAIL is given to
+// the CA for expiry notices.
+//
+// The CA checks the server over TLS-ALPN on port 443, so LISTEN_ADDR has to
+// include it. ACME_HTTP_ADDR (e.g. :80) also answers HTTP challenges there
+// and redirects everything else to HTTPS.
+
+func setupAutocert(srv *http.Server) error {
+    if getenv("TLS_CERT_FILE") != "" || getenv("TLS_KEY_FILE") != "" {
+        return errors.New("set either ACME_DOMAINS or TLS_CERT_FILE and TLS_KEY_FILE")
+    }
+    var domains []string
+    for _, d := range strings.Split(getenv("ACME_DOMAINS"), ",") {
+        if d = strings.TrimSpace(d); d != "" {
+            domains = append(domains, d)
+        }
+    }
+    dir := config.ACMECacheDir
+    if dir == "" {
+        dir = "/var/lib/user-api/acme"
+    }
+    m := &autocert.Manager{
+        Prompt:     autocert.AcceptTOS,
+        Cache:      autocert.DirCache(dir),
+        HostPolicy: autocert.HostWhitelist(domains...),
+        Email:      config.ACMEEmail,
+    }
+    if config.ACMEDirectory != "" {
+        m.Client = &acme.Client{DirectoryURL: config.ACMEDirectory, HTTPClient: newHTTPClient(0)}
+    }
+
+    if srv.TLSConfig == nil {
+        srv.TLSConfig = &tls.Config{}
+    }
+    srv.TLSConfig.MinVersion = tls.VersionTLS12
+    srv.TLSConfig.NextProtos = append(srv.TLSConfig.NextProtos, acme.ALPNProto)
+    srv.TLSConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+        cert, err := m.GetCertificate(hello)
+        if err == nil && cert.Leaf != nil {
+            tlsCertificateExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+        }
+        return cert, err
+    }
+
+    if config.ACMEHTTPAddr != "" {
+        ln, err := listenBeside(bindAddr(config.ACMEHTTPAddr), os.Getenv(listenerFDEnv) != "")
+        if err != nil {
+            return fmt.Errorf("ACME_HTTP_ADDR: %w", err)
+        }
+        go func() {
+            if err := newHTTPServer(m.HTTPHandler(nil)).Serve(ln); err != nil {
+                fatal("ACME HTTP server failed", "error", err)
+            }
+        }()
+    }
+    slog.Info("Obtaining certificates over ACME", "domains", domains, "cache", dir, "http_addr", config.ACMEHTTPAddr)
+    return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+const (
+    activityCreated = "created"
+    activityUpdated = "updated"
+    activityDeleted = "deleted"
+)
+
+// FieldChange records the old and new value of one user field.
+type FieldChange struct {
+    Old interface{} `json:"old"`
+    New interface{} `json:"new"`
+}
+
+// ActivityEvent is one change in the life of a user record. Creates list
+// every field as changed from its zero value.
+type ActivityEvent struct {
+    ID        int64                  `json:"id"`
+    UserID    int                    `json:"user_id"`
+    Type      string                 `json:"type"`
+    Actor     string                 `json:"actor"`
+    Timestamp time.Time              `json:"timestamp"`
+    Changes   map[string]FieldChange `json:"changes,omitempty"`
+    RequestID string                 `json:"request_id,omitempty"`
+}
+
+// activityStore keeps per-user event history. Events outlive the user so
+// a deleted record can still be traced. When AUDIT_LOG_FILE is set, each
+// event is also appended to that JSON-lines file, which is replayed on
+// startup, so the trail survives restarts and can be shipped elsewhere.
+// Nothing in the API edits or removes events.
+type activityStore struct {
+    mu     sync.RWMutex
+    nextID int64
+    events map[int][]ActivityEvent
+    // all holds every event in ID order, for queries across users.
+    all  []ActivityEvent
+    file *os.File
+}
+
+var activities = &activityStore{nextID: 1, events: make(map[int][]ActivityEvent)}
+
+// open loads the events recorded in path and keeps it open for appends.
+func (s *activityStore) open(path string) error {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        var event ActivityEvent
+        if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+            continue
+        }
+        s.append(event)
+        if event.ID >= s.nextID {
+            s.nextID = event.ID + 1
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        f.Close()
+        return err
+    }
+    s.file = f
+    return nil
+}
+
+func (s *activityStore) append(event ActivityEvent) {
+    s.events[event.UserID] = append(s.events[event.UserID], event)
+    s.all = append(s.all, event)
+}
+
+func (s *activityStore) add(event ActivityEvent) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    event.ID = s.nextID
+    s.nextID++
+    s.append(event)
+    if s.file != nil {
+        line, _ := json.Marshal(event)
+        if _, err := s.file.Write(append(line, '\n')); err != nil {
+            slog.Error("Failed to persist audit event", "event_id", event.ID, "user_id", event.UserID, "error", err)
+        }
+    }
+}
+
+// auditQuery selects events across users; zero fields match everything.
+type auditQuery struct {
+    Actor  string
+    Type   string
+    UserID int
+    Since  time.Time
+    Until  time.Time
+    Limit  int
+}
+
+// query returns the matching events, newest first.
+func (s *activityStore) query(q auditQuery) []ActivityEvent {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    result := []ActivityEvent{}
+    for i := len(s.all) - 1; i >= 0 && len(result) < q.Limit; i-- {
+        e := s.all[i]
+        switch {
+        case q.Actor != "" && e.Actor != q.Actor,
+            q.Type != "" && e.Type != q.Type,
+            q.UserID != 0 && e.UserID != q.UserID,
+            !q.Since.IsZero() && e.Timestamp.Before(q.Since),
+            !q.Until.IsZero() && !e.Timestamp.Before(q.Until):
+            continue
+        }
+        result = append(result, e)
+    }
+    return result
+}
+
+// page returns events for userID newest first, plus the total count.
+func (s *activityStore) page(userID, page, perPage int) ([]ActivityEvent, int) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    events := s.events[userID]
+    total := len(events)
+    result := []ActivityEvent{}
+    // Checked before multiplying so a huge page cannot overflow the index.
+    if page-1 > total/perPage {
+        return result, total
+    }
+    for i := total - 1 - (page-1)*perPage; i >= 0 && len(result) < perPage; i-- {
+        result = append(result, events[i])
+    }
+    return result, total
+}
+
+// recordActivity stores an event attributed to the request's principal.
+func recordActivity(r *http.Request, userID int, eventType string, changes map[string]FieldChange) {
+    actor := "anonymous"
+    if p, ok := principalFromContext(r.Context()); ok {
+        actor = p.Subject
+    }
+    activities.add(ActivityEvent{
+        UserID:    userID,
+        Type:      eventType,
+        Actor:     actor,
+        Timestamp: time.Now().UTC(),
+        Changes:   changes,
+        RequestID: requestIDFromContext(r.Context()),
+    })
+}
+
+// diffUsers lists the fields that differ between before and after. Password
+// values are never recorded, only the fact that the hash changed.
+func diffUsers(before, after User) map[string]FieldChange {
+    changes := make(map[string]FieldChange)
+    if before.Name != after.Name {
+        changes["name"] = FieldChange{Old: before.Name, New: after.Name}
+    }
+    if before.Email != after.Email {
+        changes["email"] = FieldChange{Old: before.Email, New: after.Email}
+    }
+    if !equalStrings(before.Roles, after.Roles) {
+        changes["roles"] = FieldChange{Old: before.Roles, New: after.Roles}
+    }
+    if before.Verified != after.Verified {
+        changes["verified"] = FieldChange{Old: before.Verified, New: after.Verified}
+    }
+    if before.PasswordHash != after.PasswordHash {
+        changes["password"] = FieldChange{Old: "[REDACTED]", New: "[REDACTED]"}
+    }
+    return changes
+}
+
+func equalStrings(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// userActivityHandler serves GET /users/{id}/activity?page=&per_page=.
+func userActivityHandler(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+    page, perPage, err := pagination(r, 20, 100)
+    if err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, err.Error()))
+        return
+    }
+
+    events, total := activities.page(id, page, perPage)
+    if total == 0 {
+        if _, err := userRepo.Get(r.Context(), id); err != nil {
+            writeError(w, r, err)
+            return
+        }
+    }
+
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data: map[string]interface{}{
+            "events":   events,
+            "page":     page,
+            "per_page": perPage,
+            "total":    total,
+        },
+    })
+}
+
+// listAuditHandler serves GET /admin/audit, the user changes of every user,
+// filterable by actor, type, user_id and a since/until range (RFC 3339),
+// newest first.
+func listAuditHandler(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    query := auditQuery{Actor: q.Get("actor"), Type: q.Get("type"), Limit: 100}
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 {
+            writeError(w, r, httpError(http.StatusBadRequest, "Invalid limit"))
+            return
+        }
+        query.Limit = n
+    }
+    if v := q.Get("user_id"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 {
+            writeError(w, r, httpError(http.StatusBadRequest, "Invalid user_id"))
+            return
+        }
+        query.UserID = n
+    }
+    for _, bound := range []struct {
+        name string
+        t    *time.Time
+    }{{"since", &query.Since}, {"until", &query.Until}} {
+        if v := q.Get(bound.name); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                writeError(w, r, httpError(http.StatusBadRequest, "Invalid "+bound.name+", expected RFC 3339"))
+                return
+            }
+            *bound.t = t
+        }
+    }
+
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "success", Data: activities.query(query)})
+}
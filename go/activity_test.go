@@ -0,0 +1,65 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestAuditLog(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "audit.jsonl")
+    store := &activityStore{nextID: 1, events: make(map[int][]ActivityEvent)}
+    if err := store.open(path); err != nil {
+        t.Fatal(err)
+    }
+    start := time.Now().UTC()
+    store.add(ActivityEvent{UserID: 1, Type: activityCreated, Actor: "alice", Timestamp: start, Changes: diffUsers(User{}, User{Name: "Bob"})})
+    store.add(ActivityEvent{UserID: 1, Type: activityUpdated, Actor: "carol", Timestamp: start.Add(time.Second)})
+    store.add(ActivityEvent{UserID: 2, Type: activityDeleted, Actor: "alice", Timestamp: start.Add(2 * time.Second)})
+    store.file.Close()
+
+    reopened := &activityStore{nextID: 1, events: make(map[int][]ActivityEvent)}
+    if err := reopened.open(path); err != nil {
+        t.Fatal(err)
+    }
+    defer reopened.file.Close()
+    if got := reopened.query(auditQuery{Limit: 10}); len(got) != 3 || got[0].ID != 3 || got[2].Changes["name"].New != "Bob" {
+        t.Fatalf("replayed %+v", got)
+    }
+    reopened.add(ActivityEvent{UserID: 2, Type: activityCreated, Actor: "dave", Timestamp: start.Add(3 * time.Second)})
+    if events, _ := reopened.page(2, 1, 10); len(events) != 2 || events[0].ID != 4 {
+        t.Fatalf("ID not continued after replay: %+v", events)
+    }
+
+    for _, c := range []struct {
+        q    auditQuery
+        want []int64
+    }{
+        {auditQuery{Actor: "alice", Limit: 10}, []int64{3, 1}},
+        {auditQuery{Type: activityUpdated, Limit: 10}, []int64{2}},
+        {auditQuery{UserID: 2, Limit: 10}, []int64{4, 3}},
+        {auditQuery{Since: start.Add(time.Second), Until: start.Add(3 * time.Second), Limit: 10}, []int64{3, 2}},
+        {auditQuery{Limit: 1}, []int64{4}},
+    } {
+        var ids []int64
+        for _, e := range reopened.query(c.q) {
+            ids = append(ids, e.ID)
+        }
+        if fmt.Sprint(ids) != fmt.Sprint(c.want) {
+            t.Errorf("%+v: got %v, want %v", c.q, ids, c.want)
+        }
+    }
+}
+
+func TestAuditHandlerParams(t *testing.T) {
+    for _, query := range []string{"limit=0", "user_id=x", "since=yesterday", "until=2024-13-01"} {
+        w := httptest.NewRecorder()
+        listAuditHandler(w, httptest.NewRequest(http.MethodGet, "/admin/audit?"+query, nil))
+        if w.Code != http.StatusBadRequest {
+            t.Errorf("%s: status %d, want 400", query, w.Code)
+        }
+    }
+}
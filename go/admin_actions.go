@@ -0,0 +1,208 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// maxAdminActions bounds how many actions are kept in memory.
+const maxAdminActions = 1000
+
+// AdminAction is one recorded mutation made through the /admin API.
+type AdminAction struct {
+    ID         int64                  `json:"id"`
+    Timestamp  time.Time              `json:"timestamp"`
+    Actor      string                 `json:"actor"`
+    Action     string                 `json:"action"`
+    Method     string                 `json:"method"`
+    Path       string                 `json:"path"`
+    Params     map[string]interface{} `json:"params,omitempty"`
+    Status     int                    `json:"status"`
+    RemoteAddr string                 `json:"remote_addr"`
+}
+
+// adminActionLog keeps recent admin actions in memory and, when
+// ADMIN_AUDIT_FILE is set, appends each one to a JSON-lines file that is
+// replayed on startup so the trail survives restarts.
+type adminActionLog struct {
+    mu      sync.Mutex
+    nextID  int64
+    entries []AdminAction
+    file    *os.File
+}
+
+var adminActions = &adminActionLog{nextID: 1}
+
+// open loads any existing actions from path and keeps it open for appends.
+func (l *adminActionLog) open(path string) error {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+    if err != nil {
+        return err
+    }
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        var action AdminAction
+        if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+            continue
+        }
+        l.append(action)
+        if action.ID >= l.nextID {
+            l.nextID = action.ID + 1
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        f.Close()
+        return err
+    }
+    l.file = f
+    return nil
+}
+
+func (l *adminActionLog) append(action AdminAction) {
+    l.entries = append(l.entries, action)
+    if len(l.entries) > maxAdminActions {
+        l.entries = l.entries[len(l.entries)-maxAdminActions:]
+    }
+}
+
+func (l *adminActionLog) record(action AdminAction) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    action.ID = l.nextID
+    l.nextID++
+    l.append(action)
+    if l.file != nil {
+        line, _ := json.Marshal(action)
+        if _, err := l.file.Write(append(line, '\n')); err != nil {
+            slog.Error("Failed to persist admin action", "action_id", action.ID, "error", err)
+        }
+    }
+}
+
+// query returns matching actions, newest first.
+func (l *adminActionLog) query(actor, action string, since time.Time, limit int) []AdminAction {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    result := []AdminAction{}
+    for i := len(l.entries) - 1; i >= 0 && len(result) < limit; i-- {
+        a := l.entries[i]
+        if actor != "" && a.Actor != actor {
+            continue
+        }
+        if action != "" && a.Action != action {
+            continue
+        }
+        if !since.IsZero() && a.Timestamp.Before(since) {
+            continue
+        }
+        result = append(result, a)
+    }
+    return result
+}
+
+// adminAuditMiddleware records every mutating /admin request together with
+// its JSON body and query parameters once the handler has run.
+func adminAuditMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !isMutating(r.Method) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        // Only the first 64 KiB are recorded; the handler still gets the
+        // whole body.
+        body, _ := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+        r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+        rec := getStatusRecorder(w)
+        defer putStatusRecorder(rec)
+        next.ServeHTTP(rec, r)
+
+        params := make(map[string]interface{})
+        if len(body) > 0 {
+            var fields map[string]interface{}
+            if err := json.Unmarshal(body, &fields); err == nil {
+                for k, v := range fields {
+                    if isSensitiveKey(k) {
+                        v = "[REDACTED]"
+                    }
+                    params[k] = v
+                }
+            }
+        }
+        for k, v := range r.URL.Query() {
+            params[k] = strings.Join(v, ",")
+        }
+
+        path := routeTemplate(r)
+        actor := "anonymous"
+        if p, ok := principalFromContext(r.Context()); ok {
+            actor = p.Subject
+        }
+        adminActions.record(AdminAction{
+            Timestamp:  time.Now().UTC(),
+            Actor:      actor,
+            Action:     strings.ReplaceAll(strings.TrimPrefix(path, "/admin/"), "/", "."),
+            Method:     r.Method,
+            Path:       r.URL.Path,
+            Params:     params,
+            Status:     rec.status,
+            RemoteAddr: r.RemoteAddr,
+        })
+    })
+}
+
+// readCloser reads from Reader and closes the original request body.
+type readCloser struct {
+    io.Reader
+    io.Closer
+}
+
+// isSensitiveKey reports whether a parameter should be kept out of the trail.
+func isSensitiveKey(key string) bool {
+    key = strings.ToLower(key)
+    for _, s := range []string{"password", "secret", "token", "key"} {
+        if strings.Contains(key, s) && key != "key" {
+            return true
+        }
+    }
+    return false
+}
+
+// listAdminActionsHandler serves GET /admin/actions, filterable by actor,
+// action and since (RFC 3339), newest first.
+func listAdminActionsHandler(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    limit := 100
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 {
+            writeError(w, r, httpError(http.StatusBadRequest, "Invalid limit"))
+            return
+        }
+        limit = n
+    }
+    var since time.Time
+    if v := q.Get("since"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            writeError(w, r, httpError(http.StatusBadRequest, "Invalid since, expected RFC 3339"))
+            return
+        }
+        since = t
+    }
+
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   adminActions.query(q.Get("actor"), q.Get("action"), since, limit),
+    })
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "net/http"
+
+    "github.com/gorilla/mux"
+)
+
+// With ADMIN_PORT set, the /admin routes move to an internal server on that
+// port, together with the health, probe, metrics and debugging routes unless
+// METRICS_PORT keeps those on a port of their own, so the public port serves
+// the business routes and /livez alone. A host:port binds it to one interface, e.g.
+// 127.0.0.1:9091. The admin routes still require an admin token there, as
+// their mutations are audited by actor. The server keeps serving while the
+// API drains, so probes and log-level changes work until the process exits.
+
+// registerAdminRoutes adds the /admin routes to admin, a subrouter for the
+// /admin prefix.
+func registerAdminRoutes(admin *mux.Router) {
+    admin.Use(adminMiddleware)
+    admin.Use(adminAuditMiddleware)
+    admin.HandleFunc("/cache/purge", purgeCacheHandler).Methods("POST")
+    admin.HandleFunc("/actions", listAdminActionsHandler).Methods("GET")
+    admin.HandleFunc("/audit", listAuditHandler).Methods("GET")
+    admin.HandleFunc("/support-bundle", supportBundleHandler).Methods("POST")
+    admin.HandleFunc("/log-level", logLevelHandler).Methods("GET", "PUT")
+    admin.HandleFunc("/features", listFeaturesHandler).Methods("GET")
+    admin.HandleFunc("/config", configHandler).Methods("GET")
+    admin.HandleFunc("/ip-filter", ipFilterHandler).Methods("GET", "PUT")
+    admin.HandleFunc("/api-keys", listAPIKeysHandler).Methods("GET")
+    admin.HandleFunc("/api-keys", createAPIKeyHandler).Methods("POST")
+    admin.HandleFunc("/api-keys/{id}", revokeAPIKeyHandler).Methods("DELETE")
+}
+
+// newAdminHandler returns the handler of the ADMIN_PORT listener. The admin
+// routes are logged and authenticated like on the public port; everything
+// else goes to the metrics router, with its own basic auth, or is not found
+// when METRICS_PORT serves those.
+func newAdminHandler() http.Handler {
+    r := mux.NewRouter()
+    r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+    r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+    r.Use(loggingMiddleware)
+    r.Use(recoveryMiddleware)
+    r.Use(authMiddleware)
+    r.Use(rateLimitMiddleware)
+    r.Use(csrfMiddleware)
+    registerAdminRoutes(r.PathPrefix("/admin").Subrouter())
+
+    mux := http.NewServeMux()
+    mux.Handle("/admin/", clientIPMiddleware(requestIDMiddleware(securityHeadersMiddleware(r))))
+    if config.MetricsPort == "" {
+        mux.Handle("/", newMetricsRouter())
+    } else {
+        mux.Handle("/", http.HandlerFunc(notFoundHandler))
+    }
+    return mux
+}
+
+// configHandler serves GET /admin/config: the effective configuration, as
+// the support bundle has it, with secrets redacted.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "success", Data: bundleConfig()})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// API keys let services call the API without logging in: a key issued with
+// POST /admin/api-keys is sent as the X-API-Key header and acts with the
+// roles it was issued with. The backend stores only the SHA-256 hash of each
+// key, so the key itself is shown once, in the response that issues it.
+// Revoked keys stay listed, with revoked_at set, for the audit trail.
+//
+// Each key is rate limited to rate_limit requests a minute, given when it
+// is issued or else API_KEY_RATE_LIMIT (default 600); 0 is unlimited. See
+// ratelimit.go.
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every key, so leaked keys are easy to recognise, e.g.
+// by secret scanners. The ID follows it, then the secret:
+// uak_<id>_<secret>.
+const apiKeyPrefix = "uak_"
+
+const maxAPIKeyNameLength = 100
+
+var errAPIKeysUnsupported = httpError(http.StatusNotImplemented, "This storage backend cannot store API keys")
+
+// APIKey is an issued key. Key is only set in the response that issues it.
+type APIKey struct {
+    ID        string     `json:"id"`
+    Name      string     `json:"name"`
+    Roles     []string   `json:"roles"`
+    RateLimit int        `json:"rate_limit"`
+    CreatedAt time.Time  `json:"created_at"`
+    RevokedAt *time.Time `json:"revoked_at,omitempty"`
+    Key       string     `json:"key,omitempty"`
+
+    // hash is the SHA-256 of the key, as hashToken returns it.
+    hash string
+}
+
+// apiKeyStore is implemented by the backends that can store API keys. The
+// memory, SQL, Bolt and MongoDB backends do; Redis does not.
+type apiKeyStore interface {
+    insertAPIKey(ctx context.Context, key APIKey) error
+    // getAPIKey returns ErrNotFound for an unknown ID.
+    getAPIKey(ctx context.Context, id string) (APIKey, error)
+    // listAPIKeys returns the keys oldest first.
+    listAPIKeys(ctx context.Context) ([]APIKey, error)
+    // revokeAPIKey sets revoked_at unless it is set already, and returns
+    // ErrNotFound for an unknown ID.
+    revokeAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// storedAPIKey is a key as the Bolt backend keeps it, as JSON, hash
+// included.
+type storedAPIKey struct {
+    APIKey
+    KeyHash string `json:"key_hash"`
+}
+
+// apiKeys returns the store of the storage backend, if it has one.
+func apiKeys() (apiKeyStore, bool) {
+    store, ok := storageBackend().(apiKeyStore)
+    return store, ok
+}
+
+// authenticateAPIKey resolves the caller from an X-API-Key header value.
+func authenticateAPIKey(ctx context.Context, key string) (Principal, bool) {
+    store, ok := apiKeys()
+    if !ok {
+        return Principal{}, false
+    }
+    id, _, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+    if !ok || !strings.HasPrefix(key, apiKeyPrefix) {
+        return Principal{}, false
+    }
+    stored, err := store.getAPIKey(ctx, id)
+    if err != nil || stored.RevokedAt != nil {
+        return Principal{}, false
+    }
+    if subtle.ConstantTimeCompare([]byte(hashToken(key)), []byte(stored.hash)) != 1 {
+        return Principal{}, false
+    }
+    return Principal{Subject: "api-key:" + stored.ID, Roles: stored.Roles, apiKey: &stored}, true
+}
+
+// apiKeyInput is the body of POST /admin/api-keys. Roles default to user,
+// and RateLimit to API_KEY_RATE_LIMIT.
+type apiKeyInput struct {
+    Name      string   `json:"name"`
+    Roles     []string `json:"roles"`
+    RateLimit *int     `json:"rate_limit"`
+}
+
+func validateAPIKeyInput(input apiKeyInput) error {
+    verr := &ValidationError{}
+    name := strings.TrimSpace(input.Name)
+    if name == "" {
+        verr.add("name", "name is required")
+    } else if len(name) > maxAPIKeyNameLength {
+        verr.add("name", "name must be at most 100 characters")
+    }
+    for _, role := range input.Roles {
+        if !knownRoles[role] {
+            verr.add("roles", "unknown role "+strconv.Quote(role))
+        }
+    }
+    if input.RateLimit != nil && *input.RateLimit < 0 {
+        verr.add("rate_limit", "rate_limit must not be negative")
+    }
+    if len(verr.Fields) > 0 {
+        return verr
+    }
+    return nil
+}
+
+// issueAPIKey creates a key and stores its hash, returning it with the key
+// set.
+func issueAPIKey(ctx context.Context, store apiKeyStore, input apiKeyInput) (APIKey, error) {
+    id, err := randomToken(6)
+    if err != nil {
+        return APIKey{}, err
+    }
+    secret, err := randomToken(24)
+    if err != nil {
+        return APIKey{}, err
+    }
+    key := APIKey{
+        ID:        id,
+        Name:      strings.TrimSpace(input.Name),
+        Roles:     input.Roles,
+        RateLimit: config.APIKeyRateLimit,
+        CreatedAt: time.Now().UTC(),
+        Key:       apiKeyPrefix + id + "_" + secret,
+    }
+    if len(key.Roles) == 0 {
+        key.Roles = []string{roleUser}
+    }
+    if input.RateLimit != nil {
+        key.RateLimit = *input.RateLimit
+    }
+    key.hash = hashToken(key.Key)
+    stored := key
+    stored.Key = ""
+    if err := store.insertAPIKey(ctx, stored); err != nil {
+        return APIKey{}, err
+    }
+    return key, nil
+}
+
+// createAPIKeyHandler serves POST /admin/api-keys.
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+    store, ok := apiKeys()
+    if !ok {
+        writeError(w, r, errAPIKeysUnsupported)
+        return
+    }
+    var input apiKeyInput
+    if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    if err := validateAPIKeyInput(input); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    key, err := issueAPIKey(r.Context(), store, input)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    w.Header().Set("Location", "/admin/api-keys/"+key.ID)
+    writeJSON(w, r, http.StatusCreated, APIResponse{
+        Status:  "success",
+        Message: "Store the key now; it cannot be shown again",
+        Data:    key,
+    })
+}
+
+// listAPIKeysHandler serves GET /admin/api-keys.
+func listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+    store, ok := apiKeys()
+    if !ok {
+        writeError(w, r, errAPIKeysUnsupported)
+        return
+    }
+    keys, err := store.listAPIKeys(r.Context())
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    if keys == nil {
+        keys = []APIKey{}
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "success", Data: keys})
+}
+
+// revokeAPIKeyHandler serves DELETE /admin/api-keys/{id}. Revoking a key
+// twice is not an error.
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+    store, ok := apiKeys()
+    if !ok {
+        writeError(w, r, errAPIKeysUnsupported)
+        return
+    }
+    err := store.revokeAPIKey(r.Context(), mux.Vars(r)["id"], time.Now().UTC())
+    if errors.Is(err, ErrNotFound) {
+        writeError(w, r, httpError(http.StatusNotFound, "API key not found"))
+        return
+    }
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
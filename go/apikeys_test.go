@@ -0,0 +1,65 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    userRepo = &memoryUserRepository{nextID: 1}
+    defer func(old string) { config.AdminToken = old }(config.AdminToken)
+    config.AdminToken = "admin-secret"
+    h := newAdminHandler()
+
+    do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, strings.NewReader(body))
+        req.Header.Set(header[0], header[1])
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        return rec
+    }
+    admin := []string{"Authorization", "Bearer admin-secret"}
+
+    rec := do(http.MethodPost, "/admin/api-keys", `{"name":"ci","roles":["admin"],"rate_limit":2}`, admin...)
+    if rec.Code != http.StatusCreated {
+        t.Fatalf("issue: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct{ Data APIKey }
+    if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasPrefix(resp.Data.Key, apiKeyPrefix+resp.Data.ID+"_") {
+        t.Fatalf("issued key %q for ID %q", resp.Data.Key, resp.Data.ID)
+    }
+
+    withKey := []string{apiKeyHeader, resp.Data.Key}
+    for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+        rec := do(http.MethodGet, "/admin/api-keys", "", withKey...)
+        if rec.Code != want {
+            t.Fatalf("request %d with the key: %d, want %d", i, rec.Code, want)
+        }
+        if want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+            t.Error("429 without Retry-After")
+        }
+    }
+    if rec := do(http.MethodGet, "/admin/api-keys", "", apiKeyHeader, resp.Data.Key+"x"); rec.Code != http.StatusUnauthorized {
+        t.Errorf("wrong key: %d, want 401", rec.Code)
+    }
+
+    if rec := do(http.MethodDelete, "/admin/api-keys/"+resp.Data.ID, "", admin...); rec.Code != http.StatusNoContent {
+        t.Fatalf("revoke: %d %s", rec.Code, rec.Body)
+    }
+    if rec := do(http.MethodGet, "/admin/api-keys", "", withKey...); rec.Code != http.StatusUnauthorized {
+        t.Errorf("revoked key: %d, want 401", rec.Code)
+    }
+    if rec := do(http.MethodDelete, "/admin/api-keys/missing", "", admin...); rec.Code != http.StatusNotFound {
+        t.Errorf("revoke unknown key: %d, want 404", rec.Code)
+    }
+    if rec := do(http.MethodPost, "/admin/api-keys", `{"name":"","roles":["root"]}`, admin...); rec.Code != http.StatusUnprocessableEntity {
+        t.Errorf("invalid key: %d, want 422", rec.Code)
+    }
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "net/http"
+    "strings"
+
+    "github.com/gorilla/mux"
+)
+
+const (
+    roleAdmin = "admin"
+    roleUser  = "user"
+)
+
+// knownRoles lists the roles a user may be assigned.
+var knownRoles = map[string]bool{
+    roleAdmin: true,
+    roleUser:  true,
+}
+
+// ADMIN_TOKEN is the shared secret operators present as a bearer token to
+// act with the admin role. Token authentication is disabled when it is empty.
+
+// publicMutations lists route templates that accept mutating requests
+// without the admin role.
+var publicMutations = map[string]bool{
+    "/users/{id:[0-9]+}/verify/send": true,
+    "/login":                         true,
+    "/token/refresh":                 true,
+    "/logout":                        true,
+    "/password/reset-request":        true,
+    "/password/reset":                true,
+}
+
+// readOnlyRoutes lists route templates whose POST does not change state,
+// e.g. because the body is too large for a query string. They skip the
+// admin check on mutations (the /admin subrouter still enforces its own)
+// and do not invalidate the response cache.
+var readOnlyRoutes = map[string]bool{
+    "/users/batch-get":      true,
+    "/admin/support-bundle": true,
+}
+
+// routeTemplate returns the template of the route matched for r, or the raw
+// path when no route matched.
+func routeTemplate(r *http.Request) string {
+    if route := mux.CurrentRoute(r); route != nil {
+        if tmpl, err := route.GetPathTemplate(); err == nil {
+            return tmpl
+        }
+    }
+    return r.URL.Path
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+    Subject string
+    Roles   []string
+
+    // claims are those of the token the caller presented, if any.
+    claims Claims
+    // apiKey is the key the caller presented, if any.
+    apiKey *APIKey
+    // session is set when the caller presented the session cookie.
+    session bool
+}
+
+func (p Principal) HasRole(role string) bool {
+    for _, r := range p.Roles {
+        if r == role {
+            return true
+        }
+    }
+    return false
+}
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+func principalFromContext(ctx context.Context) (Principal, bool) {
+    p, ok := ctx.Value(principalKey).(Principal)
+    return p, ok
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer ..." header.
+func bearerToken(r *http.Request) string {
+    header := r.Header.Get("Authorization")
+    if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+        return strings.TrimSpace(header[7:])
+    }
+    return ""
+}
+
+// authenticate resolves the caller from the request credentials.
+func authenticate(r *http.Request) (Principal, bool) {
+    if key := r.Header.Get(apiKeyHeader); key != "" {
+        return authenticateAPIKey(r.Context(), key)
+    }
+    token := bearerToken(r)
+    if token == "" {
+        // The session cookie only carries tokens /login issued.
+        if claims, err := parseToken(sessionToken(r)); err == nil {
+            return Principal{Subject: claims.Subject, Roles: claims.Roles, claims: claims.claims(), session: true}, true
+        }
+        return Principal{}, false
+    }
+    if config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1 {
+        return Principal{Subject: "admin", Roles: []string{roleAdmin}}, true
+    }
+    if claims, err := parseToken(token); err == nil {
+        return Principal{Subject: claims.Subject, Roles: claims.Roles, claims: claims.claims()}, true
+    }
+    if claims, err := verifyJWT(r.Context(), token); err == nil {
+        return Principal{Subject: claims.String("sub"), Roles: jwtRoles(claims), claims: claims}, true
+    }
+    return Principal{}, false
+}
+
+// authMiddleware attaches the authenticated principal, if any, to the request
+// context. It never rejects a request; enforcement is left to later middleware.
+func authMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if p, ok := authenticate(r); ok {
+            ctx := context.WithValue(r.Context(), principalKey, p)
+            if p.claims != nil {
+                ctx = context.WithValue(ctx, claimsKey, p.claims)
+            }
+            r = r.WithContext(ctx)
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func isMutating(method string) bool {
+    switch method {
+    case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+        return true
+    }
+    return false
+}
+
+// roleMiddleware restricts mutating requests to admins while read routes
+// stay open. Routes listed in publicMutations or readOnlyRoutes are exempt.
+func roleMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !isMutating(r.Method) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if tmpl := routeTemplate(r); publicMutations[tmpl] || readOnlyRoutes[tmpl] {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if !requireRole(w, r, roleAdmin) {
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// adminMiddleware guards the /admin routes, including reads.
+func adminMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !requireRole(w, r, roleAdmin) {
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// requireRole writes a 401 or 403 response and returns false unless the
+// request was made by a principal holding role.
+func requireRole(w http.ResponseWriter, r *http.Request, role string) bool {
+    p, ok := principalFromContext(r.Context())
+    if !ok {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="user-api"`)
+        writeError(w, r, httpError(http.StatusUnauthorized, "Authentication required"))
+        return false
+    }
+    if !p.HasRole(role) {
+        writeError(w, r, httpError(http.StatusForbidden, "Insufficient role"))
+        return false
+    }
+    return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gorilla/mux"
+)
+
+func TestRoleMiddleware(t *testing.T) {
+    r := mux.NewRouter()
+    r.Use(roleMiddleware)
+    ok := func(w http.ResponseWriter, r *http.Request) {}
+    r.HandleFunc("/users", ok).Methods("GET", "POST")
+    r.HandleFunc("/users/{id:[0-9]+}", ok).Methods("PUT", "DELETE")
+    r.HandleFunc("/login", ok).Methods("POST")
+    r.HandleFunc("/users/{id:[0-9]+}/verify/send", ok).Methods("POST")
+    r.HandleFunc("/users/batch-get", ok).Methods("POST")
+    admin := r.PathPrefix("/admin").Subrouter()
+    admin.Use(adminMiddleware)
+    admin.HandleFunc("/cache/purge", ok).Methods("POST")
+    admin.HandleFunc("/support-bundle", ok).Methods("GET", "POST")
+
+    // The want codes are for an anonymous caller, a user and an admin.
+    for _, tc := range []struct {
+        method, path string
+        want         [3]int
+    }{
+        {http.MethodGet, "/users", [3]int{200, 200, 200}},
+        {http.MethodPost, "/users", [3]int{401, 403, 200}},
+        {http.MethodPut, "/users/1", [3]int{401, 403, 200}},
+        {http.MethodDelete, "/users/1", [3]int{401, 403, 200}},
+        // publicMutations
+        {http.MethodPost, "/login", [3]int{200, 200, 200}},
+        {http.MethodPost, "/users/1/verify/send", [3]int{200, 200, 200}},
+        // readOnlyRoutes
+        {http.MethodPost, "/users/batch-get", [3]int{200, 200, 200}},
+        // The /admin subrouter checks reads too, and a read-only route
+        // there is still admin-only.
+        {http.MethodGet, "/admin/support-bundle", [3]int{401, 403, 200}},
+        {http.MethodPost, "/admin/support-bundle", [3]int{401, 403, 200}},
+        {http.MethodPost, "/admin/cache/purge", [3]int{401, 403, 200}},
+    } {
+        for i, roles := range [][]string{nil, {roleUser}, {roleAdmin}} {
+            req := httptest.NewRequest(tc.method, tc.path, nil)
+            if roles != nil {
+                req = req.WithContext(context.WithValue(req.Context(), principalKey, Principal{Subject: "1", Roles: roles}))
+            }
+            rec := httptest.NewRecorder()
+            r.ServeHTTP(rec, req)
+            if rec.Code != tc.want[i] {
+                t.Errorf("%s %s as %v: %d, want %d", tc.method, tc.path, roles, rec.Code, tc.want[i])
+            }
+            if got := rec.Header().Get("WWW-Authenticate"); (rec.Code == http.StatusUnauthorized) != (got != "") {
+                t.Errorf("%s %s as %v: %d with WWW-Authenticate %q", tc.method, tc.path, roles, rec.Code, got)
+            }
+        }
+    }
+}
+
+func TestAdminToken(t *testing.T) {
+    defer func(old string) { config.AdminToken = old }(config.AdminToken)
+    config.AdminToken = "s3cret"
+    r := mux.NewRouter()
+    r.Use(authMiddleware)
+    r.Use(roleMiddleware)
+    r.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST")
+
+    for token, want := range map[string]int{
+        "":       http.StatusUnauthorized,
+        "wrong":  http.StatusUnauthorized,
+        "s3cret": http.StatusOK,
+        "s3cre":  http.StatusUnauthorized,
+    } {
+        req := httptest.NewRequest(http.MethodPost, "/users", nil)
+        if token != "" {
+            req.Header.Set("Authorization", "Bearer "+token)
+        }
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, req)
+        if rec.Code != want {
+            t.Errorf("token %q: %d, want %d", token, rec.Code, want)
+        }
+    }
+}
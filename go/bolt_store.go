@@ -0,0 +1,375 @@
+package main
+
+import (
+    "context"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+// defaultBoltPath is relative to the working directory; in the container
+// mount a volume and point BOLT_PATH into it.
+const defaultBoltPath = "data/users.bolt"
+
+var (
+    boltUsersBucket   = []byte("users")    // big-endian ID to storedUser JSON
+    boltEmailsBucket  = []byte("emails")   // lowercased email to big-endian ID
+    boltEventsBucket  = []byte("events")   // big-endian ID to undelivered UserEvent JSON
+    boltAPIKeysBucket = []byte("api_keys") // key ID to storedAPIKey JSON
+)
+
+// boltUserRepository keeps users in a single bbolt file. Like the sqlite
+// backend it is pure Go and needs no external service, so it suits the
+// CGO-free scratch and distroless images; it is smaller and simpler, but
+// only one process can open the file at a time.
+type boltUserRepository struct {
+    db *bolt.DB
+    // tx is the transaction inside WithTx, nil outside it.
+    tx *bolt.Tx
+}
+
+// openBoltUserRepository opens or creates the file at path. It fails after
+// a few seconds if another process holds the file.
+func openBoltUserRepository(ctx context.Context, path string) (UserRepository, error) {
+    if path == "" {
+        path = defaultBoltPath
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return nil, fmt.Errorf("create bolt directory: %w", err)
+    }
+    db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+    if err != nil {
+        return nil, fmt.Errorf("open bolt file %s: %w", path, err)
+    }
+    err = db.Update(func(tx *bolt.Tx) error {
+        for _, name := range [][]byte{boltUsersBucket, boltEmailsBucket, boltEventsBucket, boltAPIKeysBucket} {
+            if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("create bolt buckets: %w", err)
+    }
+    return &boltUserRepository{db: db}, nil
+}
+
+func boltKey(id int) []byte {
+    key := make([]byte, 8)
+    binary.BigEndian.PutUint64(key, uint64(id))
+    return key
+}
+
+// view and update run fn in the WithTx transaction if there is one, or else
+// in a new read-only or read-write one.
+func (s *boltUserRepository) view(fn func(tx *bolt.Tx) error) error {
+    if s.tx != nil {
+        return fn(s.tx)
+    }
+    return s.db.View(fn)
+}
+
+func (s *boltUserRepository) update(fn func(tx *bolt.Tx) error) error {
+    if s.tx != nil {
+        return fn(s.tx)
+    }
+    return s.db.Update(fn)
+}
+
+func (s *boltUserRepository) List(ctx context.Context) ([]User, error) {
+    var users []User
+    err := s.view(func(tx *bolt.Tx) error {
+        return tx.Bucket(boltUsersBucket).ForEach(func(_, data []byte) error {
+            user, err := decodeStoredUser(data)
+            if err != nil {
+                return err
+            }
+            users = append(users, user)
+            return nil
+        })
+    })
+    return users, err
+}
+
+func (s *boltUserRepository) Get(ctx context.Context, id int) (user User, err error) {
+    err = s.view(func(tx *bolt.Tx) error {
+        user, err = boltGet(tx, boltKey(id))
+        return err
+    })
+    return user, err
+}
+
+func (s *boltUserRepository) GetByEmail(ctx context.Context, email string) (user User, err error) {
+    err = s.view(func(tx *bolt.Tx) error {
+        key := tx.Bucket(boltEmailsBucket).Get([]byte(strings.ToLower(email)))
+        if key == nil {
+            return ErrNotFound
+        }
+        user, err = boltGet(tx, key)
+        return err
+    })
+    return user, err
+}
+
+// boltGet reads the user under key. Values returned by bbolt are only valid
+// inside the transaction, which decoding copies out of.
+func boltGet(tx *bolt.Tx, key []byte) (User, error) {
+    data := tx.Bucket(boltUsersBucket).Get(key)
+    if data == nil {
+        return User{}, ErrNotFound
+    }
+    return decodeStoredUser(data)
+}
+
+func boltPut(tx *bolt.Tx, user User) error {
+    data, err := encodeStoredUser(user)
+    if err != nil {
+        return err
+    }
+    key := boltKey(user.ID)
+    if err := tx.Bucket(boltUsersBucket).Put(key, data); err != nil {
+        return err
+    }
+    return tx.Bucket(boltEmailsBucket).Put([]byte(strings.ToLower(user.Email)), key)
+}
+
+// Insert fails with a unique ConstraintError if the email is already taken.
+func (s *boltUserRepository) Insert(ctx context.Context, user User) (User, error) {
+    err := s.update(func(tx *bolt.Tx) error {
+        if tx.Bucket(boltEmailsBucket).Get([]byte(strings.ToLower(user.Email))) != nil {
+            return errEmailTaken
+        }
+        id, err := tx.Bucket(boltUsersBucket).NextSequence()
+        if err != nil {
+            return err
+        }
+        user.ID = int(id)
+        user.CreatedAt = time.Now()
+        user.Verified = false
+        user.Version = 1
+        if len(user.Roles) == 0 {
+            user.Roles = []string{roleUser}
+        }
+        return boltPut(tx, user)
+    })
+    if err != nil {
+        return User{}, err
+    }
+    return user, nil
+}
+
+func (s *boltUserRepository) Update(ctx context.Context, user User) (User, error) {
+    err := s.update(func(tx *bolt.Tx) error {
+        current, err := boltGet(tx, boltKey(user.ID))
+        if err != nil {
+            return err
+        }
+        if current.Version != user.Version {
+            return ErrVersionConflict
+        }
+        emails := tx.Bucket(boltEmailsBucket)
+        newEmail, oldEmail := strings.ToLower(user.Email), strings.ToLower(current.Email)
+        if newEmail != oldEmail {
+            if emails.Get([]byte(newEmail)) != nil {
+                return errEmailTaken
+            }
+            if err := emails.Delete([]byte(oldEmail)); err != nil {
+                return err
+            }
+        }
+        user.Version++
+        return boltPut(tx, user)
+    })
+    if err != nil {
+        return User{}, err
+    }
+    return user, nil
+}
+
+func (s *boltUserRepository) rewriteEmail(ctx context.Context, id int, from, to string) (rewritten bool, err error) {
+    err = s.update(func(tx *bolt.Tx) error {
+        user, err := boltGet(tx, boltKey(id))
+        if errors.Is(err, ErrNotFound) {
+            return nil
+        }
+        if err != nil || user.Email != from {
+            return err
+        }
+        emails := tx.Bucket(boltEmailsBucket)
+        if emails.Get([]byte(strings.ToLower(to))) != nil {
+            return errEmailTaken
+        }
+        if err := emails.Delete([]byte(strings.ToLower(from))); err != nil {
+            return err
+        }
+        user.Email = to
+        rewritten = true
+        return boltPut(tx, user)
+    })
+    return rewritten && err == nil, err
+}
+
+func (s *boltUserRepository) Delete(ctx context.Context, id int) error {
+    return s.update(func(tx *bolt.Tx) error {
+        user, err := boltGet(tx, boltKey(id))
+        if err != nil {
+            return err
+        }
+        if err := tx.Bucket(boltEmailsBucket).Delete([]byte(strings.ToLower(user.Email))); err != nil {
+            return err
+        }
+        return tx.Bucket(boltUsersBucket).Delete(boltKey(id))
+    })
+}
+
+// Stats loads every user and aggregates them like the memory backend.
+func (s *boltUserRepository) Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error) {
+    users, err := s.List(ctx)
+    if err != nil {
+        return UserStats{}, err
+    }
+    return (&memoryUserRepository{users: users}).Stats(ctx, days, top, now)
+}
+
+// WithTx runs fn in a read-write transaction, committing if it returns nil.
+// bbolt allows one writer at a time, so transactions are serialised. Calls
+// nested inside fn join the outer transaction.
+func (s *boltUserRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    if s.tx != nil {
+        return fn(s)
+    }
+    return s.db.Update(func(tx *bolt.Tx) error {
+        return fn(&boltUserRepository{db: s.db, tx: tx})
+    })
+}
+
+func (s *boltUserRepository) appendEvent(ctx context.Context, event UserEvent) error {
+    return s.update(func(tx *bolt.Tx) error {
+        events := tx.Bucket(boltEventsBucket)
+        id, err := events.NextSequence()
+        if err != nil {
+            return err
+        }
+        event.ID = int64(id)
+        data, err := json.Marshal(event)
+        if err != nil {
+            return err
+        }
+        return events.Put(boltKey(int(id)), data)
+    })
+}
+
+func (s *boltUserRepository) pendingEvents(ctx context.Context, limit int) ([]UserEvent, error) {
+    var events []UserEvent
+    err := s.view(func(tx *bolt.Tx) error {
+        c := tx.Bucket(boltEventsBucket).Cursor()
+        for k, data := c.First(); k != nil && len(events) < limit; k, data = c.Next() {
+            var event UserEvent
+            if err := json.Unmarshal(data, &event); err != nil {
+                return err
+            }
+            events = append(events, event)
+        }
+        return nil
+    })
+    return events, err
+}
+
+// markEventsSent deletes the events rather than keeping them, so the file
+// does not grow with every write.
+func (s *boltUserRepository) markEventsSent(ctx context.Context, ids []int64) error {
+    return s.update(func(tx *bolt.Tx) error {
+        events := tx.Bucket(boltEventsBucket)
+        for _, id := range ids {
+            if err := events.Delete(boltKey(int(id))); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (s *boltUserRepository) insertAPIKey(ctx context.Context, key APIKey) error {
+    data, err := json.Marshal(storedAPIKey{APIKey: key, KeyHash: key.hash})
+    if err != nil {
+        return err
+    }
+    return s.update(func(tx *bolt.Tx) error {
+        return tx.Bucket(boltAPIKeysBucket).Put([]byte(key.ID), data)
+    })
+}
+
+func decodeStoredAPIKey(data []byte) (APIKey, error) {
+    var stored storedAPIKey
+    if err := json.Unmarshal(data, &stored); err != nil {
+        return APIKey{}, err
+    }
+    key := stored.APIKey
+    key.hash = stored.KeyHash
+    return key, nil
+}
+
+func (s *boltUserRepository) getAPIKey(ctx context.Context, id string) (APIKey, error) {
+    var key APIKey
+    err := s.view(func(tx *bolt.Tx) error {
+        data := tx.Bucket(boltAPIKeysBucket).Get([]byte(id))
+        if data == nil {
+            return ErrNotFound
+        }
+        var err error
+        key, err = decodeStoredAPIKey(data)
+        return err
+    })
+    return key, err
+}
+
+// listAPIKeys sorts by creation time, as the bucket is ordered by the
+// random IDs.
+func (s *boltUserRepository) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+    var keys []APIKey
+    err := s.view(func(tx *bolt.Tx) error {
+        return tx.Bucket(boltAPIKeysBucket).ForEach(func(k, data []byte) error {
+            key, err := decodeStoredAPIKey(data)
+            if err != nil {
+                return err
+            }
+            keys = append(keys, key)
+            return nil
+        })
+    })
+    sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+    return keys, err
+}
+
+func (s *boltUserRepository) revokeAPIKey(ctx context.Context, id string, at time.Time) error {
+    return s.update(func(tx *bolt.Tx) error {
+        bucket := tx.Bucket(boltAPIKeysBucket)
+        data := bucket.Get([]byte(id))
+        if data == nil {
+            return ErrNotFound
+        }
+        key, err := decodeStoredAPIKey(data)
+        if err != nil || key.RevokedAt != nil {
+            return err
+        }
+        key.RevokedAt = &at
+        if data, err = json.Marshal(storedAPIKey{APIKey: key, KeyHash: key.hash}); err != nil {
+            return err
+        }
+        return bucket.Put([]byte(id), data)
+    })
+}
+
+func (s *boltUserRepository) Close() error {
+    return s.db.Close()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "context"
+    "path/filepath"
+    "testing"
+)
+
+func openBoltTestRepository(t *testing.T) *boltUserRepository {
+    t.Helper()
+    repo, err := openBoltUserRepository(context.Background(), filepath.Join(t.TempDir(), "users.bolt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := repo.(*boltUserRepository)
+    t.Cleanup(func() { s.Close() })
+    return s
+}
+
+func TestBoltUserLifecycle(t *testing.T) {
+    testUserLifecycle(t, openBoltTestRepository(t))
+}
+
+func TestBoltEmailTaken(t *testing.T) {
+    testEmailTaken(t, openBoltTestRepository(t))
+}
+
+func TestBoltEmailEncryption(t *testing.T) {
+    testEmailEncryption(t, openBoltTestRepository(t))
+}
+
+func TestBoltUserStats(t *testing.T) {
+    testUserStats(t, openBoltTestRepository(t))
+}
+
+func TestBoltWithTx(t *testing.T) {
+    testWithTx(t, openBoltTestRepository(t))
+}
+
+func TestBoltVersionConflict(t *testing.T) {
+    testVersionConflict(t, openBoltTestRepository(t))
+}
+
+func TestBoltOutbox(t *testing.T) {
+    testOutbox(t, openBoltTestRepository(t))
+}
+
+func TestBoltAPIKeys(t *testing.T) {
+    testAPIKeys(t, openBoltTestRepository(t))
+}
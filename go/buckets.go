@@ -0,0 +1,59 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// durationBucketPresets are the named HTTP_DURATION_BUCKETS values, in
+// seconds.
+var durationBucketPresets = map[string][]float64{
+    // default is the Prometheus default, 5ms to 10s.
+    "default": prometheus.DefBuckets,
+    // fast resolves the sub-10ms responses of the memory backend and the
+    // response cache, down to 100µs.
+    "fast": {0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+    // slo has a bucket at each common latency objective, so the share of
+    // requests within e.g. 300ms is read straight from a bucket instead of
+    // being interpolated.
+    "slo": {0.01, 0.025, 0.05, 0.1, 0.2, 0.3, 0.5, 0.75, 1, 2, 5},
+}
+
+// parseBuckets parses the buckets of http_request_duration_seconds:
+// HTTP_DURATION_BUCKETS names a preset or lists the upper bounds as
+// durations or seconds, e.g. "1ms,5ms,20ms,100ms" or "0.001,0.005,0.02,0.1".
+func parseBuckets(value string) ([]float64, error) {
+    if preset, ok := durationBucketPresets[strings.ToLower(value)]; ok {
+        return preset, nil
+    }
+    var buckets []float64
+    for _, field := range strings.Split(value, ",") {
+        field = strings.TrimSpace(field)
+        seconds, err := strconv.ParseFloat(field, 64)
+        if err != nil {
+            d, derr := time.ParseDuration(field)
+            if derr != nil {
+                return nil, fmt.Errorf("%q is neither a duration nor a number of seconds", field)
+            }
+            seconds = d.Seconds()
+        }
+        if seconds <= 0 {
+            return nil, fmt.Errorf("bucket %q is not positive", field)
+        }
+        buckets = append(buckets, seconds)
+    }
+    if !sort.Float64sAreSorted(buckets) {
+        return nil, fmt.Errorf("buckets must be in increasing order")
+    }
+    for i := 1; i < len(buckets); i++ {
+        if buckets[i] == buckets[i-1] {
+            return nil, fmt.Errorf("bucket %v is repeated", buckets[i])
+        }
+    }
+    return buckets, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "net/http"
+    "runtime"
+    "runtime/debug"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// version, commit and buildDate are set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// as the Dockerfiles do from their VERSION, COMMIT and BUILD_DATE build
+// arguments. Without them the commit and its time come from the VCS stamp
+// go build adds when run in a git checkout.
+var (
+    version   = "dev"
+    commit    = ""
+    buildDate = ""
+)
+
+// BuildInfo is the body of /version.
+type BuildInfo struct {
+    Version   string `json:"version"`
+    Commit    string `json:"commit"`
+    BuildDate string `json:"build_date"`
+    GoVersion string `json:"go_version"`
+}
+
+var buildInfo = readBuildInfo()
+
+// String formats the build information for --version.
+func (b BuildInfo) String() string {
+    s := "user-api " + b.Version
+    if b.Commit != "" {
+        s += " commit " + b.Commit
+    }
+    if b.BuildDate != "" {
+        s += " built " + b.BuildDate
+    }
+    return s + " " + b.GoVersion
+}
+
+func readBuildInfo() BuildInfo {
+    info := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+    if bi, ok := debug.ReadBuildInfo(); ok {
+        for _, s := range bi.Settings {
+            switch {
+            case s.Key == "vcs.revision" && info.Commit == "":
+                info.Commit = s.Value
+            case s.Key == "vcs.time" && info.BuildDate == "":
+                info.BuildDate = s.Value
+            }
+        }
+    }
+    return info
+}
+
+// build_info is always 1; its labels let dashboards annotate when a new
+// image was rolled out and compare behaviour before and after.
+var buildInfoGauge = prometheus.NewGaugeVec(
+    prometheus.GaugeOpts{
+        Name: "build_info",
+        Help: "Version, commit and build date of the running binary, always 1",
+    },
+    []string{"version", "commit", "build_date", "go_version"},
+)
+
+func init() {
+    prometheus.MustRegister(buildInfoGauge)
+    buildInfoGauge.WithLabelValues(buildInfo.Version, buildInfo.Commit, buildInfo.BuildDate, buildInfo.GoVersion).Set(1)
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "success", Data: buildInfo})
+}
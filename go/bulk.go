@@ -0,0 +1,158 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// maxBulkDelete caps the number of IDs accepted by one bulk delete.
+const maxBulkDelete = 10000
+
+// maxBatchGet caps the number of IDs fetched in one batch.
+const maxBatchGet = 100
+
+type BatchGetRequest struct {
+    IDs []int `json:"ids"`
+}
+
+// BatchGetResult lists the users found, in request order, and the IDs that
+// do not exist.
+type BatchGetResult struct {
+    Users   []User `json:"users"`
+    Missing []int  `json:"missing"`
+}
+
+type BulkDeleteRequest struct {
+    IDs []int `json:"ids"`
+}
+
+type BulkDeleteError struct {
+    ID      int    `json:"id"`
+    Message string `json:"message"`
+}
+
+type BulkDeleteResult struct {
+    Total   int               `json:"total"`
+    Deleted int               `json:"deleted"`
+    Failed  int               `json:"failed"`
+    Errors  []BulkDeleteError `json:"errors,omitempty"`
+}
+
+// batchGetUsersHandler is the POST form of GET /users?ids=, for clients
+// that prefer to send the IDs in a body.
+func batchGetUsersHandler(w http.ResponseWriter, r *http.Request) {
+    var req BatchGetRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    writeBatchGet(w, r, req.IDs)
+}
+
+// parseIDs reads a comma-separated list of user IDs.
+func parseIDs(value string) ([]int, error) {
+    var ids []int
+    for _, part := range strings.Split(value, ",") {
+        id, err := strconv.Atoi(strings.TrimSpace(part))
+        if err != nil || id < 1 {
+            return nil, fmt.Errorf("invalid id %q", part)
+        }
+        ids = append(ids, id)
+    }
+    return ids, nil
+}
+
+func writeBatchGet(w http.ResponseWriter, r *http.Request, ids []int) {
+    if len(ids) == 0 {
+        writeError(w, r, httpError(http.StatusBadRequest, "ids is required"))
+        return
+    }
+    if len(ids) > maxBatchGet {
+        writeError(w, r, httpError(http.StatusBadRequest, fmt.Sprintf("At most %d ids can be fetched at once", maxBatchGet)))
+        return
+    }
+
+    result := BatchGetResult{Users: []User{}, Missing: []int{}}
+    seen := make(map[int]bool, len(ids))
+    for _, id := range ids {
+        if seen[id] {
+            continue
+        }
+        seen[id] = true
+        user, err := userRepo.Get(r.Context(), id)
+        switch {
+        case err == nil:
+            result.Users = append(result.Users, user)
+        case errors.Is(err, ErrNotFound):
+            result.Missing = append(result.Missing, id)
+        default:
+            writeError(w, r, err)
+            return
+        }
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   result,
+    })
+}
+
+// bulkDeleteUsersHandler deletes every listed user, reporting IDs that could
+// not be deleted without aborting the rest. Large batches, or ?async=true,
+// run as a background job.
+func bulkDeleteUsersHandler(w http.ResponseWriter, r *http.Request) {
+    var req BulkDeleteRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    if len(req.IDs) == 0 {
+        writeError(w, r, httpError(http.StatusBadRequest, "ids is required"))
+        return
+    }
+    if len(req.IDs) > maxBulkDelete {
+        writeError(w, r, httpError(http.StatusRequestEntityTooLarge, "Too many ids in one request"))
+        return
+    }
+
+    if runAsync(r, len(req.IDs)) {
+        r := detachRequest(r)
+        submitJob(w, r, "bulk_delete", len(req.IDs), func(progress jobProgress) (interface{}, error) {
+            result := deleteUsers(r, req.IDs, progress)
+            cache.purgePrefix("/users")
+            cache.purgePrefix("/teams")
+            return result, nil
+        })
+        return
+    }
+
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   deleteUsers(r, req.IDs, nil),
+    })
+}
+
+func deleteUsers(r *http.Request, ids []int, progress jobProgress) BulkDeleteResult {
+    result := BulkDeleteResult{Total: len(ids)}
+    for _, id := range ids {
+        err := removeUser(r.Context(), id)
+        if progress != nil {
+            progress(err == nil)
+        }
+        if err != nil {
+            message := err.Error()
+            if errors.Is(err, ErrNotFound) {
+                message = "User not found"
+            }
+            result.Errors = append(result.Errors, BulkDeleteError{ID: id, Message: message})
+            continue
+        }
+        recordActivity(r, id, activityDeleted, nil)
+        result.Deleted++
+    }
+    result.Failed = len(result.Errors)
+    return result
+}
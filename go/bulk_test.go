@@ -0,0 +1,64 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func TestBatchGetUsers(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    for _, name := range []string{"Ada", "Bob", "Cy"} {
+        if _, err := repo.Insert(context.Background(), User{Name: name, Email: strings.ToLower(name) + "@example.com"}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    get := func(rec *httptest.ResponseRecorder) (ids []int, missing []int) {
+        var resp struct {
+            Data BatchGetResult `json:"data"`
+        }
+        json.Unmarshal(rec.Body.Bytes(), &resp)
+        for _, user := range resp.Data.Users {
+            ids = append(ids, user.ID)
+        }
+        return ids, resp.Data.Missing
+    }
+
+    // Both forms keep the request order, skip repeats and list unknown IDs.
+    query := httptest.NewRecorder()
+    getUsersHandler(query, httptest.NewRequest(http.MethodGet, "/users?ids=3,9,1,3", nil))
+    body := httptest.NewRecorder()
+    batchGetUsersHandler(body, httptest.NewRequest(http.MethodPost, "/users/batch-get", strings.NewReader(`{"ids":[3,9,1,3]}`)))
+    for name, rec := range map[string]*httptest.ResponseRecorder{"query": query, "body": body} {
+        ids, missing := get(rec)
+        if rec.Code != http.StatusOK || !reflect.DeepEqual(ids, []int{3, 1}) || !reflect.DeepEqual(missing, []int{9}) {
+            t.Errorf("%s: %d %s", name, rec.Code, rec.Body)
+        }
+    }
+
+    tooMany := make([]string, maxBatchGet+1)
+    for i := range tooMany {
+        tooMany[i] = strconv.Itoa(i + 1)
+    }
+    for _, target := range []string{"/users?ids=1,x", "/users?ids=0", "/users?ids=" + strings.Join(tooMany, ",")} {
+        rec := httptest.NewRecorder()
+        getUsersHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+        if rec.Code != http.StatusBadRequest {
+            t.Errorf("%.40s: %d", target, rec.Code)
+        }
+    }
+    for _, input := range []string{`{"ids":[]}`, `{"ids":`} {
+        rec := httptest.NewRecorder()
+        batchGetUsersHandler(rec, httptest.NewRequest(http.MethodPost, "/users/batch-get", strings.NewReader(input)))
+        if rec.Code != http.StatusBadRequest {
+            t.Errorf("%s: %d", input, rec.Code)
+        }
+    }
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    cacheRequestsTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "http_cache_requests_total",
+            Help: "Total number of cacheable requests by cache result",
+        },
+        []string{"result"},
+    )
+    cachePurgesTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "http_cache_purges_total",
+            Help: "Total number of response cache purges by scope",
+        },
+        []string{"scope"},
+    )
+    cachePurgedEntriesTotal = prometheus.NewCounter(
+        prometheus.CounterOpts{
+            Name: "http_cache_purged_entries_total",
+            Help: "Total number of response cache entries removed by purges",
+        },
+    )
+)
+
+func init() {
+    prometheus.MustRegister(cacheRequestsTotal)
+    prometheus.MustRegister(cachePurgesTotal)
+    prometheus.MustRegister(cachePurgedEntriesTotal)
+}
+
+// cacheablePrefixes lists the path prefixes whose GET responses are cached.
+var cacheablePrefixes = []string{"/users", "/teams"}
+
+// cachedHeaders are the response headers kept in a cache entry. They describe
+// the body; the rest, such as cookies, rate limits, request IDs and CORS
+// headers, belong to the caller the response was first made for.
+var cachedHeaders = []string{"Content-Type", "Content-Language", "Content-Disposition", "ETag", "Last-Modified"}
+
+type cacheEntry struct {
+    status  int
+    header  http.Header
+    body    []byte
+    expires time.Time
+}
+
+// responseCache is an in-memory cache of GET responses keyed by request URI.
+type responseCache struct {
+    mu      sync.RWMutex
+    ttl     time.Duration
+    entries map[string]cacheEntry
+}
+
+var cache = newResponseCache(config.ResponseCacheTTL)
+
+func newResponseCache(ttl time.Duration) *responseCache {
+    return &responseCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+func (c *responseCache) get(key string) (cacheEntry, bool) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    entry, ok := c.entries[key]
+    if !ok || time.Now().After(entry.expires) {
+        return cacheEntry{}, false
+    }
+    return entry, true
+}
+
+func (c *responseCache) set(key string, entry cacheEntry) {
+    entry.expires = time.Now().Add(c.ttl)
+    c.mu.Lock()
+    c.entries[key] = entry
+    c.mu.Unlock()
+}
+
+// purge removes the entry stored under key and returns how many were removed.
+func (c *responseCache) purge(key string) int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, ok := c.entries[key]; !ok {
+        return 0
+    }
+    delete(c.entries, key)
+    return 1
+}
+
+// purgePrefix removes every entry whose key starts with prefix.
+func (c *responseCache) purgePrefix(prefix string) int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    n := 0
+    for key := range c.entries {
+        if strings.HasPrefix(key, prefix) {
+            delete(c.entries, key)
+            n++
+        }
+    }
+    return n
+}
+
+// purgeAll empties the cache. The map is cleared rather than replaced so it
+// keeps the capacity low-latency mode sized it to.
+func (c *responseCache) purgeAll() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    n := len(c.entries)
+    clear(c.entries)
+    return n
+}
+
+// cacheRecorder tees the response into a buffer so it can be cached.
+type cacheRecorder struct {
+    http.ResponseWriter
+    status int
+    body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+    rec.status = status
+    rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+    rec.body.Write(b)
+    return rec.ResponseWriter.Write(b)
+}
+
+func isCacheable(r *http.Request) bool {
+    if r.Method != http.MethodGet {
+        return false
+    }
+    for _, prefix := range cacheablePrefixes {
+        if strings.HasPrefix(r.URL.Path, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// wantsCacheBypass reports whether an authenticated client asked for a fresh
+// response. Anonymous clients cannot bypass the cache.
+func wantsCacheBypass(r *http.Request) bool {
+    if !strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+        return false
+    }
+    _, ok := principalFromContext(r.Context())
+    return ok
+}
+
+// cacheMiddleware serves cached GET responses for the user routes and drops
+// the whole cache whenever a mutating request succeeds.
+func cacheMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if cache.ttl <= 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if !isCacheable(r) {
+            // Only the status is needed here, so the body is not teed.
+            rec := getStatusRecorder(w)
+            defer putStatusRecorder(rec)
+            next.ServeHTTP(rec, r)
+            if isMutating(r.Method) && !readOnlyRoutes[routeTemplate(r)] && rec.status < 400 {
+                cache.purgeAll()
+            }
+            return
+        }
+
+        key := r.URL.RequestURI()
+        if wantsJSONAPI(r) {
+            key += "#jsonapi"
+        }
+        result := "miss"
+        if wantsCacheBypass(r) {
+            result = "bypass"
+        } else if entry, ok := cache.get(key); ok {
+            cacheRequestsTotal.WithLabelValues("hit").Inc()
+            for name, values := range entry.header {
+                w.Header()[name] = values
+            }
+            w.Header().Set("X-Cache", "HIT")
+            w.WriteHeader(entry.status)
+            w.Write(entry.body)
+            return
+        }
+        cacheRequestsTotal.WithLabelValues(result).Inc()
+
+        w.Header().Set("X-Cache", strings.ToUpper(result))
+        rec := getCacheRecorder(w)
+        defer putCacheRecorder(rec)
+        next.ServeHTTP(rec, r)
+        if rec.status == http.StatusOK {
+            header := make(http.Header)
+            for _, name := range cachedHeaders {
+                for _, value := range w.Header().Values(name) {
+                    header.Add(name, value)
+                }
+            }
+            // The recorder goes back to the pool, so the entry needs its own copy.
+            body := bytes.Clone(rec.body.Bytes())
+            cache.set(key, cacheEntry{status: rec.status, header: header, body: body})
+        }
+    })
+}
+
+type CachePurgeRequest struct {
+    Key    string `json:"key,omitempty"`
+    Prefix string `json:"prefix,omitempty"`
+    All    bool   `json:"all,omitempty"`
+}
+
+// purgeCacheHandler removes cache entries by exact key, by prefix, or all of
+// them, for recovering from stale data without restarting the container.
+func purgeCacheHandler(w http.ResponseWriter, r *http.Request) {
+    var req CachePurgeRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+
+    var scope string
+    var purged int
+    switch {
+    case req.All:
+        scope, purged = "all", cache.purgeAll()
+    case req.Prefix != "":
+        scope, purged = "prefix", cache.purgePrefix(req.Prefix)
+    case req.Key != "":
+        scope, purged = "key", cache.purge(req.Key)
+    default:
+        writeError(w, r, httpError(http.StatusBadRequest, "One of key, prefix or all is required"))
+        return
+    }
+    cachePurgesTotal.WithLabelValues(scope).Inc()
+    cachePurgedEntriesTotal.Add(float64(purged))
+
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data: map[string]interface{}{
+            "scope":  scope,
+            "purged": purged,
+        },
+    })
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+func TestCacheStoresOnlyContentHeaders(t *testing.T) {
+    defer func(old *responseCache) { cache = old }(cache)
+    cache = newResponseCache(time.Minute)
+    calls := 0
+    h := cacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        calls++
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("ETag", `"v1"`)
+        w.Header().Set("Set-Cookie", "session=first")
+        w.Header().Set("RateLimit-Remaining", "9")
+        w.Write([]byte(`{"status":"success"}`))
+    }))
+    // The request ID is set by a middleware in front of the cache.
+    get := func(n int) *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        rec.Header().Set("X-Request-ID", "req-"+strconv.Itoa(n))
+        h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+        return rec
+    }
+
+    get(1)
+    rec := get(2)
+    if calls != 1 || rec.Header().Get("X-Cache") != "HIT" {
+        t.Fatalf("calls %d, X-Cache %q", calls, rec.Header().Get("X-Cache"))
+    }
+    if got := rec.Header().Values("X-Request-ID"); len(got) != 1 || got[0] != "req-2" {
+        t.Errorf("X-Request-ID %q, want the second request's", got)
+    }
+    for _, name := range []string{"Set-Cookie", "RateLimit-Remaining"} {
+        if got := rec.Header().Get(name); got != "" {
+            t.Errorf("%s replayed from the first caller: %q", name, got)
+        }
+    }
+    if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("ETag") != `"v1"` {
+        t.Errorf("content headers lost: %v", rec.Header())
+    }
+}
+
+func TestCacheBypass(t *testing.T) {
+    defer func(old *responseCache) { cache = old }(cache)
+    cache = newResponseCache(time.Minute)
+    calls := 0
+    h := cacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        calls++
+        w.Write([]byte(strconv.Itoa(calls)))
+    }))
+    get := func(authenticated bool) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, "/users", nil)
+        req.Header.Set("Cache-Control", "no-cache")
+        if authenticated {
+            req = req.WithContext(context.WithValue(req.Context(), principalKey, Principal{Subject: "1", Roles: []string{roleAdmin}}))
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        return rec
+    }
+
+    if rec := get(false); rec.Header().Get("X-Cache") != "MISS" {
+        t.Fatalf("first request: X-Cache %q", rec.Header().Get("X-Cache"))
+    }
+    if rec := get(false); rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != "1" {
+        t.Errorf("anonymous no-cache: X-Cache %q, body %q", rec.Header().Get("X-Cache"), rec.Body)
+    }
+    if rec := get(true); rec.Header().Get("X-Cache") != "BYPASS" || rec.Body.String() != "2" {
+        t.Errorf("authenticated no-cache: X-Cache %q, body %q", rec.Header().Get("X-Cache"), rec.Body)
+    }
+    // The fresh response replaces the stale entry.
+    if rec := get(false); rec.Body.String() != "2" {
+        t.Errorf("after bypass: body %q", rec.Body)
+    }
+}
+
+func TestPurgeCache(t *testing.T) {
+    defer func(old *responseCache) { cache = old }(cache)
+    fill := func() {
+        cache = newResponseCache(time.Minute)
+        for _, key := range []string{"/users", "/users?page=2", "/users/1", "/teams"} {
+            cache.set(key, cacheEntry{status: http.StatusOK})
+        }
+    }
+    purge := func(body string) *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        purgeCacheHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/purge", strings.NewReader(body)))
+        return rec
+    }
+
+    for _, tc := range []struct {
+        body   string
+        scope  string
+        purged int
+        left   []string
+    }{
+        {`{"key":"/users/1"}`, "key", 1, []string{"/users", "/users?page=2", "/teams"}},
+        {`{"key":"/users/2"}`, "key", 0, []string{"/users", "/users?page=2", "/users/1", "/teams"}},
+        {`{"prefix":"/users"}`, "prefix", 3, []string{"/teams"}},
+        {`{"all":true}`, "all", 4, nil},
+        // all wins over the narrower scopes.
+        {`{"all":true,"key":"/users"}`, "all", 4, nil},
+    } {
+        fill()
+        rec := purge(tc.body)
+        if rec.Code != http.StatusOK {
+            t.Errorf("%s: %d %s", tc.body, rec.Code, rec.Body)
+            continue
+        }
+        var resp struct {
+            Data struct {
+                Scope  string `json:"scope"`
+                Purged int    `json:"purged"`
+            } `json:"data"`
+        }
+        if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+            t.Fatal(err)
+        }
+        if resp.Data.Scope != tc.scope || resp.Data.Purged != tc.purged {
+            t.Errorf("%s: scope %q purged %d, want %q %d", tc.body, resp.Data.Scope, resp.Data.Purged, tc.scope, tc.purged)
+        }
+        if len(cache.entries) != len(tc.left) {
+            t.Errorf("%s: %d entries left, want %v", tc.body, len(cache.entries), tc.left)
+        }
+        for _, key := range tc.left {
+            if _, ok := cache.get(key); !ok {
+                t.Errorf("%s: %s purged", tc.body, key)
+            }
+        }
+    }
+
+    for _, body := range []string{`{}`, `not json`} {
+        if rec := purge(body); rec.Code != http.StatusBadRequest {
+            t.Errorf("%s: %d, want 400", body, rec.Code)
+        }
+    }
+}
+
+func TestCacheInvalidatedOnWrites(t *testing.T) {
+    defer func(old *responseCache) { cache = old }(cache)
+    cache = newResponseCache(time.Minute)
+    r := mux.NewRouter()
+    r.Use(cacheMiddleware)
+    ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+    r.HandleFunc("/users", ok).Methods("GET", "POST")
+    r.HandleFunc("/users/batch-get", ok).Methods("POST")
+    r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+        writeError(w, r, httpError(http.StatusNotFound, "User not found"))
+    }).Methods("DELETE")
+
+    for _, tc := range []struct {
+        method, path string
+        purged       bool
+    }{
+        {http.MethodPost, "/users", true},
+        {http.MethodPost, "/users/batch-get", false},
+        {http.MethodDelete, "/users/9", false},
+    } {
+        cache.set("/users", cacheEntry{status: http.StatusOK})
+        r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+        if _, ok := cache.get("/users"); ok == tc.purged {
+            t.Errorf("%s %s: cache purged %v, want %v", tc.method, tc.path, !ok, tc.purged)
+        }
+    }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+)
+
+// command is a subcommand of user-api. They all read the settings the same
+// way, from the environment, CONFIG_FILE and --config.
+type command struct {
+    summary string
+    run     func(args []string) int
+    // quiet commands skip the startup logging and the check of the
+    // settings files, for those run often or only printing.
+    quiet bool
+}
+
+// commands are the subcommands; without one, user-api serves.
+var commands = map[string]command{
+    "serve":            {summary: "serve the API (the default)", run: runServe},
+    "migrate":          {summary: "create or upgrade the storage schema and exit", run: func(args []string) int { return runJob("migrate", runMigrate, args) }},
+    "seed":             {summary: "fill the database with fake users", run: func(args []string) int { return runJob("seed", runSeed, args) }},
+    "healthcheck":      {summary: "check that the server in this container is ready", run: runHealthcheck, quiet: true},
+    "version":          {summary: "print the version and build information", run: runVersion, quiet: true},
+    "gen-client":       {summary: "write a typed API client from the OpenAPI spec", run: runGenClient},
+    "support-bundle":   {summary: "save the support bundle of a running server", run: runSupportBundle},
+    "gen-email-key":    {summary: "print a new data key for EMAIL_DATA_KEYS", run: runGenEmailKey, quiet: true},
+    "reencrypt-emails": {summary: "encrypt stored emails with the first key of EMAIL_DATA_KEYS", run: func(args []string) int { return runJob("reencrypt-emails", runReencryptEmails, args) }},
+}
+
+// printUsage lists the commands.
+func printUsage(w io.Writer) {
+    fmt.Fprintln(w, "Usage: user-api [flags] [command] [arguments]")
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Commands:")
+    names := make([]string, 0, len(commands))
+    for name := range commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Fprintf(w, "  %-17s %s\n", name, commands[name].summary)
+    }
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, `Run "user-api <command> -h" for the flags of a command. Settings come from`)
+    fmt.Fprintln(w, "the environment and CONFIG_FILE.")
+}
+
+func runVersion(args []string) int {
+    fs := flag.NewFlagSet("version", flag.ContinueOnError)
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+    fmt.Println(buildInfo.String())
+    return 0
+}
+
+// parseFlags handles the flags given before the command: --version and
+// --help print and exit, so the build inside an image can be checked
+// without configuring or starting anything, and --config was already
+// applied by configFlag. It returns the remaining arguments, or ok false
+// with the exit code when there is nothing more to do.
+func parseFlags(args []string, stdout, stderr io.Writer) (rest []string, code int, ok bool) {
+    fs := flag.NewFlagSet("user-api", flag.ContinueOnError)
+    fs.SetOutput(stderr)
+    showVersion := fs.Bool("version", false, "print the version and build information and exit")
+    var help bool
+    fs.BoolVar(&help, "help", false, "print this help and exit")
+    fs.BoolVar(&help, "h", false, "print this help and exit")
+    fs.String("config", "", "comma-separated settings files, overriding CONFIG_FILE")
+    fs.Usage = func() {
+        printUsage(fs.Output())
+        fmt.Fprintln(fs.Output(), "\nFlags:")
+        fs.PrintDefaults()
+    }
+    if err := fs.Parse(args); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            return nil, 0, false
+        }
+        return nil, 2, false
+    }
+    switch {
+    case help:
+        fs.SetOutput(stdout)
+        fs.Usage()
+        return nil, 0, false
+    case *showVersion:
+        fmt.Fprintln(stdout, buildInfo.String())
+        return nil, 0, false
+    }
+    return fs.Args(), 0, true
+}
+
+// configFlag returns the value of a --config flag among the flags before
+// the command. The settings files are read while the package initializes,
+// before main parses its flags, so it is looked up in the arguments
+// directly.
+func configFlag(args []string) (string, bool) {
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        if arg == "--" || !strings.HasPrefix(arg, "-") {
+            break
+        }
+        name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+        if name != "config" {
+            continue
+        }
+        if hasValue {
+            return value, true
+        }
+        if i+1 < len(args) {
+            return args[i+1], true
+        }
+    }
+    return "", false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestParseFlags(t *testing.T) {
+    var stdout, stderr bytes.Buffer
+    if _, code, ok := parseFlags([]string{"--version"}, &stdout, &stderr); ok || code != 0 || !strings.HasPrefix(stdout.String(), "user-api "+version) {
+        t.Errorf("--version: %v, %d, %q", ok, code, stdout.String())
+    }
+    stdout.Reset()
+    if _, code, ok := parseFlags([]string{"--help"}, &stdout, &stderr); ok || code != 0 || !strings.Contains(stdout.String(), "-config") {
+        t.Errorf("--help: %v, %d, %q", ok, code, stdout.String())
+    }
+    if _, code, ok := parseFlags([]string{"--bogus"}, &stdout, &stderr); ok || code != 2 {
+        t.Errorf("--bogus: %v, %d", ok, code)
+    }
+    rest, _, ok := parseFlags([]string{"--config", "a.yaml", "seed", "-n", "3"}, &stdout, &stderr)
+    if !ok || fmt.Sprint(rest) != "[seed -n 3]" {
+        t.Errorf("seed: %v, %v", rest, ok)
+    }
+}
+
+func TestConfigFlag(t *testing.T) {
+    for _, c := range []struct {
+        args []string
+        want string
+    }{
+        {[]string{"--config", "a.yaml"}, "a.yaml"},
+        {[]string{"-version", "-config=a.yaml,b.env", "seed"}, "a.yaml,b.env"},
+        {[]string{"seed", "--config", "a.yaml"}, ""},
+        {[]string{"--", "--config", "a.yaml"}, ""},
+    } {
+        if got, _ := configFlag(c.args); got != c.want {
+            t.Errorf("%q: %q, want %q", c.args, got, c.want)
+        }
+    }
+}
+
+func TestUsageListsCommands(t *testing.T) {
+    var out bytes.Buffer
+    printUsage(&out)
+    for name := range commands {
+        if !strings.Contains(out.String(), "  "+name+" ") {
+            t.Errorf("usage does not list %s:\n%s", name, out.String())
+        }
+    }
+}
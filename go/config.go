@@ -0,0 +1,390 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/BurntSushi/toml"
+    "gopkg.in/yaml.v3"
+)
+
+// CONFIG_FILE names one or more comma-separated files with settings, so a
+// compose file and a Kubernetes ConfigMap can configure the container the
+// same way. YAML (.yaml, .yml), TOML (.toml) and dotenv files (anything
+// else, e.g. .env) are read. Keys are the environment variable names, in any
+// case, and nested keys are joined with underscores, so
+//
+//	storage:
+//	  backend: postgres
+//	http_duration_buckets: [5ms, 10ms, 50ms]
+//
+// sets STORAGE_BACKEND and HTTP_DURATION_BUCKETS (lists are joined with
+// commas). Later files win over earlier ones, and a non-empty environment
+// variable wins over every file. The --config flag, given before any
+// command, replaces CONFIG_FILE.
+//
+// The files are read on the first lookup, while the package initializes,
+// and their settings exported to the environment, so libraries that read it
+// themselves, such as the OpenTelemetry SDK, see them too. A file that
+// cannot be read or parsed stops the server at startup, and keys nothing
+// looked up by then are reported, as they are likely typos. A reload (see
+// reloadConfig) reads them again.
+var configFile struct {
+    once   sync.Once
+    values map[string]string
+    mu     sync.Mutex
+    used   map[string]bool
+    err    error
+    // exported holds the keys set in the environment from the files rather
+    // than by it, which a reload may change.
+    exported map[string]bool
+    // secrets holds the secretSettings read from their _FILE variants.
+    secrets map[string]string
+}
+
+// runtimeSettings are read by the Go runtime before main, so a CONFIG_FILE
+// cannot set them.
+var runtimeSettings = []string{"GOMAXPROCS", "GOMEMLIMIT", "GOGC", "GODEBUG", "GOTRACEBACK"}
+
+// secretSettings carry credentials, so each can also be read from the file
+// its _FILE variant names, e.g. DATABASE_URL_FILE=/run/secrets/database_url
+// for a Docker or Kubernetes secret, with trailing newlines dropped. Setting
+// both is an error, as is a file that cannot be read. Secrets read from
+// files are not exported to the environment, so they do not leak into child
+// processes or support bundles.
+var secretSettings = []string{
+    "ADMIN_TOKEN", "TOKEN_SECRET", "METRICS_PASSWORD",
+    "DATABASE_URL", "DATABASE_REPLICA_URLS", "REDIS_URL", "MONGODB_URI",
+    "SENTRY_DSN", "ERROR_WEBHOOK_URL", "OUTBOX_WEBHOOK_URL", "PUSHGATEWAY_URL",
+    "JWT_HMAC_SECRET",
+}
+
+// getenv returns the setting key from the environment, CONFIG_FILE or, for
+// secrets, the file its _FILE variant names, falling back to the APP_ENV
+// profile; "" when none sets it.
+func getenv(key string) string {
+    value, _ := lookupEnv(key)
+    return value
+}
+
+// lookupEnv is getenv that also reports whether the setting was given at
+// all, for settings where an empty value means something.
+func lookupEnv(key string) (string, bool) {
+    configFile.once.Do(loadConfigFiles)
+    configFile.mu.Lock()
+    if _, ok := configFile.values[key]; ok {
+        configFile.used[key] = true
+    }
+    secret, ok := configFile.secrets[key]
+    configFile.mu.Unlock()
+    if ok {
+        return secret, true
+    }
+    if value, ok := os.LookupEnv(key); ok {
+        return value, true
+    }
+    return profileDefault(key)
+}
+
+func loadConfigFiles() {
+    if path, ok := configFlag(os.Args[1:]); ok {
+        os.Setenv("CONFIG_FILE", path)
+    }
+    configFile.used = make(map[string]bool)
+    configFile.exported = make(map[string]bool)
+    configFile.values, configFile.err = readConfigFiles()
+    if configFile.err != nil {
+        return
+    }
+    for k, v := range configFile.values {
+        if os.Getenv(k) == "" && !slices.Contains(runtimeSettings, k) {
+            os.Setenv(k, v)
+            configFile.exported[k] = true
+        }
+    }
+    configFile.secrets, configFile.err = readSecretFiles(os.Getenv)
+}
+
+// readSecretFiles reads the secretSettings whose _FILE variant is set,
+// looking the settings up with getenv.
+func readSecretFiles(getenv func(string) string) (map[string]string, error) {
+    secrets := make(map[string]string)
+    for _, key := range secretSettings {
+        path := getenv(key + "_FILE")
+        if path == "" {
+            continue
+        }
+        configFile.used[key+"_FILE"] = true
+        if getenv(key) != "" {
+            return nil, fmt.Errorf("both %s and %s_FILE are set", key, key)
+        }
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return nil, fmt.Errorf("%s_FILE: %w", key, err)
+        }
+        secrets[key] = strings.TrimRight(string(data), "\r\n")
+    }
+    return secrets, nil
+}
+
+// readConfigFiles returns the settings of every CONFIG_FILE, later files
+// winning.
+func readConfigFiles() (map[string]string, error) {
+    merged := make(map[string]string)
+    for _, path := range configFilePaths() {
+        values, err := readConfigFile(path)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", path, err)
+        }
+        for k, v := range values {
+            merged[k] = v
+        }
+    }
+    return merged, nil
+}
+
+func configFilePaths() []string {
+    var paths []string
+    for _, path := range strings.Split(os.Getenv("CONFIG_FILE"), ",") {
+        if path = strings.TrimSpace(path); path != "" {
+            paths = append(paths, path)
+        }
+    }
+    return paths
+}
+
+// reloadConfigFiles reads CONFIG_FILE and the secret files again and
+// updates the settings exported from them, returning the keys whose value
+// changed. As at startup, the environment wins, and a file that cannot be
+// read or sets what only the environment can leaves everything as it was.
+func reloadConfigFiles() ([]string, error) {
+    configFile.once.Do(loadConfigFiles)
+    values, err := readConfigFiles()
+    if err != nil {
+        return nil, err
+    }
+    for key := range values {
+        if slices.Contains(runtimeSettings, key) {
+            return nil, fmt.Errorf("%s can only be set in the environment", key)
+        }
+    }
+    configFile.mu.Lock()
+    defer configFile.mu.Unlock()
+    // The secret files are read before anything changes, with the settings
+    // as they will be.
+    secrets, err := readSecretFiles(func(key string) string {
+        if value, ok := values[key]; ok && (configFile.exported[key] || os.Getenv(key) == "") {
+            return value
+        }
+        if configFile.exported[key] {
+            return ""
+        }
+        return os.Getenv(key)
+    })
+    if err != nil {
+        return nil, err
+    }
+    var changed []string
+    for key, secret := range secrets {
+        if old, ok := configFile.secrets[key]; !ok || old != secret {
+            changed = append(changed, key)
+        }
+    }
+    for key := range configFile.secrets {
+        if _, ok := secrets[key]; !ok {
+            changed = append(changed, key)
+        }
+    }
+    for key := range configFile.exported {
+        if _, ok := values[key]; !ok {
+            os.Unsetenv(key)
+            delete(configFile.exported, key)
+            changed = append(changed, key)
+        }
+    }
+    for key, value := range values {
+        switch {
+        case configFile.exported[key]:
+            if os.Getenv(key) == value {
+                continue
+            }
+        case os.Getenv(key) == "":
+            configFile.exported[key] = true
+            if value == "" {
+                os.Setenv(key, value)
+                continue
+            }
+        default:
+            continue
+        }
+        os.Setenv(key, value)
+        changed = append(changed, key)
+    }
+    configFile.values, configFile.secrets = values, secrets
+    sort.Strings(changed)
+    return slices.Compact(changed), nil
+}
+
+// readConfigFile returns the settings in path by environment variable name.
+func readConfigFile(path string) (map[string]string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var tree map[string]any
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        err = yaml.Unmarshal(data, &tree)
+    case ".toml":
+        _, err = toml.Decode(string(data), &tree)
+    default:
+        return parseDotenv(data)
+    }
+    if err != nil {
+        return nil, err
+    }
+    values := make(map[string]string)
+    return values, flattenConfig(values, "", tree)
+}
+
+func flattenConfig(values map[string]string, prefix string, tree map[string]any) error {
+    for k, v := range tree {
+        key := strings.ToUpper(prefix + k)
+        switch v := v.(type) {
+        case map[string]any:
+            if err := flattenConfig(values, key+"_", v); err != nil {
+                return err
+            }
+        case []any:
+            items := make([]string, len(v))
+            for i, item := range v {
+                if _, ok := item.(map[string]any); ok {
+                    return fmt.Errorf("%s: lists can only hold values", key)
+                }
+                items[i] = fmt.Sprint(item)
+            }
+            values[key] = strings.Join(items, ",")
+        case nil:
+            values[key] = ""
+        case time.Time:
+            values[key] = v.Format(time.RFC3339)
+        default:
+            values[key] = fmt.Sprint(v)
+        }
+    }
+    return nil
+}
+
+// parseDotenv reads KEY=VALUE lines, optionally prefixed with export, with
+// # comments and values optionally in single or double quotes.
+func parseDotenv(data []byte) (map[string]string, error) {
+    values := make(map[string]string)
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for n := 1; scanner.Scan(); n++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        line = strings.TrimPrefix(line, "export ")
+        key, value, ok := strings.Cut(line, "=")
+        key = strings.TrimSpace(key)
+        if !ok || key == "" || strings.ContainsAny(key, " \t") {
+            return nil, fmt.Errorf("line %d: want KEY=VALUE", n)
+        }
+        value = strings.TrimSpace(value)
+        if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+            value = value[1 : len(value)-1]
+        } else if i := strings.Index(value, " #"); i >= 0 {
+            value = strings.TrimSpace(value[:i])
+        }
+        values[key] = value
+    }
+    return values, scanner.Err()
+}
+
+// checkConfigFile fails on a CONFIG_FILE or secret file that could not be
+// read, a CONFIG_FILE that sets what only the environment can, an unknown
+// APP_ENV or any invalid setting (see Config).
+func checkConfigFile() error {
+    configFile.once.Do(loadConfigFiles)
+    if configFile.err != nil {
+        return configFile.err
+    }
+    if err := startupEnv.err(); err != nil {
+        return err
+    }
+    for key := range configFile.values {
+        if slices.Contains(runtimeSettings, key) {
+            return fmt.Errorf("%s can only be set in the environment, as the Go runtime reads it before CONFIG_FILE", key)
+        }
+    }
+    if len(configFile.values) > 0 {
+        slog.Info("Loaded settings from CONFIG_FILE", "files", os.Getenv("CONFIG_FILE"), "settings", len(configFile.values))
+    }
+    if len(configFile.secrets) > 0 {
+        keys := make([]string, 0, len(configFile.secrets))
+        for key := range configFile.secrets {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        slog.Info("Read secrets from files", "keys", keys)
+    }
+    return checkProfile()
+}
+
+// warnUnusedConfig reports the CONFIG_FILE keys no setting has looked up,
+// once startup is done, e.g. a misspelt key or DATABASE_URL with the memory
+// backend. OTEL_ keys are left to the OpenTelemetry SDK.
+func warnUnusedConfig() {
+    configFile.mu.Lock()
+    defer configFile.mu.Unlock()
+    var unused []string
+    for key := range configFile.values {
+        if !configFile.used[key] && !strings.HasPrefix(key, "OTEL_") {
+            unused = append(unused, key)
+        }
+    }
+    if len(unused) > 0 {
+        sort.Strings(unused)
+        slog.Warn("Settings in CONFIG_FILE not used; check them for typos", "keys", unused)
+    }
+}
+
+// durationSetting is a duration setting that a reload can change while
+// requests read it.
+type durationSetting struct {
+    v atomic.Int64
+}
+
+func reloadableDuration(key string, def time.Duration) *durationSetting {
+    s := new(durationSetting)
+    s.set(def)
+    reloadableSetting(func() error {
+        var env envReader
+        d := env.duration(key, def)
+        if err := env.err(); err != nil {
+            return err
+        }
+        s.set(d)
+        return nil
+    }, key)
+    return s
+}
+
+func (s *durationSetting) get() time.Duration {
+    return time.Duration(s.v.Load())
+}
+
+func (s *durationSetting) set(d time.Duration) {
+    s.v.Store(int64(d))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestConfigFile(t *testing.T) {
+    dir := t.TempDir()
+    write := func(name, content string) string {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+        return path
+    }
+    yamlPath := write("config.yaml", `
+storage:
+  backend: sqlite
+http_duration_buckets: [5ms, 10ms]
+log_level: debug
+port: 9000
+stroage_backend: typo
+`)
+    tomlPath := write("config.toml", `
+log_level = "warn"
+[statsd]
+prefix = ""
+`)
+    envPath := write(".env", `# local overrides
+export SQLITE_PATH="/data/users db.sqlite"
+ADMIN_TOKEN=abc # not a secret
+`)
+
+    for _, key := range []string{"STORAGE_BACKEND", "HTTP_DURATION_BUCKETS", "LOG_LEVEL", "STATSD_PREFIX", "SQLITE_PATH", "ADMIN_TOKEN", "STROAGE_BACKEND"} {
+        t.Setenv(key, "")
+        os.Unsetenv(key)
+    }
+    t.Setenv("PORT", "8081")
+    t.Setenv("CONFIG_FILE", yamlPath+", "+tomlPath+","+envPath)
+    defer func() { configFile.once, configFile.values, configFile.used, configFile.err = sync.Once{}, nil, nil, nil }()
+    configFile.once = sync.Once{}
+
+    if err := checkConfigFile(); err != nil {
+        t.Fatal(err)
+    }
+    for key, want := range map[string]string{
+        "STORAGE_BACKEND":       "sqlite",
+        "HTTP_DURATION_BUCKETS": "5ms,10ms",
+        "LOG_LEVEL":             "warn",
+        "PORT":                  "8081",
+        "SQLITE_PATH":           "/data/users db.sqlite",
+        "ADMIN_TOKEN":           "abc",
+    } {
+        if got := getenv(key); got != want {
+            t.Errorf("%s = %q, want %q", key, got, want)
+        }
+    }
+    if value, ok := lookupEnv("STATSD_PREFIX"); !ok || value != "" {
+        t.Errorf("empty STATSD_PREFIX from the file: %q, %v", value, ok)
+    }
+    if !configFile.used["STORAGE_BACKEND"] || configFile.used["STROAGE_BACKEND"] {
+        t.Errorf("used settings %v", configFile.used)
+    }
+
+    configFile.once = sync.Once{}
+    t.Setenv("CONFIG_FILE", write("bad.env", "GOMAXPROCS=2\n"))
+    if err := checkConfigFile(); err == nil {
+        t.Error("GOMAXPROCS accepted from a file")
+    }
+    for _, content := range []string{"just words\n", "A B=1\n"} {
+        configFile.once = sync.Once{}
+        t.Setenv("CONFIG_FILE", write("broken.env", content))
+        if err := checkConfigFile(); err == nil {
+            t.Errorf("%q accepted", content)
+        }
+    }
+}
+
+func TestReloadConfig(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    write := func(content string) {
+        if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+    write("log_level: warn\nslow_request_threshold: 1s\nport: 9000\nsqlite_path: /data/a.db\n")
+    for _, key := range []string{"LOG_LEVEL", "SLOW_REQUEST_THRESHOLD", "PORT", "SQLITE_PATH"} {
+        t.Setenv(key, "")
+        os.Unsetenv(key)
+    }
+    t.Setenv("SQLITE_PATH", "/env.db")
+    t.Setenv("CONFIG_FILE", path)
+    defer func() { configFile.once, configFile.values, configFile.used, configFile.err = sync.Once{}, nil, nil, nil }()
+    configFile.once = sync.Once{}
+    defer logLevel.Set(logLevel.Level())
+    defer slowRequestThreshold.set(slowRequestThreshold.get())
+    if err := checkConfigFile(); err != nil {
+        t.Fatal(err)
+    }
+
+    write("log_level: debug\nslow_request_threshold: 2s\nsqlite_path: /data/b.db\n")
+    if err := reloadConfig(); err != nil {
+        t.Fatal(err)
+    }
+    if logLevel.Level() != slog.LevelDebug || slowRequestThreshold.get() != 2*time.Second {
+        t.Errorf("reload applied level %v, threshold %v", logLevel.Level(), slowRequestThreshold.get())
+    }
+    if value, ok := os.LookupEnv("PORT"); ok {
+        t.Errorf("PORT removed from the file still %q", value)
+    }
+    if got := getenv("SQLITE_PATH"); got != "/env.db" {
+        t.Errorf("environment overridden by the file: SQLITE_PATH = %q", got)
+    }
+
+    write("log_level: debug\nGOGC: 50\n")
+    if err := reloadConfig(); err == nil {
+        t.Error("GOGC accepted on reload")
+    }
+    if got := getenv("SLOW_REQUEST_THRESHOLD"); got != "2s" {
+        t.Errorf("failed reload changed SLOW_REQUEST_THRESHOLD to %q", got)
+    }
+
+    write("log_level: warn\nslow_request_threshold: soon\n")
+    if err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "SLOW_REQUEST_THRESHOLD") {
+        t.Errorf("invalid SLOW_REQUEST_THRESHOLD on reload: %v", err)
+    }
+    if logLevel.Level() != slog.LevelWarn || slowRequestThreshold.get() != 2*time.Second {
+        t.Errorf("reload applied level %v, threshold %v; want the valid level and the threshold kept", logLevel.Level(), slowRequestThreshold.get())
+    }
+}
+
+func TestSecretFiles(t *testing.T) {
+    dir := t.TempDir()
+    secret := filepath.Join(dir, "database_url")
+    if err := os.WriteFile(secret, []byte("postgres://app:s3cret@db/users\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    for _, key := range []string{"DATABASE_URL", "DATABASE_URL_FILE", "TOKEN_SECRET", "TOKEN_SECRET_FILE"} {
+        t.Setenv(key, "")
+        os.Unsetenv(key)
+    }
+    t.Setenv("CONFIG_FILE", "")
+    t.Setenv("DATABASE_URL_FILE", secret)
+    defer func() {
+        configFile.once, configFile.values, configFile.used, configFile.err, configFile.secrets = sync.Once{}, nil, nil, nil, nil
+    }()
+    configFile.once = sync.Once{}
+
+    if err := checkConfigFile(); err != nil {
+        t.Fatal(err)
+    }
+    if got := getenv("DATABASE_URL"); got != "postgres://app:s3cret@db/users" {
+        t.Errorf("DATABASE_URL = %q", got)
+    }
+    if value, ok := os.LookupEnv("DATABASE_URL"); ok {
+        t.Errorf("secret exported to the environment: %q", value)
+    }
+
+    if err := os.WriteFile(secret, []byte("postgres://app:rotated@db/users"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    changed, err := reloadConfigFiles()
+    if err != nil || len(changed) != 1 || changed[0] != "DATABASE_URL" {
+        t.Errorf("reload after rotation: %v, %v", changed, err)
+    }
+    if got := getenv("DATABASE_URL"); got != "postgres://app:rotated@db/users" {
+        t.Errorf("DATABASE_URL after reload = %q", got)
+    }
+
+    for _, c := range []struct{ key, value string }{
+        {"TOKEN_SECRET_FILE", filepath.Join(dir, "missing")},
+        {"DATABASE_URL", "postgres://db/users"},
+    } {
+        t.Run(c.key, func(t *testing.T) {
+            t.Setenv(c.key, c.value)
+            configFile.once = sync.Once{}
+            if err := checkConfigFile(); err == nil {
+                t.Errorf("%s=%s accepted", c.key, c.value)
+            }
+        })
+    }
+}
+
+func TestProfiles(t *testing.T) {
+    t.Setenv("LOG_FORMAT", "")
+    os.Unsetenv("LOG_FORMAT")
+    t.Setenv("APP_ENV", "dev")
+    if got := getenv("LOG_FORMAT"); got != "text" {
+        t.Errorf("development LOG_FORMAT = %q, want text", got)
+    }
+    if err := checkProfile(); err != nil {
+        t.Error(err)
+    }
+    t.Setenv("LOG_FORMAT", "json")
+    if got := getenv("LOG_FORMAT"); got != "json" {
+        t.Errorf("LOG_FORMAT = %q, want the environment's json", got)
+    }
+
+    t.Setenv("APP_ENV", "")
+    if got := getenv("CORS_ALLOWED_ORIGINS"); got != "" {
+        t.Errorf("CORS_ALLOWED_ORIGINS = %q without a profile", got)
+    }
+    t.Setenv("APP_ENV", "qa")
+    if err := checkProfile(); err == nil {
+        t.Error("unknown APP_ENV accepted")
+    }
+}
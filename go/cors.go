@@ -0,0 +1,88 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// CORS_ALLOWED_ORIGINS lists the origins whose browser scripts may call the
+// API, comma separated, e.g. https://app.example.com, or * for any. By
+// default none may, as the embedded UI is served from the API's own origin;
+// the development profile allows any, for a front end on its own dev
+// server. Tokens travel in the Authorization header rather than cookies, so
+// credentialed requests are not allowed. Preflights are cached by browsers
+// for CORS_MAX_AGE (default 10m). Both change on a reload.
+var (
+    corsOrigins atomic.Pointer[map[string]bool]
+    corsMaxAge  = reloadableDuration("CORS_MAX_AGE", 10*time.Minute)
+)
+
+func init() {
+    reloadableSetting(func() error {
+        origins := parseCORSOrigins(getenv("CORS_ALLOWED_ORIGINS"))
+        corsOrigins.Store(&origins)
+        return nil
+    }, "CORS_ALLOWED_ORIGINS")
+}
+
+// corsExposedHeaders are the response headers scripts may read besides the
+// safelisted ones.
+const corsExposedHeaders = "ETag, Location, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, X-Cache, X-Request-ID"
+
+func parseCORSOrigins(value string) map[string]bool {
+    origins := make(map[string]bool)
+    for _, origin := range strings.Split(value, ",") {
+        if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+            origins[origin] = true
+        }
+    }
+    return origins
+}
+
+// corsAllowed reports the Access-Control-Allow-Origin to answer origin with,
+// "" when it is not allowed.
+func corsAllowed(origins map[string]bool, origin string) string {
+    switch {
+    case origin == "":
+        return ""
+    case origins["*"]:
+        return "*"
+    case origins[origin]:
+        return origin
+    }
+    return ""
+}
+
+// corsMiddleware answers preflights from allowed origins and lets them read
+// the responses. It wraps the router rather than being one of its
+// middlewares, as the router answers OPTIONS requests 405 before those run.
+func corsMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        origins := *corsOrigins.Load()
+        if len(origins) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+        w.Header().Add("Vary", "Origin")
+        allow := corsAllowed(origins, r.Header.Get("Origin"))
+        if allow == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        w.Header().Set("Access-Control-Allow-Origin", allow)
+        if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+            if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+                w.Header().Set("Access-Control-Allow-Headers", headers)
+            }
+            w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.get().Seconds())))
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestCORS(t *testing.T) {
+    defer corsOrigins.Store(corsOrigins.Load())
+    setCORSOrigins("https://app.example.com/, https://admin.example.com")
+    h := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusMethodNotAllowed)
+        }
+    }))
+
+    req := httptest.NewRequest(http.MethodOptions, "/users", nil)
+    req.Header.Set("Origin", "https://app.example.com")
+    req.Header.Set("Access-Control-Request-Method", "POST")
+    req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+        rec.Header().Get("Access-Control-Allow-Headers") != "authorization, content-type" {
+        t.Errorf("preflight: %d %v", rec.Code, rec.Header())
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/users", nil)
+    req.Header.Set("Origin", "https://admin.example.com")
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Header().Get("Access-Control-Allow-Origin") != "https://admin.example.com" || rec.Header().Get("Access-Control-Expose-Headers") == "" {
+        t.Errorf("GET: %v", rec.Header())
+    }
+
+    req.Header.Set("Origin", "https://evil.example.com")
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+        t.Errorf("other origin allowed as %q", got)
+    }
+}
+
+func TestCORSOnCacheHits(t *testing.T) {
+    defer corsOrigins.Store(corsOrigins.Load())
+    setCORSOrigins("https://app.example.com")
+    defer func(old *responseCache) { cache = old }(cache)
+    cache = newResponseCache(time.Minute)
+    h := corsMiddleware(cacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`{"status":"success"}`))
+    })))
+    get := func(origin string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, "/users", nil)
+        if origin != "" {
+            req.Header.Set("Origin", origin)
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        return rec
+    }
+
+    get("https://app.example.com")
+    for _, origin := range []string{"https://evil.example.com", ""} {
+        rec := get(origin)
+        if rec.Header().Get("X-Cache") != "HIT" {
+            t.Fatalf("origin %q: X-Cache %q", origin, rec.Header().Get("X-Cache"))
+        }
+        for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Expose-Headers"} {
+            if got := rec.Header().Get(name); got != "" {
+                t.Errorf("origin %q: %s replayed as %q", origin, name, got)
+            }
+        }
+        if got := rec.Header().Values("Vary"); len(got) != 1 {
+            t.Errorf("origin %q: Vary %q", origin, got)
+        }
+    }
+    if rec := get("https://app.example.com"); rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+        t.Errorf("allowed origin on a hit: %v", rec.Header())
+    }
+}
+
+func TestCORSReload(t *testing.T) {
+    defer corsOrigins.Store(corsOrigins.Load())
+    setCORSOrigins("")
+    h := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    get := func() *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, "/users", nil)
+        req.Header.Set("Origin", "https://app.example.com")
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        return rec
+    }
+    if rec := get(); rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "" {
+        t.Fatalf("CORS headers with no origins allowed: %v", rec.Header())
+    }
+
+    t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+    reloadable["CORS_ALLOWED_ORIGINS"]()
+    if rec := get(); rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+        t.Errorf("origin allowed by the reload refused: %v", rec.Header())
+    }
+}
+
+func setCORSOrigins(value string) {
+    origins := parseCORSOrigins(value)
+    corsOrigins.Store(&origins)
+}
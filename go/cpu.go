@@ -0,0 +1,149 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "math"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "strings"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var cpuLimitCores = prometheus.NewGaugeVec(
+    prometheus.GaugeOpts{
+        Name: "cpu_limit_cores",
+        Help: "CPUs the container may use, by where the limit came from; the GOMAXPROCS derived from it is go_sched_gomaxprocs_threads",
+    },
+    []string{"source"},
+)
+
+func init() {
+    prometheus.MustRegister(cpuLimitCores)
+}
+
+// cpuLimit is the number of CPUs the container may use, detected once at
+// start-up. Concurrency defaults are derived from it so the same image
+// behaves sensibly from a 0.25 CPU limit up to several cores.
+var cpuLimit = detectCPULimit()
+
+type cpuLimitInfo struct {
+    CPUs   float64 // fractional limit, e.g. 0.25
+    Source string  // where the limit came from
+}
+
+// procs rounds the limit up to whole processors, never below one.
+func (c cpuLimitInfo) procs() int {
+    return int(math.Max(1, math.Ceil(c.CPUs)))
+}
+
+// detectCPULimit reads, in order, the CPU_LIMIT override, the cgroup v2
+// cpu.max files and the cgroup v1 CFS quota, falling back to the host CPU
+// count when no quota is set.
+func detectCPULimit() cpuLimitInfo {
+    if v := getenv("CPU_LIMIT"); v != "" {
+        if cpus, err := strconv.ParseFloat(v, 64); err == nil && cpus > 0 {
+            return cpuLimitInfo{CPUs: cpus, Source: "CPU_LIMIT"}
+        }
+        startupEnv.invalid("CPU_LIMIT", v, "not a positive number of CPUs")
+    }
+    if cpus, ok := cgroupV2Min(cgroupV2Dir(), "cpu.max", cgroupV2CPULimit); ok {
+        return cpuLimitInfo{CPUs: cpus, Source: "cgroup v2 cpu.max"}
+    }
+    if cpus, ok := cgroupV1CPULimit("/sys/fs/cgroup/cpu/cpu.cfs_quota_us", "/sys/fs/cgroup/cpu/cpu.cfs_period_us"); ok {
+        return cpuLimitInfo{CPUs: cpus, Source: "cgroup v1 cfs quota"}
+    }
+    return cpuLimitInfo{CPUs: float64(runtime.NumCPU()), Source: "host CPU count"}
+}
+
+// cgroupRoot is where the cgroup hierarchy is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupV2Dir returns the directory of the cgroup v2 of this process, from
+// /proc/self/cgroup. Inside a cgroup namespace, as in most containers, that
+// is the root; without one, e.g. with Docker's --cgroupns=host or under
+// systemd, it is nested below it.
+func cgroupV2Dir() string {
+    data, err := os.ReadFile("/proc/self/cgroup")
+    if err != nil {
+        return cgroupRoot
+    }
+    for _, line := range strings.Split(string(data), "\n") {
+        if path, ok := strings.CutPrefix(line, "0::"); ok {
+            return filepath.Join(cgroupRoot, path)
+        }
+    }
+    return cgroupRoot
+}
+
+// cgroupV2Min returns the smallest limit read from file in dir and each of
+// its parents up to the root, as a parent's limit caps its children's.
+func cgroupV2Min(dir, file string, read func(path string) (float64, bool)) (float64, bool) {
+    limit, found := 0.0, false
+    for {
+        if v, ok := read(filepath.Join(dir, file)); ok && (!found || v < limit) {
+            limit, found = v, true
+        }
+        if dir == cgroupRoot || !strings.HasPrefix(dir, cgroupRoot) {
+            return limit, found
+        }
+        dir = filepath.Dir(dir)
+    }
+}
+
+// cgroupV2CPULimit parses "<quota> <period>", where quota is "max" when
+// the cgroup is unlimited.
+func cgroupV2CPULimit(path string) (float64, bool) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return 0, false
+    }
+    fields := strings.Fields(string(data))
+    if len(fields) != 2 || fields[0] == "max" {
+        return 0, false
+    }
+    return quotaCPUs(fields[0], fields[1])
+}
+
+// cgroupV1CPULimit reads the CFS quota and period; a quota of -1 means
+// unlimited.
+func cgroupV1CPULimit(quotaPath, periodPath string) (float64, bool) {
+    quota, err := os.ReadFile(quotaPath)
+    if err != nil {
+        return 0, false
+    }
+    period, err := os.ReadFile(periodPath)
+    if err != nil {
+        return 0, false
+    }
+    return quotaCPUs(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func quotaCPUs(quota, period string) (float64, bool) {
+    q, err := strconv.ParseFloat(quota, 64)
+    if err != nil || q <= 0 {
+        return 0, false
+    }
+    p, err := strconv.ParseFloat(period, 64)
+    if err != nil || p <= 0 {
+        return 0, false
+    }
+    return q / p, true
+}
+
+// applyCPULimit sets GOMAXPROCS from the detected limit unless the
+// GOMAXPROCS variable already pins it, and logs how every derived setting
+// was chosen.
+func applyCPULimit() {
+    gomaxprocs := fmt.Sprintf("%d (GOMAXPROCS)", runtime.GOMAXPROCS(0))
+    if os.Getenv("GOMAXPROCS") == "" {
+        runtime.GOMAXPROCS(cpuLimit.procs())
+        gomaxprocs = strconv.Itoa(cpuLimit.procs())
+    }
+    cpuLimitCores.WithLabelValues(cpuLimit.Source).Set(cpuLimit.CPUs)
+    slog.Info("CPU limit applied", "cpus", cpuLimit.CPUs, "source", cpuLimit.Source, "gomaxprocs", gomaxprocs,
+        "job_workers", config.JobWorkers, "job_queue", config.JobQueueSize, "db_max_open_conns", config.DBMaxOpenConns, "db_max_idle_conns", config.DBMaxIdleConns)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestCgroupV2Nested(t *testing.T) {
+    defer func(old string) { cgroupRoot = old }(cgroupRoot)
+    cgroupRoot = t.TempDir()
+    dir := filepath.Join(cgroupRoot, "system.slice", "docker-1.scope")
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        t.Fatal(err)
+    }
+    write := func(dir, content string) {
+        if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(content), 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if _, ok := cgroupV2Min(dir, "cpu.max", cgroupV2CPULimit); ok {
+        t.Error("limit found without cpu.max files")
+    }
+    write(dir, "max 100000\n")
+    write(filepath.Join(cgroupRoot, "system.slice"), "150000 100000\n")
+    if cpus, ok := cgroupV2Min(dir, "cpu.max", cgroupV2CPULimit); !ok || cpus != 1.5 {
+        t.Errorf("parent limit: %v, %v; want 1.5", cpus, ok)
+    }
+    write(dir, "50000 100000\n")
+    if cpus, ok := cgroupV2Min(dir, "cpu.max", cgroupV2CPULimit); !ok || cpus != 0.5 {
+        t.Errorf("own limit below the parent's: %v, %v; want 0.5", cpus, ok)
+    }
+}
+
+func TestCgroupMemoryLimit(t *testing.T) {
+    dir := t.TempDir()
+    for content, want := range map[string]float64{"536870912\n": 536870912, "max\n": 0, "9223372036854771712\n": 0} {
+        path := filepath.Join(dir, "memory.max")
+        if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+            t.Fatal(err)
+        }
+        if n, ok := readMemoryLimit(path); n != want || ok != (want > 0) {
+            t.Errorf("%q: %v, %v; want %v", content, n, ok, want)
+        }
+    }
+}
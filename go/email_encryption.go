@@ -0,0 +1,480 @@
+package main
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base32"
+    "encoding/base64"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// With EMAIL_ENCRYPTION_KEY set, emails are encrypted before they reach the
+// storage backend and decrypted as they are read back, so a copy of the
+// database or a backup does not give them away. It is envelope encryption:
+// emails are encrypted with data keys, and the data keys are kept in
+// EMAIL_DATA_KEYS wrapped by the key-encryption key, a base64 32-byte key
+// here, though any keyWrapper, e.g. a KMS client, can hold it instead.
+// "user-api gen-email-key -id <id>" prints a new data key to add.
+//
+// The encryption is deterministic, like SIV: the same email always encrypts
+// the same way under the same data key, so the unique indexes and lookups
+// by email of every backend keep working unchanged. The price is that equal
+// emails have equal ciphertexts, and that emails are stored in lower case,
+// as they compare case-insensitively. Stats by domain decrypt every user.
+//
+// To rotate, put a new data key first in EMAIL_DATA_KEYS: emails written
+// from then on are encrypted with it, the others are still read and found
+// with the keys after it, and "user-api reencrypt-emails" re-encrypts them
+// in place, leaving versions and ETags as they are, so the old keys can be
+// dropped. That also encrypts the emails stored
+// before encryption was turned on, which are read as they are until then.
+// Events waiting in the outbox are encrypted too, and decrypted as they are
+// delivered; the Redis cache of REDIS_URL holds them in the clear.
+
+// emailKeys are the data keys of EMAIL_DATA_KEYS, nil without encryption.
+var emailKeys *emailKeyring
+
+const encryptedEmailPrefix = "enc:"
+
+// Ciphertexts are encoded in lower-case base32, as some backends lower-case
+// emails for their indexes.
+var emailEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+var dataKeyIDPattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// keyWrapper holds the key-encryption key of the data keys.
+type keyWrapper interface {
+    wrapKey(ctx context.Context, key []byte) ([]byte, error)
+    unwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// localKeyWrapper wraps keys with AES-256-GCM under a key it holds.
+type localKeyWrapper struct {
+    aead cipher.AEAD
+}
+
+func newLocalKeyWrapper(encoded string) (*localKeyWrapper, error) {
+    key, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil || len(key) != 32 {
+        return nil, errors.New("EMAIL_ENCRYPTION_KEY must be 32 bytes, base64-encoded")
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return &localKeyWrapper{aead: aead}, nil
+}
+
+func (w *localKeyWrapper) wrapKey(ctx context.Context, key []byte) ([]byte, error) {
+    nonce := make([]byte, w.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    return w.aead.Seal(nonce, nonce, key, nil), nil
+}
+
+func (w *localKeyWrapper) unwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+    n := w.aead.NonceSize()
+    if len(wrapped) < n {
+        return nil, errors.New("wrapped key is too short")
+    }
+    return w.aead.Open(nil, wrapped[:n], wrapped[n:], nil)
+}
+
+// dataKey encrypts emails with AES-256-CTR under an IV that is an HMAC of
+// the email, which authenticates it again on decryption.
+type dataKey struct {
+    id    string
+    block cipher.Block
+    mac   []byte
+}
+
+func newDataKey(id string, key []byte) (*dataKey, error) {
+    if len(key) != 32 {
+        return nil, fmt.Errorf("data key %q is not 32 bytes", id)
+    }
+    // Separate keys for encryption and the IV, derived from the data key.
+    block, err := aes.NewCipher(deriveKey(key, "user-api email encryption"))
+    if err != nil {
+        return nil, err
+    }
+    return &dataKey{id: id, block: block, mac: deriveKey(key, "user-api email iv")}, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(purpose))
+    return h.Sum(nil)
+}
+
+func (k *dataKey) iv(plaintext []byte) []byte {
+    h := hmac.New(sha256.New, k.mac)
+    h.Write(plaintext)
+    return h.Sum(nil)[:aes.BlockSize]
+}
+
+// encrypt returns "enc:<key ID>:<IV and ciphertext>".
+func (k *dataKey) encrypt(email string) string {
+    plaintext := []byte(email)
+    out := make([]byte, aes.BlockSize+len(plaintext))
+    iv := k.iv(plaintext)
+    copy(out, iv)
+    cipher.NewCTR(k.block, iv).XORKeyStream(out[aes.BlockSize:], plaintext)
+    return encryptedEmailPrefix + k.id + ":" + emailEncoding.EncodeToString(out)
+}
+
+func (k *dataKey) decrypt(data []byte) (string, error) {
+    if len(data) < aes.BlockSize {
+        return "", fmt.Errorf("email encrypted with data key %q is too short", k.id)
+    }
+    iv, ciphertext := data[:aes.BlockSize], data[aes.BlockSize:]
+    plaintext := make([]byte, len(ciphertext))
+    cipher.NewCTR(k.block, iv).XORKeyStream(plaintext, ciphertext)
+    if !hmac.Equal(iv, k.iv(plaintext)) {
+        return "", fmt.Errorf("email encrypted with data key %q does not decrypt", k.id)
+    }
+    return string(plaintext), nil
+}
+
+// emailKeyring is the data keys by ID; the first encrypts.
+type emailKeyring struct {
+    keys []*dataKey
+    byID map[string]*dataKey
+}
+
+// openEmailKeyring unwraps the data keys of EMAIL_DATA_KEYS, returning nil
+// if EMAIL_ENCRYPTION_KEY is not set.
+func openEmailKeyring(ctx context.Context) (*emailKeyring, error) {
+    if config.EmailEncryptionKey == "" {
+        if config.EmailDataKeys != "" {
+            return nil, errors.New("EMAIL_DATA_KEYS is set without EMAIL_ENCRYPTION_KEY")
+        }
+        return nil, nil
+    }
+    wrapper, err := newLocalKeyWrapper(config.EmailEncryptionKey)
+    if err != nil {
+        return nil, err
+    }
+    return loadEmailKeyring(ctx, wrapper, config.EmailDataKeys)
+}
+
+// loadEmailKeyring parses value, a comma-separated list of
+// <id>:<base64 wrapped key>, and unwraps the keys with wrapper.
+func loadEmailKeyring(ctx context.Context, wrapper keyWrapper, value string) (*emailKeyring, error) {
+    ring := &emailKeyring{byID: make(map[string]*dataKey)}
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item == "" {
+            continue
+        }
+        id, encoded, _ := strings.Cut(item, ":")
+        if !dataKeyIDPattern.MatchString(id) {
+            return nil, fmt.Errorf("EMAIL_DATA_KEYS: invalid key ID %q, use up to 32 lower-case letters, digits and -", id)
+        }
+        if ring.byID[id] != nil {
+            return nil, fmt.Errorf("EMAIL_DATA_KEYS: key ID %q is used twice", id)
+        }
+        wrapped, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil {
+            return nil, fmt.Errorf("EMAIL_DATA_KEYS: key %q is not base64", id)
+        }
+        raw, err := wrapper.unwrapKey(ctx, wrapped)
+        if err != nil {
+            return nil, fmt.Errorf("EMAIL_DATA_KEYS: unwrap key %q: %w", id, err)
+        }
+        key, err := newDataKey(id, raw)
+        if err != nil {
+            return nil, fmt.Errorf("EMAIL_DATA_KEYS: %w", err)
+        }
+        ring.keys = append(ring.keys, key)
+        ring.byID[id] = key
+    }
+    if len(ring.keys) == 0 {
+        return nil, errors.New(`EMAIL_ENCRYPTION_KEY is set but EMAIL_DATA_KEYS is empty; create a data key with "user-api gen-email-key"`)
+    }
+    return ring, nil
+}
+
+// encrypt encrypts email, in lower case, with the first key.
+func (r *emailKeyring) encrypt(email string) string {
+    return r.keys[0].encrypt(strings.ToLower(email))
+}
+
+// current reports whether stored was encrypted with the first key.
+func (r *emailKeyring) current(stored string) bool {
+    return strings.HasPrefix(stored, encryptedEmailPrefix+r.keys[0].id+":")
+}
+
+// decrypt returns the email stored encrypted, or stored itself if it was
+// written before encryption was turned on.
+func (r *emailKeyring) decrypt(stored string) (string, error) {
+    rest, ok := strings.CutPrefix(stored, encryptedEmailPrefix)
+    if !ok {
+        return stored, nil
+    }
+    id, encoded, _ := strings.Cut(rest, ":")
+    key := r.byID[id]
+    if key == nil {
+        return "", fmt.Errorf("email encrypted with unknown data key %q", id)
+    }
+    data, err := emailEncoding.DecodeString(encoded)
+    if err != nil {
+        return "", fmt.Errorf("email encrypted with data key %q is not base32", id)
+    }
+    return key.decrypt(data)
+}
+
+// storedForms returns the values email may be stored as: encrypted with
+// each key, first key first, then in the clear.
+func (r *emailKeyring) storedForms(email string) []string {
+    forms := make([]string, 0, len(r.keys)+1)
+    for _, key := range r.keys {
+        forms = append(forms, key.encrypt(strings.ToLower(email)))
+    }
+    return append(forms, email)
+}
+
+// encryptingRepository encrypts the emails of the users written to the
+// repository it wraps and decrypts those read from it.
+type encryptingRepository struct {
+    UserRepository
+    keys *emailKeyring
+}
+
+// encryptEmails wraps repo to encrypt emails with keys, if there are any.
+func encryptEmails(repo UserRepository, keys *emailKeyring) UserRepository {
+    if keys == nil {
+        return repo
+    }
+    return &encryptingRepository{UserRepository: repo, keys: keys}
+}
+
+// Unwrap returns the repository the encrypted emails are stored in.
+func (e *encryptingRepository) Unwrap() UserRepository {
+    return e.UserRepository
+}
+
+func (e *encryptingRepository) decryptUser(user User) (User, error) {
+    email, err := e.keys.decrypt(user.Email)
+    if err != nil {
+        return User{}, fmt.Errorf("user %d: %w", user.ID, err)
+    }
+    user.Email = email
+    return user, nil
+}
+
+func (e *encryptingRepository) List(ctx context.Context) ([]User, error) {
+    users, err := e.UserRepository.List(ctx)
+    if err != nil {
+        return nil, err
+    }
+    for i := range users {
+        if users[i], err = e.decryptUser(users[i]); err != nil {
+            return nil, err
+        }
+    }
+    return users, nil
+}
+
+func (e *encryptingRepository) Get(ctx context.Context, id int) (User, error) {
+    user, err := e.UserRepository.Get(ctx, id)
+    if err != nil {
+        return User{}, err
+    }
+    return e.decryptUser(user)
+}
+
+// GetByEmail looks email up in each form it may be stored in.
+func (e *encryptingRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+    for _, stored := range e.keys.storedForms(email) {
+        user, err := e.UserRepository.GetByEmail(ctx, stored)
+        if err == nil {
+            return e.decryptUser(user)
+        }
+        if !errors.Is(err, ErrNotFound) {
+            return User{}, err
+        }
+    }
+    return User{}, ErrNotFound
+}
+
+// emailTaken reports whether a user other than id has email stored in a
+// form other than the current one, which the backend cannot tell apart.
+func (e *encryptingRepository) emailTaken(ctx context.Context, email string, id int) (bool, error) {
+    for _, stored := range e.keys.storedForms(email)[1:] {
+        user, err := e.UserRepository.GetByEmail(ctx, stored)
+        if err == nil && user.ID != id {
+            return true, nil
+        }
+        if err != nil && !errors.Is(err, ErrNotFound) {
+            return false, err
+        }
+    }
+    return false, nil
+}
+
+func (e *encryptingRepository) Insert(ctx context.Context, user User) (User, error) {
+    if taken, err := e.emailTaken(ctx, user.Email, 0); err != nil || taken {
+        if err == nil {
+            err = errEmailTaken
+        }
+        return User{}, err
+    }
+    user.Email = e.keys.encrypt(user.Email)
+    inserted, err := e.UserRepository.Insert(ctx, user)
+    if err != nil {
+        return User{}, err
+    }
+    return e.decryptUser(inserted)
+}
+
+func (e *encryptingRepository) Update(ctx context.Context, user User) (User, error) {
+    if taken, err := e.emailTaken(ctx, user.Email, user.ID); err != nil || taken {
+        if err == nil {
+            err = errEmailTaken
+        }
+        return User{}, err
+    }
+    user.Email = e.keys.encrypt(user.Email)
+    updated, err := e.UserRepository.Update(ctx, user)
+    if err != nil {
+        return User{}, err
+    }
+    return e.decryptUser(updated)
+}
+
+// Stats aggregates the decrypted users, as the backend's own queries would
+// see ciphertexts instead of domains.
+func (e *encryptingRepository) Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error) {
+    users, err := e.List(ctx)
+    if err != nil {
+        return UserStats{}, err
+    }
+    return (&memoryUserRepository{users: users}).Stats(ctx, days, top, now)
+}
+
+func (e *encryptingRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    return e.UserRepository.WithTx(ctx, func(tx UserRepository) error {
+        return fn(&encryptingRepository{UserRepository: tx, keys: e.keys})
+    })
+}
+
+// emailRewriter is implemented by the backends whose emails can be
+// re-encrypted. rewriteEmail replaces the stored email of user id with to if
+// it still is from, and reports whether it did. The version is left as it
+// is, as the user has not changed and its ETag stays valid.
+type emailRewriter interface {
+    rewriteEmail(ctx context.Context, id int, from, to string) (bool, error)
+}
+
+// reencrypt encrypts every email not encrypted with the first key with it,
+// returning how many it changed. Users written in the meantime are skipped,
+// as the write encrypted them again.
+func (e *encryptingRepository) reencrypt(ctx context.Context) (int, error) {
+    rewriter, ok := e.UserRepository.(emailRewriter)
+    if !ok {
+        return 0, errors.New("this storage backend cannot rewrite emails")
+    }
+    users, err := e.UserRepository.List(ctx)
+    if err != nil {
+        return 0, err
+    }
+    changed := 0
+    for _, user := range users {
+        if e.keys.current(user.Email) {
+            continue
+        }
+        email, err := e.keys.decrypt(user.Email)
+        if err != nil {
+            return changed, fmt.Errorf("user %d: %w", user.ID, err)
+        }
+        ok, err := rewriter.rewriteEmail(ctx, user.ID, user.Email, e.keys.encrypt(email))
+        if err != nil {
+            return changed, fmt.Errorf("user %d: %w", user.ID, err)
+        }
+        if ok {
+            changed++
+        }
+    }
+    return changed, nil
+}
+
+// runGenEmailKey implements the gen-email-key command: it prints a new
+// data key, wrapped with EMAIL_ENCRYPTION_KEY, as an EMAIL_DATA_KEYS entry.
+func runGenEmailKey(args []string) int {
+    fs := flag.NewFlagSet("gen-email-key", flag.ContinueOnError)
+    id := fs.String("id", "", "ID of the key, e.g. 2025-06; lower-case letters, digits and -")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+    if !dataKeyIDPattern.MatchString(*id) {
+        fmt.Fprintln(os.Stderr, "gen-email-key: -id must be up to 32 lower-case letters, digits and -")
+        return 2
+    }
+    wrapper, err := newLocalKeyWrapper(config.EmailEncryptionKey)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "gen-email-key: %v\n", err)
+        return 1
+    }
+    key := make([]byte, 32)
+    if _, err := rand.Read(key); err != nil {
+        fmt.Fprintf(os.Stderr, "gen-email-key: %v\n", err)
+        return 1
+    }
+    wrapped, err := wrapper.wrapKey(context.Background(), key)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "gen-email-key: %v\n", err)
+        return 1
+    }
+    fmt.Printf("%s:%s\n", *id, base64.StdEncoding.EncodeToString(wrapped))
+    return 0
+}
+
+// runReencryptEmails implements the reencrypt-emails command, run after a
+// new data key was put first in EMAIL_DATA_KEYS.
+func runReencryptEmails(args []string) int {
+    fs := flag.NewFlagSet("reencrypt-emails", flag.ContinueOnError)
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+    ctx := context.Background()
+    keys, err := openEmailKeyring(ctx)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "reencrypt-emails: %v\n", err)
+        return 1
+    }
+    if keys == nil {
+        fmt.Fprintln(os.Stderr, "reencrypt-emails: EMAIL_ENCRYPTION_KEY is not set")
+        return 1
+    }
+    repo, err := openStorageBackend(ctx)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "reencrypt-emails: %v\n", err)
+        return 1
+    }
+    defer repo.Close()
+    if _, ok := repo.(*memoryUserRepository); ok {
+        fmt.Fprintln(os.Stderr, "reencrypt-emails: the memory backend lives in the server process and is encrypted as it is written")
+        return 1
+    }
+    changed, err := (&encryptingRepository{UserRepository: repo, keys: keys}).reencrypt(ctx)
+    fmt.Printf("Re-encrypted %d emails with data key %q\n", changed, keys.keys[0].id)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "reencrypt-emails: %v\n", err)
+        return 1
+    }
+    return 0
+}
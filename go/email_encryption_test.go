@@ -0,0 +1,82 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "strings"
+    "testing"
+)
+
+// testEmailKeyring returns a keyring of one new data key with the given ID.
+func testEmailKeyring(t *testing.T, id string) *emailKeyring {
+    t.Helper()
+    raw := make([]byte, 32)
+    rand.Read(raw)
+    key, err := newDataKey(id, raw)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &emailKeyring{keys: []*dataKey{key}, byID: map[string]*dataKey{id: key}}
+}
+
+func TestEmailKeyring(t *testing.T) {
+    kek := make([]byte, 32)
+    rand.Read(kek)
+    wrapper, err := newLocalKeyWrapper(base64.StdEncoding.EncodeToString(kek))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var entries []string
+    for _, id := range []string{"k2", "k1"} {
+        raw := make([]byte, 32)
+        rand.Read(raw)
+        wrapped, err := wrapper.wrapKey(context.Background(), raw)
+        if err != nil {
+            t.Fatal(err)
+        }
+        entries = append(entries, id+":"+base64.StdEncoding.EncodeToString(wrapped))
+    }
+    ring, err := loadEmailKeyring(context.Background(), wrapper, strings.Join(entries, ", "))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    stored := ring.encrypt("Ada@Example.com")
+    if stored != ring.encrypt("ada@example.com") || !strings.HasPrefix(stored, "enc:k2:") || stored != strings.ToLower(stored) {
+        t.Fatalf("encrypted %q", stored)
+    }
+    if email, err := ring.decrypt(stored); err != nil || email != "ada@example.com" {
+        t.Fatalf("decrypt: %q, %v", email, err)
+    }
+    if email, err := ring.decrypt("plain@example.com"); err != nil || email != "plain@example.com" {
+        t.Errorf("decrypt unencrypted: %q, %v", email, err)
+    }
+    // The last base32 character may carry only padding bits, so the first
+    // one of the ciphertext is changed.
+    i := len("enc:k2:")
+    tampered := stored[:i] + "a" + stored[i+1:]
+    if tampered == stored {
+        tampered = stored[:i] + "b" + stored[i+1:]
+    }
+    if _, err := ring.decrypt(tampered); err == nil {
+        t.Error("tampered ciphertext decrypted")
+    }
+    if _, err := ring.decrypt("enc:gone:" + stored[len("enc:k2:"):]); err == nil {
+        t.Error("unknown key decrypted")
+    }
+
+    for _, value := range []string{entries[0] + "," + entries[0], "UPPER:" + entries[0][3:], "k3:not-base64!", ""} {
+        if _, err := loadEmailKeyring(context.Background(), wrapper, value); err == nil {
+            t.Errorf("loaded %q", value)
+        }
+    }
+}
+
+func TestEncryptedEmailFitsMySQLColumn(t *testing.T) {
+    ring := testEmailKeyring(t, strings.Repeat("k", 32))
+    email := strings.Repeat("a", maxEmailLength-len("@example.com")) + "@example.com"
+    if n := len(ring.encrypt(email)); n > 512 {
+        t.Errorf("encrypted %d-character email is %d characters, more than the 512 of the MySQL column", len(email), n)
+    }
+}
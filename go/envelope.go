@@ -0,0 +1,17 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+)
+
+// wantsEnvelope reports whether the response to r should be wrapped in
+// APIResponse. RESPONSE_ENVELOPE=raw returns the resource itself at the top
+// level, which some API gateways expect; ?envelope=true|false overrides it
+// per request.
+func wantsEnvelope(r *http.Request) bool {
+    if v, err := strconv.ParseBool(r.URL.Query().Get("envelope")); err == nil {
+        return v
+    }
+    return config.DefaultEnvelope
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "go.opentelemetry.io/otel/trace"
+)
+
+var errorReportsTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "error_reports_total",
+        Help: "Total number of 5xx responses and panics reported to SENTRY_DSN or ERROR_WEBHOOK_URL by result",
+    },
+    []string{"result"},
+)
+
+func init() {
+    prometheus.MustRegister(errorReportsTotal)
+}
+
+// With SENTRY_DSN or ERROR_WEBHOOK_URL set, every 5xx response and every
+// panic is reported there with the request it happened in: method, path
+// without the query, route, status, request and trace IDs and the
+// authenticated subject, plus the error or the panic and its stack. Headers
+// and bodies are never sent, as they carry credentials and personal data.
+//
+// SENTRY_DSN sends Sentry events, tagged with SENTRY_ENVIRONMENT and the
+// release from the build info; ERROR_WEBHOOK_URL POSTs an ErrorReport as
+// JSON, for anything else. Reports are sent in the background, and dropped
+// rather than slowing requests down when errorReportQueue of them are
+// waiting, e.g. during an outage.
+
+const errorReportQueue = 100
+
+// ErrorReport is one 5xx response or panic, as POSTed to ERROR_WEBHOOK_URL.
+type ErrorReport struct {
+    Time      time.Time `json:"time"`
+    Message   string    `json:"message"`
+    Panic     bool      `json:"panic"`
+    Stack     string    `json:"stack,omitempty"`
+    Status    int       `json:"status"`
+    Method    string    `json:"method"`
+    Path      string    `json:"path"`
+    Route     string    `json:"route"`
+    RequestID string    `json:"request_id,omitempty"`
+    TraceID   string    `json:"trace_id,omitempty"`
+    Actor     string    `json:"actor,omitempty"`
+    Version   string    `json:"version"`
+}
+
+// errorReporter sends reports from a queue to each configured destination.
+type errorReporter struct {
+    client *http.Client
+    queue  chan ErrorReport
+    sinks  []func(context.Context, ErrorReport) error
+}
+
+// errorReports is nil unless error reporting is configured.
+var errorReports *errorReporter
+
+func setupErrorReporting() error {
+    if config.SentryDSN == "" && config.ErrorWebhookURL == "" {
+        return nil
+    }
+    rep := &errorReporter{client: newHTTPClient(10 * time.Second), queue: make(chan ErrorReport, errorReportQueue)}
+    if config.SentryDSN != "" {
+        sink, err := rep.sentrySink(config.SentryDSN)
+        if err != nil {
+            return err
+        }
+        rep.sinks = append(rep.sinks, sink)
+    }
+    if config.ErrorWebhookURL != "" {
+        if _, err := url.ParseRequestURI(config.ErrorWebhookURL); err != nil {
+            return fmt.Errorf("invalid ERROR_WEBHOOK_URL: %w", err)
+        }
+        rep.sinks = append(rep.sinks, rep.webhookSink(config.ErrorWebhookURL))
+    }
+    go rep.run()
+    errorReports = rep
+    slog.Info("Reporting errors", "sentry", config.SentryDSN != "", "webhook", config.ErrorWebhookURL != "")
+    return nil
+}
+
+func (rep *errorReporter) report(report ErrorReport) {
+    select {
+    case rep.queue <- report:
+    default:
+        errorReportsTotal.WithLabelValues("dropped").Inc()
+    }
+}
+
+func (rep *errorReporter) run() {
+    for report := range rep.queue {
+        for _, sink := range rep.sinks {
+            // The failed request's ID goes along in X-Request-ID.
+            ctx := context.WithValue(context.Background(), requestIDKey, report.RequestID)
+            ctx, cancel := context.WithTimeout(ctx, rep.client.Timeout)
+            err := sink(ctx, report)
+            cancel()
+            if err != nil {
+                errorReportsTotal.WithLabelValues("failed").Inc()
+                slog.Warn("Failed to report error", "error", err, "request_id", report.RequestID)
+                continue
+            }
+            errorReportsTotal.WithLabelValues("sent").Inc()
+        }
+    }
+}
+
+func (rep *errorReporter) post(ctx context.Context, target string, body any, header http.Header) error {
+    data, err := json.Marshal(body)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    for k, v := range header {
+        req.Header[k] = v
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := rep.client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+    }
+    return nil
+}
+
+func (rep *errorReporter) webhookSink(target string) func(context.Context, ErrorReport) error {
+    return func(ctx context.Context, report ErrorReport) error {
+        return rep.post(ctx, target, report, nil)
+    }
+}
+
+// sentrySink sends reports to the store endpoint of the project in dsn,
+// which has the form https://<public key>@<host>/<project ID>.
+func (rep *errorReporter) sentrySink(dsn string) (func(context.Context, ErrorReport) error, error) {
+    u, err := url.Parse(dsn)
+    if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+        return nil, errors.New("invalid SENTRY_DSN, want https://<key>@<host>/<project>")
+    }
+    i := strings.LastIndex(u.Path, "/")
+    project := u.Path[i+1:]
+    if _, err := strconv.Atoi(project); err != nil {
+        return nil, errors.New("invalid SENTRY_DSN: no project ID")
+    }
+    store := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], project)
+    header := http.Header{}
+    header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=user-api/%s, sentry_key=%s", buildInfo.Version, u.User.Username()))
+    host, _ := os.Hostname()
+    return func(ctx context.Context, report ErrorReport) error {
+        return rep.post(ctx, store, sentryEvent(report, host), header)
+    }, nil
+}
+
+// sentryEvent converts report to a Sentry event. The stack is attached as
+// text, as Go's formatted stacks do not map onto Sentry frames reliably.
+func sentryEvent(report ErrorReport, host string) map[string]any {
+    id := make([]byte, 16)
+    rand.Read(id)
+    level, kind := "error", "error"
+    if report.Panic {
+        level, kind = "fatal", "panic"
+    }
+    event := map[string]any{
+        "event_id":    hex.EncodeToString(id),
+        "timestamp":   report.Time.Format(time.RFC3339Nano),
+        "level":       level,
+        "platform":    "go",
+        "logger":      "user-api",
+        "server_name": host,
+        "release":     report.Version,
+        "transaction": report.Method + " " + report.Route,
+        "exception": map[string]any{
+            "values": []map[string]any{{"type": kind, "value": report.Message}},
+        },
+        "request": map[string]any{"method": report.Method, "url": report.Path},
+        "tags": map[string]string{
+            "route":      report.Route,
+            "status":     strconv.Itoa(report.Status),
+            "request_id": report.RequestID,
+            "trace_id":   report.TraceID,
+        },
+    }
+    if config.SentryEnvironment != "" {
+        event["environment"] = config.SentryEnvironment
+    }
+    if report.Actor != "" {
+        event["user"] = map[string]string{"id": report.Actor}
+    }
+    if report.Stack != "" {
+        event["extra"] = map[string]string{"stack": report.Stack}
+    }
+    return event
+}
+
+// capturedError is what the handlers of a request noted for its report.
+type capturedError struct {
+    err   error
+    panic any
+    stack []byte
+    actor string
+}
+
+type errorCaptureKeyType struct{}
+
+var errorCaptureKey errorCaptureKeyType
+
+// noteError records the error behind a 500 for the report, along with the
+// subject the request was authenticated as, which only the inner
+// middleware knows.
+func noteError(r *http.Request, err error) {
+    if c, ok := r.Context().Value(errorCaptureKey).(*capturedError); ok {
+        c.err = err
+        c.actor = requestActor(r)
+    }
+}
+
+func notePanic(r *http.Request, v any, stack []byte) {
+    if c, ok := r.Context().Value(errorCaptureKey).(*capturedError); ok {
+        c.panic, c.stack = v, stack
+        c.actor = requestActor(r)
+    }
+}
+
+func requestActor(r *http.Request) string {
+    if p, ok := principalFromContext(r.Context()); ok {
+        return p.Subject
+    }
+    return ""
+}
+
+// errorReportingMiddleware reports the 5xx responses and panics of the
+// requests it wraps. It runs outside recoveryMiddleware, so it sees the 500
+// a panic is turned into, and also panics re-raised to abort a response.
+func errorReportingMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if errorReports == nil {
+            next.ServeHTTP(w, r)
+            return
+        }
+        c := &capturedError{}
+        rec := getStatusRecorder(w)
+        defer putStatusRecorder(rec)
+        defer func() {
+            if c.panic == nil && rec.status < 500 {
+                return
+            }
+            report := ErrorReport{
+                Time:      time.Now().UTC(),
+                Message:   http.StatusText(rec.status),
+                Status:    rec.status,
+                Method:    r.Method,
+                Path:      r.URL.Path,
+                Route:     routeTemplate(r),
+                RequestID: requestIDFromContext(r.Context()),
+                Actor:     c.actor,
+                Version:   buildInfo.Version,
+            }
+            if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+                report.TraceID = sc.TraceID().String()
+            }
+            if c.err != nil {
+                report.Message = c.err.Error()
+            }
+            if c.panic != nil {
+                report.Panic, report.Message, report.Stack = true, fmt.Sprint(c.panic), string(c.stack)
+            }
+            errorReports.report(report)
+        }()
+        next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), errorCaptureKey, c)))
+    })
+}
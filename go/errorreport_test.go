@@ -0,0 +1,99 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+func TestErrorReporting(t *testing.T) {
+    received := make(chan *http.Request, 10)
+    bodies := make(chan map[string]any, 10)
+    collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var body map[string]any
+        json.NewDecoder(r.Body).Decode(&body)
+        received <- r
+        bodies <- body
+    }))
+    defer collector.Close()
+
+    defer func(dsn, hook string, rep *errorReporter) {
+        config.SentryDSN, config.ErrorWebhookURL, errorReports = dsn, hook, rep
+    }(config.SentryDSN, config.ErrorWebhookURL, errorReports)
+    config.SentryDSN = strings.Replace(collector.URL, "://", "://public@", 1) + "/42"
+    config.ErrorWebhookURL = collector.URL + "/hook"
+    if err := setupErrorReporting(); err != nil {
+        t.Fatal(err)
+    }
+
+    r := mux.NewRouter()
+    r.Use(errorReportingMiddleware)
+    r.Use(recoveryMiddleware)
+    r.HandleFunc("/users/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+        r = r.WithContext(context.WithValue(r.Context(), principalKey, Principal{Subject: "7"}))
+        writeError(w, r, errors.New("connection reset"))
+    })
+    r.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+    r.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+
+    for _, path := range []string{"/ok", "/users/1", "/boom"} {
+        r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path+"?token=secret", nil))
+    }
+
+    reports := make(map[string]map[string]any)
+    for i := 0; i < 4; i++ {
+        select {
+        case req := <-received:
+            body := <-bodies
+            switch req.URL.Path {
+            case "/api/42/store/":
+                if !strings.Contains(req.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+                    t.Errorf("Sentry event without the key: %q", req.Header.Get("X-Sentry-Auth"))
+                }
+                reports["sentry "+body["transaction"].(string)] = body
+            case "/hook":
+                reports["hook "+body["route"].(string)] = body
+            default:
+                t.Errorf("report sent to %s", req.URL.Path)
+            }
+        case <-time.After(2 * time.Second):
+            t.Fatalf("got %d reports, want 4", i)
+        }
+    }
+
+    hook := reports["hook /users/{id:[0-9]+}"]
+    if hook["message"] != "connection reset" || hook["actor"] != "7" || hook["status"] != float64(500) || hook["path"] != "/users/1" {
+        t.Errorf("webhook report %v", hook)
+    }
+    if p := reports["hook /boom"]; p["panic"] != true || !strings.Contains(p["stack"].(string), "errorreport_test.go") {
+        t.Errorf("panic report %v", p)
+    }
+    sentry := reports["sentry GET /boom"]
+    if sentry["level"] != "fatal" || sentry["platform"] != "go" {
+        t.Errorf("Sentry event %v", sentry)
+    }
+    if _, ok := reports["sentry GET /users/{id:[0-9]+}"]; !ok {
+        t.Errorf("500 not sent to Sentry: %v", reports)
+    }
+    select {
+    case req := <-received:
+        t.Errorf("unexpected report to %s", req.URL.Path)
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    if v := redactConfigValue("SENTRY_DSN", "https://abc123@sentry.example.com/42"); strings.Contains(v, "abc123") {
+        t.Errorf("support bundle shows the Sentry key: %s", v)
+    }
+
+    config.SentryDSN = "https://sentry.example.com/42"
+    if err := setupErrorReporting(); err == nil {
+        t.Error("DSN without a key accepted")
+    }
+}
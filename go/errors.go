@@ -0,0 +1,129 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strings"
+)
+
+// ErrNotFound is returned by the store when a record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrVersionConflict is returned by the store when an update carries a
+// version other than the stored one, i.e. the user changed since it was read.
+var ErrVersionConflict = errors.New("version conflict")
+
+const (
+    constraintUnique     = "unique"
+    constraintForeignKey = "foreign_key"
+    constraintCheck      = "check"
+)
+
+// ConstraintError is returned by the store when a write violates a
+// constraint. Backends translate their native violations into this type so
+// handlers can report the offending field instead of a generic 500.
+type ConstraintError struct {
+    Kind    string
+    Field   string
+    Message string
+}
+
+func (e *ConstraintError) Error() string {
+    return fmt.Sprintf("%s constraint violated on %s: %s", e.Kind, e.Field, e.Message)
+}
+
+// errEmailTaken is returned by repositories when an email is already in use.
+var errEmailTaken = &ConstraintError{Kind: constraintUnique, Field: "email", Message: "email is already registered"}
+
+// FieldError describes a problem with a single request field.
+type FieldError struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// ValidationError collects every field that failed validation.
+type ValidationError struct {
+    Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+    messages := make([]string, len(e.Fields))
+    for i, f := range e.Fields {
+        messages[i] = f.Message
+    }
+    return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, message string) {
+    e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// HTTPError is a request-level failure, such as malformed input or missing
+// credentials, that already knows the status it should be reported with.
+type HTTPError struct {
+    Status int
+    Detail string
+}
+
+func (e *HTTPError) Error() string {
+    return e.Detail
+}
+
+func httpError(status int, detail string) error {
+    return &HTTPError{Status: status, Detail: detail}
+}
+
+// problemFor maps an error to a problem document: unique violations become
+// 409, other constraint and validation failures 422, missing records 404,
+// version conflicts 412, HTTPErrors their own status, and anything else 500.
+func problemFor(err error) Problem {
+    var constraint *ConstraintError
+    var validation *ValidationError
+    var httpErr *HTTPError
+    switch {
+    case errors.As(err, &validation):
+        return Problem{
+            Type:   problemTypeValidation,
+            Title:  "Validation failed",
+            Status: http.StatusUnprocessableEntity,
+            Detail: validation.Error(),
+            Errors: validation.Fields,
+        }
+    case errors.As(err, &constraint):
+        status := http.StatusUnprocessableEntity
+        if constraint.Kind == constraintUnique {
+            status = http.StatusConflict
+        }
+        return Problem{
+            Type:   problemTypeConstraint,
+            Title:  "Constraint violation",
+            Status: status,
+            Detail: constraint.Message,
+            Errors: []FieldError{{Field: constraint.Field, Message: constraint.Message}},
+        }
+    case errors.Is(err, ErrTeamNotFound):
+        return newProblem(http.StatusNotFound, "Team not found")
+    case errors.Is(err, ErrMembershipNotFound):
+        return newProblem(http.StatusNotFound, "User is not a member of the team")
+    case errors.Is(err, ErrNotFound):
+        return newProblem(http.StatusNotFound, "User not found")
+    case errors.Is(err, ErrVersionConflict):
+        return newProblem(http.StatusPreconditionFailed, "User was modified by another request; fetch it again and retry")
+    case errors.As(err, &httpErr):
+        return newProblem(httpErr.Status, httpErr.Detail)
+    default:
+        return newProblem(http.StatusInternalServerError, "")
+    }
+}
+
+// writeError reports err to the client as a problem document.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+    p := problemFor(err)
+    if p.Status == http.StatusInternalServerError {
+        slog.ErrorContext(r.Context(), "Internal error", "error", err)
+        noteError(r, err)
+    }
+    writeProblem(w, r, p)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "reflect"
+    "testing"
+)
+
+func TestProblemFor(t *testing.T) {
+    validation := &ValidationError{}
+    validation.add("name", "name is required")
+    validation.add("email", "email is invalid")
+    for _, tc := range []struct {
+        name   string
+        err    error
+        status int
+        typ    string
+        detail string
+        fields []FieldError
+    }{
+        {"validation", validation, http.StatusUnprocessableEntity, problemTypeValidation, "name is required; email is invalid", validation.Fields},
+        {"unique", errEmailTaken, http.StatusConflict, problemTypeConstraint, "email is already registered", []FieldError{{Field: "email", Message: "email is already registered"}}},
+        {"wrapped unique", fmt.Errorf("insert: %w", errEmailTaken), http.StatusConflict, problemTypeConstraint, "email is already registered", []FieldError{{Field: "email", Message: "email is already registered"}}},
+        {"foreign key", &ConstraintError{Kind: constraintForeignKey, Field: "user_id", Message: "user does not exist"}, http.StatusUnprocessableEntity, problemTypeConstraint, "user does not exist", []FieldError{{Field: "user_id", Message: "user does not exist"}}},
+        {"check", &ConstraintError{Kind: constraintCheck, Field: "name", Message: "name is too long"}, http.StatusUnprocessableEntity, problemTypeConstraint, "name is too long", []FieldError{{Field: "name", Message: "name is too long"}}},
+        {"user not found", ErrNotFound, http.StatusNotFound, problemTypeBlank, "User not found", nil},
+        {"team not found", ErrTeamNotFound, http.StatusNotFound, problemTypeBlank, "Team not found", nil},
+        {"membership not found", ErrMembershipNotFound, http.StatusNotFound, problemTypeBlank, "User is not a member of the team", nil},
+        {"version conflict", ErrVersionConflict, http.StatusPreconditionFailed, problemTypeBlank, "User was modified by another request; fetch it again and retry", nil},
+        {"http error", httpError(http.StatusTooManyRequests, "Slow down"), http.StatusTooManyRequests, problemTypeBlank, "Slow down", nil},
+        // Other errors may carry internals, so their text is not sent.
+        {"other", errors.New("dial tcp 10.0.0.5:5432: connection refused"), http.StatusInternalServerError, problemTypeBlank, "", nil},
+    } {
+        p := problemFor(tc.err)
+        if p.Status != tc.status || p.Type != tc.typ || p.Detail != tc.detail || !reflect.DeepEqual(p.Errors, tc.fields) {
+            t.Errorf("%s: got %+v", tc.name, p)
+        }
+        if p.Title == "" {
+            t.Errorf("%s: no title", tc.name)
+        }
+    }
+}
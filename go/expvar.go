@@ -0,0 +1,62 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "runtime"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// /debug/vars serves the expvar variables as one JSON object: the standard
+// memstats and cmdline plus a few application counters, for a quick look
+// with curl in containers that have no Prometheus at hand:
+//
+//	curl -s localhost:9090/debug/vars | jq '{users_stored, cache_requests}'
+//
+// It is guarded like the profiles: admins only on the API port, open on
+// METRICS_PORT.
+func init() {
+    expvar.Publish("build", expvar.Func(func() any { return buildInfo }))
+    expvar.Publish("storage_backend", expvar.Func(func() any { return storageBackendName() }))
+    expvar.Publish("users_stored", expvar.Func(usersStored))
+    expvar.Publish("cache_requests", expvar.Func(func() any { return gatheredCounter("http_cache_requests_total", "result") }))
+    expvar.Publish("requests_total", expvar.Func(func() any { return requestStats.snapshot().RequestsTotal }))
+    expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+}
+
+// usersStored counts the users in storage, or returns the error as a string
+// when storage cannot say.
+func usersStored() any {
+    if userRepo == nil {
+        return nil
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout.get())
+    defer cancel()
+    stats, err := userRepo.Stats(ctx, 1, 0, time.Now())
+    if err != nil {
+        return err.Error()
+    }
+    return stats.Total
+}
+
+// gatheredCounter returns the values of the counter family name from the
+// Prometheus registry, by the value of label.
+func gatheredCounter(name, label string) map[string]float64 {
+    values := make(map[string]float64)
+    families, _ := prometheus.DefaultGatherer.Gather()
+    for _, family := range families {
+        if family.GetName() != name {
+            continue
+        }
+        for _, m := range family.GetMetric() {
+            for _, l := range m.GetLabel() {
+                if l.GetName() == label {
+                    values[l.GetValue()] += m.GetCounter().GetValue()
+                }
+            }
+        }
+    }
+    return values
+}
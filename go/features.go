@@ -0,0 +1,199 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Feature flags turn parts of the API on or off without a release, so an
+// experimental endpoint can ship dark and be enabled per environment, and a
+// costly one can be switched off during an incident. Each flag is a setting
+// named FEATURE_<NAME>, e.g. FEATURE_BULK_JOBS=false or
+//
+//	feature:
+//	  bulk_jobs: false
+//
+// in CONFIG_FILE, and changes with a reload. With FEATURE_FLAGS_URL set, the
+// flags are also fetched from a flag service every FEATURE_FLAGS_REFRESH
+// (default 30s) as a JSON object of booleans by flag name; its values win
+// over the settings, and the last ones fetched are kept while it is down.
+// GET /admin/features lists the effective flags and where they came from.
+
+// The flags. Handlers consult them with enabled, or are wrapped in
+// withFeature.
+var (
+    featureBulkJobs = newFeature("bulk_jobs", true, "Bulk import and bulk delete of users")
+)
+
+// FeatureProvider supplies flag values from outside the process, such as a
+// flag service. Flags it has no value for fall back to the settings.
+type FeatureProvider interface {
+    FeatureFlags(ctx context.Context) (map[string]bool, error)
+}
+
+// Feature is a flag as GET /admin/features reports it. Source is
+// "provider", "config" or "default".
+type Feature struct {
+    Name        string `json:"name"`
+    Description string `json:"description"`
+    Enabled     bool   `json:"enabled"`
+    Default     bool   `json:"default"`
+    Source      string `json:"source"`
+}
+
+type feature struct {
+    name        string
+    description string
+    def         bool
+    state       atomic.Pointer[Feature]
+}
+
+var (
+    features = make(map[string]*feature)
+    // providedFeatures holds the values last fetched from the provider.
+    providedFeatures atomic.Pointer[map[string]bool]
+)
+
+func newFeature(name string, def bool, description string) *feature {
+    f := &feature{name: name, description: description, def: def}
+    features[name] = f
+    f.state.Store(f.defaultState())
+    reloadableSetting(f.load, f.key())
+    return f
+}
+
+func (f *feature) key() string {
+    return "FEATURE_" + strings.ToUpper(f.name)
+}
+
+func (f *feature) defaultState() *Feature {
+    return &Feature{Name: f.name, Description: f.description, Enabled: f.def, Default: f.def, Source: "default"}
+}
+
+// load works out the value of the flag from the provider, the settings and
+// the default, in that order. An invalid setting leaves the flag as it was.
+func (f *feature) load() error {
+    state := f.defaultState()
+    if provided := providedFeatures.Load(); provided != nil {
+        if enabled, ok := (*provided)[f.name]; ok {
+            state.Enabled, state.Source = enabled, "provider"
+            f.state.Store(state)
+            return nil
+        }
+    }
+    if value := getenv(f.key()); value != "" {
+        var env envReader
+        state.Enabled = env.bool(f.key(), f.def)
+        if err := env.err(); err != nil {
+            return err
+        }
+        state.Source = "config"
+    }
+    f.state.Store(state)
+    return nil
+}
+
+func (f *feature) enabled() bool {
+    return f.state.Load().Enabled
+}
+
+// withFeature answers 404 for the route while f is off, as if it did not
+// exist.
+func withFeature(f *feature, h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !f.enabled() {
+            notFoundHandler(w, r)
+            return
+        }
+        h(w, r)
+    }
+}
+
+// currentFeatures returns the effective flags by name.
+func currentFeatures() []Feature {
+    list := make([]Feature, 0, len(features))
+    for _, f := range features {
+        list = append(list, *f.state.Load())
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list
+}
+
+// listFeaturesHandler serves GET /admin/features.
+func listFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "success", Data: currentFeatures()})
+}
+
+// setupFeatureProvider starts polling FEATURE_FLAGS_URL, if set. The first
+// fetch is waited for, so the server starts with the flags it will serve
+// with, but its failure only logs.
+func setupFeatureProvider() {
+    if config.FeatureFlagsURL == "" {
+        return
+    }
+    p := &httpFeatureProvider{url: config.FeatureFlagsURL, client: newHTTPClient(10 * time.Second)}
+    refreshFeatures(p)
+    slog.Info("Fetching feature flags", "url", redactConfigValue("FEATURE_FLAGS_URL", config.FeatureFlagsURL), "refresh", config.FeatureFlagsRefresh.String())
+    if config.FeatureFlagsRefresh > 0 {
+        go func() {
+            for range time.Tick(config.FeatureFlagsRefresh) {
+                refreshFeatures(p)
+            }
+        }()
+    }
+}
+
+// refreshFeatures fetches the flags from p and applies them, logging the
+// ones that changed.
+func refreshFeatures(p FeatureProvider) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    provided, err := p.FeatureFlags(ctx)
+    if err != nil {
+        slog.Warn("Failed to fetch feature flags, keeping the last ones", "error", err)
+        return
+    }
+    providedFeatures.Store(&provided)
+    for _, f := range features {
+        before := f.enabled()
+        // An invalid setting was reported when it was read.
+        f.load()
+        if after := f.enabled(); after != before {
+            slog.Info("Feature flag changed", "feature", f.name, "enabled", after)
+        }
+    }
+}
+
+// httpFeatureProvider GETs the flags as a JSON object of booleans.
+type httpFeatureProvider struct {
+    url    string
+    client *http.Client
+}
+
+func (p *httpFeatureProvider) FeatureFlags(ctx context.Context) (map[string]bool, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/json")
+    resp, err := p.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("feature flag service returned %s", resp.Status)
+    }
+    var flags map[string]bool
+    if err := json.NewDecoder(resp.Body).Decode(&flags); err != nil {
+        return nil, fmt.Errorf("decode feature flags: %w", err)
+    }
+    return flags, nil
+}
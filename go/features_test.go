@@ -0,0 +1,55 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+type staticFeatureProvider map[string]bool
+
+func (p staticFeatureProvider) FeatureFlags(ctx context.Context) (map[string]bool, error) {
+    return p, nil
+}
+
+func TestFeatureFlags(t *testing.T) {
+    f := &feature{name: "test_dark", def: false}
+    features[f.name] = f
+    defer delete(features, f.name)
+    defer providedFeatures.Store(nil)
+
+    f.load()
+    if f.enabled() || f.state.Load().Source != "default" {
+        t.Errorf("default: %+v", *f.state.Load())
+    }
+    h := withFeature(f, func(w http.ResponseWriter, r *http.Request) {})
+    rec := httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodGet, "/dark", nil))
+    if rec.Code != http.StatusNotFound {
+        t.Errorf("disabled route answered %d", rec.Code)
+    }
+
+    t.Setenv("FEATURE_TEST_DARK", "true")
+    f.load()
+    if !f.enabled() || f.state.Load().Source != "config" {
+        t.Errorf("config: %+v", *f.state.Load())
+    }
+    rec = httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodGet, "/dark", nil))
+    if rec.Code != http.StatusOK {
+        t.Errorf("enabled route answered %d", rec.Code)
+    }
+
+    refreshFeatures(staticFeatureProvider{"test_dark": false})
+    if f.enabled() || f.state.Load().Source != "provider" {
+        t.Errorf("provider: %+v", *f.state.Load())
+    }
+    found := false
+    for _, listed := range currentFeatures() {
+        found = found || listed.Name == "test_dark"
+    }
+    if !found {
+        t.Error("flag not listed")
+    }
+}
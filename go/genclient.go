@@ -0,0 +1,685 @@
+package main
+
+import (
+    "bytes"
+    _ "embed"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "go/format"
+    "go/token"
+    "io"
+    "net/http"
+    "os"
+    "sort"
+    "strings"
+    "text/template"
+    "unicode"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(openAPISpec)
+}
+
+// apiSpec is the subset of OpenAPI 3 understood by the client generator.
+type apiSpec struct {
+    Info struct {
+        Title   string `json:"title"`
+        Version string `json:"version"`
+    } `json:"info"`
+    Paths      map[string]map[string]apiOperation `json:"paths"`
+    Components struct {
+        Schemas map[string]*apiSchema `json:"schemas"`
+    } `json:"components"`
+}
+
+type apiOperation struct {
+    OperationID string         `json:"operationId"`
+    Summary     string         `json:"summary"`
+    Parameters  []apiParameter `json:"parameters"`
+    RequestBody *struct {
+        Content map[string]struct {
+            Schema *apiSchema `json:"schema"`
+        } `json:"content"`
+    } `json:"requestBody"`
+    Responses map[string]struct {
+        Content map[string]struct {
+            Schema *apiSchema `json:"schema"`
+        } `json:"content"`
+    } `json:"responses"`
+}
+
+type apiParameter struct {
+    Name     string     `json:"name"`
+    In       string     `json:"in"`
+    Required bool       `json:"required"`
+    Schema   *apiSchema `json:"schema"`
+}
+
+type apiSchema struct {
+    Ref        string                `json:"$ref"`
+    Type       string                `json:"type"`
+    Format     string                `json:"format"`
+    Items      *apiSchema            `json:"items"`
+    Properties map[string]*apiSchema `json:"properties"`
+    Required   []string              `json:"required"`
+    OneOf      []*apiSchema          `json:"oneOf"`
+}
+
+// clientModel is the language-neutral view of the spec fed to the templates.
+type clientModel struct {
+    Package    string
+    Title      string
+    Version    string
+    Types      []clientType
+    Operations []clientOperation
+    UsesTime   bool
+    UsesBytes  bool
+}
+
+type clientType struct {
+    Name   string
+    Fields []clientField
+}
+
+type clientField struct {
+    Name     string
+    Schema   *apiSchema
+    Required bool
+}
+
+type clientOperation struct {
+    Name        string
+    Summary     string
+    Method      string
+    Path        string
+    PathParams  []apiParameter
+    QueryParams []apiParameter
+    Body        *apiSchema
+    RawBody     bool
+    Result      *apiSchema
+}
+
+// runGenClient implements the gen-client subcommand. It reads the served (or
+// embedded) OpenAPI spec and writes a typed client for the requested language.
+func runGenClient(args []string) int {
+    fs := flag.NewFlagSet("gen-client", flag.ContinueOnError)
+    lang := fs.String("lang", "go", "client language: go or ts")
+    specSource := fs.String("spec", "", "spec URL or file path (defaults to the spec embedded in this binary)")
+    out := fs.String("out", "", "output file (defaults to stdout)")
+    pkg := fs.String("package", "client", "package name for the Go client")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    raw, err := loadSpec(*specSource)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "gen-client: %v\n", err)
+        return 1
+    }
+    var spec apiSpec
+    if err := json.Unmarshal(raw, &spec); err != nil {
+        fmt.Fprintf(os.Stderr, "gen-client: invalid spec: %v\n", err)
+        return 1
+    }
+    model := buildClientModel(spec, *pkg)
+
+    var code []byte
+    switch *lang {
+    case "go":
+        code, err = generateGoClient(model)
+    case "ts":
+        code, err = generateTSClient(model)
+    default:
+        err = fmt.Errorf("unsupported language %q (want go or ts)", *lang)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "gen-client: %v\n", err)
+        return 1
+    }
+
+    if *out == "" {
+        os.Stdout.Write(code)
+        return 0
+    }
+    if err := os.WriteFile(*out, code, 0o644); err != nil {
+        fmt.Fprintf(os.Stderr, "gen-client: %v\n", err)
+        return 1
+    }
+    fmt.Fprintf(os.Stderr, "Wrote %s client to %s\n", *lang, *out)
+    return 0
+}
+
+func loadSpec(source string) ([]byte, error) {
+    switch {
+    case source == "":
+        return openAPISpec, nil
+    case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+        resp, err := http.Get(source)
+        if err != nil {
+            return nil, err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+        }
+        return io.ReadAll(resp.Body)
+    default:
+        return os.ReadFile(source)
+    }
+}
+
+func buildClientModel(spec apiSpec, pkg string) clientModel {
+    model := clientModel{Package: pkg, Title: spec.Info.Title, Version: spec.Info.Version}
+
+    for _, name := range sortedKeys(spec.Components.Schemas) {
+        schema := spec.Components.Schemas[name]
+        required := make(map[string]bool)
+        for _, field := range schema.Required {
+            required[field] = true
+        }
+        t := clientType{Name: name}
+        for _, field := range sortedKeys(schema.Properties) {
+            t.Fields = append(t.Fields, clientField{Name: field, Schema: schema.Properties[field], Required: required[field]})
+        }
+        model.Types = append(model.Types, t)
+    }
+
+    for _, path := range sortedKeys(spec.Paths) {
+        for _, method := range sortedKeys(spec.Paths[path]) {
+            op := spec.Paths[path][method]
+            c := clientOperation{
+                Name:    op.OperationID,
+                Summary: op.Summary,
+                Method:  strings.ToUpper(method),
+                Path:    path,
+            }
+            for _, p := range op.Parameters {
+                switch p.In {
+                case "path":
+                    c.PathParams = append(c.PathParams, p)
+                case "query":
+                    c.QueryParams = append(c.QueryParams, p)
+                }
+            }
+            if op.RequestBody != nil {
+                if content, ok := op.RequestBody.Content["application/json"]; ok && content.Schema != nil && content.Schema.Ref != "" {
+                    c.Body = content.Schema
+                } else {
+                    c.RawBody = true
+                }
+            }
+            c.Result = resultSchema(op)
+            model.Operations = append(model.Operations, c)
+        }
+    }
+    return model
+}
+
+// resultSchema returns the schema of the "data" member of the first 2xx
+// response, since the client unwraps the APIResponse envelope. Responses
+// that are not JSON are returned as-is if the spec marks them binary.
+func resultSchema(op apiOperation) *apiSchema {
+    for _, code := range sortedKeys(op.Responses) {
+        if !strings.HasPrefix(code, "2") {
+            continue
+        }
+        content, ok := op.Responses[code].Content["application/json"]
+        if !ok {
+            for _, other := range op.Responses[code].Content {
+                if isBinary(other.Schema) {
+                    return other.Schema
+                }
+            }
+            return nil
+        }
+        if content.Schema == nil {
+            return nil
+        }
+        if data, ok := content.Schema.Properties["data"]; ok {
+            return data
+        }
+        return content.Schema
+    }
+    return nil
+}
+
+func isBinary(s *apiSchema) bool {
+    return s != nil && s.Type == "string" && s.Format == "binary"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func refName(ref string) string {
+    return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// exportedName turns snake_case or camelCase identifiers into Go exported names.
+func exportedName(s string) string {
+    var b strings.Builder
+    upper := true
+    for _, r := range s {
+        if r == '_' || r == '-' {
+            upper = true
+            continue
+        }
+        if upper {
+            r = unicode.ToUpper(r)
+            upper = false
+        }
+        b.WriteRune(r)
+    }
+    name := b.String()
+    for _, initialism := range []string{"Id", "Url", "Ms"} {
+        if strings.HasSuffix(name, initialism) {
+            name = strings.TrimSuffix(name, initialism) + strings.ToUpper(initialism)
+        }
+    }
+    return name
+}
+
+func goType(s *apiSchema) string {
+    if s == nil {
+        return "interface{}"
+    }
+    if s.Ref != "" {
+        return refName(s.Ref)
+    }
+    // Go has no union types, so the caller decodes the alternative it expects.
+    if len(s.OneOf) > 0 {
+        return "json.RawMessage"
+    }
+    switch s.Type {
+    case "array":
+        return "[]" + goType(s.Items)
+    case "integer":
+        return "int"
+    case "number":
+        return "float64"
+    case "boolean":
+        return "bool"
+    case "string":
+        switch s.Format {
+        case "date-time":
+            return "time.Time"
+        case "binary":
+            return "[]byte"
+        }
+        return "string"
+    }
+    return "map[string]interface{}"
+}
+
+// goIsSet renders the condition under which an optional scalar parameter
+// differs from its zero value and should be sent.
+// goIdent turns a parameter name into a Go identifier, suffixing names that
+// are keywords, such as type.
+func goIdent(name string) string {
+    if token.IsKeyword(name) {
+        return name + "_"
+    }
+    return name
+}
+
+func goIsSet(name string, s *apiSchema) string {
+    name = goIdent(name)
+    switch goType(s) {
+    case "int", "float64":
+        return name + " != 0"
+    case "bool":
+        return name
+    case "string":
+        return name + ` != ""`
+    }
+    return name + " != nil"
+}
+
+func tsType(s *apiSchema) string {
+    if s == nil {
+        return "unknown"
+    }
+    if s.Ref != "" {
+        return refName(s.Ref)
+    }
+    if len(s.OneOf) > 0 {
+        var types []string
+        for _, alt := range s.OneOf {
+            types = append(types, tsType(alt))
+        }
+        return strings.Join(types, " | ")
+    }
+    switch s.Type {
+    case "array":
+        return tsType(s.Items) + "[]"
+    case "integer", "number":
+        return "number"
+    case "boolean":
+        return "boolean"
+    case "string":
+        if s.Format == "binary" {
+            return "Blob"
+        }
+        return "string"
+    }
+    return "Record<string, unknown>"
+}
+
+// goPath renders an OpenAPI path template as a Go expression.
+func goPath(op clientOperation) string {
+    if len(op.PathParams) == 0 {
+        return fmt.Sprintf("%q", op.Path)
+    }
+    format := op.Path
+    var args []string
+    for _, p := range op.PathParams {
+        format = strings.Replace(format, "{"+p.Name+"}", "%s", 1)
+        args = append(args, fmt.Sprintf("url.PathEscape(fmt.Sprint(%s))", goIdent(p.Name)))
+    }
+    return fmt.Sprintf("fmt.Sprintf(%q, %s)", format, strings.Join(args, ", "))
+}
+
+// tsPath renders an OpenAPI path template as a TypeScript template literal.
+func tsPath(op clientOperation) string {
+    path := op.Path
+    for _, p := range op.PathParams {
+        path = strings.Replace(path, "{"+p.Name+"}", "${encodeURIComponent(String("+p.Name+"))}", 1)
+    }
+    return "`" + path + "`"
+}
+
+// goParams renders the parameter list of a generated Go method.
+func goParams(op clientOperation) string {
+    params := []string{"ctx context.Context"}
+    for _, p := range append(op.PathParams, op.QueryParams...) {
+        params = append(params, goIdent(p.Name)+" "+goType(p.Schema))
+    }
+    if op.Body != nil {
+        params = append(params, "body "+goType(op.Body))
+    }
+    if op.RawBody {
+        params = append(params, "body io.Reader", "contentType string")
+    }
+    return strings.Join(params, ", ")
+}
+
+// tsParams renders the parameter list of a generated TypeScript method.
+func tsParams(op clientOperation) string {
+    var params []string
+    for _, p := range op.PathParams {
+        params = append(params, p.Name+": "+tsType(p.Schema))
+    }
+    // TypeScript requires optional parameters to follow required ones, so
+    // optional query parameters go after the body.
+    var optional []string
+    for _, p := range op.QueryParams {
+        if p.Required {
+            params = append(params, p.Name+": "+tsType(p.Schema))
+        } else {
+            optional = append(optional, p.Name+"?: "+tsType(p.Schema))
+        }
+    }
+    if op.Body != nil {
+        params = append(params, "body: "+tsType(op.Body))
+    }
+    if op.RawBody {
+        params = append(params, "body: BodyInit")
+    }
+    params = append(params, optional...)
+    if op.RawBody {
+        params = append(params, "contentType?: string")
+    }
+    return strings.Join(params, ", ")
+}
+
+// tsQuery renders the query object passed to the TypeScript request helper.
+func tsQuery(op clientOperation) string {
+    if len(op.QueryParams) == 0 {
+        return "{}"
+    }
+    var names []string
+    for _, p := range op.QueryParams {
+        names = append(names, p.Name)
+    }
+    return "{ " + strings.Join(names, ", ") + " }"
+}
+
+var clientFuncs = template.FuncMap{
+    "exported": exportedName,
+    "goType":   goType,
+    "goIdent":  goIdent,
+    "goIsSet":  goIsSet,
+    "tsType":   tsType,
+    "goPath":   goPath,
+    "tsPath":   tsPath,
+    "goParams": goParams,
+    "tsParams": tsParams,
+    "tsQuery":  tsQuery,
+}
+
+func generateGoClient(model clientModel) ([]byte, error) {
+    for _, t := range model.Types {
+        for _, f := range t.Fields {
+            if strings.Contains(goType(f.Schema), "time.Time") {
+                model.UsesTime = true
+            }
+        }
+    }
+    for _, op := range model.Operations {
+        if op.Body != nil {
+            model.UsesBytes = true
+        }
+    }
+
+    var buf bytes.Buffer
+    if err := goClientTemplate.Execute(&buf, model); err != nil {
+        return nil, err
+    }
+    code, err := format.Source(buf.Bytes())
+    if err != nil {
+        return nil, fmt.Errorf("formatting generated Go client: %v", err)
+    }
+    return code, nil
+}
+
+func generateTSClient(model clientModel) ([]byte, error) {
+    var buf bytes.Buffer
+    if err := tsClientTemplate.Execute(&buf, model); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+var goClientTemplate = template.Must(template.New("go").Funcs(clientFuncs).Parse(`// Code generated by user-api gen-client from {{.Title}} {{.Version}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .UsesBytes}}
+    "bytes"
+{{- end}}
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+{{- if .UsesTime}}
+    "time"
+{{- end}}
+)
+{{range .Types}}
+type {{.Name}} struct {
+{{- range .Fields}}
+    {{exported .Name}} {{goType .Schema}} ` + "`" + `json:"{{.Name}}{{if not .Required}},omitempty{{end}}"` + "`" + `
+{{- end}}
+}
+{{end}}
+// APIError is returned for non-2xx responses.
+type APIError struct {
+    StatusCode int
+    Message    string
+}
+
+func (e *APIError) Error() string {
+    return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+}
+
+type Client struct {
+    BaseURL    string
+    Token      string
+    HTTPClient *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+    return &Client{BaseURL: baseURL, HTTPClient: http.DefaultClient}
+}
+
+// envelope covers both success bodies and application/problem+json errors.
+type envelope struct {
+    Status json.RawMessage ` + "`" + `json:"status"` + "`" + `
+    Data   json.RawMessage ` + "`" + `json:"data"` + "`" + `
+    Title  string          ` + "`" + `json:"title"` + "`" + `
+    Detail string          ` + "`" + `json:"detail"` + "`" + `
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string, out interface{}) error {
+    // The server may be configured to send bare resources; the client
+    // always asks for the envelope it knows how to unwrap.
+    query.Set("envelope", "true")
+    u := c.BaseURL + path + "?" + query.Encode()
+    req, err := http.NewRequestWithContext(ctx, method, u, body)
+    if err != nil {
+        return err
+    }
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    if c.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.Token)
+    }
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    // Binary results, such as archives, are not wrapped in the envelope.
+    if raw, ok := out.(*[]byte); ok && resp.StatusCode < 300 {
+        *raw, err = io.ReadAll(resp.Body)
+        return err
+    }
+    var env envelope
+    if err := json.NewDecoder(resp.Body).Decode(&env); err != nil && err != io.EOF {
+        return err
+    }
+    if resp.StatusCode >= 300 {
+        message := env.Detail
+        if message == "" {
+            message = env.Title
+        }
+        return &APIError{StatusCode: resp.StatusCode, Message: message}
+    }
+    if out == nil || len(env.Data) == 0 {
+        return nil
+    }
+    return json.Unmarshal(env.Data, out)
+}
+{{range .Operations}}
+// {{exported .Name}} calls {{.Method}} {{.Path}}: {{.Summary}}.
+func (c *Client) {{exported .Name}}({{goParams .}}) ({{if .Result}}{{goType .Result}}, {{end}}error) {
+{{- if .Result}}
+    var out {{goType .Result}}
+{{- end}}
+    query := url.Values{}
+{{- range .QueryParams}}
+{{- if .Required}}
+    query.Set("{{.Name}}", fmt.Sprint({{goIdent .Name}}))
+{{- else}}
+    if {{goIsSet .Name .Schema}} {
+        query.Set("{{.Name}}", fmt.Sprint({{goIdent .Name}}))
+    }
+{{- end}}
+{{- end}}
+    var reqBody io.Reader
+    reqType := ""
+{{- if .Body}}
+    payload, err := json.Marshal(body)
+    if err != nil {
+        return {{if .Result}}out, {{end}}err
+    }
+    reqBody, reqType = bytes.NewReader(payload), "application/json"
+{{- else if .RawBody}}
+    reqBody, reqType = body, contentType
+{{- end}}
+{{- if .Result}}
+    if err := c.do(ctx, "{{.Method}}", {{goPath .}}, query, reqBody, reqType, &out); err != nil {
+        return out, err
+    }
+    return out, nil
+{{- else}}
+    return c.do(ctx, "{{.Method}}", {{goPath .}}, query, reqBody, reqType, nil)
+{{- end}}
+}
+{{end}}`))
+
+var tsClientTemplate = template.Must(template.New("ts").Funcs(clientFuncs).Parse(`// Code generated by user-api gen-client from {{.Title}} {{.Version}}; DO NOT EDIT.
+{{range .Types}}
+export interface {{.Name}} {
+{{- range .Fields}}
+  {{.Name}}{{if not .Required}}?{{end}}: {{tsType .Schema}};
+{{- end}}
+}
+{{end}}
+export class ApiError extends Error {
+  constructor(public readonly status: number, message: string) {
+    super(message);
+  }
+}
+
+type Query = Record<string, string | number | boolean | undefined>;
+
+export class ApiClient {
+  constructor(private readonly baseUrl: string, public token?: string) {}
+
+  private async request<T>(method: string, path: string, query?: Query, body?: BodyInit, contentType?: string): Promise<T> {
+    const url = new URL(this.baseUrl + path);
+    url.searchParams.set("envelope", "true");
+    for (const [key, value] of Object.entries(query ?? {})) {
+      if (value !== undefined) url.searchParams.set(key, String(value));
+    }
+    const headers: Record<string, string> = {};
+    if (contentType) headers["Content-Type"] = contentType;
+    if (this.token) headers["Authorization"] = ` + "`Bearer ${this.token}`" + `;
+
+    const res = await fetch(url, { method, headers, body });
+    // Binary results, such as archives, are not wrapped in the envelope.
+    const type = res.headers.get("Content-Type") ?? "";
+    if (res.ok && type !== "" && !type.includes("json")) {
+      return (await res.blob()) as T;
+    }
+    const env = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      throw new ApiError(res.status, env.detail || env.title || res.statusText);
+    }
+    return env.data as T;
+  }
+{{range .Operations}}
+  /** {{.Method}} {{.Path}}: {{.Summary}}. */
+  async {{.Name}}({{tsParams .}}): Promise<{{if .Result}}{{tsType .Result}}{{else}}void{{end}}> {
+    return this.request("{{.Method}}", {{tsPath .}}, {{tsQuery .}}
+{{- if .Body}}, JSON.stringify(body), "application/json"{{else if .RawBody}}, body, contentType{{end}});
+  }
+{{end -}}
+}
+`))
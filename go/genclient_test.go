@@ -0,0 +1,108 @@
+package main
+
+import (
+    "encoding/json"
+    "go/ast"
+    "go/importer"
+    "go/parser"
+    "go/token"
+    "go/types"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func embeddedClientModel(t *testing.T) clientModel {
+    t.Helper()
+    var spec apiSpec
+    if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+        t.Fatal(err)
+    }
+    return buildClientModel(spec, "client")
+}
+
+func TestExportedName(t *testing.T) {
+    for in, want := range map[string]string{
+        "name":          "Name",
+        "user_id":       "UserID",
+        "created_at":    "CreatedAt",
+        "latency_ms":    "LatencyMS",
+        "webhook-url":   "WebhookURL",
+        "getUserStats":  "GetUserStats",
+        "refresh_token": "RefreshToken",
+    } {
+        if got := exportedName(in); got != want {
+            t.Errorf("exportedName(%q) = %q, want %q", in, got, want)
+        }
+    }
+}
+
+func TestGenerateGoClient(t *testing.T) {
+    model := embeddedClientModel(t)
+    code, err := generateGoClient(model)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The client only uses the standard library, so it must type-check on
+    // its own.
+    fset := token.NewFileSet()
+    file, err := parser.ParseFile(fset, "client.go", code, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+    if _, err := conf.Check("client", fset, []*ast.File{file}, nil); err != nil {
+        t.Fatalf("generated client does not compile: %v", err)
+    }
+
+    methods := make(map[string]bool)
+    for _, decl := range file.Decls {
+        if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil {
+            methods[fn.Name.Name] = true
+        }
+    }
+    for _, op := range model.Operations {
+        if !methods[exportedName(op.Name)] {
+            t.Errorf("no method for %s %s", op.Method, op.Path)
+        }
+    }
+    for _, name := range []string{"User", "Team", "Job"} {
+        if !strings.Contains(string(code), "type "+name+" struct") {
+            t.Errorf("no %s type", name)
+        }
+    }
+}
+
+func TestGenerateTSClient(t *testing.T) {
+    model := embeddedClientModel(t)
+    code, err := generateTSClient(model)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, op := range model.Operations {
+        if !strings.Contains(string(code), op.Name+"(") {
+            t.Errorf("no function for %s %s", op.Method, op.Path)
+        }
+    }
+    if !strings.Contains(string(code), "export interface User {") {
+        t.Error("no User interface")
+    }
+}
+
+func TestRunGenClient(t *testing.T) {
+    out := filepath.Join(t.TempDir(), "client.ts")
+    if code := runGenClient([]string{"-lang", "ts", "-out", out}); code != 0 {
+        t.Fatalf("exit code %d", code)
+    }
+    if data, err := os.ReadFile(out); err != nil || !strings.Contains(string(data), "listUsers(") {
+        t.Errorf("written client: %v", err)
+    }
+    if code := runGenClient([]string{"-lang", "rust", "-out", out}); code != 1 {
+        t.Errorf("unsupported language: exit code %d", code)
+    }
+    if code := runGenClient([]string{"-spec", filepath.Join(t.TempDir(), "missing.json")}); code != 1 {
+        t.Errorf("missing spec: exit code %d", code)
+    }
+}
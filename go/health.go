@@ -0,0 +1,203 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var dependencyUp = prometheus.NewGaugeVec(
+    prometheus.GaugeOpts{
+        Name: "dependency_up",
+        Help: "Whether each dependency passed its last health check (1) or not (0)",
+    },
+    []string{"dependency"},
+)
+
+func init() {
+    prometheus.MustRegister(dependencyUp)
+}
+
+// healthCheckTimeout bounds each dependency check, so /health answers in
+// time for the probe calling it even when a dependency hangs.
+var healthCheckTimeout = reloadableDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second)
+
+// healthCheck is one dependency reported by /health. A critical dependency
+// that is down makes the service unhealthy (503); any other makes it
+// degraded but still serving.
+type healthCheck struct {
+    name     string
+    critical bool
+    check    func(ctx context.Context) error
+
+    mu          sync.Mutex
+    lastError   string
+    lastErrorAt time.Time
+}
+
+// DependencyStatus is the outcome of one dependency's check. The last error
+// is kept after the dependency recovers, to show flapping.
+type DependencyStatus struct {
+    Status      string     `json:"status"`
+    Critical    bool       `json:"critical"`
+    LatencyMS   float64    `json:"latency_ms"`
+    LastError   string     `json:"last_error,omitempty"`
+    LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// healthChecks are the dependencies of userRepo, set in main.
+var healthChecks []*healthCheck
+
+// dependencyChecks lists what repo depends on: the storage backend unless
+// it is embedded, its read replicas and the Redis cache.
+func dependencyChecks(repo UserRepository) []*healthCheck {
+    var checks []*healthCheck
+    if cache, ok := repo.(*redisCachedRepository); ok {
+        checks = append(checks, &healthCheck{name: "cache", check: func(ctx context.Context) error {
+            return cache.client.Ping(ctx).Err()
+        }})
+    }
+    for {
+        w, ok := repo.(interface{ Unwrap() UserRepository })
+        if !ok {
+            break
+        }
+        repo = w.Unwrap()
+    }
+    if p, ok := repo.(interface{ Ping(context.Context) error }); ok {
+        checks = append(checks, &healthCheck{name: "storage", critical: true, check: p.Ping})
+    }
+    if s, ok := repo.(*sqlUserRepository); ok && s.replicas != nil {
+        for _, r := range s.replicas.replicas {
+            r := r
+            checks = append(checks, &healthCheck{name: "replica-" + r.name, check: func(ctx context.Context) error {
+                if err := r.db.PingContext(ctx); err != nil {
+                    return err
+                }
+                if !r.healthy.Load() {
+                    return errors.New("out of rotation: lagging or failing the lag check")
+                }
+                return nil
+            }})
+        }
+    }
+    return checks
+}
+
+func (c *healthCheck) run(ctx context.Context) DependencyStatus {
+    ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout.get())
+    defer cancel()
+    start := time.Now()
+    err := c.check(ctx)
+    status := DependencyStatus{
+        Status:    "up",
+        Critical:  c.critical,
+        LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if err != nil {
+        status.Status = "down"
+        c.lastError, c.lastErrorAt = err.Error(), time.Now()
+        dependencyUp.WithLabelValues(c.name).Set(0)
+    } else {
+        dependencyUp.WithLabelValues(c.name).Set(1)
+    }
+    if c.lastError != "" {
+        at := c.lastErrorAt
+        status.LastError, status.LastErrorAt = c.lastError, &at
+    }
+    return status
+}
+
+// checkDependencies runs every check concurrently and returns the overall
+// status: "healthy", "degraded" if only non-critical dependencies are down,
+// or "unhealthy".
+func checkDependencies(ctx context.Context) (string, map[string]DependencyStatus) {
+    results := make([]DependencyStatus, len(healthChecks))
+    var wg sync.WaitGroup
+    for i, c := range healthChecks {
+        wg.Add(1)
+        go func(i int, c *healthCheck) {
+            defer wg.Done()
+            results[i] = c.run(ctx)
+        }(i, c)
+    }
+    wg.Wait()
+
+    overall := "healthy"
+    deps := make(map[string]DependencyStatus, len(results))
+    for i, c := range healthChecks {
+        deps[c.name] = results[i]
+        if results[i].Status == "up" {
+            continue
+        }
+        if c.critical {
+            overall = "unhealthy"
+        } else if overall == "healthy" {
+            overall = "degraded"
+        }
+    }
+    return overall, deps
+}
+
+// The Kubernetes probes, next to the full report of /health:
+//
+//   - /livez (livenessProbe) answers 200 as long as the process can serve
+//     at all; it checks no dependency, so an outage of the database does not
+//     get every pod restarted.
+//   - /healthz (startupProbe) answers 503 until startup has finished, i.e.
+//     storage is open and seeding is done, then 200.
+//   - /readyz (readinessProbe) answers 503 while starting, while draining
+//     for a restart and while a critical dependency is down, so the pod is
+//     taken out of the Service until it can serve again.
+var (
+    started  atomic.Bool
+    draining atomic.Bool
+)
+
+// ProbeResult is the data of the probe endpoints.
+type ProbeResult struct {
+    Reason       string                      `json:"reason,omitempty"`
+    Dependencies map[string]DependencyStatus `json:"dependencies,omitempty"`
+}
+
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "ok", Data: ProbeResult{}})
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+    if !started.Load() {
+        writeProbe(w, r, "starting", nil)
+        return
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "ok", Data: ProbeResult{}})
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+    switch {
+    case !started.Load():
+        writeProbe(w, r, "starting", nil)
+        return
+    case draining.Load():
+        writeProbe(w, r, "draining", nil)
+        return
+    }
+    status, deps := checkDependencies(r.Context())
+    if status == "unhealthy" {
+        writeProbe(w, r, "critical dependency down", deps)
+        return
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "ok", Data: ProbeResult{Dependencies: deps}})
+}
+
+// writeProbe answers a probe with 503 and why.
+func writeProbe(w http.ResponseWriter, r *http.Request, reason string, deps map[string]DependencyStatus) {
+    writeJSON(w, r, http.StatusServiceUnavailable, APIResponse{Status: "unavailable", Data: ProbeResult{Reason: reason, Dependencies: deps}})
+}
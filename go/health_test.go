@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gorilla/mux"
+)
+
+func TestHealthDependencies(t *testing.T) {
+    cacheErr, storageErr := errors.New("cache down"), error(nil)
+    defer func(old []*healthCheck) { healthChecks = old }(healthChecks)
+    healthChecks = []*healthCheck{
+        {name: "cache", check: func(context.Context) error { return cacheErr }},
+        {name: "storage", critical: true, check: func(context.Context) error { return storageErr }},
+    }
+
+    health := func() (int, string, map[string]DependencyStatus) {
+        w := httptest.NewRecorder()
+        healthHandler(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+        var body struct {
+            Status string
+            Data   struct{ Dependencies map[string]DependencyStatus }
+        }
+        if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+            t.Fatal(err)
+        }
+        return w.Code, body.Status, body.Data.Dependencies
+    }
+
+    code, status, deps := health()
+    if code != http.StatusOK || status != "degraded" {
+        t.Fatalf("cache down: got %d %q, want 200 degraded", code, status)
+    }
+    if deps["cache"].Status != "down" || deps["cache"].LastError != "cache down" || deps["storage"].Status != "up" {
+        t.Fatalf("cache down: dependencies %+v", deps)
+    }
+
+    storageErr = errors.New("connection refused")
+    if code, status, _ = health(); code != http.StatusServiceUnavailable || status != "unhealthy" {
+        t.Fatalf("storage down: got %d %q, want 503 unhealthy", code, status)
+    }
+
+    cacheErr, storageErr = nil, nil
+    code, status, deps = health()
+    if code != http.StatusOK || status != "healthy" {
+        t.Fatalf("all up: got %d %q, want 200 healthy", code, status)
+    }
+    if deps["storage"].LastError != "connection refused" || deps["storage"].LastErrorAt == nil {
+        t.Fatalf("all up: last storage error not kept: %+v", deps["storage"])
+    }
+}
+
+func TestProbes(t *testing.T) {
+    storageErr := error(nil)
+    defer func(old []*healthCheck) { healthChecks = old }(healthChecks)
+    healthChecks = []*healthCheck{
+        {name: "storage", critical: true, check: func(context.Context) error { return storageErr }},
+    }
+    defer func(s, d bool) { started.Store(s); draining.Store(d) }(started.Load(), draining.Load())
+    started.Store(false)
+    draining.Store(false)
+
+    probe := func(path string) (int, string) {
+        r := mux.NewRouter()
+        registerOpsRoutes(r, func(next http.Handler) http.Handler { return next })
+        w := httptest.NewRecorder()
+        r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+        var body struct{ Data ProbeResult }
+        if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+            t.Fatal(err)
+        }
+        return w.Code, body.Data.Reason
+    }
+    expect := func(state string, want map[string]int) {
+        t.Helper()
+        for path, code := range want {
+            if got, reason := probe(path); got != code {
+                t.Errorf("%s: %s answered %d (%s), want %d", state, path, got, reason, code)
+            }
+        }
+    }
+
+    expect("starting", map[string]int{"/livez": 200, "/healthz": 503, "/readyz": 503})
+    started.Store(true)
+    expect("started", map[string]int{"/livez": 200, "/healthz": 200, "/readyz": 200})
+    storageErr = errors.New("connection refused")
+    expect("storage down", map[string]int{"/livez": 200, "/healthz": 200, "/readyz": 503})
+    storageErr = nil
+    draining.Store(true)
+    expect("draining", map[string]int{"/livez": 200, "/healthz": 200, "/readyz": 503})
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+    status := http.StatusOK
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/readyz" {
+            t.Errorf("checked %s", r.URL.Path)
+        }
+        w.WriteHeader(status)
+    }))
+    defer srv.Close()
+
+    if code := runHealthcheck([]string{"-url", srv.URL + "/readyz"}); code != 0 {
+        t.Errorf("ready server: exit %d", code)
+    }
+    status = http.StatusServiceUnavailable
+    if code := runHealthcheck([]string{"-url", srv.URL + "/readyz"}); code != 1 {
+        t.Errorf("unready server: exit %d", code)
+    }
+    srv.Close()
+    if code := runHealthcheck([]string{"-url", srv.URL + "/readyz", "-timeout", "1s"}); code != 1 {
+        t.Errorf("no server: exit %d", code)
+    }
+}
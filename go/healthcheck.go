@@ -0,0 +1,63 @@
+package main
+
+import (
+    "crypto/tls"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "time"
+)
+
+// runHealthcheck implements the healthcheck subcommand, for a Dockerfile
+// HEALTHCHECK in the scratch image, which has no curl or wget:
+//
+//	HEALTHCHECK CMD ["/main", "healthcheck"]
+//
+// It GETs /readyz of the server in the same container, on METRICS_PORT or
+// else ADMIN_PORT when set and the first LISTEN_ADDR (over HTTPS with
+// TLS_CERT_FILE) otherwise, and exits 0 when it answers 2xx, 1 otherwise, so a draining
+// or unready server is reported unhealthy too.
+func runHealthcheck(args []string) int {
+    fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+    addr, scheme := bindAddr(config.MetricsPort), "http"
+    if addr == "" {
+        addr = bindAddr(config.AdminPort)
+    }
+    if addr == "" {
+        addrs, err := listenAddrs()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+            return 2
+        }
+        addr = addrs[0]
+        if tlsConfigured() {
+            scheme = "https"
+        }
+    }
+    defaultTarget := scheme + "://" + localAddr(addr) + "/readyz"
+    target := fs.String("url", defaultTarget, "URL to check")
+    timeout := fs.Duration("timeout", 3*time.Second, "time to wait for the response")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    // The server is this container's own, so its certificate, issued for
+    // its public name, is not checked.
+    transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+    if *target == defaultTarget {
+        transport.DialContext = localDial(addr)
+    }
+    client := &http.Client{Timeout: *timeout, Transport: transport}
+    resp, err := client.Get(*target)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+        return 1
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        fmt.Fprintf(os.Stderr, "healthcheck: %s returned %s\n", *target, resp.Status)
+        return 1
+    }
+    return 0
+}
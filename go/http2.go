@@ -0,0 +1,84 @@
+package main
+
+import (
+    "crypto/tls"
+    "log/slog"
+    "net/http"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
+)
+
+var (
+    httpProtocolRequestsTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "http_protocol_requests_total",
+            Help: "Total number of HTTP requests by protocol: http/1.0, http/1.1, h2 (over TLS) or h2c (cleartext)",
+        },
+        []string{"protocol"},
+    )
+    httpProtocolRequestDuration = prometheus.NewHistogramVec(
+        prometheus.HistogramOpts{
+            Name:    "http_protocol_request_duration_seconds",
+            Help:    "HTTP request duration in seconds by protocol",
+            Buckets: config.HTTPDurationBuckets,
+        },
+        []string{"protocol"},
+    )
+)
+
+func init() {
+    prometheus.MustRegister(httpProtocolRequestsTotal, httpProtocolRequestDuration)
+}
+
+// HTTP/2 is negotiated over TLS unless HTTP2=false. H2C=true also accepts
+// it in cleartext, for a proxy in front that terminates TLS and speaks
+// HTTP/2 to the container, such as Envoy or a gRPC-aware load balancer;
+// clients either upgrade from HTTP/1.1 or start with HTTP/2 outright.
+// HTTP2_MAX_CONCURRENT_STREAMS (default 250) bounds the requests one
+// connection runs at once.
+//
+// The requests and their durations are also counted by protocol, to compare
+// multiplexed connections with HTTP/1.1 ones under the same load.
+
+// configureHTTP2 sets srv up for HTTP/2 as configured.
+func configureHTTP2(srv *http.Server) {
+    if !config.HTTP2Enabled {
+        // A non-nil empty map keeps net/http from adding h2 itself.
+        srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+        if config.H2CEnabled {
+            slog.Warn("H2C ignored, as HTTP2 is false")
+        }
+        return
+    }
+    h2s := &http2.Server{MaxConcurrentStreams: uint32(config.HTTP2MaxStreams), IdleTimeout: srv.IdleTimeout}
+    if err := http2.ConfigureServer(srv, h2s); err != nil {
+        slog.Warn("HTTP/2 unavailable", "error", err)
+        return
+    }
+    if config.H2CEnabled {
+        srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+    }
+}
+
+// requestProtocol names the protocol r came in over, for the metrics.
+func requestProtocol(r *http.Request) string {
+    switch {
+    case r.ProtoMajor == 2 && r.TLS != nil:
+        return "h2"
+    case r.ProtoMajor == 2:
+        return "h2c"
+    case r.ProtoMajor == 1 && r.ProtoMinor == 0:
+        return "http/1.0"
+    default:
+        return "http/1.1"
+    }
+}
+
+func recordProtocol(r *http.Request, elapsed time.Duration) {
+    protocol := requestProtocol(r)
+    httpProtocolRequestsTotal.WithLabelValues(protocol).Inc()
+    httpProtocolRequestDuration.WithLabelValues(protocol).Observe(elapsed.Seconds())
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "path/filepath"
+    "strings"
+)
+
+// maxImportSize caps the size of an uploaded import file.
+const maxImportSize = 10 << 20
+
+type ImportRowError struct {
+    Row     int          `json:"row"`
+    Message string       `json:"message"`
+    Errors  []FieldError `json:"errors,omitempty"`
+}
+
+type ImportResult struct {
+    Total    int              `json:"total"`
+    Imported int              `json:"imported"`
+    Failed   int              `json:"failed"`
+    Users    []User           `json:"users"`
+    Errors   []ImportRowError `json:"errors,omitempty"`
+}
+
+// importUsersHandler accepts a CSV or JSON file, either as the raw request
+// body or as the "file" field of a multipart form, and inserts every valid row.
+// Rows that fail validation are reported back without aborting the import.
+// Large files, or ?async=true, are imported by a background job instead.
+func importUsersHandler(w http.ResponseWriter, r *http.Request) {
+    r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+    body, format, err := importSource(r)
+    if err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, err.Error()))
+        return
+    }
+    defer body.Close()
+
+    var rows []User
+    switch format {
+    case "csv":
+        rows, err = parseCSVUsers(body)
+    default:
+        rows, err = parseJSONUsers(body)
+    }
+    if err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, err.Error()))
+        return
+    }
+
+    if runAsync(r, len(rows)) {
+        r := detachRequest(r)
+        submitJob(w, r, "import", len(rows), func(progress jobProgress) (interface{}, error) {
+            result := importRows(r, rows, progress)
+            cache.purgePrefix("/users")
+            return result, nil
+        })
+        return
+    }
+
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   importRows(r, rows, nil),
+    })
+}
+
+// importRows validates and inserts each row, reporting it to progress when
+// the import runs as a job.
+func importRows(r *http.Request, rows []User, progress jobProgress) ImportResult {
+    result := ImportResult{Total: len(rows), Users: []User{}}
+    for i, row := range rows {
+        var user User
+        sanitizeUser(&row)
+        err := validateUser(row)
+        if err == nil {
+            user, err = userRepo.Insert(r.Context(), User{Name: row.Name, Email: row.Email})
+        }
+        if progress != nil {
+            progress(err == nil)
+        }
+        if err != nil {
+            result.Errors = append(result.Errors, importRowError(i+1, err))
+            continue
+        }
+        recordActivity(r, user.ID, activityCreated, diffUsers(User{}, user))
+        result.Users = append(result.Users, user)
+    }
+    result.Imported = len(result.Users)
+    result.Failed = len(result.Errors)
+    return result
+}
+
+// importRowError converts a validation or store error into a per-row report.
+func importRowError(row int, err error) ImportRowError {
+    rowErr := ImportRowError{Row: row, Message: err.Error()}
+    var validation *ValidationError
+    var constraint *ConstraintError
+    switch {
+    case errors.As(err, &validation):
+        rowErr.Errors = validation.Fields
+    case errors.As(err, &constraint):
+        rowErr.Message = constraint.Message
+        rowErr.Errors = []FieldError{{Field: constraint.Field, Message: constraint.Message}}
+    }
+    return rowErr
+}
+
+// importSource returns the uploaded file and its format ("csv" or "json"),
+// detected from the file name or the content type.
+func importSource(r *http.Request) (io.ReadCloser, string, error) {
+    contentType := r.Header.Get("Content-Type")
+    if strings.HasPrefix(contentType, "multipart/form-data") {
+        file, header, err := r.FormFile("file")
+        if err != nil {
+            return nil, "", errors.New("multipart upload must contain a \"file\" field")
+        }
+        format := formatFromName(header.Filename)
+        if format == "" {
+            format = formatFromContentType(header.Header.Get("Content-Type"))
+        }
+        if format == "" {
+            file.Close()
+            return nil, "", errors.New("unsupported file type, expected .csv or .json")
+        }
+        return file, format, nil
+    }
+
+    format := formatFromContentType(contentType)
+    if format == "" {
+        return nil, "", errors.New("unsupported content type, expected text/csv or application/json")
+    }
+    return r.Body, format, nil
+}
+
+func formatFromName(name string) string {
+    switch strings.ToLower(filepath.Ext(name)) {
+    case ".csv":
+        return "csv"
+    case ".json":
+        return "json"
+    }
+    return ""
+}
+
+func formatFromContentType(contentType string) string {
+    switch {
+    case strings.HasPrefix(contentType, "text/csv"):
+        return "csv"
+    case strings.HasPrefix(contentType, "application/json"):
+        return "json"
+    }
+    return ""
+}
+
+// parseCSVUsers reads a CSV file whose header row names the columns.
+// Only the name and email columns are used; others are ignored.
+func parseCSVUsers(r io.Reader) ([]User, error) {
+    reader := csv.NewReader(r)
+    reader.FieldsPerRecord = -1
+    reader.TrimLeadingSpace = true
+
+    header, err := reader.Read()
+    if err != nil {
+        return nil, errors.New("CSV file must start with a header row")
+    }
+    nameCol, emailCol := -1, -1
+    for i, column := range header {
+        switch strings.ToLower(strings.TrimSpace(column)) {
+        case "name":
+            nameCol = i
+        case "email":
+            emailCol = i
+        }
+    }
+    if nameCol < 0 || emailCol < 0 {
+        return nil, errors.New("CSV header must contain name and email columns")
+    }
+
+    var rows []User
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid CSV: %v", err)
+        }
+        var user User
+        if nameCol < len(record) {
+            user.Name = strings.TrimSpace(record[nameCol])
+        }
+        if emailCol < len(record) {
+            user.Email = strings.TrimSpace(record[emailCol])
+        }
+        rows = append(rows, user)
+    }
+    return rows, nil
+}
+
+// parseJSONUsers reads a JSON array of user objects.
+func parseJSONUsers(r io.Reader) ([]User, error) {
+    var rows []User
+    if err := json.NewDecoder(r).Decode(&rows); err != nil {
+        return nil, errors.New("JSON file must contain an array of users")
+    }
+    return rows, nil
+}
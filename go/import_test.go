@@ -0,0 +1,108 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "mime/multipart"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseCSVUsers(t *testing.T) {
+    rows, err := parseCSVUsers(strings.NewReader("Email, name ,team\n ada@example.com,Ada ,ops\nbob@example.com\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []User{{Name: "Ada", Email: "ada@example.com"}, {Email: "bob@example.com"}}
+    if !reflect.DeepEqual(rows, want) {
+        t.Errorf("rows %+v, want %+v", rows, want)
+    }
+
+    for _, input := range []string{"", "name,team\nAda,ops\n", "name,email\n\"Ada,ada@example.com\n"} {
+        if _, err := parseCSVUsers(strings.NewReader(input)); err == nil {
+            t.Errorf("%q accepted", input)
+        }
+    }
+}
+
+func TestParseJSONUsers(t *testing.T) {
+    rows, err := parseJSONUsers(strings.NewReader(`[{"name":"Ada","email":"ada@example.com"},{"name":"Bob"}]`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []User{{Name: "Ada", Email: "ada@example.com"}, {Name: "Bob"}}
+    if !reflect.DeepEqual(rows, want) {
+        t.Errorf("rows %+v, want %+v", rows, want)
+    }
+    for _, input := range []string{`{"name":"Ada"}`, `[{"name":`, ``} {
+        if _, err := parseJSONUsers(strings.NewReader(input)); err == nil {
+            t.Errorf("%q accepted", input)
+        }
+    }
+}
+
+func TestImportUsers(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    userRepo = &memoryUserRepository{nextID: 1}
+    importFile := func(req *http.Request) (*httptest.ResponseRecorder, ImportResult) {
+        rec := httptest.NewRecorder()
+        importUsersHandler(rec, req)
+        var resp struct {
+            Data ImportResult `json:"data"`
+        }
+        json.Unmarshal(rec.Body.Bytes(), &resp)
+        return rec, resp.Data
+    }
+    newRequest := func(contentType, body string) *http.Request {
+        req := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(body))
+        req.Header.Set("Content-Type", contentType)
+        return req
+    }
+
+    // The second row is invalid, the fourth repeats the first email.
+    csvFile := "name,email\nAda,ada@example.com\n,not-an-email\nBob,bob@example.com\nAda Again,ada@example.com\n"
+    rec, result := importFile(newRequest("text/csv", csvFile))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("import: %d %s", rec.Code, rec.Body)
+    }
+    if result.Total != 4 || result.Imported != 2 || result.Failed != 2 || len(result.Users) != 2 {
+        t.Fatalf("result %+v", result)
+    }
+    if len(result.Errors) != 2 || result.Errors[0].Row != 2 || result.Errors[1].Row != 4 {
+        t.Fatalf("errors %+v", result.Errors)
+    }
+    var fields []string
+    for _, e := range result.Errors[0].Errors {
+        fields = append(fields, e.Field)
+    }
+    if !reflect.DeepEqual(fields, []string{"name", "email"}) {
+        t.Errorf("row 2 errors %+v, want name and email", result.Errors[0].Errors)
+    }
+    if e := result.Errors[1]; e.Message != errEmailTaken.Message || len(e.Errors) != 1 || e.Errors[0].Field != "email" {
+        t.Errorf("row 4 error %+v", e)
+    }
+
+    // A multipart upload is detected from the file name.
+    var body bytes.Buffer
+    form := multipart.NewWriter(&body)
+    part, _ := form.CreateFormFile("file", "users.json")
+    part.Write([]byte(`[{"name":"Cy","email":"cy@example.com"}]`))
+    form.Close()
+    rec, result = importFile(newRequest(form.FormDataContentType(), body.String()))
+    if rec.Code != http.StatusOK || result.Imported != 1 || result.Failed != 0 || result.Users[0].Email != "cy@example.com" {
+        t.Errorf("multipart import: %d %s", rec.Code, rec.Body)
+    }
+
+    for _, req := range []*http.Request{
+        newRequest("text/plain", "name,email\n"),
+        newRequest("application/json", `{"name":"Ada"}`),
+        newRequest("text/csv", "name\nAda\n"),
+    } {
+        if rec, _ := importFile(req); rec.Code != http.StatusBadRequest {
+            t.Errorf("%s: %d %s", req.Header.Get("Content-Type"), rec.Code, rec.Body)
+        }
+    }
+}
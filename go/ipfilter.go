@@ -0,0 +1,162 @@
+package main
+
+import (
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/netip"
+    "strings"
+    "sync/atomic"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// IP_DENYLIST and IP_ALLOWLIST are comma-separated lists of CIDRs or
+// addresses, like TRUSTED_PROXIES. Requests from a denied address are
+// answered 403, and so are those from any address not allowed when the
+// allowlist is not empty; the denylist wins where both match. The address
+// is the client's as clientIPMiddleware resolves it, so set TRUSTED_PROXIES
+// behind a proxy, or every client is judged by the proxy's address.
+//
+// PUT /admin/ip-filter replaces both lists at once, e.g. to block an
+// abusive source during an incident; the change lasts until the process
+// exits or a reload reads the settings again. The probes and /metrics are
+// never filtered, and neither is /admin/ip-filter for an admin, so an
+// operator cannot lock themselves out of undoing a change. The other /admin
+// routes are filtered like the rest; the ADMIN_PORT listener has no filter.
+var ipFilterRules atomic.Pointer[ipFilter]
+
+// ipFilter is an allowlist and a denylist of prefixes.
+type ipFilter struct {
+    allow, deny []netip.Prefix
+}
+
+// IPFilter is the JSON form of the lists.
+type IPFilter struct {
+    Allow []string `json:"allow"`
+    Deny  []string `json:"deny"`
+}
+
+var ipBlockedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Name: "http_requests_ip_blocked_total",
+    Help: "Requests answered 403 by the IP filter, by the list that blocked them (allow or deny)",
+}, []string{"list"})
+
+func init() {
+    prometheus.MustRegister(ipBlockedTotal)
+    reloadableSetting(func() error {
+        var env envReader
+        rules := &ipFilter{allow: env.prefixes("IP_ALLOWLIST"), deny: env.prefixes("IP_DENYLIST")}
+        if err := env.err(); err != nil {
+            return err
+        }
+        ipFilterRules.Store(rules)
+        return nil
+    }, "IP_ALLOWLIST", "IP_DENYLIST")
+}
+
+// blockedBy returns the list that blocks addr, or "" if it may pass.
+func (f *ipFilter) blockedBy(addr string) string {
+    if len(f.allow) == 0 && len(f.deny) == 0 {
+        return ""
+    }
+    ip, err := netip.ParseAddr(remoteHost(addr))
+    if err != nil {
+        if len(f.allow) > 0 {
+            return "allow"
+        }
+        return ""
+    }
+    ip = ip.Unmap()
+    if containsAddr(f.deny, ip) {
+        return "deny"
+    }
+    if len(f.allow) > 0 && !containsAddr(f.allow, ip) {
+        return "allow"
+    }
+    return ""
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+    for _, prefix := range prefixes {
+        if prefix.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// ipFilterMiddleware rejects requests from addresses the lists block.
+func ipFilterMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if probeRoutes[routeTemplate(r)] || r.URL.Path == "/metrics" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if list := ipFilterRules.Load().blockedBy(r.RemoteAddr); list != "" && !adminChangingIPFilter(r) {
+            ipBlockedTotal.WithLabelValues(list).Inc()
+            writeError(w, r, httpError(http.StatusForbidden, "Access from this address is not allowed"))
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// adminChangingIPFilter reports whether r is an admin's request to
+// /admin/ip-filter. The filter runs before authMiddleware, so the
+// credentials are checked here.
+func adminChangingIPFilter(r *http.Request) bool {
+    if r.URL.Path != "/admin/ip-filter" {
+        return false
+    }
+    p, ok := authenticate(r)
+    return ok && p.HasRole(roleAdmin)
+}
+
+// ipFilterHandler serves GET and PUT /admin/ip-filter. PUT takes both
+// lists; an entry that is neither a CIDR nor an address rejects the whole
+// change.
+func ipFilterHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodPut {
+        var req IPFilter
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+            return
+        }
+        verr := &ValidationError{}
+        allow := parsePrefixList(verr, "allow", req.Allow)
+        deny := parsePrefixList(verr, "deny", req.Deny)
+        if len(verr.Fields) > 0 {
+            writeError(w, r, verr)
+            return
+        }
+        ipFilterRules.Store(&ipFilter{allow: allow, deny: deny})
+        slog.Warn("IP filter changed", "allow", len(allow), "deny", len(deny))
+    }
+    f := ipFilterRules.Load()
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "success", Data: IPFilter{
+        Allow: prefixStrings(f.allow),
+        Deny:  prefixStrings(f.deny),
+    }})
+}
+
+func parsePrefixList(verr *ValidationError, field string, items []string) []netip.Prefix {
+    var prefixes []netip.Prefix
+    for _, item := range items {
+        prefix, err := parsePrefix(strings.TrimSpace(item))
+        if err != nil {
+            verr.add(field, err.Error())
+            continue
+        }
+        prefixes = append(prefixes, prefix)
+    }
+    return prefixes
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+    items := make([]string, len(prefixes))
+    for i, prefix := range prefixes {
+        items[i] = prefix.String()
+    }
+    return items
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "net/netip"
+    "strings"
+    "testing"
+)
+
+func TestIPFilter(t *testing.T) {
+    allow, _ := parsePrefixes("10.0.0.0/8, 2001:db8::/32")
+    deny, _ := parsePrefixes("10.6.6.6")
+    f := &ipFilter{allow: allow, deny: deny}
+    for addr, want := range map[string]string{
+        "10.1.2.3:5000":        "",
+        "[2001:db8::1]:443":    "",
+        "[::ffff:10.1.2.3]:80": "",
+        "10.6.6.6:5000":        "deny",
+        "203.0.113.9:5000":     "allow",
+        "@":                    "allow",
+    } {
+        if got := f.blockedBy(addr); got != want {
+            t.Errorf("blockedBy(%s) = %q, want %q", addr, got, want)
+        }
+    }
+    if got := (&ipFilter{}).blockedBy("203.0.113.9:5000"); got != "" {
+        t.Errorf("empty lists block: %q", got)
+    }
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+    defer func(old []netip.Prefix) { config.TrustedProxies = old }(config.TrustedProxies)
+    config.TrustedProxies, _ = parsePrefixes("10.0.0.0/8")
+    defer ipFilterRules.Store(ipFilterRules.Load())
+    defer func(old string) { config.AdminToken = old }(config.AdminToken)
+    config.AdminToken = "admin-secret"
+
+    admin := newAdminHandler()
+    do := func(method, path, body string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer admin-secret")
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, req)
+        return rec
+    }
+    if rec := do(http.MethodPut, "/admin/ip-filter", `{"deny":["bogus"]}`); rec.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("invalid entry: %d %s", rec.Code, rec.Body)
+    }
+    rec := do(http.MethodPut, "/admin/ip-filter", `{"deny":["198.51.100.0/24"]}`)
+    if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"198.51.100.0/24"`) {
+        t.Fatalf("deny: %d %s", rec.Code, rec.Body)
+    }
+
+    h := clientIPMiddleware(ipFilterMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+    for _, c := range []struct {
+        peer, xff string
+        want      int
+    }{
+        {"198.51.100.7:5000", "", http.StatusForbidden},
+        {"10.1.2.3:5000", "198.51.100.7", http.StatusForbidden},
+        {"10.1.2.3:5000", "203.0.113.9", http.StatusOK},
+        {"203.0.113.9:5000", "", http.StatusOK},
+    } {
+        req := httptest.NewRequest(http.MethodGet, "/users", nil)
+        req.RemoteAddr = c.peer
+        if c.xff != "" {
+            req.Header.Set("X-Forwarded-For", c.xff)
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        if rec.Code != c.want {
+            t.Errorf("peer %s, X-Forwarded-For %q: %d, want %d", c.peer, c.xff, rec.Code, c.want)
+        }
+    }
+    for _, c := range []struct {
+        path, token string
+        want        int
+    }{
+        {"/admin/actions", "admin-secret", http.StatusForbidden},
+        {"/admin/ip-filter", "", http.StatusForbidden},
+        {"/admin/ip-filter", "wrong", http.StatusForbidden},
+        {"/admin/ip-filter", "admin-secret", http.StatusOK},
+    } {
+        req := httptest.NewRequest(http.MethodGet, c.path, nil)
+        req.RemoteAddr = "198.51.100.7:5000"
+        if c.token != "" {
+            req.Header.Set("Authorization", "Bearer "+c.token)
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        if rec.Code != c.want {
+            t.Errorf("blocked %s with token %q: %d, want %d", c.path, c.token, rec.Code, c.want)
+        }
+    }
+
+    if rec := do(http.MethodPut, "/admin/ip-filter", `{}`); rec.Code != http.StatusOK {
+        t.Fatalf("clear: %d %s", rec.Code, rec.Body)
+    }
+    req := httptest.NewRequest(http.MethodGet, "/users", nil)
+    req.RemoteAddr = "198.51.100.7:5000"
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK {
+        t.Errorf("after clearing: %d", rec.Code)
+    }
+}
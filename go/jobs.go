@@ -0,0 +1,236 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+const (
+    jobQueued    = "queued"
+    jobRunning   = "running"
+    jobSucceeded = "succeeded"
+    jobFailed    = "failed"
+)
+
+// errJobQueueFull is returned when every worker is busy and the queue is full.
+var errJobQueueFull = errors.New("job queue is full")
+
+// Job tracks a bulk operation that runs after its request has returned.
+type Job struct {
+    ID         string      `json:"id"`
+    Type       string      `json:"type"`
+    Status     string      `json:"status"`
+    Total      int         `json:"total"`
+    Processed  int         `json:"processed"`
+    Failed     int         `json:"failed"`
+    Result     interface{} `json:"result,omitempty"`
+    Error      string      `json:"error,omitempty"`
+    CreatedAt  time.Time   `json:"created_at"`
+    StartedAt  *time.Time  `json:"started_at,omitempty"`
+    FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+// jobProgress is handed to a running job to report each processed item.
+type jobProgress func(ok bool)
+
+type jobFunc func(progress jobProgress) (interface{}, error)
+
+type queuedJob struct {
+    id  string
+    run jobFunc
+}
+
+// jobTracker runs jobs on a fixed set of workers fed by a bounded queue and
+// keeps finished jobs around for JOB_RETENTION (default 1h) so clients can
+// poll them.
+type jobTracker struct {
+    mu      sync.Mutex
+    jobs    map[string]*Job
+    queue   chan queuedJob
+    closed  bool
+    workers sync.WaitGroup
+}
+
+// Bulk jobs are CPU-bound, so by default there are as many JOB_WORKERS as
+// available CPUs and room for a few queued jobs per worker in
+// JOB_QUEUE_SIZE. At least one worker is required, or accepted jobs would
+// never run.
+var jobs = newJobTracker(config.JobWorkers, config.JobQueueSize)
+
+func newJobTracker(workers, queueSize int) *jobTracker {
+    t := &jobTracker{
+        jobs:  make(map[string]*Job),
+        queue: make(chan queuedJob, queueSize),
+    }
+    t.workers.Add(workers)
+    for i := 0; i < workers; i++ {
+        go t.work()
+    }
+    return t
+}
+
+// submit queues run and returns the new job, or errJobQueueFull.
+func (t *jobTracker) submit(jobType string, total int, run jobFunc) (Job, error) {
+    id, err := randomToken(12)
+    if err != nil {
+        return Job{}, err
+    }
+    job := &Job{ID: id, Type: jobType, Status: jobQueued, Total: total, CreatedAt: time.Now().UTC()}
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.closed {
+        return Job{}, errJobQueueFull
+    }
+    t.sweep()
+    select {
+    case t.queue <- queuedJob{id: id, run: run}:
+    default:
+        return Job{}, errJobQueueFull
+    }
+    t.jobs[id] = job
+    return *job, nil
+}
+
+func (t *jobTracker) get(id string) (Job, bool) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    job, ok := t.jobs[id]
+    if !ok {
+        return Job{}, false
+    }
+    return *job, true
+}
+
+// drain stops accepting jobs and waits until the queued and running ones
+// have finished or ctx is done.
+func (t *jobTracker) drain(ctx context.Context) error {
+    t.mu.Lock()
+    if !t.closed {
+        t.closed = true
+        close(t.queue)
+    }
+    t.mu.Unlock()
+
+    done := make(chan struct{})
+    go func() {
+        t.workers.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func (t *jobTracker) work() {
+    defer t.workers.Done()
+    for q := range t.queue {
+        t.update(q.id, func(job *Job) {
+            now := time.Now().UTC()
+            job.Status = jobRunning
+            job.StartedAt = &now
+        })
+
+        result, err := q.run(func(ok bool) {
+            t.update(q.id, func(job *Job) {
+                job.Processed++
+                if !ok {
+                    job.Failed++
+                }
+            })
+        })
+
+        t.update(q.id, func(job *Job) {
+            now := time.Now().UTC()
+            job.FinishedAt = &now
+            job.Result = result
+            job.Status = jobSucceeded
+            if err != nil {
+                job.Status = jobFailed
+                job.Error = err.Error()
+                slog.Error("Job failed", "job_id", job.ID, "type", job.Type, "error", err)
+            }
+        })
+    }
+}
+
+func (t *jobTracker) update(id string, fn func(*Job)) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    // submit registers the job after queueing it, under the same lock, so
+    // it is always present by the time a worker gets here.
+    fn(t.jobs[id])
+}
+
+// sweep drops finished jobs older than JOB_RETENTION. Callers hold t.mu.
+func (t *jobTracker) sweep() {
+    cutoff := time.Now().Add(-config.JobRetention)
+    for id, job := range t.jobs {
+        if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+            delete(t.jobs, id)
+        }
+    }
+}
+
+// runAsync reports whether a bulk request of n items should become a job:
+// ?async=true or false forces the choice, otherwise large batches go async.
+func runAsync(r *http.Request, n int) bool {
+    if v, err := strconv.ParseBool(r.URL.Query().Get("async")); err == nil {
+        return v
+    }
+    return n > config.AsyncBulkThreshold
+}
+
+// detachRequest returns a copy of r whose context outlives the request, for
+// jobs that keep using it (for storage calls and the activity actor) after
+// the 202 has been sent.
+func detachRequest(r *http.Request) *http.Request {
+    return r.WithContext(context.WithoutCancel(r.Context()))
+}
+
+// submitJob queues run and answers 202 with the job and its status URL.
+func submitJob(w http.ResponseWriter, r *http.Request, jobType string, total int, run jobFunc) {
+    job, err := jobs.submit(jobType, total, run)
+    if errors.Is(err, errJobQueueFull) {
+        w.Header().Set("Retry-After", "5")
+        writeError(w, r, httpError(http.StatusServiceUnavailable, "Too many bulk jobs in progress, retry later"))
+        return
+    }
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    w.Header().Set("Location", "/jobs/"+job.ID)
+    writeJSON(w, r, http.StatusAccepted, APIResponse{
+        Status:  "success",
+        Message: "Job accepted",
+        Data:    job,
+    })
+}
+
+// getJobHandler reports the progress of a bulk job. Only admins can start
+// bulk jobs, so only admins can read them.
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+    if !requireRole(w, r, roleAdmin) {
+        return
+    }
+    job, ok := jobs.get(mux.Vars(r)["id"])
+    if !ok {
+        writeError(w, r, httpError(http.StatusNotFound, "Job not found"))
+        return
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   job,
+    })
+}
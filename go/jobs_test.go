@@ -0,0 +1,132 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// waitForJob polls tracker until the job finishes.
+func waitForJob(t *testing.T, tracker *jobTracker, id string) Job {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for time.Now().Before(deadline) {
+        if job, ok := tracker.get(id); ok && job.FinishedAt != nil {
+            return job
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("job %s did not finish", id)
+    return Job{}
+}
+
+func TestJobTracker(t *testing.T) {
+    tracker := newJobTracker(1, 1)
+    defer tracker.drain(context.Background())
+
+    job, err := tracker.submit("test", 3, func(progress jobProgress) (interface{}, error) {
+        progress(true)
+        progress(false)
+        progress(true)
+        return "done", nil
+    })
+    if err != nil || job.Status != jobQueued {
+        t.Fatalf("submit: %+v, %v", job, err)
+    }
+    job = waitForJob(t, tracker, job.ID)
+    if job.Status != jobSucceeded || job.Processed != 3 || job.Failed != 1 || job.Result != "done" || job.StartedAt == nil {
+        t.Errorf("finished job %+v", job)
+    }
+
+    job, _ = tracker.submit("test", 0, func(jobProgress) (interface{}, error) {
+        return nil, errors.New("store unavailable")
+    })
+    if job = waitForJob(t, tracker, job.ID); job.Status != jobFailed || job.Error != "store unavailable" {
+        t.Errorf("failed job %+v", job)
+    }
+
+    // Hold the only worker so the next job stays queued and fills the queue.
+    release := make(chan struct{})
+    started := make(chan struct{})
+    tracker.submit("block", 0, func(jobProgress) (interface{}, error) {
+        close(started)
+        <-release
+        return nil, nil
+    })
+    <-started
+    if _, err := tracker.submit("test", 0, func(jobProgress) (interface{}, error) { return nil, nil }); err != nil {
+        t.Fatalf("queued job: %v", err)
+    }
+    if _, err := tracker.submit("test", 0, func(jobProgress) (interface{}, error) { return nil, nil }); !errors.Is(err, errJobQueueFull) {
+        t.Errorf("submit to a full queue: %v", err)
+    }
+    close(release)
+
+    if err := tracker.drain(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tracker.submit("test", 0, func(jobProgress) (interface{}, error) { return nil, nil }); err == nil {
+        t.Error("job accepted after drain")
+    }
+}
+
+func TestAsyncImport(t *testing.T) {
+    defer func(old *jobTracker) { jobs = old }(jobs)
+    jobs = newJobTracker(1, 1)
+    defer jobs.drain(context.Background())
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    userRepo = &memoryUserRepository{nextID: 1}
+
+    r := mux.NewRouter()
+    r.HandleFunc("/users/import", importUsersHandler).Methods("POST")
+    r.HandleFunc("/jobs/{id}", getJobHandler).Methods("GET")
+    serve := func(req *http.Request, roles ...string) *httptest.ResponseRecorder {
+        if roles != nil {
+            req = req.WithContext(context.WithValue(req.Context(), principalKey, Principal{Subject: "1", Roles: roles}))
+        }
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, req)
+        return rec
+    }
+
+    req := httptest.NewRequest(http.MethodPost, "/users/import?async=true", strings.NewReader("name,email\nAda,ada@example.com\n,bad\n"))
+    req.Header.Set("Content-Type", "text/csv")
+    rec := serve(req, roleAdmin)
+    if rec.Code != http.StatusAccepted {
+        t.Fatalf("import: %d %s", rec.Code, rec.Body)
+    }
+    var accepted struct {
+        Data Job `json:"data"`
+    }
+    json.Unmarshal(rec.Body.Bytes(), &accepted)
+    if location := rec.Header().Get("Location"); location != "/jobs/"+accepted.Data.ID || accepted.Data.Total != 2 {
+        t.Fatalf("Location %q, job %+v", location, accepted.Data)
+    }
+    waitForJob(t, jobs, accepted.Data.ID)
+
+    rec = serve(httptest.NewRequest(http.MethodGet, "/jobs/"+accepted.Data.ID, nil), roleAdmin)
+    var status struct {
+        Data struct {
+            Job
+            Result ImportResult `json:"result"`
+        } `json:"data"`
+    }
+    json.Unmarshal(rec.Body.Bytes(), &status)
+    if rec.Code != http.StatusOK || status.Data.Status != jobSucceeded || status.Data.Processed != 2 || status.Data.Failed != 1 || status.Data.Result.Imported != 1 {
+        t.Errorf("job status: %d %s", rec.Code, rec.Body)
+    }
+
+    if rec := serve(httptest.NewRequest(http.MethodGet, "/jobs/"+accepted.Data.ID, nil), roleUser); rec.Code != http.StatusForbidden {
+        t.Errorf("job read by a user: %d", rec.Code)
+    }
+    if rec := serve(httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil), roleAdmin); rec.Code != http.StatusNotFound {
+        t.Errorf("unknown job: %d", rec.Code)
+    }
+}
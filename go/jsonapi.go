@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// RESPONSE_FORMAT=jsonapi switches every response to JSON:API. Otherwise
+// clients opt in per request through the Accept header.
+
+// jsonAPIResource is implemented by types that render as JSON:API resource
+// objects. Any other payload is emitted as top-level meta.
+type jsonAPIResource interface {
+    jsonAPIType() string
+    jsonAPIID() string
+}
+
+func (u User) jsonAPIType() string { return "users" }
+func (u User) jsonAPIID() string   { return strconv.Itoa(u.ID) }
+
+func (e ActivityEvent) jsonAPIType() string { return "activity-events" }
+func (e ActivityEvent) jsonAPIID() string   { return strconv.FormatInt(e.ID, 10) }
+
+type jsonAPIResourceObject struct {
+    Type       string                 `json:"type"`
+    ID         string                 `json:"id"`
+    Attributes map[string]interface{} `json:"attributes"`
+}
+
+type jsonAPIError struct {
+    Status string              `json:"status"`
+    Title  string              `json:"title"`
+    Detail string              `json:"detail,omitempty"`
+    Source *jsonAPIErrorSource `json:"source,omitempty"`
+}
+
+type jsonAPIErrorSource struct {
+    Pointer string `json:"pointer"`
+}
+
+type jsonAPIDocument struct {
+    Data   interface{}    `json:"data,omitempty"`
+    Errors []jsonAPIError `json:"errors,omitempty"`
+    Meta   interface{}    `json:"meta,omitempty"`
+}
+
+func wantsJSONAPI(r *http.Request) bool {
+    return config.DefaultJSONAPI || strings.Contains(r.Header.Get("Accept"), jsonAPIMediaType)
+}
+
+// writeJSONAPI renders an APIResponse as a JSON:API document.
+func writeJSONAPI(w http.ResponseWriter, status int, response APIResponse) {
+    var doc jsonAPIDocument
+    if status >= 400 {
+        doc.Errors = jsonAPIErrors(status, response)
+    } else if data, ok := jsonAPIData(response.Data); ok {
+        doc.Data = data
+    } else {
+        doc.Meta = response.Data
+    }
+
+    buf := getBuffer()
+    defer putBuffer(buf)
+    json.NewEncoder(buf).Encode(doc)
+
+    w.Header().Set("Content-Type", jsonAPIMediaType)
+    w.WriteHeader(status)
+    w.Write(buf.Bytes())
+}
+
+func jsonAPIErrors(status int, response APIResponse) []jsonAPIError {
+    code := strconv.Itoa(status)
+    if len(response.Errors) == 0 {
+        return []jsonAPIError{{Status: code, Title: response.Message}}
+    }
+    errs := make([]jsonAPIError, len(response.Errors))
+    for i, fe := range response.Errors {
+        errs[i] = jsonAPIError{
+            Status: code,
+            Title:  response.Message,
+            Detail: fe.Message,
+            Source: &jsonAPIErrorSource{Pointer: "/data/attributes/" + fe.Field},
+        }
+    }
+    return errs
+}
+
+// jsonAPIData converts a resource, or a slice of resources, into resource
+// objects. It reports false for payloads that are not resources.
+func jsonAPIData(data interface{}) (interface{}, bool) {
+    if res, ok := data.(jsonAPIResource); ok {
+        return jsonAPIObject(res), true
+    }
+    v := reflect.ValueOf(data)
+    if v.Kind() != reflect.Slice || !v.Type().Elem().Implements(reflect.TypeOf((*jsonAPIResource)(nil)).Elem()) {
+        return nil, false
+    }
+    objects := make([]jsonAPIResourceObject, v.Len())
+    for i := range objects {
+        objects[i] = jsonAPIObject(v.Index(i).Interface().(jsonAPIResource))
+    }
+    return objects, true
+}
+
+// jsonAPIObject moves every field except the ID into attributes.
+func jsonAPIObject(res jsonAPIResource) jsonAPIResourceObject {
+    raw, _ := json.Marshal(res)
+    var attributes map[string]interface{}
+    json.Unmarshal(raw, &attributes)
+    delete(attributes, "id")
+    return jsonAPIResourceObject{Type: res.jsonAPIType(), ID: res.jsonAPIID(), Attributes: attributes}
+}
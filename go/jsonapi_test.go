@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+)
+
+func TestJSONAPI(t *testing.T) {
+    write := func(accept string, status int, response APIResponse) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, "/users", nil)
+        req.Header.Set("Accept", accept)
+        rec := httptest.NewRecorder()
+        writeJSON(rec, req, status, response)
+        return rec
+    }
+    decode := func(rec *httptest.ResponseRecorder) map[string]interface{} {
+        t.Helper()
+        var doc map[string]interface{}
+        if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+            t.Fatalf("%v: %s", err, rec.Body)
+        }
+        return doc
+    }
+    ada := User{ID: 7, Name: "Ada", Email: "ada@example.com"}
+
+    if rec := write("application/json", http.StatusOK, APIResponse{Status: "success", Data: ada}); rec.Header().Get("Content-Type") == jsonAPIMediaType {
+        t.Error("JSON:API sent without being asked for")
+    }
+
+    rec := write(jsonAPIMediaType, http.StatusOK, APIResponse{Status: "success", Data: ada})
+    if rec.Header().Get("Content-Type") != jsonAPIMediaType {
+        t.Errorf("Content-Type %q", rec.Header().Get("Content-Type"))
+    }
+    data, _ := decode(rec)["data"].(map[string]interface{})
+    attributes, _ := data["attributes"].(map[string]interface{})
+    if data["type"] != "users" || data["id"] != "7" || attributes["name"] != "Ada" || attributes["id"] != nil {
+        t.Errorf("single resource: %s", rec.Body)
+    }
+
+    rec = write(jsonAPIMediaType, http.StatusOK, APIResponse{Status: "success", Data: []User{ada, {ID: 8, Name: "Bob"}}})
+    if list, _ := decode(rec)["data"].([]interface{}); len(list) != 2 || list[1].(map[string]interface{})["id"] != "8" {
+        t.Errorf("resource list: %s", rec.Body)
+    }
+
+    // Payloads that are not resources go to meta.
+    rec = write(jsonAPIMediaType, http.StatusOK, APIResponse{Status: "success", Data: map[string]int{"total": 2}})
+    if doc := decode(rec); doc["data"] != nil || !reflect.DeepEqual(doc["meta"], map[string]interface{}{"total": 2.0}) {
+        t.Errorf("meta: %s", rec.Body)
+    }
+
+    rec = write(jsonAPIMediaType, http.StatusUnprocessableEntity, APIResponse{Status: "error", Message: "Validation failed", Errors: []FieldError{
+        {Field: "name", Message: "name is required"},
+        {Field: "email", Message: "email is invalid"},
+    }})
+    errs, _ := decode(rec)["errors"].([]interface{})
+    if rec.Code != http.StatusUnprocessableEntity || len(errs) != 2 {
+        t.Fatalf("errors: %d %s", rec.Code, rec.Body)
+    }
+    second := errs[1].(map[string]interface{})
+    source, _ := second["source"].(map[string]interface{})
+    if second["status"] != "422" || second["title"] != "Validation failed" || second["detail"] != "email is invalid" || source["pointer"] != "/data/attributes/email" {
+        t.Errorf("field error: %v", second)
+    }
+
+    // Errors go through writeError too.
+    req := httptest.NewRequest(http.MethodGet, "/users/9", nil)
+    req.Header.Set("Accept", jsonAPIMediaType)
+    rec = httptest.NewRecorder()
+    writeError(rec, req, ErrNotFound)
+    errs, _ = decode(rec)["errors"].([]interface{})
+    if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != jsonAPIMediaType || len(errs) != 1 || errs[0].(map[string]interface{})["title"] != "User not found" {
+        t.Errorf("not found: %d %s", rec.Code, rec.Body)
+    }
+}
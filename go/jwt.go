@@ -0,0 +1,392 @@
+package main
+
+import (
+    "context"
+    "crypto"
+    "crypto/hmac"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/sha512"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "hash"
+    "log/slog"
+    "math/big"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Besides the tokens /login issues, bearer tokens can be JWTs from another
+// issuer, such as an identity provider or a service sharing a key:
+//
+//   - HS256, HS384 and HS512 tokens signed with JWT_HMAC_SECRET;
+//   - RS256, RS384 and RS512 tokens signed with the key in
+//     JWT_PUBLIC_KEY_FILE (PEM, PKIX or PKCS #1) or a key of the JWKS at
+//     JWT_JWKS_URL, picked by the token's kid. The JWKS is fetched at
+//     startup and every JWT_JWKS_REFRESH (default 1h), and again, at most
+//     once a minute, when a token names a kid it does not have, so rotated
+//     keys are picked up. OIDC_ISSUER_URL finds the JWKS of an OpenID
+//     Connect provider instead; see oidc.go.
+//
+// Such tokens must not be expired or used before nbf, allowing JWT_LEEWAY
+// (default 30s) of clock skew, and must carry JWT_ISSUER and JWT_AUDIENCE
+// when those are set. Their sub is the caller and the JWT_ROLES_CLAIM claim
+// (default roles), a list or a space-separated string, its roles. Handlers
+// get every claim through claimsFromContext.
+//
+// With AUTH_REQUIRED=true, the /users routes answer 401 to requests without
+// a valid token, except those that publicMutations lets anyone make.
+var (
+    jwtHMACSecret []byte
+    jwtPublicKey  *rsa.PublicKey
+    jwtKeys       = &jwksCache{}
+)
+
+var errJWTAlgorithm = errors.New("unsupported token algorithm")
+
+// Claims are the claims of the verified token of a request.
+type Claims map[string]any
+
+const claimsKey contextKey = "claims"
+
+// claimsFromContext returns the claims of the token the request was
+// authenticated with.
+func claimsFromContext(ctx context.Context) (Claims, bool) {
+    c, ok := ctx.Value(claimsKey).(Claims)
+    return c, ok
+}
+
+// String returns the claim name if it is a string.
+func (c Claims) String(name string) string {
+    s, _ := c[name].(string)
+    return s
+}
+
+// setupJWT loads the keys for verifying external tokens.
+func setupJWT() error {
+    if secret := getenv("JWT_HMAC_SECRET"); secret != "" {
+        jwtHMACSecret = []byte(secret)
+    }
+    if path := getenv("JWT_PUBLIC_KEY_FILE"); path != "" {
+        key, err := readRSAPublicKey(path)
+        if err != nil {
+            return fmt.Errorf("JWT_PUBLIC_KEY_FILE: %w", err)
+        }
+        jwtPublicKey = key
+    }
+    if config.OIDCIssuerURL != "" {
+        if err := setupOIDC(); err != nil {
+            return err
+        }
+    } else if config.JWTJWKSURL != "" {
+        jwtKeys.url = config.JWTJWKSURL
+    }
+    if jwtKeys.url != "" {
+        jwtKeys.client = newHTTPClient(10 * time.Second)
+        if err := jwtKeys.refresh(context.Background()); err != nil {
+            // The provider may be down for now; tokens are rejected until
+            // the next refresh succeeds.
+            slog.Warn("Failed to fetch JWKS", "url", jwtKeys.url, "error", err)
+        }
+        if config.JWTJWKSRefresh > 0 {
+            go func() {
+                for range time.Tick(config.JWTJWKSRefresh) {
+                    if err := jwtKeys.refresh(context.Background()); err != nil {
+                        slog.Warn("Failed to refresh JWKS", "url", jwtKeys.url, "error", err)
+                    }
+                }
+            }()
+        }
+    }
+    if jwtHMACSecret != nil || jwtPublicKey != nil || jwtKeys.url != "" {
+        slog.Info("Accepting external JWTs", "hmac", jwtHMACSecret != nil, "public_key", jwtPublicKey != nil, "jwks", jwtKeys.url, "issuer", config.JWTIssuer, "audience", config.JWTAudience)
+    }
+    return nil
+}
+
+func readRSAPublicKey(path string) (*rsa.PublicKey, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("no PEM block")
+    }
+    if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+        return key, nil
+    }
+    parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+    if err != nil {
+        return nil, err
+    }
+    key, ok := parsed.(*rsa.PublicKey)
+    if !ok {
+        return nil, errors.New("not an RSA public key")
+    }
+    return key, nil
+}
+
+// verifyJWT checks an external token and returns its claims.
+func verifyJWT(ctx context.Context, token string) (Claims, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return nil, errInvalidToken
+    }
+    var header struct {
+        Alg string `json:"alg"`
+        Kid string `json:"kid"`
+    }
+    if err := decodeJWTPart(parts[0], &header); err != nil {
+        return nil, errInvalidToken
+    }
+    sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, errInvalidToken
+    }
+    signed := []byte(parts[0] + "." + parts[1])
+    if err := verifyJWTSignature(ctx, header.Alg, header.Kid, signed, sig); err != nil {
+        return nil, err
+    }
+    var claims Claims
+    if err := decodeJWTPart(parts[1], &claims); err != nil {
+        return nil, errInvalidToken
+    }
+    if err := checkJWTClaims(claims, time.Now()); err != nil {
+        return nil, err
+    }
+    return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+    data, err := base64.RawURLEncoding.DecodeString(part)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+func verifyJWTSignature(ctx context.Context, alg, kid string, signed, sig []byte) error {
+    if len(alg) != 5 {
+        return errJWTAlgorithm
+    }
+    var newHash func() hash.Hash
+    var h crypto.Hash
+    switch alg[2:] {
+    case "256":
+        newHash, h = sha256.New, crypto.SHA256
+    case "384":
+        newHash, h = sha512.New384, crypto.SHA384
+    case "512":
+        newHash, h = sha512.New, crypto.SHA512
+    default:
+        return errJWTAlgorithm
+    }
+    switch alg[:2] {
+    case "HS":
+        if jwtHMACSecret == nil {
+            return errJWTAlgorithm
+        }
+        mac := hmac.New(newHash, jwtHMACSecret)
+        mac.Write(signed)
+        if !hmac.Equal(mac.Sum(nil), sig) {
+            return errInvalidToken
+        }
+        return nil
+    case "RS":
+        key := jwtPublicKey
+        if jwtKeys.client != nil && (key == nil || kid != "") {
+            key = jwtKeys.key(ctx, kid)
+        }
+        if key == nil {
+            return errInvalidToken
+        }
+        digest := h.New()
+        digest.Write(signed)
+        if rsa.VerifyPKCS1v15(key, h, digest.Sum(nil), sig) != nil {
+            return errInvalidToken
+        }
+        return nil
+    }
+    return errJWTAlgorithm
+}
+
+// checkJWTClaims checks the time, issuer and audience claims.
+func checkJWTClaims(claims Claims, now time.Time) error {
+    exp, ok := claims["exp"].(float64)
+    if !ok {
+        return errInvalidToken
+    }
+    if now.Add(-config.JWTLeeway).Unix() >= int64(exp) {
+        return errExpiredToken
+    }
+    if nbf, ok := claims["nbf"].(float64); ok && now.Add(config.JWTLeeway).Unix() < int64(nbf) {
+        return errInvalidToken
+    }
+    if config.JWTIssuer != "" && claims.String("iss") != config.JWTIssuer {
+        return errInvalidToken
+    }
+    if config.JWTAudience != "" {
+        switch aud := claims["aud"].(type) {
+        case string:
+            if aud != config.JWTAudience {
+                return errInvalidToken
+            }
+        case []any:
+            found := false
+            for _, a := range aud {
+                found = found || a == config.JWTAudience
+            }
+            if !found {
+                return errInvalidToken
+            }
+        default:
+            return errInvalidToken
+        }
+    }
+    return nil
+}
+
+// jwtRoles returns the roles in the JWT_ROLES_CLAIM claim, translated by
+// JWT_ROLE_MAP.
+func jwtRoles(claims Claims) []string {
+    name := config.JWTRolesClaim
+    if name == "" {
+        name = "roles"
+    }
+    var roles []string
+    switch v := claimPath(claims, name).(type) {
+    case string:
+        roles = strings.Fields(v)
+    case []any:
+        for _, r := range v {
+            if s, ok := r.(string); ok {
+                roles = append(roles, s)
+            }
+        }
+    }
+    return mapRoles(roles)
+}
+
+// requireAuthMiddleware rejects anonymous requests to the /users routes
+// with AUTH_REQUIRED.
+func requireAuthMiddleware(next http.Handler) http.Handler {
+    if !config.AuthRequired {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        tmpl := routeTemplate(r)
+        if strings.HasPrefix(tmpl, "/users") && !publicMutations[tmpl] {
+            if _, ok := principalFromContext(r.Context()); !ok {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="user-api", error="invalid_token"`)
+                writeError(w, r, httpError(http.StatusUnauthorized, "Authentication required"))
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// jwksCache holds the RSA keys of JWT_JWKS_URL, or of the OIDC provider,
+// by kid.
+type jwksCache struct {
+    // url is the JWKS, or with discover the discovery document that names
+    // it.
+    url      string
+    discover func(ctx context.Context) (string, error)
+    client   *http.Client
+
+    mu          sync.RWMutex
+    keys        map[string]*rsa.PublicKey
+    lastRefresh time.Time
+}
+
+// key returns the key kid names, refetching the JWKS for an unknown kid
+// unless it was fetched within the last minute. Without a kid, the only
+// key of the set is used.
+func (c *jwksCache) key(ctx context.Context, kid string) *rsa.PublicKey {
+    c.mu.RLock()
+    key, ok := c.lookup(kid)
+    recent := time.Since(c.lastRefresh) < time.Minute
+    c.mu.RUnlock()
+    if ok || recent {
+        return key
+    }
+    if err := c.refresh(ctx); err != nil {
+        slog.Warn("Failed to refresh JWKS", "url", c.url, "error", err)
+    }
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    key, _ = c.lookup(kid)
+    return key
+}
+
+func (c *jwksCache) lookup(kid string) (*rsa.PublicKey, bool) {
+    if kid == "" && len(c.keys) == 1 {
+        for _, key := range c.keys {
+            return key, true
+        }
+    }
+    key, ok := c.keys[kid]
+    return key, ok
+}
+
+func (c *jwksCache) refresh(ctx context.Context) error {
+    c.mu.Lock()
+    c.lastRefresh = time.Now()
+    c.mu.Unlock()
+    url := c.url
+    if c.discover != nil {
+        var err error
+        if url, err = c.discover(ctx); err != nil {
+            return err
+        }
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("JWKS endpoint returned %s", resp.Status)
+    }
+    var set struct {
+        Keys []struct {
+            Kty string `json:"kty"`
+            Kid string `json:"kid"`
+            Use string `json:"use"`
+            N   string `json:"n"`
+            E   string `json:"e"`
+        } `json:"keys"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+        return fmt.Errorf("decode JWKS: %w", err)
+    }
+    keys := make(map[string]*rsa.PublicKey)
+    for _, k := range set.Keys {
+        if k.Kty != "RSA" || k.Use != "" && k.Use != "sig" {
+            continue
+        }
+        n, errN := base64.RawURLEncoding.DecodeString(k.N)
+        e, errE := base64.RawURLEncoding.DecodeString(k.E)
+        if errN != nil || errE != nil || len(e) > 4 {
+            continue
+        }
+        keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+    }
+    c.mu.Lock()
+    c.keys = keys
+    c.mu.Unlock()
+    return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "crypto"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+// testJWT signs claims with alg: HS256 with secret or RS256 with key.
+func testJWT(t *testing.T, alg, kid string, claims map[string]any, secret []byte, key *rsa.PrivateKey) string {
+    t.Helper()
+    header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+    payload, _ := json.Marshal(claims)
+    signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+    var sig []byte
+    if alg == "HS256" {
+        mac := hmac.New(sha256.New, secret)
+        mac.Write([]byte(signed))
+        sig = mac.Sum(nil)
+    } else {
+        digest := sha256.Sum256([]byte(signed))
+        var err error
+        if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+    defer func(secret []byte, iss, url string) { jwtHMACSecret, config.JWTIssuer, config.JWTJWKSURL = secret, iss, url }(jwtHMACSecret, config.JWTIssuer, config.JWTJWKSURL)
+    jwtHMACSecret, config.JWTIssuer = []byte("shared"), "https://idp.example.com"
+    exp := float64(time.Now().Add(time.Hour).Unix())
+    ctx := context.Background()
+
+    claims, err := verifyJWT(ctx, testJWT(t, "HS256", "", map[string]any{"sub": "svc", "iss": config.JWTIssuer, "exp": exp, "roles": "admin user"}, jwtHMACSecret, nil))
+    if err != nil || claims.String("sub") != "svc" || len(jwtRoles(claims)) != 2 {
+        t.Errorf("HS256: %v, %v", claims, err)
+    }
+    if _, err := verifyJWT(ctx, testJWT(t, "HS256", "", map[string]any{"sub": "svc", "iss": "other", "exp": exp}, jwtHMACSecret, nil)); err == nil {
+        t.Error("wrong issuer accepted")
+    }
+    if _, err := verifyJWT(ctx, testJWT(t, "HS256", "", map[string]any{"sub": "svc", "iss": config.JWTIssuer, "exp": exp}, []byte("guess"), nil)); err == nil {
+        t.Error("wrong key accepted")
+    }
+    if _, err := verifyJWT(ctx, testJWT(t, "HS256", "", map[string]any{"iss": config.JWTIssuer, "exp": float64(time.Now().Add(-time.Hour).Unix())}, jwtHMACSecret, nil)); err != errExpiredToken {
+        t.Errorf("expired: %v", err)
+    }
+
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+            "kty": "RSA", "kid": "k1", "use": "sig",
+            "n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+            "e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+        }}})
+    }))
+    defer jwks.Close()
+    config.JWTJWKSURL = jwks.URL
+    defer func(c *jwksCache) { jwtKeys = c }(jwtKeys)
+    jwtKeys = &jwksCache{url: jwks.URL, client: jwks.Client()}
+
+    token := testJWT(t, "RS256", "k1", map[string]any{"sub": "alice", "iss": config.JWTIssuer, "exp": exp, "roles": []string{"user"}}, nil, key)
+    r := httptest.NewRequest(http.MethodGet, "/users", nil)
+    r.Header.Set("Authorization", "Bearer "+token)
+    var got Claims
+    authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got, _ = claimsFromContext(r.Context())
+    })).ServeHTTP(httptest.NewRecorder(), r)
+    if got.String("sub") != "alice" {
+        t.Errorf("RS256 claims: %v", got)
+    }
+    if _, err := verifyJWT(ctx, testJWT(t, "RS256", "k2", map[string]any{"sub": "alice", "iss": config.JWTIssuer, "exp": exp}, nil, key)); err == nil {
+        t.Error("unknown kid accepted")
+    }
+}
+
+func TestAuthRequired(t *testing.T) {
+    defer func(old bool) { config.AuthRequired = old }(config.AuthRequired)
+    config.AuthRequired = true
+    h := authMiddleware(requireAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+    for path, want := range map[string]int{"/users": http.StatusUnauthorized, "/teams": http.StatusOK} {
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+        if rec.Code != want {
+            t.Errorf("%s: %d, want %d", path, rec.Code, want)
+        }
+    }
+}
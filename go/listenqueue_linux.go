@@ -0,0 +1,116 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "log/slog"
+    "net"
+    "os"
+    "slices"
+    "strconv"
+    "strings"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    listenQueueLengthDesc = prometheus.NewDesc(
+        "http_listen_queue_length",
+        "Connections accepted by the kernel on the API ports and waiting for the server to pick them up",
+        nil, nil,
+    )
+    listenQueueMaxDesc = prometheus.NewDesc(
+        "http_listen_queue_max_length",
+        "Size of the accept queue of each API port (net.core.somaxconn); connections beyond it are dropped",
+        nil, nil,
+    )
+)
+
+// listenQueueCollector reads the accept queues of listening TCP ports from
+// /proc/net/tcp and tcp6, where the receive queue of a listening socket is
+// its current backlog. Go listens with a backlog of net.core.somaxconn, so
+// that is the maximum. A queue that is not empty means the server cannot
+// keep up, e.g. because it is throttled by its CPU limit.
+type listenQueueCollector struct {
+    ports []uint64
+}
+
+func somaxconn() (float64, error) {
+    data, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+    if err != nil {
+        return 0, err
+    }
+    return strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+}
+
+// registerListenQueueMetrics exports the accept queues of lns, summed.
+func registerListenQueueMetrics(lns ...net.Listener) {
+    var c listenQueueCollector
+    for _, ln := range lns {
+        if addr, ok := ln.Addr().(*net.TCPAddr); ok && !slices.Contains(c.ports, uint64(addr.Port)) {
+            c.ports = append(c.ports, uint64(addr.Port))
+        }
+    }
+    if len(c.ports) == 0 {
+        return
+    }
+    if _, err := c.read(); err != nil {
+        slog.Warn("Accept queue metrics unavailable", "error", err)
+        return
+    }
+    prometheus.MustRegister(c)
+}
+
+func (c listenQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- listenQueueLengthDesc
+    ch <- listenQueueMaxDesc
+}
+
+func (c listenQueueCollector) Collect(ch chan<- prometheus.Metric) {
+    if length, err := c.read(); err != nil {
+        ch <- prometheus.NewInvalidMetric(listenQueueLengthDesc, err)
+    } else {
+        ch <- prometheus.MustNewConstMetric(listenQueueLengthDesc, prometheus.GaugeValue, length)
+    }
+    if max, err := somaxconn(); err == nil {
+        ch <- prometheus.MustNewConstMetric(listenQueueMaxDesc, prometheus.GaugeValue, max)
+    }
+}
+
+// read sums the queues of the sockets listening on the ports, e.g. one for
+// IPv4 and one for IPv6.
+func (c listenQueueCollector) read() (length float64, err error) {
+    found := false
+    for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+        f, err := os.Open(path)
+        if err != nil {
+            continue
+        }
+        scanner := bufio.NewScanner(f)
+        scanner.Scan() // header
+        for scanner.Scan() {
+            // sl local_address rem_address st tx_queue:rx_queue ...
+            fields := strings.Fields(scanner.Text())
+            if len(fields) < 5 || fields[3] != "0A" {
+                continue
+            }
+            i := strings.LastIndexByte(fields[1], ':')
+            port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+            if err != nil || !slices.Contains(c.ports, port) {
+                continue
+            }
+            _, rx, _ := strings.Cut(fields[4], ":")
+            queue, err := strconv.ParseUint(rx, 16, 32)
+            if err != nil {
+                continue
+            }
+            length += float64(queue)
+            found = true
+        }
+        f.Close()
+    }
+    if !found {
+        return 0, fmt.Errorf("no socket listening on ports %v in /proc/net/tcp", c.ports)
+    }
+    return length, nil
+}
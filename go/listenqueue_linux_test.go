@@ -0,0 +1,31 @@
+package main
+
+import (
+    "net"
+    "testing"
+)
+
+func TestListenQueue(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    c := listenQueueCollector{ports: []uint64{uint64(ln.Addr().(*net.TCPAddr).Port)}}
+
+    // Connections the server has not accepted yet wait in the queue.
+    for i := 0; i < 3; i++ {
+        conn, err := net.Dial("tcp", ln.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+    }
+    length, err := c.read()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if length != 3 {
+        t.Fatalf("accept queue length %v, want 3", length)
+    }
+}
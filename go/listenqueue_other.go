@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// registerListenQueueMetrics does nothing: the accept queue is read from
+// /proc, which only Linux has.
+func registerListenQueueMetrics(lns ...net.Listener) {}
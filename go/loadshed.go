@@ -0,0 +1,62 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "sync/atomic"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// MAX_IN_FLIGHT caps the requests served at once; beyond it, requests are
+// answered 503 with a Retry-After of LOAD_SHED_RETRY_AFTER seconds (default
+// 1) at once, rather than queueing behind the others until they all time
+// out, as happens to a container throttled at its CPU limit. Compare it with
+// http_requests_in_flight under load to pick a value. 0, the default, turns
+// it off. The probes and /metrics are never shed, so an overloaded replica is
+// neither restarted nor blind. Both settings change with a reload.
+var (
+    maxInFlight        atomic.Int64
+    loadShedRetryAfter atomic.Int64
+    // inFlight counts the requests being served that can be shed.
+    inFlight atomic.Int64
+)
+
+var httpRequestsShedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+    Name: "http_requests_shed_total",
+    Help: "Requests answered 503 because MAX_IN_FLIGHT requests were being served",
+})
+
+func init() {
+    prometheus.MustRegister(httpRequestsShedTotal)
+    reloadableSetting(func() error {
+        var env envReader
+        limit := env.intAtLeast("MAX_IN_FLIGHT", 0, 0)
+        retryAfter := env.intAtLeast("LOAD_SHED_RETRY_AFTER", 1, 1)
+        if err := env.err(); err != nil {
+            return err
+        }
+        maxInFlight.Store(int64(limit))
+        loadShedRetryAfter.Store(int64(retryAfter))
+        return nil
+    }, "MAX_IN_FLIGHT", "LOAD_SHED_RETRY_AFTER")
+}
+
+// loadShedMiddleware rejects requests over MAX_IN_FLIGHT.
+func loadShedMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        limit := maxInFlight.Load()
+        if limit == 0 || probeRoutes[routeTemplate(r)] || r.URL.Path == "/metrics" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        defer inFlight.Add(-1)
+        if inFlight.Add(1) > limit {
+            httpRequestsShedTotal.Inc()
+            w.Header().Set("Retry-After", strconv.FormatInt(loadShedRetryAfter.Load(), 10))
+            writeError(w, r, httpError(http.StatusServiceUnavailable, "Server overloaded, retry later"))
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
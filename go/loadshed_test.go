@@ -0,0 +1,46 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gorilla/mux"
+)
+
+func TestLoadShed(t *testing.T) {
+    defer maxInFlight.Store(maxInFlight.Load())
+    maxInFlight.Store(1)
+
+    entered, release := make(chan struct{}), make(chan struct{})
+    r := mux.NewRouter()
+    r.Use(loadShedMiddleware)
+    r.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+        entered <- struct{}{}
+        <-release
+    })
+    r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+    call := func(path string) *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+        return rec
+    }
+
+    done := make(chan int)
+    go func() { done <- call("/users").Code }()
+    <-entered
+    if rec := call("/users"); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+        t.Errorf("over the limit: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+    }
+    if rec := call("/healthz"); rec.Code != http.StatusOK {
+        t.Errorf("probe over the limit: %d", rec.Code)
+    }
+    close(release)
+    if code := <-done; code != http.StatusOK {
+        t.Errorf("first request: %d", code)
+    }
+    go func() { <-entered }()
+    if rec := call("/users"); rec.Code != http.StatusOK {
+        t.Errorf("after the first finished: %d", rec.Code)
+    }
+}
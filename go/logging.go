@@ -0,0 +1,83 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// logLevel is the minimum level logged: LOG_LEVEL at startup and on reload
+// (debug, info, warn or error, default info), changed at runtime through
+// /admin/log-level.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging makes slog log one JSON object per line to stderr, or
+// logfmt-style text with LOG_FORMAT=text, so log pipelines such as Loki or
+// ELK can parse entries without regexes. Entries are also kept for support
+// bundles, and anything still using the log package goes through slog at
+// the info level.
+func setupLogging() {
+    out := io.MultiWriter(os.Stderr, recentLogs)
+    opts := &slog.HandlerOptions{Level: logLevel}
+    var handler slog.Handler
+    if startupEnv.oneOf("LOG_FORMAT", "json", "text") == "text" {
+        handler = slog.NewTextHandler(out, opts)
+    } else {
+        handler = slog.NewJSONHandler(out, opts)
+    }
+    slog.SetDefault(slog.New(requestIDHandler{handler}))
+}
+
+func init() {
+    reloadableSetting(applyLogLevel, "LOG_LEVEL")
+}
+
+func applyLogLevel() error {
+    level := slog.LevelInfo
+    if value := getenv("LOG_LEVEL"); value != "" {
+        if err := level.UnmarshalText([]byte(value)); err != nil {
+            return invalidSetting("LOG_LEVEL", value, "not debug, info, warn or error")
+        }
+    }
+    logLevel.Set(level)
+    return nil
+}
+
+// LogLevel is the body of /admin/log-level.
+type LogLevel struct {
+    Level string `json:"level"`
+}
+
+func currentLogLevel() LogLevel {
+    return LogLevel{Level: strings.ToLower(logLevel.Level().String())}
+}
+
+// logLevelHandler serves GET and PUT /admin/log-level, so debug logging can
+// be turned on in a running container and off again without a restart.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodPut {
+        var req LogLevel
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+            return
+        }
+        var level slog.Level
+        if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+            writeError(w, r, httpError(http.StatusBadRequest, "level must be debug, info, warn or error"))
+            return
+        }
+        previous := currentLogLevel()
+        logLevel.Set(level)
+        slog.Warn("Log level changed", "from", previous.Level, "to", currentLogLevel().Level)
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{Status: "success", Data: currentLogLevel()})
+}
+
+// fatal logs an error and exits, like log.Fatalf.
+func fatal(msg string, args ...any) {
+    slog.Error(msg, args...)
+    os.Exit(1)
+}
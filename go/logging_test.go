@@ -0,0 +1,31 @@
+package main
+
+import (
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+    defer logLevel.Set(logLevel.Level())
+
+    put := func(body string) int {
+        w := httptest.NewRecorder()
+        logLevelHandler(w, httptest.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(body)))
+        return w.Code
+    }
+    if code := put(`{"level":"debug"}`); code != http.StatusOK || logLevel.Level() != slog.LevelDebug {
+        t.Fatalf("PUT debug: got %d and level %v", code, logLevel.Level())
+    }
+    if code := put(`{"level":"verbose"}`); code != http.StatusBadRequest || logLevel.Level() != slog.LevelDebug {
+        t.Fatalf("PUT verbose: got %d and level %v, want 400 and debug kept", code, logLevel.Level())
+    }
+
+    w := httptest.NewRecorder()
+    logLevelHandler(w, httptest.NewRequest(http.MethodGet, "/admin/log-level", nil))
+    if !strings.Contains(w.Body.String(), `"level":"debug"`) {
+        t.Fatalf("GET returned %s", w.Body)
+    }
+}
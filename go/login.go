@@ -0,0 +1,114 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the shortest password accepted on create.
+const minPasswordLength = 8
+
+// dummyPasswordHash is compared against when the email is unknown so that
+// failed logins take the same time whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("user-api-dummy-password"), bcrypt.DefaultCost)
+
+type LoginRequest struct {
+    Email    string `json:"email"`
+    Password string `json:"password"`
+}
+
+type TokenResponse struct {
+    AccessToken  string `json:"access_token"`
+    TokenType    string `json:"token_type"`
+    ExpiresIn    int    `json:"expires_in"`
+    RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+func validatePassword(password string) error {
+    if len(password) < minPasswordLength {
+        return errors.New("password must be at least " + strconv.Itoa(minPasswordLength) + " characters")
+    }
+    if len(password) > 72 {
+        return errors.New("password must be at most 72 bytes")
+    }
+    return nil
+}
+
+// hashPassword moves a plaintext password into PasswordHash. The plaintext
+// is cleared so it can never be serialized back to a client.
+func hashPassword(user *User) error {
+    if user.Password == "" {
+        return nil
+    }
+    hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+    if err != nil {
+        return err
+    }
+    user.PasswordHash = string(hash)
+    user.Password = ""
+    return nil
+}
+
+// issueAccessToken signs a token carrying the user's ID and roles.
+func issueAccessToken(user User) (TokenResponse, error) {
+    now := time.Now()
+    token, err := signToken(TokenClaims{
+        Subject:   strconv.Itoa(user.ID),
+        Email:     user.Email,
+        Roles:     user.Roles,
+        IssuedAt:  now.Unix(),
+        ExpiresAt: now.Add(config.AccessTokenTTL).Unix(),
+    })
+    if err != nil {
+        return TokenResponse{}, err
+    }
+    return TokenResponse{
+        AccessToken: token,
+        TokenType:   "Bearer",
+        ExpiresIn:   int(config.AccessTokenTTL.Seconds()),
+    }, nil
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+    var req LoginRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    if !checkLoginLockout(w, r, req.Email) {
+        return
+    }
+
+    hash := dummyPasswordHash
+    user, err := userRepo.GetByEmail(r.Context(), req.Email)
+    if err != nil && !errors.Is(err, ErrNotFound) {
+        writeError(w, r, err)
+        return
+    }
+    ok := err == nil && user.PasswordHash != ""
+    if ok {
+        hash = []byte(user.PasswordHash)
+    }
+    if err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || !ok {
+        recordLogin(r, req.Email, false)
+        writeError(w, r, httpError(http.StatusUnauthorized, "Invalid email or password"))
+        return
+    }
+    recordLogin(r, req.Email, true)
+
+    token, err := issueTokens(user, "")
+    if err != nil {
+        writeError(w, r, httpError(http.StatusInternalServerError, "Could not issue token"))
+        return
+    }
+    setSessionCookie(w, r, token)
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   token,
+    })
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Failed logins are counted per account and per client address. Once an
+// account has LOGIN_MAX_FAILURES (default 5) in a row, or an address
+// LOGIN_MAX_FAILURES_PER_IP (default 20), further logins for it are
+// answered 429 without checking the password for LOGIN_LOCKOUT (default
+// 30s), doubling with every failure after that up to LOGIN_LOCKOUT_MAX
+// (default 15m). Unknown emails are counted like accounts, so a lockout
+// does not tell whether one exists. A successful login clears the count of
+// its account; the counts are otherwise forgotten LOGIN_LOCKOUT_MAX after
+// the last failure. Like the rate limits, they are kept per process.
+
+var (
+    loginFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "login_failures_total",
+        Help: "Logins rejected for a wrong email or password",
+    })
+    loginLockoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "login_lockouts_total",
+        Help: "Logins answered 429 because of earlier failures, by what was locked out (account or ip)",
+    }, []string{"by"})
+)
+
+func init() {
+    prometheus.MustRegister(loginFailuresTotal)
+    prometheus.MustRegister(loginLockoutsTotal)
+}
+
+// loginGuard counts failed logins by key.
+type loginGuard struct {
+    mu       sync.Mutex
+    failures map[string]*loginFailures
+    // swept is when stale counts were last dropped.
+    swept time.Time
+}
+
+type loginFailures struct {
+    count       int
+    last        time.Time
+    lockedUntil time.Time
+}
+
+func newLoginGuard() *loginGuard {
+    return &loginGuard{failures: make(map[string]*loginFailures)}
+}
+
+var loginGuards = newLoginGuard()
+
+// lockedOut returns how long key stays locked out, or 0.
+func (g *loginGuard) lockedOut(key string, now time.Time) time.Duration {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.sweep(now)
+    if f := g.failures[key]; f != nil && now.Before(f.lockedUntil) {
+        return f.lockedUntil.Sub(now)
+    }
+    return 0
+}
+
+// fail counts a failure of key, locking it out from the threshold on.
+func (g *loginGuard) fail(key string, threshold int, now time.Time) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    f := g.failures[key]
+    if f == nil {
+        f = &loginFailures{}
+        g.failures[key] = f
+    }
+    f.count++
+    f.last = now
+    if f.count >= threshold {
+        lockout := float64(config.LoginLockout) * math.Pow(2, float64(f.count-threshold))
+        f.lockedUntil = now.Add(time.Duration(math.Min(lockout, float64(config.LoginLockoutMax))))
+    }
+}
+
+// reset forgets the failures of key.
+func (g *loginGuard) reset(key string) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    delete(g.failures, key)
+}
+
+// sweep drops the counts of keys that have neither failed nor been locked
+// out for LOGIN_LOCKOUT_MAX, at most once a minute; it must be called with
+// g.mu held.
+func (g *loginGuard) sweep(now time.Time) {
+    if now.Sub(g.swept) < time.Minute {
+        return
+    }
+    g.swept = now
+    for key, f := range g.failures {
+        if now.Sub(f.last) > config.LoginLockoutMax && now.After(f.lockedUntil) {
+            delete(g.failures, key)
+        }
+    }
+}
+
+// loginKeys returns the keys the failures of a login count against.
+func loginKeys(r *http.Request, email string) (account, ip string) {
+    return "account:" + strings.ToLower(strings.TrimSpace(email)), "ip:" + remoteHost(r.RemoteAddr)
+}
+
+// checkLoginLockout answers 429 and reports false if the account or the
+// address of a login is locked out.
+func checkLoginLockout(w http.ResponseWriter, r *http.Request, email string) bool {
+    account, ip := loginKeys(r, email)
+    now := time.Now()
+    by, wait := "account", loginGuards.lockedOut(account, now)
+    if ipWait := loginGuards.lockedOut(ip, now); ipWait > wait {
+        by, wait = "ip", ipWait
+    }
+    if wait == 0 {
+        return true
+    }
+    loginLockoutsTotal.WithLabelValues(by).Inc()
+    w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+    writeError(w, r, httpError(http.StatusTooManyRequests, "Too many failed logins, retry later"))
+    return false
+}
+
+// recordLogin counts a failed login against its account and address, or
+// clears the failures of the account after a successful one.
+func recordLogin(r *http.Request, email string, ok bool) {
+    account, ip := loginKeys(r, email)
+    if ok {
+        loginGuards.reset(account)
+        return
+    }
+    loginFailuresTotal.Inc()
+    now := time.Now()
+    loginGuards.fail(account, config.LoginMaxFailures, now)
+    loginGuards.fail(ip, config.LoginMaxFailuresPerIP, now)
+}
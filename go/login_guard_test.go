@@ -0,0 +1,75 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginGuardBackoff(t *testing.T) {
+    g := newLoginGuard()
+    now := time.Now()
+    for i := 0; i < 2; i++ {
+        g.fail("k", 3, now)
+    }
+    if wait := g.lockedOut("k", now); wait != 0 {
+        t.Fatalf("locked out below the threshold for %s", wait)
+    }
+    for i, want := range []time.Duration{config.LoginLockout, 2 * config.LoginLockout, 4 * config.LoginLockout} {
+        g.fail("k", 3, now)
+        if wait := g.lockedOut("k", now); wait != want {
+            t.Errorf("failure %d: locked out for %s, want %s", i+3, wait, want)
+        }
+    }
+    for i := 0; i < 20; i++ {
+        g.fail("k", 3, now)
+    }
+    if wait := g.lockedOut("k", now); wait != config.LoginLockoutMax {
+        t.Errorf("locked out for %s, want at most %s", wait, config.LoginLockoutMax)
+    }
+    g.reset("k")
+    if wait := g.lockedOut("k", now); wait != 0 {
+        t.Errorf("locked out for %s after reset", wait)
+    }
+}
+
+func TestLoginLockout(t *testing.T) {
+    defer func(old *loginGuard) { loginGuards = old }(loginGuards)
+    loginGuards = newLoginGuard()
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+    if _, err := repo.Insert(context.Background(), User{Name: "Ada", Email: "ada@example.com", PasswordHash: string(hash)}); err != nil {
+        t.Fatal(err)
+    }
+
+    login := func(addr, email, password string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"`+email+`","password":"`+password+`"}`))
+        req.RemoteAddr = addr
+        rec := httptest.NewRecorder()
+        loginHandler(rec, req)
+        return rec
+    }
+    for i := 0; i < config.LoginMaxFailures; i++ {
+        if rec := login("198.51.100.1:1000", "ada@example.com", "wrong"); rec.Code != http.StatusUnauthorized {
+            t.Fatalf("failure %d: %d", i+1, rec.Code)
+        }
+    }
+    rec := login("198.51.100.2:1000", "ADA@example.com", "correct horse")
+    if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+        t.Fatalf("locked account: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+    }
+
+    for i := 0; i < config.LoginMaxFailuresPerIP-config.LoginMaxFailures; i++ {
+        login("198.51.100.1:1000", "user"+string(rune('a'+i))+"@example.com", "wrong")
+    }
+    if rec := login("198.51.100.1:1000", "grace@example.com", "whatever"); rec.Code != http.StatusTooManyRequests {
+        t.Errorf("locked address: %d", rec.Code)
+    }
+}
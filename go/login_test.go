@@ -0,0 +1,88 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/gorilla/mux"
+    "golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin(t *testing.T) {
+    defer func(old *loginGuard) { loginGuards = old }(loginGuards)
+    loginGuards = newLoginGuard()
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+
+    r := mux.NewRouter()
+    r.HandleFunc("/users", getUsersHandler).Methods("GET")
+    r.HandleFunc("/users", createUserHandler).Methods("POST")
+    r.HandleFunc("/users/{id:[0-9]+}", getUserHandler).Methods("GET")
+    r.HandleFunc("/users/{id:[0-9]+}", updateUserHandler).Methods("PUT")
+    r.HandleFunc("/users/{id:[0-9]+}/activity", userActivityHandler).Methods("GET")
+    r.HandleFunc("/login", loginHandler).Methods("POST")
+    // Every response is kept so none can be checked to leak the hash.
+    var bodies []string
+    do := func(method, path, body string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, strings.NewReader(body))
+        req.Header.Set("If-Match", "*")
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, req)
+        bodies = append(bodies, rec.Body.String())
+        return rec
+    }
+
+    if rec := do(http.MethodPost, "/users", `{"name":"Ada","email":"ada@example.com","password":"correct horse"}`); rec.Code != http.StatusCreated {
+        t.Fatalf("create: %d %s", rec.Code, rec.Body)
+    }
+    user, err := repo.GetByEmail(context.Background(), "ada@example.com")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if user.Password != "" {
+        t.Error("plaintext password stored")
+    }
+    if cost, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil || cost != bcrypt.DefaultCost {
+        t.Errorf("stored hash %q: cost %d, %v", user.PasswordHash, cost, err)
+    }
+    if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("correct horse")); err != nil {
+        t.Errorf("stored hash does not match the password: %v", err)
+    }
+    id := strconv.Itoa(user.ID)
+
+    if rec := do(http.MethodPost, "/login", `{"email":"ada@example.com","password":"correct horse"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"access_token"`) {
+        t.Errorf("login: %d %s", rec.Code, rec.Body)
+    }
+    wrong := do(http.MethodPost, "/login", `{"email":"ada@example.com","password":"battery staple"}`)
+    unknown := do(http.MethodPost, "/login", `{"email":"nobody@example.com","password":"correct horse"}`)
+    if wrong.Code != http.StatusUnauthorized || unknown.Code != http.StatusUnauthorized {
+        t.Errorf("wrong password %d, unknown email %d", wrong.Code, unknown.Code)
+    }
+    if wrong.Body.String() != unknown.Body.String() {
+        t.Errorf("unknown email told apart: %s vs %s", wrong.Body, unknown.Body)
+    }
+
+    if rec := do(http.MethodPut, "/users/"+id, `{"name":"Ada","email":"ada@example.com","password":"battery staple"}`); rec.Code != http.StatusOK {
+        t.Fatalf("update: %d %s", rec.Code, rec.Body)
+    }
+    if rec := do(http.MethodPost, "/login", `{"email":"ada@example.com","password":"battery staple"}`); rec.Code != http.StatusOK {
+        t.Errorf("login with the new password: %d %s", rec.Code, rec.Body)
+    }
+    do(http.MethodGet, "/users", "")
+    do(http.MethodGet, "/users/"+id, "")
+    do(http.MethodGet, "/users/"+id+"/activity", "")
+
+    updated, _ := repo.Get(context.Background(), user.ID)
+    for _, body := range bodies {
+        for _, secret := range []string{user.PasswordHash, updated.PasswordHash, "$2a$", "password_hash", "correct horse", "battery staple"} {
+            if strings.Contains(body, secret) {
+                t.Errorf("response leaks %q: %s", secret, body)
+            }
+        }
+    }
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "strings"
+    "sync"
+)
+
+// recentLogs keeps the last log lines in memory for support bundles.
+var recentLogs = newLogRing(config.SupportLogLines)
+
+// logRing is an io.Writer that retains the most recent lines written to it.
+// The log package issues one Write per entry.
+type logRing struct {
+    mu    sync.Mutex
+    lines []string
+    next  int
+    full  bool
+}
+
+func newLogRing(size int) *logRing {
+    if size < 1 {
+        size = 1
+    }
+    return &logRing{lines: make([]string, size)}
+}
+
+func (l *logRing) Write(p []byte) (int, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.lines[l.next] = strings.TrimSuffix(string(p), "\n")
+    l.next = (l.next + 1) % len(l.lines)
+    if l.next == 0 {
+        l.full = true
+    }
+    return len(p), nil
+}
+
+// snapshot returns the retained lines, oldest first.
+func (l *logRing) snapshot() []string {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if !l.full {
+        return append([]string(nil), l.lines[:l.next]...)
+    }
+    return append(append([]string(nil), l.lines[l.next:]...), l.lines[:l.next]...)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync/atomic"
+)
+
+// ACCESS_LOG_SAMPLE thins out the access log of busy routes, as a comma
+// separated list of route templates and rates, e.g.
+//
+//	ACCESS_LOG_SAMPLE=/health=100,/users/{id:[0-9]+}=10
+//
+// logs 1 in 100 successful health checks and 1 in 10 successful user
+// lookups. Responses with a 4xx or 5xx status are always logged, as are slow
+// requests; metrics still count every request. Routes not listed are logged
+// in full. A reload replaces the sampler, restarting its counts.
+var accessLogSampler atomic.Pointer[logSampler]
+
+func init() {
+    reloadableSetting(func() error {
+        spec := getenv("ACCESS_LOG_SAMPLE")
+        s, err := newLogSampler(spec)
+        if err != nil {
+            return invalidSetting("ACCESS_LOG_SAMPLE", spec, err)
+        }
+        accessLogSampler.Store(s)
+        return nil
+    }, "ACCESS_LOG_SAMPLE")
+}
+
+// logSampler keeps 1 in rate successful entries per route, starting with
+// the first, so a quiet route is not silent.
+type logSampler struct {
+    routes map[string]*sampledRoute
+}
+
+type sampledRoute struct {
+    rate uint64
+    seen atomic.Uint64
+}
+
+// newLogSampler parses spec. The invalid entries are skipped and returned as
+// an error.
+func newLogSampler(spec string) (*logSampler, error) {
+    s := &logSampler{routes: make(map[string]*sampledRoute)}
+    var errs []error
+    for _, item := range strings.Split(spec, ",") {
+        item = strings.TrimSpace(item)
+        if item == "" {
+            continue
+        }
+        // Route templates can contain "=" in their patterns, so the rate is
+        // after the last one.
+        i := strings.LastIndex(item, "=")
+        if i <= 0 {
+            errs = append(errs, fmt.Errorf("%q is not a route=rate pair", item))
+            continue
+        }
+        rate, err := strconv.ParseUint(strings.TrimSpace(item[i+1:]), 10, 64)
+        if err != nil || rate == 0 {
+            errs = append(errs, fmt.Errorf("%q does not have a positive integer rate", item))
+            continue
+        }
+        s.routes[strings.TrimSpace(item[:i])] = &sampledRoute{rate: rate}
+    }
+    return s, errors.Join(errs...)
+}
+
+// sample reports whether to log a request to route with status, and the
+// rate it was sampled at, for reweighting counts taken from the log.
+func (s *logSampler) sample(route string, status int) (bool, int) {
+    r := s.routes[route]
+    if r == nil || r.rate == 1 || status >= 400 {
+        return true, 1
+    }
+    return (r.seen.Add(1)-1)%r.rate == 0, int(r.rate)
+}
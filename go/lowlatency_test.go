@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "runtime"
+    "runtime/debug"
+    "testing"
+    "time"
+)
+
+// The benchmarks run the same work with the default settings and with
+// those of low-latency mode, for the tuning chapter:
+//
+//	go test -run '^$' -bench LowLatency -benchmem
+//
+// The low-latency runs copy fewer bytes growing the store and the response
+// cache, and go through fewer GC cycles serving GET /users.
+
+func benchUsers(n int) []User {
+    users := make([]User, n)
+    for i := range users {
+        users[i] = User{Name: "Bench User", Email: fmt.Sprintf("bench-%d@example.com", i)}
+    }
+    return users
+}
+
+func lowLatencyCase(on bool) string {
+    if on {
+        return "low-latency"
+    }
+    return "default"
+}
+
+// BenchmarkLowLatencyStoreGrowth appends 1000 users to an empty memory
+// store, with and without the slots low-latency mode reserves up front. It
+// appends to the slice directly, since Insert's email check would swamp the
+// cost of growing it.
+func BenchmarkLowLatencyStoreGrowth(b *testing.B) {
+    batch := benchUsers(1000)
+    for _, on := range []bool{false, true} {
+        b.Run(lowLatencyCase(on), func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                repo := &memoryUserRepository{}
+                if on {
+                    repo.reserve(len(batch))
+                }
+                for _, u := range batch {
+                    repo.users = append(repo.users, u)
+                }
+            }
+        })
+    }
+}
+
+// BenchmarkLowLatencyCacheFill stores 1000 responses in an empty cache, with
+// and without the map sized up front.
+func BenchmarkLowLatencyCacheFill(b *testing.B) {
+    keys := make([]string, 1000)
+    for i := range keys {
+        keys[i] = fmt.Sprintf("/users/%d", i+1)
+    }
+    entry := cacheEntry{status: http.StatusOK, body: []byte(`{"status":"success"}`)}
+    for _, on := range []bool{false, true} {
+        b.Run(lowLatencyCase(on), func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                c := newResponseCache(time.Minute)
+                if on {
+                    c.entries = make(map[string]cacheEntry, len(keys))
+                }
+                for _, key := range keys {
+                    c.set(key, entry)
+                }
+            }
+        })
+    }
+}
+
+// BenchmarkLowLatencyListUsers serves GET /users from a store of 1000 users
+// at the default GC target and at LOW_LATENCY_GC_PERCENT, and reports the
+// collections per request.
+func BenchmarkLowLatencyListUsers(b *testing.B) {
+    repo := newMemoryUserRepository()
+    for _, u := range benchUsers(1000) {
+        if _, err := repo.Insert(context.Background(), u); err != nil {
+            b.Fatal(err)
+        }
+    }
+    defer func(previous UserRepository) { userRepo = previous }(userRepo)
+    userRepo = repo
+
+    for _, on := range []bool{false, true} {
+        b.Run(lowLatencyCase(on), func(b *testing.B) {
+            gcPercent := 100
+            if on {
+                gcPercent = config.LowLatencyGCPercent
+            }
+            defer debug.SetGCPercent(debug.SetGCPercent(gcPercent))
+            r := httptest.NewRequest(http.MethodGet, "/users", nil)
+
+            var before, after runtime.MemStats
+            runtime.GC()
+            runtime.ReadMemStats(&before)
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                getUsersHandler(httptest.NewRecorder(), r)
+            }
+            b.StopTimer()
+            runtime.ReadMemStats(&after)
+            b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "gcs/op")
+        })
+    }
+}
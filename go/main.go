@@ -2,12 +2,17 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "errors"
+    "flag"
     "fmt"
-    "log"
+    "log/slog"
     "net/http"
+    "net/mail"
     "os"
     "strconv"
+    "strings"
     "time"
 
     "github.com/gorilla/mux"
@@ -19,13 +24,22 @@ type User struct {
     ID        int       `json:"id"`
     Name      string    `json:"name"`
     Email     string    `json:"email"`
+    Password  string    `json:"password,omitempty"`
+    Roles     []string  `json:"roles,omitempty"`
+    Verified  bool      `json:"verified"`
     CreatedAt time.Time `json:"created_at"`
+    // Version starts at 1 and increases with every update; see
+    // ErrVersionConflict.
+    Version int `json:"version"`
+
+    PasswordHash string `json:"-"`
 }
 
 type APIResponse struct {
-    Status  string      `json:"status"`
-    Message string      `json:"message,omitempty"`
-    Data    interface{} `json:"data,omitempty"`
+    Status  string       `json:"status"`
+    Message string       `json:"message,omitempty"`
+    Data    interface{}  `json:"data,omitempty"`
+    Errors  []FieldError `json:"errors,omitempty"`
 }
 
 // Prometheus metrics
@@ -39,144 +53,665 @@ var (
     )
     httpRequestDuration = prometheus.NewHistogramVec(
         prometheus.HistogramOpts{
-            Name: "http_request_duration_seconds",
-            Help: "HTTP request duration in seconds",
+            Name:    "http_request_duration_seconds",
+            Help:    "HTTP request duration in seconds",
+            Buckets: config.HTTPDurationBuckets,
         },
         []string{"method", "endpoint"},
     )
+    // httpRequestErrorsTotal counts the requests answered with a 4xx or 5xx
+    // by class, so error rates can be alerted on without summing every
+    // status code.
+    httpRequestErrorsTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "http_request_errors_total",
+            Help: "Total number of HTTP requests answered with a client (4xx) or server (5xx) error",
+        },
+        []string{"method", "endpoint", "class"},
+    )
 )
 
-// Mock database
-var users = []User{
-    {ID: 1, Name: "Alice", Email: "alice@example.com", CreatedAt: time.Now()},
-    {ID: 2, Name: "Bob", Email: "bob@example.com", CreatedAt: time.Now()},
+// maxEmailLength is the longest address SMTP allows (RFC 5321). Encrypted,
+// an email this long still fits the MySQL email column.
+const maxEmailLength = 254
+
+// validateUser checks the fields a client is required to supply and
+// reports every invalid field at once.
+func validateUser(user User) error {
+    verr := &ValidationError{}
+    if strings.TrimSpace(user.Name) == "" {
+        verr.add("name", "name is required")
+    } else if !validName(user.Name) {
+        verr.add("name", "name may only contain letters, digits, spaces and . , ' -")
+    }
+    addr, err := mail.ParseAddress(user.Email)
+    if err != nil || addr.Address != user.Email || !printableASCII(user.Email) {
+        verr.add("email", "email is invalid")
+    } else if len(user.Email) > maxEmailLength {
+        verr.add("email", fmt.Sprintf("email must be at most %d characters", maxEmailLength))
+    }
+    if user.Password != "" {
+        if err := validatePassword(user.Password); err != nil {
+            verr.add("password", err.Error())
+        }
+    }
+    for _, role := range user.Roles {
+        if !knownRoles[role] {
+            verr.add("roles", fmt.Sprintf("unknown role %q", role))
+        }
+    }
+    if len(verr.Fields) > 0 {
+        return verr
+    }
+    return nil
 }
 
 func init() {
     prometheus.MustRegister(httpRequestsTotal)
     prometheus.MustRegister(httpRequestDuration)
+    prometheus.MustRegister(httpRequestErrorsTotal)
 }
 
+// writeJSON encodes response in the format negotiated for r: JSON:API, the
+// APIResponse envelope, or the bare data.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, response APIResponse) {
+    if wantsJSONAPI(r) {
+        writeJSONAPI(w, status, response)
+        return
+    }
+    buf := getBuffer()
+    defer putBuffer(buf)
+    if wantsEnvelope(r) {
+        json.NewEncoder(buf).Encode(response)
+    } else {
+        json.NewEncoder(buf).Encode(response.Data)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    w.Write(buf.Bytes())
+}
+
+// pagination reads the page and per_page query parameters.
+func pagination(r *http.Request, defaultPerPage, maxPerPage int) (int, int, error) {
+    page, perPage := 1, defaultPerPage
+    q := r.URL.Query()
+    if v := q.Get("page"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            return 0, 0, errors.New("page must be a positive integer")
+        }
+        page = n
+    }
+    if v := q.Get("per_page"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > maxPerPage {
+            return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+        }
+        perPage = n
+    }
+    return page, perPage, nil
+}
+
+// statusRecorder remembers the status code written by the wrapped handler
+// and how many body bytes it wrote.
+type statusRecorder struct {
+    http.ResponseWriter
+    status      int
+    wroteHeader bool
+    written     int64
+}
+
+// WriteHeader records the status actually sent: the first final one, as
+// net/http ignores the later calls. Writing a body without it sends 200, the
+// status the recorder starts with.
+func (rec *statusRecorder) WriteHeader(status int) {
+    if !rec.wroteHeader {
+        rec.status, rec.wroteHeader = status, status >= 200
+    }
+    rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+    rec.wroteHeader = true
+    n, err := rec.ResponseWriter.Write(b)
+    rec.written += int64(n)
+    return n, err
+}
+
+// Unwrap lets http.ResponseController reach the writer underneath, e.g. to
+// flush.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+    return rec.ResponseWriter
+}
+
+// loggingMiddleware logs one entry per request once it has been served, in
+// the ACCESS_LOG_FORMAT. The query string is left out, as it can carry
+// tokens.
 func loggingMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         start := time.Now()
-        log.Printf("%s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
-        next.ServeHTTP(w, r)
-        duration := time.Since(start)
-        log.Printf("Request completed in %v", duration)
+        rec := getStatusRecorder(w)
+        defer putStatusRecorder(rec)
+        next.ServeHTTP(rec, r)
+        elapsed := time.Since(start)
+        entry := &AccessLogEntry{
+            Time:       start,
+            Method:     r.Method,
+            Path:       r.URL.Path,
+            Proto:      r.Proto,
+            Status:     rec.status,
+            Bytes:      rec.written,
+            LatencyMS:  float64(elapsed.Microseconds()) / 1000,
+            RemoteAddr: r.RemoteAddr,
+            RemoteHost: remoteHost(r.RemoteAddr),
+            Referer:    r.Referer(),
+            UserAgent:  r.UserAgent(),
+            RequestID:  requestIDFromContext(r.Context()),
+        }
+        route := routeTemplate(r)
+        var keep bool
+        if keep, entry.SampleRate = accessLogSampler.Load().sample(route, rec.status); keep {
+            accessLog.Load().log(r.Context(), entry)
+        }
+        logSlowRequest(r.Context(), entry, route, r.ContentLength, elapsed)
     })
 }
 
+// metricsHandler serves the default registry like promhttp.Handler, also
+// offering the OpenMetrics format, the only one that carries exemplars.
+func metricsHandler() http.Handler {
+    return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+        promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
+
 func metricsMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // Labelling with the route template rather than the path keeps one
+        // series per route instead of one per user ID. Unmatched paths never
+        // get here, as mux does not run middleware for its NotFoundHandler.
+        endpoint := routeTemplate(r)
+        inFlight := httpRequestsInFlight.WithLabelValues(r.Method, endpoint)
+        inFlight.Inc()
+        defer inFlight.Dec()
+
         start := time.Now()
-        next.ServeHTTP(w, r)
-        duration := time.Since(start).Seconds()
+        rec := getStatusRecorder(w)
+        defer putStatusRecorder(rec)
+        next.ServeHTTP(rec, r)
+        elapsed := time.Since(start)
         
-        httpRequestsTotal.WithLabelValues(r.Method, r.URL.Path, "200").Inc()
-        httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(duration)
+        requestMetrics.requestServed(r.Context(), r.Method, endpoint, rec.status, elapsed)
+        recordProtocol(r, elapsed)
+        requestStats.record(elapsed)
     })
 }
 
+// requestMetricsSink records each request served: in Prometheus by default,
+// or sent to StatsD with METRICS_BACKEND (see statsd.go).
+type requestMetricsSink interface {
+    requestServed(ctx context.Context, method, endpoint string, status int, elapsed time.Duration)
+}
+
+var requestMetrics requestMetricsSink = prometheusRequestMetrics{}
+
+type prometheusRequestMetrics struct{}
+
+func (prometheusRequestMetrics) requestServed(ctx context.Context, method, endpoint string, status int, elapsed time.Duration) {
+    httpRequestsTotal.WithLabelValues(method, endpoint, strconv.Itoa(status)).Inc()
+    // With tracing, each observation carries the trace ID as an exemplar,
+    // so a slow bucket in Grafana links to a trace of it.
+    observer := httpRequestDuration.WithLabelValues(method, endpoint)
+    if exemplar := traceExemplar(ctx); exemplar != nil {
+        observer.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed.Seconds(), exemplar)
+    } else {
+        observer.Observe(elapsed.Seconds())
+    }
+    if class := errorClass(status); class != "" {
+        httpRequestErrorsTotal.WithLabelValues(method, endpoint, class).Inc()
+    }
+}
+
+// errorClass returns "4xx" or "5xx" for error statuses and "" otherwise.
+func errorClass(status int) string {
+    switch {
+    case status >= 500:
+        return "5xx"
+    case status >= 400:
+        return "4xx"
+    }
+    return ""
+}
+
+// healthHandler reports the service and its dependencies (see health.go),
+// with 503 while a critical one is down.
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
+    status, deps := checkDependencies(r.Context())
+    code := http.StatusOK
+    if status == "unhealthy" {
+        code = http.StatusServiceUnavailable
+    }
     response := APIResponse{
-        Status: "healthy",
+        Status: status,
         Data: map[string]interface{}{
-            "timestamp": time.Now(),
-            "service":   "User API",
-            "version":   "1.0.0",
+            "timestamp":    time.Now(),
+            "service":      "User API",
+            "version":      buildInfo.Version,
+            "dependencies": deps,
         },
     }
-    json.NewEncoder(w).Encode(response)
+    writeJSON(w, r, code, response)
 }
 
+// getUsersHandler lists every user, or with ?ids=1,2,3 only those users
+// plus the IDs that were not found.
 func getUsersHandler(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
+    if value := r.URL.Query().Get("ids"); value != "" {
+        ids, err := parseIDs(value)
+        if err != nil {
+            writeError(w, r, httpError(http.StatusBadRequest, err.Error()))
+            return
+        }
+        writeBatchGet(w, r, ids)
+        return
+    }
+    users, err := userRepo.List(r.Context())
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
     response := APIResponse{
         Status: "success",
         Data:   users,
     }
-    json.NewEncoder(w).Encode(response)
+    writeJSON(w, r, http.StatusOK, response)
 }
 
 func getUserHandler(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id, err := strconv.Atoi(vars["id"])
     if err != nil {
-        w.WriteHeader(http.StatusBadRequest)
-        response := APIResponse{
-            Status:  "error",
-            Message: "Invalid user ID",
-        }
-        json.NewEncoder(w).Encode(response)
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid user ID"))
         return
     }
 
-    for _, user := range users {
-        if user.ID == id {
-            w.Header().Set("Content-Type", "application/json")
-            response := APIResponse{
-                Status: "success",
-                Data:   user,
-            }
-            json.NewEncoder(w).Encode(response)
-            return
-        }
+    user, err := userRepo.Get(r.Context(), id)
+    if err != nil {
+        writeError(w, r, err)
+        return
     }
-
-    w.WriteHeader(http.StatusNotFound)
+    w.Header().Set("ETag", userETag(user))
     response := APIResponse{
-        Status:  "error",
-        Message: "User not found",
+        Status: "success",
+        Data:   user,
     }
-    json.NewEncoder(w).Encode(response)
+    writeJSON(w, r, http.StatusOK, response)
 }
 
 func createUserHandler(w http.ResponseWriter, r *http.Request) {
     var user User
     if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-        w.WriteHeader(http.StatusBadRequest)
-        response := APIResponse{
-            Status:  "error",
-            Message: "Invalid JSON",
-        }
-        json.NewEncoder(w).Encode(response)
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
         return
     }
 
-    user.ID = len(users) + 1
-    user.CreatedAt = time.Now()
-    users = append(users, user)
+    sanitizeUser(&user)
+    if err := validateUser(user); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    if err := hashPassword(&user); err != nil {
+        writeError(w, r, httpError(http.StatusInternalServerError, "Could not hash password"))
+        return
+    }
+    user, err := userRepo.Insert(r.Context(), user)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    recordActivity(r, user.ID, activityCreated, diffUsers(User{}, user))
 
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(http.StatusCreated)
     response := APIResponse{
         Status: "success",
         Data:   user,
     }
-    json.NewEncoder(w).Encode(response)
+    writeJSON(w, r, http.StatusCreated, response)
+}
+
+// userETag is the entity tag of a user, its version. GET /users/{id} sends
+// it so clients can make their update conditional with If-Match.
+func userETag(user User) string {
+    return `"` + strconv.Itoa(user.Version) + `"`
+}
+
+// expectedVersion returns the version a PUT is based on: the If-Match tag,
+// or else the version field of the body. "If-Match: *" accepts any version
+// and returns 0.
+func expectedVersion(r *http.Request, input User) (int, error) {
+    match := r.Header.Get("If-Match")
+    if match == "" {
+        if input.Version <= 0 {
+            return 0, httpError(http.StatusPreconditionRequired, "Send the version of the user being updated in If-Match or the version field")
+        }
+        return input.Version, nil
+    }
+    if match == "*" {
+        return 0, nil
+    }
+    version, err := strconv.Atoi(strings.Trim(match, `"`))
+    if err != nil || version <= 0 || match != `"`+strconv.Itoa(version)+`"` {
+        return 0, httpError(http.StatusBadRequest, "If-Match must be a single entity tag from ETag")
+    }
+    return version, nil
+}
+
+func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+    var input User
+    if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    version, err := expectedVersion(r, input)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    sanitizeUser(&input)
+    if err := validateUser(input); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    if err := hashPassword(&input); err != nil {
+        writeError(w, r, httpError(http.StatusInternalServerError, "Could not hash password"))
+        return
+    }
+
+    // Read and write in one transaction so a concurrent update can't slip in
+    // between and be overwritten with stale fields. The version check
+    // catches clients editing a copy that has since changed.
+    var before, updated User
+    err = userRepo.WithTx(r.Context(), func(tx UserRepository) error {
+        var err error
+        if before, err = tx.Get(r.Context(), id); err != nil {
+            return err
+        }
+        updated = before
+        if version != 0 {
+            updated.Version = version
+        }
+        updated.Name = input.Name
+        updated.Email = input.Email
+        if len(input.Roles) > 0 {
+            updated.Roles = input.Roles
+        }
+        if input.PasswordHash != "" {
+            updated.PasswordHash = input.PasswordHash
+        }
+        if updated.Email != before.Email {
+            updated.Verified = false
+        }
+        updated, err = tx.Update(r.Context(), updated)
+        return err
+    })
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    if updated.Email != before.Email {
+        verifications.revoke(id)
+    }
+    recordActivity(r, id, activityUpdated, diffUsers(before, updated))
+
+    w.Header().Set("ETag", userETag(updated))
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   updated,
+    })
+}
+
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+    if err := removeUser(r.Context(), id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    recordActivity(r, id, activityDeleted, nil)
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// notFoundHandler and methodNotAllowedHandler replace the plain-text
+// responses mux sends when no route matches.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+    writeProblem(w, r, newProblem(http.StatusNotFound, "No route for "+r.URL.Path))
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+    writeProblem(w, r, newProblem(http.StatusMethodNotAllowed, r.Method+" is not supported for "+r.URL.Path))
 }
 
 func main() {
+    args, code, ok := parseFlags(os.Args[1:], os.Stdout, os.Stderr)
+    if !ok {
+        os.Exit(code)
+    }
+    name := "serve"
+    if len(args) > 0 {
+        name, args = args[0], args[1:]
+    }
+    cmd, ok := commands[name]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "unknown command %q; run \"user-api -h\" for the commands\n", name)
+        os.Exit(2)
+    }
+    if !cmd.quiet {
+        setupLogging()
+        if err := checkConfigFile(); err != nil {
+            fatal("Invalid configuration", "error", err)
+        }
+    }
+    os.Exit(cmd.run(args))
+}
+
+// runServe implements the serve command, the default: it serves the API
+// until stopped or, after a restart signal, replaced.
+func runServe(args []string) int {
+    fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+    if fs.NArg() > 0 {
+        fmt.Fprintf(os.Stderr, "serve: unexpected arguments %q\n", fs.Args())
+        return 2
+    }
+
+    applyCPULimit()
+    applyMemoryLimit()
+    tokenSecret = loadTokenSecret()
+    if err := setupJWT(); err != nil {
+        fatal("Failed to set up JWT verification", "error", err)
+    }
+
     r := mux.NewRouter()
+    r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+    r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
     
     // Middleware
+    r.Use(tracingMiddleware)
     r.Use(loggingMiddleware)
     r.Use(metricsMiddleware)
+    r.Use(ipFilterMiddleware)
+    r.Use(loadShedMiddleware)
+    r.Use(errorReportingMiddleware)
+    r.Use(recoveryMiddleware)
+    r.Use(clientCertMiddleware)
+    r.Use(authMiddleware)
+    r.Use(rateLimitMiddleware)
+    r.Use(requireAuthMiddleware)
+    r.Use(csrfMiddleware)
+    r.Use(roleMiddleware)
+    r.Use(cacheMiddleware)
     
     // Routes
-    r.HandleFunc("/health", healthHandler).Methods("GET")
+    r.HandleFunc("/version", versionHandler).Methods("GET")
     r.HandleFunc("/users", getUsersHandler).Methods("GET")
     r.HandleFunc("/users/{id:[0-9]+}", getUserHandler).Methods("GET")
+    r.HandleFunc("/users/stats", userStatsHandler).Methods("GET")
     r.HandleFunc("/users", createUserHandler).Methods("POST")
-    r.Handle("/metrics", promhttp.Handler())
+    r.HandleFunc("/users/{id:[0-9]+}", updateUserHandler).Methods("PUT")
+    r.HandleFunc("/users/{id:[0-9]+}", deleteUserHandler).Methods("DELETE")
+    r.HandleFunc("/users/{id:[0-9]+}/activity", userActivityHandler).Methods("GET")
+    r.HandleFunc("/users/import", withFeature(featureBulkJobs, importUsersHandler)).Methods("POST")
+    r.HandleFunc("/users/bulk-delete", withFeature(featureBulkJobs, bulkDeleteUsersHandler)).Methods("POST")
+    r.HandleFunc("/users/batch-get", batchGetUsersHandler).Methods("POST")
+    r.HandleFunc("/jobs/{id}", getJobHandler).Methods("GET")
+    r.HandleFunc("/teams", getTeamsHandler).Methods("GET")
+    r.HandleFunc("/teams", createTeamHandler).Methods("POST")
+    r.HandleFunc("/teams/{id:[0-9]+}", getTeamHandler).Methods("GET")
+    r.HandleFunc("/teams/{id:[0-9]+}", updateTeamHandler).Methods("PUT")
+    r.HandleFunc("/teams/{id:[0-9]+}", deleteTeamHandler).Methods("DELETE")
+    r.HandleFunc("/teams/{id:[0-9]+}/members", getTeamMembersHandler).Methods("GET")
+    r.HandleFunc("/teams/{id:[0-9]+}/members/{user_id:[0-9]+}", addTeamMemberHandler).Methods("PUT")
+    r.HandleFunc("/teams/{id:[0-9]+}/members/{user_id:[0-9]+}", removeTeamMemberHandler).Methods("DELETE")
+    r.HandleFunc("/users/{id:[0-9]+}/verify/send", sendVerificationHandler).Methods("POST")
+    r.HandleFunc("/verify", verifyEmailHandler).Methods("GET")
+    r.HandleFunc("/login", loginHandler).Methods("POST")
+    r.HandleFunc("/token/refresh", refreshTokenHandler).Methods("POST")
+    r.HandleFunc("/logout", logoutHandler).Methods("POST")
+    r.HandleFunc("/password/reset-request", passwordResetRequestHandler).Methods("POST")
+    r.HandleFunc("/password/reset", passwordResetHandler).Methods("POST")
+    r.HandleFunc("/stats", statsHandler).Methods("GET")
+    r.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
+
+    // Embedded admin UI
+    r.Handle("/", http.RedirectHandler("/ui/", http.StatusFound)).Methods("GET")
+    r.PathPrefix("/ui/").Handler(uiHandler()).Methods("GET")
+
+    // Admin routes
+    if config.AdminPort == "" {
+        registerAdminRoutes(r.PathPrefix("/admin").Subrouter())
+    }
+    if config.MetricsPort == "" && config.AdminPort == "" {
+        registerOpsRoutes(r, adminMiddleware)
+    } else {
+        // The admin UI polls /livez, so it stays on the public port too.
+        r.HandleFunc("/livez", livezHandler).Methods("GET")
+    }
+
+    if path := getenv("ADMIN_AUDIT_FILE"); path != "" {
+        if err := adminActions.open(path); err != nil {
+            fatal("Failed to open admin audit file", "error", err)
+        }
+    }
+    if path := getenv("AUDIT_LOG_FILE"); path != "" {
+        if err := activities.open(path); err != nil {
+            fatal("Failed to open audit log", "error", err)
+        }
+    }
+
+    shutdownTracing, err := setupTracing(context.Background())
+    if err != nil {
+        fatal("Failed to set up tracing", "error", err)
+    }
+    defer func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := shutdownTracing(ctx); err != nil {
+            slog.Warn("Failed to flush traces", "error", err)
+        }
+    }()
+
+    shutdownMetricsExport, err := setupMetricsExport(context.Background())
+    if err != nil {
+        fatal("Failed to set up metrics export", "error", err)
+    }
+    defer func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := shutdownMetricsExport(ctx); err != nil {
+            slog.Warn("Failed to push final metrics", "error", err)
+        }
+    }()
+
+    if err := setupMetricsBackend(); err != nil {
+        fatal("Failed to set up metrics", "error", err)
+    }
+    if err := setupErrorReporting(); err != nil {
+        fatal("Failed to set up error reporting", "error", err)
+    }
+    setupFeatureProvider()
+
+    repo, err := openUserRepository(context.Background())
+    if err != nil {
+        fatal("Failed to open storage", "error", err)
+    }
+    userRepo = repo
+    defer userRepo.Close()
+    healthChecks = dependencyChecks(userRepo)
+    if _, ok := storageBackend().(*memoryUserRepository); ok {
+        seedDemoTeams()
+    }
+    seedOnStartup(context.Background())
+    startUserSweeper()
 
-    port := os.Getenv("PORT")
-    if port == "" {
-        port = "8080"
+    if config.LowLatency {
+        enableLowLatencyMode()
     }
 
-    log.Printf("Server starting on port %s", port)
-    log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), r))
+    addrs, err := listenAddrs()
+    if err != nil {
+        fatal("Invalid listen address", "error", err)
+    }
+    srv := newHTTPServer(clientIPMiddleware(requestIDMiddleware(securityHeadersMiddleware(corsMiddleware(r)))))
+    useTLS := tlsConfigured()
+    if useTLS {
+        if err := setupTLS(srv); err != nil {
+            fatal("Failed to set up TLS", "error", err)
+        }
+    }
+    if err := setupClientAuth(srv, useTLS); err != nil {
+        fatal("Failed to set up client certificates", "error", err)
+    }
+    lns, err := listen(addrs)
+    if err != nil {
+        fatal("Failed to listen", "error", err)
+    }
+    if config.ProxyProtocol {
+        for i, ln := range lns {
+            lns[i] = proxyListener{ln}
+        }
+    }
+
+    registerListenQueueMetrics(lns...)
+    inherited := os.Getenv(listenerFDEnv) != ""
+    var internal []*http.Server
+    if config.MetricsPort != "" {
+        internal = append(internal, serveInternal("metrics", config.MetricsPort, newMetricsRouter(), inherited, "basic_auth", config.MetricsUsername != "" || config.MetricsPassword != ""))
+    }
+    if config.AdminPort != "" {
+        internal = append(internal, serveInternal("admin", config.AdminPort, newAdminHandler(), inherited))
+    }
+    warnUnusedConfig()
+    watchConfig()
+    bound := make([]string, len(lns))
+    for i, ln := range lns {
+        bound[i] = ln.Addr().String()
+    }
+    slog.Info("Server starting", "addrs", bound, "tls", useTLS)
+    started.Store(true)
+    signalReady()
+    rs := newRestarter(srv, lns...)
+    rs.tls = useTLS
+    if err := rs.serve(); err != nil {
+        fatal("Server failed", "error", err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    for _, srv := range internal {
+        srv.Shutdown(ctx)
+    }
+    slog.Info("Server stopped")
+    return 0
 }
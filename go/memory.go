@@ -0,0 +1,91 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "os"
+    "runtime/debug"
+    "strconv"
+    "strings"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var memoryLimitBytes = prometheus.NewGaugeVec(
+    prometheus.GaugeOpts{
+        Name: "memory_limit_bytes",
+        Help: "Memory the container may use, by where the limit came from; the GOMEMLIMIT derived from it is go_gc_gomemlimit_bytes",
+    },
+    []string{"source"},
+)
+
+func init() {
+    prometheus.MustRegister(memoryLimitBytes)
+}
+
+// memoryLimit is the memory the container may use, detected once at
+// start-up. Without a soft limit the Go heap grows until the kernel kills
+// the container, as GOGC only looks at the live heap; with GOMEMLIMIT set
+// below the container limit, the GC works harder as the heap nears it
+// instead.
+var memoryLimit = detectMemoryLimit()
+
+// GOMEMLIMIT_HEADROOM_PERCENT (default 10, at most 90) is the share of the limit left
+// for memory the Go runtime does not manage, such as SQLite's, which it
+// allocates itself, and for the heap to overshoot while the GC catches up.
+
+type memoryLimitInfo struct {
+    Bytes  int64  // 0 when there is no limit
+    Source string // where the limit came from
+}
+
+// detectMemoryLimit reads, in order, the MEMORY_LIMIT override in bytes,
+// the cgroup v2 memory.max files and the cgroup v1 memory limit.
+func detectMemoryLimit() memoryLimitInfo {
+    if v := getenv("MEMORY_LIMIT"); v != "" {
+        if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+            return memoryLimitInfo{Bytes: n, Source: "MEMORY_LIMIT"}
+        }
+        startupEnv.invalid("MEMORY_LIMIT", v, "not a positive number of bytes")
+    }
+    if n, ok := cgroupV2Min(cgroupV2Dir(), "memory.max", readMemoryLimit); ok {
+        return memoryLimitInfo{Bytes: int64(n), Source: "cgroup v2 memory.max"}
+    }
+    if n, ok := readMemoryLimit(cgroupRoot + "/memory/memory.limit_in_bytes"); ok {
+        return memoryLimitInfo{Bytes: int64(n), Source: "cgroup v1 memory limit"}
+    }
+    return memoryLimitInfo{Source: "none"}
+}
+
+// readMemoryLimit reads a limit in bytes. cgroup v2 writes "max" when there
+// is none, v1 a number near the largest int64.
+func readMemoryLimit(path string) (float64, bool) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return 0, false
+    }
+    n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+    if err != nil || n <= 0 || n >= 1<<62 {
+        return 0, false
+    }
+    return float64(n), true
+}
+
+// applyMemoryLimit sets the soft memory limit to the detected limit less
+// the headroom, unless the GOMEMLIMIT variable already sets it.
+func applyMemoryLimit() {
+    gomemlimit := "unlimited"
+    switch {
+    case os.Getenv("GOMEMLIMIT") != "":
+        gomemlimit = fmt.Sprintf("%d (GOMEMLIMIT)", debug.SetMemoryLimit(-1))
+    case memoryLimit.Bytes > 0:
+        limit := memoryLimit.Bytes / 100 * int64(100-config.MemoryHeadroomPercent)
+        debug.SetMemoryLimit(limit)
+        gomemlimit = strconv.FormatInt(limit, 10)
+    }
+    if memoryLimit.Bytes > 0 {
+        memoryLimitBytes.WithLabelValues(memoryLimit.Source).Set(float64(memoryLimit.Bytes))
+    }
+    slog.Info("Memory limit applied", "bytes", memoryLimit.Bytes, "source", memoryLimit.Source,
+        "headroom_percent", config.MemoryHeadroomPercent, "gomemlimit", gomemlimit)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+    "encoding/gob"
+    "errors"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// MEMORY_SNAPSHOT_INTERVAL (default 30s) is how often a changed memory store
+// is written to MEMORY_SNAPSHOT_PATH. Changes made since the last snapshot are lost if the
+// process is killed, so shorter intervals lose less at the cost of more
+// writes.
+
+// memorySnapshot is the file format. It is gob rather than JSON because
+// User hides the password hash from JSON.
+type memorySnapshot struct {
+    Users  []User
+    NextID int
+    // Events are the undelivered outbox events.
+    Events      []UserEvent
+    LastEventID int64
+}
+
+// memorySnapshotter periodically writes a memory repository to a file, e.g.
+// on a mounted volume, so the lightweight mode survives container restarts.
+type memorySnapshotter struct {
+    repo *memoryUserRepository
+    path string
+    // saved is the repository version in the file.
+    saved uint64
+    quit  chan struct{}
+    done  chan struct{}
+}
+
+// openMemorySnapshots restores repo from the snapshot at path, if there is
+// one, and starts snapshotting it there.
+func openMemorySnapshots(repo *memoryUserRepository, path string) error {
+    if err := loadMemorySnapshot(repo, path); err != nil {
+        return err
+    }
+    s := &memorySnapshotter{
+        repo:  repo,
+        path:  path,
+        saved: repo.version,
+        quit:  make(chan struct{}),
+        done:  make(chan struct{}),
+    }
+    repo.snapshots = s
+    go s.run()
+    return nil
+}
+
+func loadMemorySnapshot(repo *memoryUserRepository, path string) error {
+    f, err := os.Open(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        slog.Info("No memory snapshot yet, starting with the demo users", "path", path)
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    var snap memorySnapshot
+    if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+        return fmt.Errorf("read memory snapshot %s: %w", path, err)
+    }
+    for i := range snap.Users {
+        if snap.Users[i].Version == 0 {
+            // Saved before users had versions.
+            snap.Users[i].Version = 1
+        }
+    }
+    repo.mu.Lock()
+    repo.users, repo.nextID = snap.Users, snap.NextID
+    repo.events, repo.lastEventID = snap.Events, snap.LastEventID
+    repo.mu.Unlock()
+    slog.Info("Restored users from memory snapshot", "users", len(snap.Users), "path", path)
+    return nil
+}
+
+func (s *memorySnapshotter) run() {
+    defer close(s.done)
+    ticker := time.NewTicker(config.MemorySnapshotInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            if err := s.save(); err != nil {
+                slog.Error("Failed to write memory snapshot", "error", err)
+            }
+        case <-s.quit:
+            return
+        }
+    }
+}
+
+// stop ends the periodic snapshots and writes a final one.
+func (s *memorySnapshotter) stop() error {
+    close(s.quit)
+    <-s.done
+    return s.save()
+}
+
+// save writes the users if they changed since the last snapshot. The file
+// is replaced atomically, so a crash mid-write leaves the previous one.
+func (s *memorySnapshotter) save() error {
+    s.repo.mu.RLock()
+    version := s.repo.version
+    snap := memorySnapshot{
+        Users:       append([]User(nil), s.repo.users...),
+        NextID:      s.repo.nextID,
+        Events:      append([]UserEvent(nil), s.repo.events...),
+        LastEventID: s.repo.lastEventID,
+    }
+    s.repo.mu.RUnlock()
+    if version == s.saved {
+        return nil
+    }
+
+    tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if err := gob.NewEncoder(tmp).Encode(snap); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp.Name(), s.path); err != nil {
+        return err
+    }
+    s.saved = version
+    return nil
+}
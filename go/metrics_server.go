@@ -0,0 +1,76 @@
+package main
+
+import (
+    "crypto/subtle"
+    "errors"
+    "expvar"
+    "log/slog"
+    "net/http"
+
+    "github.com/gorilla/mux"
+)
+
+// With METRICS_PORT set, /metrics, /health, the probes, /debug/vars and,
+// with PPROF_ENABLED, the profiles are served on that port, so the public
+// port exposes just the API and /livez, which the admin UI polls. A
+// host:port binds it to one interface, e.g. 127.0.0.1:9090. The port is
+// meant to stay internal to the cluster or host; setting METRICS_USERNAME
+// and METRICS_PASSWORD also requires them as HTTP basic auth, e.g. in the
+// scrape config's basic_auth. Without METRICS_PORT, ADMIN_PORT (see
+// admin_server.go) serves them beside the /admin routes.
+
+// registerOpsRoutes adds the health, probe, metrics and debugging routes to
+// r. guard protects /debug: the admin role on the public router, nothing
+// more on the internal one.
+func registerOpsRoutes(r *mux.Router, guard mux.MiddlewareFunc) {
+    r.HandleFunc("/health", healthHandler).Methods("GET")
+    r.HandleFunc("/livez", livezHandler).Methods("GET")
+    r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+    r.HandleFunc("/readyz", readyzHandler).Methods("GET")
+    r.Handle("/metrics", metricsHandler())
+    r.Handle("/debug/vars", guard(expvar.Handler())).Methods("GET")
+    registerPprof(r, guard)
+}
+
+// newMetricsRouter returns the router of the METRICS_PORT listener. Scrapes
+// and probes are not access logged, as they would drown out the API.
+func newMetricsRouter() *mux.Router {
+    r := mux.NewRouter()
+    r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+    r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+    if config.MetricsUsername != "" || config.MetricsPassword != "" {
+        r.Use(metricsAuthMiddleware)
+    }
+    registerOpsRoutes(r, func(next http.Handler) http.Handler { return next })
+    return r
+}
+
+func metricsAuthMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        user, pass, ok := r.BasicAuth()
+        if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(config.MetricsUsername)) != 1 ||
+            subtle.ConstantTimeCompare([]byte(pass), []byte(config.MetricsPassword)) != 1 {
+            w.Header().Set("WWW-Authenticate", `Basic realm="user-api metrics"`)
+            writeError(w, r, httpError(http.StatusUnauthorized, "Authentication required"))
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// serveInternal serves h on addr in the background, beside the API, until
+// the returned server is shut down. name is the server's name in logs.
+func serveInternal(name, addr string, h http.Handler, inherited bool, attrs ...any) *http.Server {
+    srv := newHTTPServer(h)
+    go func() {
+        ln, err := listenBeside(bindAddr(addr), inherited)
+        if err != nil {
+            fatal("Failed to listen for the "+name+" server", "addr", addr, "error", err)
+        }
+        slog.Info("Serving the "+name+" server", append([]any{"addr", addr}, attrs...)...)
+        if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            fatal("The "+name+" server failed", "error", err)
+        }
+    }()
+    return srv
+}
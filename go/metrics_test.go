@@ -0,0 +1,178 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsStatus(t *testing.T) {
+    handler := metricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/missing":
+            writeError(w, r, ErrNotFound)
+        case "/broken":
+            w.WriteHeader(http.StatusServiceUnavailable)
+            w.WriteHeader(http.StatusOK)
+        default:
+            w.Write([]byte("ok"))
+        }
+    }))
+    for _, path := range []string{"/ok", "/missing", "/broken"} {
+        handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+    }
+
+    for _, c := range []struct {
+        path, status string
+    }{{"/ok", "200"}, {"/missing", "404"}, {"/broken", "503"}} {
+        if n := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", c.path, c.status)); n != 1 {
+            t.Errorf("%s counted %v times with status %s", c.path, n, c.status)
+        }
+    }
+    for _, c := range []struct {
+        path, class string
+        want        float64
+    }{{"/ok", "4xx", 0}, {"/ok", "5xx", 0}, {"/missing", "4xx", 1}, {"/broken", "5xx", 1}} {
+        if n := testutil.ToFloat64(httpRequestErrorsTotal.WithLabelValues("GET", c.path, c.class)); n != c.want {
+            t.Errorf("%s counted %v %s errors, want %v", c.path, n, c.class, c.want)
+        }
+    }
+}
+
+func TestMetricsRouteTemplate(t *testing.T) {
+    r := mux.NewRouter()
+    r.Use(metricsMiddleware)
+    r.HandleFunc("/things/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {})
+    for _, path := range []string{"/things/1", "/things/2", "/things/3"} {
+        r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+    }
+    if n := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", "/things/{id:[0-9]+}", "200")); n != 3 {
+        t.Errorf("route template counted %v times, want 3", n)
+    }
+    if n := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", "/things/1", "200")); n != 0 {
+        t.Errorf("raw path counted %v times", n)
+    }
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+    families, err := prometheus.DefaultGatherer.Gather()
+    if err != nil {
+        t.Fatal(err)
+    }
+    found := make(map[string]bool)
+    for _, f := range families {
+        found[f.GetName()] = true
+    }
+    for _, name := range []string{
+        "go_goroutines", "go_gc_gomemlimit_bytes", "go_sched_gomaxprocs_threads",
+        "go_gc_heap_goal_bytes", "go_sched_latencies_seconds", "process_resident_memory_bytes",
+    } {
+        if !found[name] {
+            t.Errorf("%s not exported", name)
+        }
+    }
+}
+
+func TestMetricsRouterAuth(t *testing.T) {
+    defer func(user, pass string) { config.MetricsUsername, config.MetricsPassword = user, pass }(config.MetricsUsername, config.MetricsPassword)
+    config.MetricsUsername, config.MetricsPassword = "prom", "secret"
+    r := newMetricsRouter()
+
+    for _, c := range []struct {
+        user, pass string
+        want       int
+    }{{"", "", http.StatusUnauthorized}, {"prom", "wrong", http.StatusUnauthorized}, {"prom", "secret", http.StatusOK}} {
+        req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+        if c.user != "" {
+            req.SetBasicAuth(c.user, c.pass)
+        }
+        w := httptest.NewRecorder()
+        r.ServeHTTP(w, req)
+        if w.Code != c.want {
+            t.Errorf("%q/%q: status %d, want %d", c.user, c.pass, w.Code, c.want)
+        }
+    }
+}
+
+func TestParseBuckets(t *testing.T) {
+    for _, c := range []struct {
+        value string
+        want  []float64
+    }{
+        {"fast", durationBucketPresets["fast"]},
+        {"SLO", durationBucketPresets["slo"]},
+        {"1ms, 5ms,0.02,100ms", []float64{0.001, 0.005, 0.02, 0.1}},
+    } {
+        got, err := parseBuckets(c.value)
+        if err != nil || fmt.Sprint(got) != fmt.Sprint(c.want) {
+            t.Errorf("%q: got %v, %v; want %v", c.value, got, err, c.want)
+        }
+    }
+    for _, value := range []string{"", "fastest", "10ms,5ms", "5ms,5ms", "-1", "0"} {
+        if got, err := parseBuckets(value); err == nil {
+            t.Errorf("%q: accepted as %v", value, got)
+        }
+    }
+}
+
+func TestConnectionMetrics(t *testing.T) {
+    idle := httpConnections.WithLabelValues("idle")
+    before, accepted := testutil.ToFloat64(idle), testutil.ToFloat64(httpConnectionsAcceptedTotal)
+    srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    srv.Config.ConnState = trackConnState
+    srv.Start()
+    defer srv.Close()
+
+    resp, err := srv.Client().Get(srv.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    deadline := time.Now().Add(2 * time.Second)
+    for testutil.ToFloat64(idle) != before+1 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if n := testutil.ToFloat64(idle); n != before+1 {
+        t.Fatalf("%v idle connections after a keep-alive request, want %v", n, before+1)
+    }
+    if n := testutil.ToFloat64(httpConnectionsAcceptedTotal); n != accepted+1 {
+        t.Fatalf("%v connections accepted, want %v", n, accepted+1)
+    }
+}
+
+func TestAdminHandler(t *testing.T) {
+    defer func(token, user, pass string) { config.AdminToken, config.MetricsUsername, config.MetricsPassword = token, user, pass }(config.AdminToken, config.MetricsUsername, config.MetricsPassword)
+    config.AdminToken, config.MetricsUsername, config.MetricsPassword = "adm", "prom", "secret"
+    h := newAdminHandler()
+
+    for _, c := range []struct {
+        path, bearer, user string
+        want               int
+    }{
+        {"/admin/log-level", "", "", http.StatusUnauthorized},
+        {"/admin/log-level", "adm", "", http.StatusOK},
+        {"/admin/config", "adm", "", http.StatusOK},
+        {"/metrics", "", "", http.StatusUnauthorized},
+        {"/metrics", "", "prom", http.StatusOK},
+        {"/users", "adm", "", http.StatusNotFound},
+    } {
+        req := httptest.NewRequest(http.MethodGet, c.path, nil)
+        if c.bearer != "" {
+            req.Header.Set("Authorization", "Bearer "+c.bearer)
+        }
+        if c.user != "" {
+            req.SetBasicAuth(c.user, config.MetricsPassword)
+        }
+        w := httptest.NewRecorder()
+        h.ServeHTTP(w, req)
+        if w.Code != c.want {
+            t.Errorf("%s: status %d, want %d", c.path, w.Code, c.want)
+        }
+    }
+}
@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// lockMemory pins current and future pages in RAM. It needs CAP_IPC_LOCK or
+// a sufficient RLIMIT_MEMLOCK, e.g. `docker run --cap-add IPC_LOCK --ulimit memlock=-1`.
+func lockMemory() error {
+    return syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE)
+}
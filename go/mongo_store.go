@@ -0,0 +1,446 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// defaultMongoDatabase is used when MONGODB_URI names no database.
+const defaultMongoDatabase = "user_api"
+
+// MONGODB_TIMEOUT (default 5s) bounds each MongoDB operation, so a stalled
+// server fails a request instead of holding it until the client gives up.
+
+// mongoUserRepository stores users in a MongoDB collection. IDs stay small
+// integers like in the other backends, drawn from a counters collection.
+type mongoUserRepository struct {
+    client   *mongo.Client
+    users    *mongo.Collection
+    counters *mongo.Collection
+    events   *mongo.Collection
+    apiKeys  *mongo.Collection
+    // session is the transaction inside WithTx, nil outside it.
+    session mongo.Session
+}
+
+// mongoUser is the stored document. email_lower carries the unique index,
+// as emails compare case-insensitively.
+type mongoUser struct {
+    ID           int       `bson:"_id"`
+    Name         string    `bson:"name"`
+    Email        string    `bson:"email"`
+    EmailLower   string    `bson:"email_lower"`
+    PasswordHash string    `bson:"password_hash"`
+    Roles        []string  `bson:"roles"`
+    Verified     bool      `bson:"verified"`
+    CreatedAt    time.Time `bson:"created_at"`
+    Version      int       `bson:"version"`
+}
+
+func toMongoUser(user User) mongoUser {
+    return mongoUser{
+        ID:           user.ID,
+        Name:         user.Name,
+        Email:        user.Email,
+        EmailLower:   strings.ToLower(user.Email),
+        PasswordHash: user.PasswordHash,
+        Roles:        user.Roles,
+        Verified:     user.Verified,
+        CreatedAt:    user.CreatedAt,
+        Version:      user.Version,
+    }
+}
+
+func (d mongoUser) user() User {
+    return User{
+        ID:           d.ID,
+        Name:         d.Name,
+        Email:        d.Email,
+        PasswordHash: d.PasswordHash,
+        Roles:        d.Roles,
+        Verified:     d.Verified,
+        CreatedAt:    d.CreatedAt,
+        Version:      d.Version,
+    }
+}
+
+// openMongoUserRepository connects to the MongoDB URI, e.g.
+// "mongodb://mongo:27017/user_api", and creates the indexes.
+func openMongoUserRepository(ctx context.Context, uri string) (UserRepository, error) {
+    if uri == "" {
+        return nil, errors.New("MONGODB_URI is required for the mongodb backend")
+    }
+    cs, err := connstring.ParseAndValidate(uri)
+    if err != nil {
+        return nil, fmt.Errorf("parse MONGODB_URI: %w", err)
+    }
+    database := cs.Database
+    if database == "" {
+        database = defaultMongoDatabase
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+    if err != nil {
+        return nil, fmt.Errorf("connect to mongodb: %w", err)
+    }
+    if err := client.Ping(ctx, nil); err != nil {
+        client.Disconnect(context.Background())
+        return nil, fmt.Errorf("connect to mongodb: %w", err)
+    }
+    db := client.Database(database)
+    repo := &mongoUserRepository{client: client, users: db.Collection("users"), counters: db.Collection("counters"), events: db.Collection("user_events"), apiKeys: db.Collection("api_keys")}
+    _, err = repo.users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.D{{Key: "email_lower", Value: 1}}, Options: options.Index().SetUnique(true).SetName("users_email_key")},
+        {Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetName("users_created_at")},
+    })
+    if err == nil {
+        _, err = repo.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+            Keys: bson.D{{Key: "sent_at", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("user_events_pending"),
+        })
+    }
+    if err != nil {
+        client.Disconnect(context.Background())
+        return nil, fmt.Errorf("create mongodb indexes: %w", err)
+    }
+    return repo, nil
+}
+
+// opContext applies MONGODB_TIMEOUT and, inside WithTx, the transaction.
+func (s *mongoUserRepository) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+    ctx, cancel := context.WithTimeout(ctx, config.MongoTimeout)
+    if s.session != nil {
+        ctx = mongo.NewSessionContext(ctx, s.session)
+    }
+    return ctx, cancel
+}
+
+func (s *mongoUserRepository) List(ctx context.Context) ([]User, error) {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    cursor, err := s.users.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoUser
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+    var users []User
+    for _, d := range docs {
+        users = append(users, d.user())
+    }
+    return users, nil
+}
+
+func (s *mongoUserRepository) Get(ctx context.Context, id int) (User, error) {
+    return s.findOne(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+func (s *mongoUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+    return s.findOne(ctx, bson.D{{Key: "email_lower", Value: strings.ToLower(email)}})
+}
+
+func (s *mongoUserRepository) findOne(ctx context.Context, filter bson.D) (User, error) {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    var d mongoUser
+    if err := s.users.FindOne(ctx, filter).Decode(&d); err != nil {
+        return User{}, s.translate(err)
+    }
+    return d.user(), nil
+}
+
+// Insert fails with a unique ConstraintError if the email is already taken.
+func (s *mongoUserRepository) Insert(ctx context.Context, user User) (User, error) {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    id, err := s.nextSeq(ctx, "users")
+    if err != nil {
+        return User{}, err
+    }
+    user.ID = id
+    user.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+    user.Verified = false
+    user.Version = 1
+    if len(user.Roles) == 0 {
+        user.Roles = []string{roleUser}
+    }
+    if _, err := s.users.InsertOne(ctx, toMongoUser(user)); err != nil {
+        return User{}, s.translate(err)
+    }
+    return user, nil
+}
+
+// nextSeq draws the next ID from the named counter.
+func (s *mongoUserRepository) nextSeq(ctx context.Context, name string) (int, error) {
+    var counter struct {
+        Seq int `bson:"seq"`
+    }
+    err := s.counters.FindOneAndUpdate(ctx,
+        bson.D{{Key: "_id", Value: name}},
+        bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: 1}}}},
+        options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+    ).Decode(&counter)
+    return counter.Seq, err
+}
+
+func (s *mongoUserRepository) Update(ctx context.Context, user User) (User, error) {
+    d := toMongoUser(user)
+    opCtx, cancel := s.opContext(ctx)
+    defer cancel()
+    res, err := s.users.UpdateOne(opCtx,
+        bson.D{{Key: "_id", Value: user.ID}, {Key: "version", Value: user.Version}},
+        bson.D{
+            {Key: "$set", Value: bson.D{
+                {Key: "name", Value: d.Name},
+                {Key: "email", Value: d.Email},
+                {Key: "email_lower", Value: d.EmailLower},
+                {Key: "password_hash", Value: d.PasswordHash},
+                {Key: "roles", Value: d.Roles},
+                {Key: "verified", Value: d.Verified},
+            }},
+            {Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+        },
+    )
+    if err != nil {
+        return User{}, s.translate(err)
+    }
+    if res.MatchedCount == 0 {
+        // Either the user is gone or its version moved on.
+        if _, err := s.Get(ctx, user.ID); err != nil {
+            return User{}, err
+        }
+        return User{}, ErrVersionConflict
+    }
+    return s.Get(ctx, user.ID)
+}
+
+func (s *mongoUserRepository) rewriteEmail(ctx context.Context, id int, from, to string) (bool, error) {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    res, err := s.users.UpdateOne(ctx,
+        bson.D{{Key: "_id", Value: id}, {Key: "email", Value: from}},
+        bson.D{{Key: "$set", Value: bson.D{
+            {Key: "email", Value: to},
+            {Key: "email_lower", Value: strings.ToLower(to)},
+        }}},
+    )
+    if err != nil {
+        return false, s.translate(err)
+    }
+    return res.MatchedCount > 0, nil
+}
+
+func (s *mongoUserRepository) Delete(ctx context.Context, id int) error {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    res, err := s.users.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+    if err != nil {
+        return s.translate(err)
+    }
+    if res.DeletedCount == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// Stats loads every user and aggregates them like the memory backend.
+func (s *mongoUserRepository) Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error) {
+    users, err := s.List(ctx)
+    if err != nil {
+        return UserStats{}, err
+    }
+    return (&memoryUserRepository{users: users}).Stats(ctx, days, top, now)
+}
+
+// WithTx runs fn in a MongoDB transaction, committing if it returns nil.
+// Transactions need a replica set; a single-node one started with --replSet
+// is enough. Calls nested inside fn join the outer transaction.
+func (s *mongoUserRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    if s.session != nil {
+        return fn(s)
+    }
+    session, err := s.client.StartSession()
+    if err != nil {
+        return err
+    }
+    defer session.EndSession(context.Background())
+    tx := &mongoUserRepository{client: s.client, users: s.users, counters: s.counters, events: s.events, apiKeys: s.apiKeys, session: session}
+    _, err = session.WithTransaction(ctx, func(mongo.SessionContext) (interface{}, error) {
+        return nil, fn(tx)
+    })
+    return err
+}
+
+// mongoEvent is a stored outbox event. The user is kept as JSON, like in
+// the SQL backends, so the password hash stays out of it.
+type mongoEvent struct {
+    ID         int64      `bson:"_id"`
+    Type       string     `bson:"type"`
+    UserID     int        `bson:"user_id"`
+    Payload    string     `bson:"payload"`
+    OccurredAt time.Time  `bson:"occurred_at"`
+    SentAt     *time.Time `bson:"sent_at"`
+}
+
+func (s *mongoUserRepository) appendEvent(ctx context.Context, event UserEvent) error {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    payload, err := encodeEventUser(event.User)
+    if err != nil {
+        return err
+    }
+    id, err := s.nextSeq(ctx, "user_events")
+    if err != nil {
+        return err
+    }
+    _, err = s.events.InsertOne(ctx, mongoEvent{
+        ID:         int64(id),
+        Type:       event.Type,
+        UserID:     event.UserID,
+        Payload:    payload,
+        OccurredAt: event.OccurredAt,
+    })
+    return err
+}
+
+func (s *mongoUserRepository) pendingEvents(ctx context.Context, limit int) ([]UserEvent, error) {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    cursor, err := s.events.Find(ctx, bson.D{{Key: "sent_at", Value: nil}},
+        options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit)))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoEvent
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+    var events []UserEvent
+    for _, d := range docs {
+        user, err := decodeEventUser(d.Payload)
+        if err != nil {
+            return nil, fmt.Errorf("event %d: %w", d.ID, err)
+        }
+        events = append(events, UserEvent{ID: d.ID, Type: d.Type, UserID: d.UserID, User: user, OccurredAt: d.OccurredAt})
+    }
+    return events, nil
+}
+
+func (s *mongoUserRepository) markEventsSent(ctx context.Context, ids []int64) error {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    _, err := s.events.UpdateMany(ctx,
+        bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}},
+        bson.D{{Key: "$set", Value: bson.D{{Key: "sent_at", Value: time.Now().UTC()}}}},
+    )
+    return err
+}
+
+func (s *mongoUserRepository) Ping(ctx context.Context) error {
+    return s.client.Ping(ctx, nil)
+}
+
+// mongoAPIKey is a stored API key.
+type mongoAPIKey struct {
+    ID        string     `bson:"_id"`
+    Name      string     `bson:"name"`
+    KeyHash   string     `bson:"key_hash"`
+    Roles     []string   `bson:"roles"`
+    RateLimit int        `bson:"rate_limit"`
+    CreatedAt time.Time  `bson:"created_at"`
+    RevokedAt *time.Time `bson:"revoked_at"`
+}
+
+func (d mongoAPIKey) apiKey() APIKey {
+    return APIKey{ID: d.ID, Name: d.Name, Roles: d.Roles, RateLimit: d.RateLimit, CreatedAt: d.CreatedAt, RevokedAt: d.RevokedAt, hash: d.KeyHash}
+}
+
+func (s *mongoUserRepository) insertAPIKey(ctx context.Context, key APIKey) error {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    _, err := s.apiKeys.InsertOne(ctx, mongoAPIKey{
+        ID:        key.ID,
+        Name:      key.Name,
+        KeyHash:   key.hash,
+        Roles:     key.Roles,
+        RateLimit: key.RateLimit,
+        CreatedAt: key.CreatedAt,
+    })
+    return err
+}
+
+func (s *mongoUserRepository) getAPIKey(ctx context.Context, id string) (APIKey, error) {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    var doc mongoAPIKey
+    if err := s.apiKeys.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&doc); err != nil {
+        return APIKey{}, s.translate(err)
+    }
+    return doc.apiKey(), nil
+}
+
+func (s *mongoUserRepository) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    cursor, err := s.apiKeys.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoAPIKey
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+    var keys []APIKey
+    for _, d := range docs {
+        keys = append(keys, d.apiKey())
+    }
+    return keys, nil
+}
+
+// revokeAPIKey updates with a pipeline, so an earlier revoked_at is kept.
+func (s *mongoUserRepository) revokeAPIKey(ctx context.Context, id string, at time.Time) error {
+    ctx, cancel := s.opContext(ctx)
+    defer cancel()
+    result, err := s.apiKeys.UpdateOne(ctx,
+        bson.D{{Key: "_id", Value: id}},
+        bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$revoked_at", at}}}}}}}},
+    )
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+func (s *mongoUserRepository) Close() error {
+    ctx, cancel := context.WithTimeout(context.Background(), config.MongoTimeout)
+    defer cancel()
+    return s.client.Disconnect(ctx)
+}
+
+// translate maps driver errors onto the repository error contract.
+func (s *mongoUserRepository) translate(err error) error {
+    switch {
+    case err == nil:
+        return nil
+    case errors.Is(err, mongo.ErrNoDocuments):
+        return ErrNotFound
+    case mongo.IsDuplicateKeyError(err):
+        return errEmailTaken
+    default:
+        return err
+    }
+}
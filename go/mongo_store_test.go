@@ -0,0 +1,73 @@
+package main
+
+import (
+    "context"
+    "os"
+    "testing"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// The MongoDB tests run against the server in MONGODB_TEST_URI and are
+// skipped without it. The transaction test needs a replica set, e.g.
+//
+//	docker run -d --name mongo-test -p 27017:27017 mongo:7 --replSet rs0
+//	docker exec mongo-test mongosh --eval 'rs.initiate()'
+//	MONGODB_TEST_URI='mongodb://localhost:27017/users_test?directConnection=true' go test -run Mongo
+//
+// Each test empties the collections first, so point it at a throwaway
+// database.
+
+func openMongoTestRepository(t *testing.T) *mongoUserRepository {
+    t.Helper()
+    uri := os.Getenv("MONGODB_TEST_URI")
+    if uri == "" {
+        t.Skip("MONGODB_TEST_URI is not set")
+    }
+    ctx := context.Background()
+    repo, err := openMongoUserRepository(ctx, uri)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := repo.(*mongoUserRepository)
+    t.Cleanup(func() { s.Close() })
+    if _, err := s.users.DeleteMany(ctx, bson.D{}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.counters.DeleteMany(ctx, bson.D{}); err != nil {
+        t.Fatal(err)
+    }
+    return s
+}
+
+func TestMongoUserLifecycle(t *testing.T) {
+    testUserLifecycle(t, openMongoTestRepository(t))
+}
+
+func TestMongoEmailTaken(t *testing.T) {
+    testEmailTaken(t, openMongoTestRepository(t))
+}
+
+func TestMongoEmailEncryption(t *testing.T) {
+    testEmailEncryption(t, openMongoTestRepository(t))
+}
+
+func TestMongoUserStats(t *testing.T) {
+    testUserStats(t, openMongoTestRepository(t))
+}
+
+func TestMongoWithTx(t *testing.T) {
+    testWithTx(t, openMongoTestRepository(t))
+}
+
+func TestMongoVersionConflict(t *testing.T) {
+    testVersionConflict(t, openMongoTestRepository(t))
+}
+
+func TestMongoOutbox(t *testing.T) {
+    testOutbox(t, openMongoTestRepository(t))
+}
+
+func TestMongoAPIKeys(t *testing.T) {
+    testAPIKeys(t, openMongoTestRepository(t))
+}
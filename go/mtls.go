@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "os"
+)
+
+// With TLS_CLIENT_CA_FILE set to a PEM bundle of CA certificates, clients
+// of the HTTPS listener can authenticate with a certificate those CAs
+// issued. TLS_CLIENT_AUTH says who has to:
+//
+//   - require (the default): every request but the probes, /health,
+//     /livez, /healthz and /readyz, so the kubelet needs no certificate;
+//   - mutations: POST, PUT, PATCH and DELETE requests only;
+//   - optional: no one, but a certificate presented is still verified.
+//
+// A handshake only fails for a certificate that does not verify; a missing
+// one is answered with 401, so probes and ACME challenges still complete.
+// Handlers get the verified client through clientIdentityFromContext. The
+// bundle is read at startup; a changed one takes a restart.
+
+const (
+    clientAuthRequire   = "require"
+    clientAuthMutations = "mutations"
+    clientAuthOptional  = "optional"
+)
+
+// probeRoutes need no client certificate under TLS_CLIENT_AUTH=require.
+var probeRoutes = map[string]bool{
+    "/health":  true,
+    "/livez":   true,
+    "/healthz": true,
+    "/readyz":  true,
+}
+
+// ClientIdentity is the verified client certificate of a request.
+type ClientIdentity struct {
+    Subject  string   `json:"subject"`
+    DNSNames []string `json:"dns_names,omitempty"`
+    // URIs carry e.g. SPIFFE IDs.
+    URIs   []string `json:"uris,omitempty"`
+    Serial string   `json:"serial"`
+    // Fingerprint is the hex SHA-256 of the certificate.
+    Fingerprint string `json:"fingerprint"`
+}
+
+const clientIdentityKey contextKey = "client_identity"
+
+// clientIdentityFromContext returns the client certificate the request was
+// made with.
+func clientIdentityFromContext(ctx context.Context) (ClientIdentity, bool) {
+    id, ok := ctx.Value(clientIdentityKey).(ClientIdentity)
+    return id, ok
+}
+
+// setupClientAuth makes srv verify client certificates, if
+// TLS_CLIENT_CA_FILE is set. It is called once srv serves HTTPS.
+func setupClientAuth(srv *http.Server, useTLS bool) error {
+    if config.TLSClientCAFile == "" {
+        if config.TLSClientAuth != "" {
+            return errors.New("TLS_CLIENT_AUTH requires TLS_CLIENT_CA_FILE")
+        }
+        return nil
+    }
+    if !useTLS {
+        return errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE or ACME_DOMAINS")
+    }
+    switch config.TLSClientAuth {
+    case "":
+        config.TLSClientAuth = clientAuthRequire
+    case clientAuthRequire, clientAuthMutations, clientAuthOptional:
+    default:
+        return fmt.Errorf("TLS_CLIENT_AUTH must be %s, %s or %s, not %q", clientAuthRequire, clientAuthMutations, clientAuthOptional, config.TLSClientAuth)
+    }
+    data, err := os.ReadFile(config.TLSClientCAFile)
+    if err != nil {
+        return err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(data) {
+        return fmt.Errorf("no certificates in %s", config.TLSClientCAFile)
+    }
+    srv.TLSConfig.ClientCAs = pool
+    srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+    slog.Info("Verifying client certificates", "ca_file", config.TLSClientCAFile, "client_auth", config.TLSClientAuth)
+    return nil
+}
+
+// clientCertMiddleware attaches the verified client certificate, if any, to
+// the request context, and answers 401 to requests TLS_CLIENT_AUTH requires
+// one for that have none.
+func clientCertMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+            id := newClientIdentity(r.TLS.VerifiedChains[0][0])
+            r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey, id))
+        } else if clientCertRequired(r) {
+            writeError(w, r, httpError(http.StatusUnauthorized, "Client certificate required"))
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func clientCertRequired(r *http.Request) bool {
+    if config.TLSClientCAFile == "" {
+        return false
+    }
+    switch config.TLSClientAuth {
+    case clientAuthRequire:
+        return !probeRoutes[routeTemplate(r)]
+    case clientAuthMutations:
+        return isMutating(r.Method)
+    }
+    return false
+}
+
+func newClientIdentity(cert *x509.Certificate) ClientIdentity {
+    sum := sha256.Sum256(cert.Raw)
+    id := ClientIdentity{
+        Subject:     cert.Subject.CommonName,
+        DNSNames:    cert.DNSNames,
+        Serial:      cert.SerialNumber.String(),
+        Fingerprint: hex.EncodeToString(sum[:]),
+    }
+    for _, u := range cert.URIs {
+        id.URIs = append(id.URIs, u.String())
+    }
+    return id
+}
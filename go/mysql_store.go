@@ -0,0 +1,176 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "time"
+
+    "github.com/go-sql-driver/mysql"
+)
+
+// mysqlDialect also covers MariaDB. The email column's default collation is
+// case-insensitive on both, so a plain unique key matches the lower(email)
+// index of the other backends. MySQL has no INSERT ... RETURNING, so IDs
+// come from LastInsertId. The email column is wide enough for an encrypted
+// email of maxEmailLength; tables created with the narrower one of older
+// releases are widened, which is a no-op once done.
+var mysqlDialect = sqlDialect{
+    name: "mysql",
+    schema: []string{
+        `CREATE TABLE IF NOT EXISTS users (
+            id            BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+            name          VARCHAR(255) NOT NULL,
+            email         VARCHAR(512) NOT NULL,
+            password_hash VARCHAR(255) NOT NULL DEFAULT '',
+            roles         VARCHAR(255) NOT NULL DEFAULT '',
+            verified      BOOLEAN NOT NULL DEFAULT FALSE,
+            created_at    DATETIME(6) NOT NULL,
+            version       INT NOT NULL DEFAULT 1,
+            UNIQUE KEY users_email_key (email)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+        `ALTER TABLE users MODIFY email VARCHAR(512) NOT NULL`,
+        `CREATE TABLE IF NOT EXISTS user_events (
+            id          BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+            type        VARCHAR(64) NOT NULL,
+            user_id     BIGINT NOT NULL,
+            payload     TEXT NOT NULL,
+            occurred_at DATETIME(6) NOT NULL,
+            sent_at     DATETIME(6) NULL,
+            KEY user_events_pending (sent_at, id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+        `CREATE TABLE IF NOT EXISTS api_keys (
+            id         VARCHAR(32) NOT NULL PRIMARY KEY,
+            name       VARCHAR(255) NOT NULL,
+            key_hash   CHAR(64) NOT NULL,
+            roles      VARCHAR(255) NOT NULL DEFAULT '',
+            rate_limit INT NOT NULL DEFAULT 0,
+            created_at DATETIME(6) NOT NULL,
+            revoked_at DATETIME(6) NULL
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+    },
+    dayExpr:    `DATE_FORMAT(created_at, '%Y-%m-%d')`,
+    domainExpr: `LOWER(SUBSTRING_INDEX(email, '@', -1))`,
+    isUniqueViolation: func(err error) bool {
+        var myErr *mysql.MySQLError
+        return errors.As(err, &myErr) && myErr.Number == 1062
+    },
+    constraintViolation: func(err error) *ConstraintError {
+        var myErr *mysql.MySQLError
+        if !errors.As(err, &myErr) {
+            return nil
+        }
+        switch myErr.Number {
+        case 1451, 1452:
+            return foreignKeyViolation(mysqlErrorName(mysqlForeignKey, myErr.Message))
+        case 3819:
+            return checkViolation(mysqlErrorName(mysqlCheck, myErr.Message), "value is not allowed")
+        case 1048:
+            return checkViolation(mysqlErrorName(mysqlColumn, myErr.Message), "value is required")
+        case 1406:
+            return checkViolation(mysqlErrorName(mysqlColumn, myErr.Message), "value is too long")
+        }
+        return nil
+    },
+    replicaLag: mysqlReplicaLag,
+}
+
+// mysqlReplicaLag reads Seconds_Behind_Source (Seconds_Behind_Master on
+// MariaDB), which is NULL while replication is stopped.
+func mysqlReplicaLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+    rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+    if err != nil {
+        return 0, err
+    }
+    defer rows.Close()
+    columns, err := rows.Columns()
+    if err != nil {
+        return 0, err
+    }
+    if !rows.Next() {
+        if err := rows.Err(); err != nil {
+            return 0, err
+        }
+        return 0, errors.New("not a replica")
+    }
+    values := make([]sql.RawBytes, len(columns))
+    dest := make([]interface{}, len(columns))
+    for i := range values {
+        dest[i] = &values[i]
+    }
+    if err := rows.Scan(dest...); err != nil {
+        return 0, err
+    }
+    for i, column := range columns {
+        if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+            continue
+        }
+        if values[i] == nil {
+            return 0, errors.New("replication is stopped")
+        }
+        seconds, err := strconv.Atoi(string(values[i]))
+        return time.Duration(seconds) * time.Second, err
+    }
+    return 0, errors.New("no Seconds_Behind_Source in SHOW REPLICA STATUS")
+}
+
+// MySQL only names the offending column or constraint in the message text,
+// e.g. "Data too long for column 'name' at row 1".
+var (
+    mysqlColumn     = regexp.MustCompile("[Cc]olumn '([^']+)'")
+    mysqlCheck      = regexp.MustCompile("[Cc]heck constraint '([^']+)'")
+    mysqlForeignKey = regexp.MustCompile("FOREIGN KEY \\(`([^`]+)`\\)")
+)
+
+func mysqlErrorName(re *regexp.Regexp, message string) string {
+    if m := re.FindStringSubmatch(message); m != nil {
+        return m[1]
+    }
+    return ""
+}
+
+// openMySQLUserRepository connects with a go-sql-driver DSN such as
+// "user:pass@tcp(db:3306)/users". Times are always read and written in UTC
+// so DATETIME columns round-trip regardless of the server time zone.
+func openMySQLUserRepository(ctx context.Context, dsn string) (UserRepository, error) {
+    if dsn == "" {
+        return nil, errors.New("DATABASE_URL is required for the mysql backend")
+    }
+    db, err := openMySQLDB(dsn)
+    if err != nil {
+        return nil, fmt.Errorf("parse DATABASE_URL: %w", err)
+    }
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    if err := db.PingContext(ctx); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("connect to mysql: %w", err)
+    }
+    repo, err := newSQLUserRepository(ctx, db, mysqlDialect)
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("create mysql schema: %w", err)
+    }
+    if err := repo.openReplicas(ctx, replicaURLs(), openMySQLDB); err != nil {
+        repo.Close()
+        return nil, err
+    }
+    return repo, nil
+}
+
+func openMySQLDB(dsn string) (*sql.DB, error) {
+    cfg, err := mysql.ParseDSN(dsn)
+    if err != nil {
+        return nil, err
+    }
+    cfg.ParseTime = true
+    cfg.Loc = time.UTC
+    connector, err := mysql.NewConnector(cfg)
+    if err != nil {
+        return nil, err
+    }
+    return sql.OpenDB(connector), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "context"
+    "os"
+    "testing"
+)
+
+// The MySQL tests run against the server in MYSQL_TEST_DSN and are skipped
+// without it, e.g.
+//
+//	docker run -d --name mysql-test -e MYSQL_ROOT_PASSWORD=test -e MYSQL_DATABASE=users_test -p 3306:3306 mysql:8
+//	MYSQL_TEST_DSN='root:test@tcp(localhost:3306)/users_test' go test -run MySQL
+//
+// Each test empties the tables first, so point it at a throwaway database.
+
+func openMySQLTestRepository(t *testing.T) *sqlUserRepository {
+    t.Helper()
+    dsn := os.Getenv("MYSQL_TEST_DSN")
+    if dsn == "" {
+        t.Skip("MYSQL_TEST_DSN is not set")
+    }
+    ctx := context.Background()
+    repo, err := openMySQLUserRepository(ctx, dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := repo.(*sqlUserRepository)
+    t.Cleanup(func() { s.Close() })
+    for _, table := range []string{"users"} {
+        if _, err := s.db.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return s
+}
+
+func TestMySQLUserLifecycle(t *testing.T) {
+    testUserLifecycle(t, openMySQLTestRepository(t))
+}
+
+func TestMySQLEmailTaken(t *testing.T) {
+    testEmailTaken(t, openMySQLTestRepository(t))
+}
+
+func TestMySQLEmailEncryption(t *testing.T) {
+    testEmailEncryption(t, openMySQLTestRepository(t))
+}
+
+func TestMySQLUserStats(t *testing.T) {
+    testUserStats(t, openMySQLTestRepository(t))
+}
+
+func TestMySQLWithTx(t *testing.T) {
+    testWithTx(t, openMySQLTestRepository(t))
+}
+
+func TestMySQLVersionConflict(t *testing.T) {
+    testVersionConflict(t, openMySQLTestRepository(t))
+}
+
+func TestMySQLOutbox(t *testing.T) {
+    testOutbox(t, openMySQLTestRepository(t))
+}
+
+func TestMySQLAPIKeys(t *testing.T) {
+    testAPIKeys(t, openMySQLTestRepository(t))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// With OIDC_ISSUER_URL set, the API accepts the access tokens of an OpenID
+// Connect provider such as Keycloak or Auth0, e.g.
+//
+//	OIDC_ISSUER_URL=http://keycloak:8080/realms/demo
+//	OIDC_CLIENT_ID=user-api
+//	JWT_ROLES_CLAIM=realm_access.roles
+//	JWT_ROLE_MAP=api-admin=admin,api-user=user
+//
+// The JWKS is found through the provider's discovery document, which is
+// fetched again on every JWKS refresh, and cached like JWT_JWKS_URL, which
+// must not be set as well. Tokens must be issued by OIDC_ISSUER_URL, which
+// has to match the issuer of the discovery document exactly, trailing slash
+// included, and, with OIDC_CLIENT_ID set, be meant for that audience.
+// JWT_ISSUER and JWT_AUDIENCE still override both.
+//
+// JWT_ROLES_CLAIM may name a nested claim with a dotted path, like the
+// realm_access.roles of Keycloak; a claim whose name contains dots, like the
+// namespaced claims of Auth0, is matched first. JWT_ROLE_MAP, a comma
+// separated list of provider=api role pairs, translates the roles of
+// external tokens; with it set, roles it does not list are dropped.
+
+// oidcDiscovery is the part of the discovery document the API uses.
+type oidcDiscovery struct {
+    Issuer  string `json:"issuer"`
+    JWKSURI string `json:"jwks_uri"`
+}
+
+// oidcDiscoveryURL returns where the discovery document of issuer is.
+func oidcDiscoveryURL(issuer string) string {
+    return strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+}
+
+// setupOIDC points the issuer, audience and JWKS of external tokens at the
+// provider.
+func setupOIDC() error {
+    if config.JWTJWKSURL != "" {
+        return errors.New("set OIDC_ISSUER_URL or JWT_JWKS_URL, not both")
+    }
+    if config.JWTIssuer == "" {
+        config.JWTIssuer = config.OIDCIssuerURL
+    }
+    if config.JWTAudience == "" {
+        config.JWTAudience = config.OIDCClientID
+    }
+    jwtKeys.url = oidcDiscoveryURL(config.OIDCIssuerURL)
+    jwtKeys.discover = func(ctx context.Context) (string, error) {
+        doc, err := discoverOIDC(ctx, jwtKeys.client, config.OIDCIssuerURL)
+        if err != nil {
+            return "", err
+        }
+        return doc.JWKSURI, nil
+    }
+    return nil
+}
+
+// discoverOIDC fetches the discovery document of issuer.
+func discoverOIDC(ctx context.Context, client *http.Client, issuer string) (oidcDiscovery, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, oidcDiscoveryURL(issuer), nil)
+    if err != nil {
+        return oidcDiscovery{}, err
+    }
+    req.Header.Set("Accept", "application/json")
+    resp, err := client.Do(req)
+    if err != nil {
+        return oidcDiscovery{}, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return oidcDiscovery{}, fmt.Errorf("OIDC discovery returned %s", resp.Status)
+    }
+    var doc oidcDiscovery
+    if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+        return oidcDiscovery{}, fmt.Errorf("decode OIDC discovery document: %w", err)
+    }
+    if doc.Issuer != issuer {
+        return oidcDiscovery{}, fmt.Errorf("OIDC discovery document names issuer %q, not %q", doc.Issuer, issuer)
+    }
+    if doc.JWKSURI == "" {
+        return oidcDiscovery{}, errors.New("OIDC discovery document has no jwks_uri")
+    }
+    return doc, nil
+}
+
+// parseRoleMap parses JWT_ROLE_MAP. Malformed pairs and unknown API roles
+// are skipped and returned as an error.
+func parseRoleMap(value string) (map[string]string, error) {
+    if value == "" {
+        return nil, nil
+    }
+    roles := make(map[string]string)
+    var errs []error
+    for _, pair := range strings.Split(value, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        from, to, ok := strings.Cut(pair, "=")
+        from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+        if !ok || from == "" || !knownRoles[to] {
+            errs = append(errs, fmt.Errorf("%q is not a provider=api role pair with an API role of %s or %s", pair, roleAdmin, roleUser))
+            continue
+        }
+        roles[from] = to
+    }
+    return roles, errors.Join(errs...)
+}
+
+// mapRoles translates provider roles with JWT_ROLE_MAP, if set.
+func mapRoles(roles []string) []string {
+    if config.JWTRoleMap == nil {
+        return roles
+    }
+    var mapped []string
+    seen := make(map[string]bool)
+    for _, role := range roles {
+        if to, ok := config.JWTRoleMap[role]; ok && !seen[to] {
+            seen[to] = true
+            mapped = append(mapped, to)
+        }
+    }
+    return mapped
+}
+
+// claimPath returns the claim name, or the nested claim its dotted path
+// leads to.
+func claimPath(claims Claims, name string) any {
+    if v, ok := claims[name]; ok {
+        return v
+    }
+    var v any = map[string]any(claims)
+    for _, part := range strings.Split(name, ".") {
+        m, ok := v.(map[string]any)
+        if !ok {
+            return nil
+        }
+        v = m[part]
+    }
+    return v
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestOIDC(t *testing.T) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var provider *httptest.Server
+    issuer := ""
+    provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/realms/demo/.well-known/openid-configuration":
+            json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": provider.URL + "/realms/demo/certs"})
+        case "/realms/demo/certs":
+            json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+                "kty": "RSA", "kid": "k1",
+                "n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+                "e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+            }}})
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer provider.Close()
+    issuer = provider.URL + "/realms/demo"
+
+    defer func(url, id, iss, aud, claim string, roles map[string]string, keys *jwksCache) {
+        config.OIDCIssuerURL, config.OIDCClientID, config.JWTIssuer, config.JWTAudience, config.JWTRolesClaim, config.JWTRoleMap, jwtKeys = url, id, iss, aud, claim, roles, keys
+    }(config.OIDCIssuerURL, config.OIDCClientID, config.JWTIssuer, config.JWTAudience, config.JWTRolesClaim, config.JWTRoleMap, jwtKeys)
+    config.OIDCIssuerURL, config.OIDCClientID, config.JWTIssuer, config.JWTAudience = issuer, "user-api", "", ""
+    roles, err := parseRoleMap("api-admin=admin, api-user=user, bad=root")
+    if len(roles) != 2 || err == nil || !strings.Contains(err.Error(), "bad=root") {
+        t.Fatalf("parsed %v, %v; want the 2 valid pairs and bad=root as an error", roles, err)
+    }
+    config.JWTRolesClaim, config.JWTRoleMap = "realm_access.roles", roles
+    jwtKeys = &jwksCache{client: provider.Client()}
+    if err := setupOIDC(); err != nil {
+        t.Fatal(err)
+    }
+    if err := jwtKeys.refresh(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+
+    exp := float64(time.Now().Add(time.Hour).Unix())
+    claims := map[string]any{
+        "sub": "alice", "iss": issuer, "aud": []string{"account", "user-api"}, "exp": exp,
+        "realm_access": map[string]any{"roles": []string{"api-admin", "offline_access"}},
+    }
+    got, err := verifyJWT(context.Background(), testJWT(t, "RS256", "k1", claims, nil, key))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if roles := jwtRoles(got); len(roles) != 1 || roles[0] != roleAdmin {
+        t.Errorf("roles %v, want [admin]", roles)
+    }
+    claims["aud"] = "account"
+    if _, err := verifyJWT(context.Background(), testJWT(t, "RS256", "k1", claims, nil, key)); err == nil {
+        t.Error("token for another audience accepted")
+    }
+
+    issuer = "https://elsewhere.example.com"
+    if _, err := discoverOIDC(context.Background(), provider.Client(), provider.URL+"/realms/demo"); err == nil {
+        t.Error("discovery document of another issuer accepted")
+    }
+}
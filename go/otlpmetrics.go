@@ -0,0 +1,44 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "strings"
+
+    prometheusbridge "go.opentelemetry.io/contrib/bridges/prometheus"
+    "go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+    sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// setupMetricsExport pushes the metrics over OTLP/HTTP when
+// OTEL_METRICS_EXPORTER=otlp, for collectors that do not scrape. /metrics is
+// still served. Every metric in the Prometheus registry is exported as it is
+// scraped, labels becoming attributes, every OTEL_METRIC_EXPORT_INTERVAL
+// (default 60s) to OTEL_EXPORTER_OTLP_METRICS_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT, with the same resource as the traces. The
+// returned function pushes the metrics one last time.
+func setupMetricsExport(ctx context.Context) (func(context.Context) error, error) {
+    switch exporter := strings.ToLower(getenv("OTEL_METRICS_EXPORTER")); exporter {
+    case "", "none", "prometheus":
+        return func(context.Context) error { return nil }, nil
+    case "otlp":
+    default:
+        return nil, fmt.Errorf("unsupported OTEL_METRICS_EXPORTER %q (want otlp or none)", exporter)
+    }
+    if strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+        return func(context.Context) error { return nil }, nil
+    }
+    exporter, err := otlpmetrichttp.New(ctx)
+    if err != nil {
+        return nil, err
+    }
+    res, err := otelResource(ctx)
+    if err != nil {
+        return nil, err
+    }
+    reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithProducer(prometheusbridge.NewMetricProducer()))
+    provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+    slog.Info("Exporting metrics over OTLP")
+    return provider.Shutdown, nil
+}
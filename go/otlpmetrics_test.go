@@ -0,0 +1,47 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+)
+
+func TestMetricsExport(t *testing.T) {
+    var mu sync.Mutex
+    var received []byte
+    collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        mu.Lock()
+        received = append(received, body...)
+        mu.Unlock()
+    }))
+    defer collector.Close()
+    t.Setenv("OTEL_METRICS_EXPORTER", "otlp")
+    t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", collector.URL+"/v1/metrics")
+    t.Setenv("OTEL_EXPORTER_OTLP_COMPRESSION", "none")
+
+    httpRequestsTotal.WithLabelValues("GET", "/exported", "200").Inc()
+    shutdown, err := setupMetricsExport(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := shutdown(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    mu.Lock()
+    defer mu.Unlock()
+    for _, want := range []string{"http_requests_total", "/exported", "user-api"} {
+        if !bytes.Contains(received, []byte(want)) {
+            t.Errorf("pushed metrics do not mention %q", want)
+        }
+    }
+
+    t.Setenv("OTEL_METRICS_EXPORTER", "carrier-pigeon")
+    if _, err := setupMetricsExport(context.Background()); err == nil {
+        t.Error("unknown exporter accepted")
+    }
+}
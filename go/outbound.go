@@ -0,0 +1,85 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+    "go.opentelemetry.io/otel/trace"
+)
+
+var (
+    httpClientRequestsTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "http_client_requests_total",
+            Help: "Total number of outbound HTTP requests by host, method and status, or error when no response came back",
+        },
+        []string{"host", "method", "status"},
+    )
+    httpClientRequestDuration = prometheus.NewHistogramVec(
+        prometheus.HistogramOpts{
+            Name:    "http_client_request_duration_seconds",
+            Help:    "Duration of outbound HTTP requests until the response headers arrived",
+            Buckets: prometheus.DefBuckets,
+        },
+        []string{"host", "method"},
+    )
+)
+
+func init() {
+    prometheus.MustRegister(httpClientRequestsTotal, httpClientRequestDuration)
+}
+
+// newHTTPClient returns the client for outbound calls such as webhooks. Each
+// request gets a client span and carries the trace context of its context
+// in traceparent (and baggage), plus its request ID in X-Request-ID, so the
+// receiver's logs and traces join up with ours. Requests made outside a
+// request, e.g. by the outbox dispatcher, start a trace of their own.
+func newHTTPClient(timeout time.Duration) *http.Client {
+    return &http.Client{Timeout: timeout, Transport: &outboundTransport{base: http.DefaultTransport}}
+}
+
+type outboundTransport struct {
+    base http.RoundTripper
+}
+
+func (t *outboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(
+            semconv.HTTPRequestMethodKey.String(req.Method),
+            semconv.ServerAddress(req.URL.Hostname()),
+            // The query is left out, as it can carry tokens.
+            semconv.URLFull(req.URL.Scheme+"://"+req.URL.Host+req.URL.Path),
+        ),
+    )
+    defer span.End()
+
+    // RoundTrippers must not modify the request they are given.
+    out := req.Clone(ctx)
+    otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(out.Header))
+    if id := requestIDFromContext(ctx); id != "" && out.Header.Get(requestIDHeader) == "" {
+        out.Header.Set(requestIDHeader, id)
+    }
+
+    start := time.Now()
+    resp, err := t.base.RoundTrip(out)
+    httpClientRequestDuration.WithLabelValues(req.URL.Host, req.Method).Observe(time.Since(start).Seconds())
+    if err != nil {
+        httpClientRequestsTotal.WithLabelValues(req.URL.Host, req.Method, "error").Inc()
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+        return nil, err
+    }
+    httpClientRequestsTotal.WithLabelValues(req.URL.Host, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+    span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+    if resp.StatusCode >= 500 {
+        span.SetStatus(codes.Error, resp.Status)
+    }
+    return resp, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    outboxEventsPublishedTotal = prometheus.NewCounter(
+        prometheus.CounterOpts{
+            Name: "outbox_events_published_total",
+            Help: "Total number of user events delivered to OUTBOX_WEBHOOK_URL",
+        },
+    )
+    outboxPublishFailuresTotal = prometheus.NewCounter(
+        prometheus.CounterOpts{
+            Name: "outbox_publish_failures_total",
+            Help: "Total number of failed user event deliveries, each retried on the next poll",
+        },
+    )
+)
+
+func init() {
+    prometheus.MustRegister(outboxEventsPublishedTotal, outboxPublishFailuresTotal)
+}
+
+const (
+    eventUserCreated = "user.created"
+    eventUserUpdated = "user.updated"
+    eventUserDeleted = "user.deleted"
+)
+
+// With OUTBOX_WEBHOOK_URL set, every user write also records an event in the
+// same transaction, and a dispatcher POSTs the pending events there in order
+// every OUTBOX_POLL_INTERVAL (default 1s), or as soon as one is written,
+// OUTBOX_BATCH_SIZE (default 100) at a time.
+
+// UserEvent is one user write, as delivered to OUTBOX_WEBHOOK_URL. User is
+// the user after the write, and is omitted for deletes.
+type UserEvent struct {
+    ID         int64     `json:"id"`
+    Type       string    `json:"type"`
+    UserID     int       `json:"user_id"`
+    User       *User     `json:"user,omitempty"`
+    OccurredAt time.Time `json:"occurred_at"`
+}
+
+func newUserEvent(eventType string, userID int, user *User) UserEvent {
+    if user != nil {
+        u := *user
+        u.Password = ""
+        user = &u
+    }
+    return UserEvent{Type: eventType, UserID: userID, User: user, OccurredAt: time.Now().UTC()}
+}
+
+// outboxStore is implemented by the backends that can record events in the
+// transaction of the write they describe. appendEvent is called on the
+// repository WithTx passes to fn.
+type outboxStore interface {
+    appendEvent(ctx context.Context, event UserEvent) error
+    // pendingEvents returns up to limit undelivered events, oldest first.
+    pendingEvents(ctx context.Context, limit int) ([]UserEvent, error)
+    markEventsSent(ctx context.Context, ids []int64) error
+}
+
+// outboxRepository runs each write of the backend in a transaction together
+// with its event. Delivery is at least once: an event whose delivery
+// succeeded but could not be marked sent, or that two replicas of the API
+// picked up at the same time, is delivered again, so consumers should
+// deduplicate by the X-Event-ID header.
+type outboxRepository struct {
+    UserRepository
+    dispatcher *outboxDispatcher
+}
+
+// newOutboxRepository wraps repo and starts delivering its events to url.
+func newOutboxRepository(repo UserRepository, url string) (UserRepository, error) {
+    store, ok := repo.(outboxStore)
+    if !ok {
+        return nil, errors.New("this storage backend cannot record events for OUTBOX_WEBHOOK_URL")
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    d := &outboxDispatcher{
+        store:  store,
+        url:    url,
+        client: newHTTPClient(10 * time.Second),
+        wake:   make(chan struct{}, 1),
+        ctx:    ctx,
+        cancel: cancel,
+        done:   make(chan struct{}),
+    }
+    go d.run()
+    slog.Info("Publishing user events", "url", redactConfigValue("OUTBOX_WEBHOOK_URL", url))
+    return &outboxRepository{UserRepository: repo, dispatcher: d}, nil
+}
+
+// Unwrap returns the repository whose writes are recorded.
+func (o *outboxRepository) Unwrap() UserRepository {
+    return o.UserRepository
+}
+
+func (o *outboxRepository) Insert(ctx context.Context, user User) (inserted User, err error) {
+    err = o.WithTx(ctx, func(tx UserRepository) error {
+        inserted, err = tx.Insert(ctx, user)
+        return err
+    })
+    return inserted, err
+}
+
+func (o *outboxRepository) Update(ctx context.Context, user User) (updated User, err error) {
+    err = o.WithTx(ctx, func(tx UserRepository) error {
+        updated, err = tx.Update(ctx, user)
+        return err
+    })
+    return updated, err
+}
+
+func (o *outboxRepository) Delete(ctx context.Context, id int) error {
+    return o.WithTx(ctx, func(tx UserRepository) error {
+        return tx.Delete(ctx, id)
+    })
+}
+
+func (o *outboxRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    err := o.UserRepository.WithTx(ctx, func(tx UserRepository) error {
+        return fn(&outboxTxRepository{UserRepository: tx, outbox: tx.(outboxStore)})
+    })
+    if err == nil {
+        o.dispatcher.notify()
+    }
+    return err
+}
+
+// Close stops the deliveries; pending events are delivered after a restart.
+func (o *outboxRepository) Close() error {
+    o.dispatcher.stop()
+    return o.UserRepository.Close()
+}
+
+// outboxTxRepository records an event for each write in a transaction.
+type outboxTxRepository struct {
+    UserRepository
+    outbox outboxStore
+}
+
+func (t *outboxTxRepository) Insert(ctx context.Context, user User) (User, error) {
+    inserted, err := t.UserRepository.Insert(ctx, user)
+    if err != nil {
+        return User{}, err
+    }
+    return inserted, t.outbox.appendEvent(ctx, newUserEvent(eventUserCreated, inserted.ID, &inserted))
+}
+
+func (t *outboxTxRepository) Update(ctx context.Context, user User) (User, error) {
+    updated, err := t.UserRepository.Update(ctx, user)
+    if err != nil {
+        return User{}, err
+    }
+    return updated, t.outbox.appendEvent(ctx, newUserEvent(eventUserUpdated, updated.ID, &updated))
+}
+
+func (t *outboxTxRepository) Delete(ctx context.Context, id int) error {
+    if err := t.UserRepository.Delete(ctx, id); err != nil {
+        return err
+    }
+    return t.outbox.appendEvent(ctx, newUserEvent(eventUserDeleted, id, nil))
+}
+
+func (t *outboxTxRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    return fn(t)
+}
+
+// outboxDispatcher delivers pending events one at a time, stopping at the
+// first failure so consumers see each user's events in order.
+type outboxDispatcher struct {
+    store  outboxStore
+    url    string
+    client *http.Client
+    wake   chan struct{}
+    // ctx is cancelled by stop, aborting a delivery in progress.
+    ctx    context.Context
+    cancel context.CancelFunc
+    done   chan struct{}
+}
+
+func (d *outboxDispatcher) notify() {
+    select {
+    case d.wake <- struct{}{}:
+    default:
+    }
+}
+
+func (d *outboxDispatcher) run() {
+    defer close(d.done)
+    ticker := time.NewTicker(config.OutboxPollInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+        case <-d.wake:
+        case <-d.ctx.Done():
+            return
+        }
+        if err := d.dispatch(d.ctx); err != nil && d.ctx.Err() == nil {
+            outboxPublishFailuresTotal.Inc()
+            slog.Warn("Failed to publish user events", "error", err)
+        }
+    }
+}
+
+func (d *outboxDispatcher) stop() {
+    d.cancel()
+    <-d.done
+}
+
+// dispatch delivers pending events until there are none left or one fails.
+func (d *outboxDispatcher) dispatch(ctx context.Context) error {
+    for {
+        events, err := d.store.pendingEvents(ctx, config.OutboxBatchSize)
+        if err != nil || len(events) == 0 {
+            return err
+        }
+        var sent []int64
+        for _, event := range events {
+            if err = d.publish(ctx, event); err != nil {
+                break
+            }
+            sent = append(sent, event.ID)
+            slog.Debug("Published user event", "event_id", event.ID, "type", event.Type, "user_id", event.UserID)
+        }
+        if len(sent) > 0 {
+            if err := d.store.markEventsSent(ctx, sent); err != nil {
+                return fmt.Errorf("mark events sent: %w", err)
+            }
+            outboxEventsPublishedTotal.Add(float64(len(sent)))
+        }
+        if err != nil || len(events) < config.OutboxBatchSize {
+            return err
+        }
+    }
+}
+
+func (d *outboxDispatcher) publish(ctx context.Context, event UserEvent) error {
+    if event.User != nil && emailKeys != nil {
+        user := *event.User
+        email, err := emailKeys.decrypt(user.Email)
+        if err != nil {
+            return fmt.Errorf("event %d: %w", event.ID, err)
+        }
+        user.Email = email
+        event.User = &user
+    }
+    body, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Event-ID", strconv.FormatInt(event.ID, 10))
+    req.Header.Set("X-Event-Type", event.Type)
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("event %d: %w", event.ID, err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("event %d: webhook returned %s", event.ID, resp.Status)
+    }
+    return nil
+}
+
+// encodeEventUser and decodeEventUser store an event's user for the backends
+// that keep it as text. The password hash is left out, as in the API.
+func encodeEventUser(user *User) (string, error) {
+    if user == nil {
+        return "", nil
+    }
+    data, err := json.Marshal(user)
+    return string(data), err
+}
+
+func decodeEventUser(data string) (*User, error) {
+    if data == "" {
+        return nil, nil
+    }
+    var user User
+    if err := json.Unmarshal([]byte(data), &user); err != nil {
+        return nil, err
+    }
+    return &user, nil
+}
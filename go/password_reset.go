@@ -0,0 +1,156 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// PASSWORD_RESET_TTL (default 1h) is how long a password reset token stays
+// valid.
+
+type passwordResetToken struct {
+    userID  int
+    expires time.Time
+}
+
+// passwordResetStore holds outstanding password reset tokens by their
+// SHA-256 hash, so a dump of it cannot be used to reset a password. Each
+// token is single-use, and issuing one replaces those issued to the user
+// before.
+type passwordResetStore struct {
+    mu     sync.Mutex
+    tokens map[string]passwordResetToken
+}
+
+var passwordResets = &passwordResetStore{tokens: make(map[string]passwordResetToken)}
+
+// issue creates a token for userID.
+func (s *passwordResetStore) issue(userID int, ttl time.Duration) (string, time.Time, error) {
+    token, err := randomToken(32)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    expires := time.Now().Add(ttl)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for hash, t := range s.tokens {
+        if t.userID == userID || time.Now().After(t.expires) {
+            delete(s.tokens, hash)
+        }
+    }
+    s.tokens[hashToken(token)] = passwordResetToken{userID: userID, expires: expires}
+    return token, expires, nil
+}
+
+// consume returns the user a token belongs to and removes it. Expired or
+// unknown tokens report false.
+func (s *passwordResetStore) consume(token string) (int, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    hash := hashToken(token)
+    t, ok := s.tokens[hash]
+    if !ok {
+        return 0, false
+    }
+    delete(s.tokens, hash)
+    if time.Now().After(t.expires) {
+        return 0, false
+    }
+    return t.userID, true
+}
+
+type PasswordResetRequest struct {
+    Email string `json:"email"`
+}
+
+type PasswordReset struct {
+    Token    string `json:"token"`
+    Password string `json:"password"`
+}
+
+// passwordResetRequestHandler issues a reset token for the account with
+// the email given. The answer is the same whether or not there is one, so
+// it cannot be used to find out which addresses have accounts. There is no
+// mail transport in the demo, so the link is written to the log instead.
+func passwordResetRequestHandler(w http.ResponseWriter, r *http.Request) {
+    var req PasswordResetRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+        writeError(w, r, httpError(http.StatusBadRequest, "email is required"))
+        return
+    }
+
+    user, err := userRepo.GetByEmail(r.Context(), req.Email)
+    if err != nil && !errors.Is(err, ErrNotFound) {
+        writeError(w, r, err)
+        return
+    }
+    if err == nil {
+        token, _, err := passwordResets.issue(user.ID, config.PasswordResetTTL)
+        if err != nil {
+            writeError(w, r, httpError(http.StatusInternalServerError, "Could not generate reset token"))
+            return
+        }
+        slog.InfoContext(r.Context(), "Password reset link", "email", user.Email, "link", "/password/reset?token="+token)
+    }
+
+    writeJSON(w, r, http.StatusAccepted, APIResponse{
+        Status:  "success",
+        Message: "If an account has this email, a password reset link has been sent to it",
+    })
+}
+
+// passwordResetHandler sets a new password with a reset token, and signs
+// the user out of the sessions their refresh tokens keep alive.
+func passwordResetHandler(w http.ResponseWriter, r *http.Request) {
+    var req PasswordReset
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    if req.Token == "" {
+        writeError(w, r, httpError(http.StatusBadRequest, "Missing token"))
+        return
+    }
+    // The password is checked first so a weak one does not use up the token.
+    if err := validatePassword(req.Password); err != nil {
+        verr := &ValidationError{}
+        verr.add("password", err.Error())
+        writeError(w, r, verr)
+        return
+    }
+
+    id, ok := passwordResets.consume(req.Token)
+    if !ok {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid or expired token"))
+        return
+    }
+    err := userRepo.WithTx(r.Context(), func(tx UserRepository) error {
+        user, err := tx.Get(r.Context(), id)
+        if err != nil {
+            return err
+        }
+        user.Password = req.Password
+        if err := hashPassword(&user); err != nil {
+            return err
+        }
+        _, err = tx.Update(r.Context(), user)
+        return err
+    })
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    refreshTokens.revokeUser(id)
+    cache.purgePrefix("/users")
+    slog.InfoContext(r.Context(), "Password reset", "user_id", id)
+
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status:  "success",
+        Message: "Password changed",
+    })
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "regexp"
+    "strings"
+    "testing"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordReset(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+    user, err := repo.Insert(context.Background(), User{Name: "Ada", Email: "ada@example.com", PasswordHash: string(hash)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    refresh, err := refreshTokens.issue(user.ID, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var logged bytes.Buffer
+    defer func(old *slog.Logger) { slog.SetDefault(old) }(slog.Default())
+    slog.SetDefault(slog.New(slog.NewJSONHandler(&logged, nil)))
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("POST /login", loginHandler)
+    mux.HandleFunc("POST /password/reset-request", passwordResetRequestHandler)
+    mux.HandleFunc("POST /password/reset", passwordResetHandler)
+    do := func(path, body string) *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+        return rec
+    }
+
+    unknown := do("/password/reset-request", `{"email":"nobody@example.com"}`)
+    known := do("/password/reset-request", `{"email":"ada@example.com"}`)
+    if unknown.Code != http.StatusAccepted || known.Code != http.StatusAccepted || unknown.Body.String() != known.Body.String() {
+        t.Fatalf("unknown %d %s, known %d %s", unknown.Code, unknown.Body, known.Code, known.Body)
+    }
+    m := regexp.MustCompile(`token=([0-9a-f]+)`).FindStringSubmatch(logged.String())
+    if m == nil {
+        t.Fatalf("no reset link logged: %s", logged.String())
+    }
+    token := m[1]
+    if _, ok := passwordResets.tokens[token]; ok {
+        t.Fatal("token stored in the clear")
+    }
+
+    if rec := do("/password/reset", `{"token":"`+token+`","password":"short"}`); rec.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("short password: %d %s", rec.Code, rec.Body)
+    }
+    if rec := do("/password/reset", `{"token":"`+token+`","password":"battery staple"}`); rec.Code != http.StatusOK {
+        t.Fatalf("reset: %d %s", rec.Code, rec.Body)
+    }
+    if rec := do("/password/reset", `{"token":"`+token+`","password":"battery staple"}`); rec.Code != http.StatusBadRequest {
+        t.Errorf("reused token: %d %s", rec.Code, rec.Body)
+    }
+    if rec := do("/login", `{"email":"ada@example.com","password":"correct horse"}`); rec.Code != http.StatusUnauthorized {
+        t.Errorf("old password: %d", rec.Code)
+    }
+    if rec := do("/login", `{"email":"ada@example.com","password":"battery staple"}`); rec.Code != http.StatusOK {
+        t.Errorf("new password: %d %s", rec.Code, rec.Body)
+    }
+    if _, _, err := refreshTokens.redeem(refresh); err == nil {
+        t.Error("refresh token survived the reset")
+    }
+
+    expired, _, err := passwordResets.issue(user.ID, -time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rec := do("/password/reset", `{"token":"`+expired+`","password":"battery staple"}`); rec.Code != http.StatusBadRequest {
+        t.Errorf("expired token: %d %s", rec.Code, rec.Body)
+    }
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "sync"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    poolGetsTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "object_pool_gets_total",
+            Help: "Total number of objects taken from a pool",
+        },
+        []string{"pool"},
+    )
+    poolAllocationsTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "object_pool_allocations_total",
+            Help: "Total number of objects allocated because a pool was empty",
+        },
+        []string{"pool"},
+    )
+)
+
+func init() {
+    prometheus.MustRegister(poolGetsTotal)
+    prometheus.MustRegister(poolAllocationsTotal)
+}
+
+// maxPooledBufferSize keeps one oversized response from pinning a large
+// buffer in the pool forever.
+const maxPooledBufferSize = 64 << 10
+
+// objectPool is a sync.Pool that counts gets and fresh allocations, so the
+// hit rate (1 - allocations/gets) can be watched under load.
+type objectPool[T any] struct {
+    pool   sync.Pool
+    gets   prometheus.Counter
+    allocs prometheus.Counter
+}
+
+func newObjectPool[T any](name string, alloc func() T) *objectPool[T] {
+    p := &objectPool[T]{
+        gets:   poolGetsTotal.WithLabelValues(name),
+        allocs: poolAllocationsTotal.WithLabelValues(name),
+    }
+    p.pool.New = func() interface{} {
+        p.allocs.Inc()
+        return alloc()
+    }
+    return p
+}
+
+func (p *objectPool[T]) get() T {
+    p.gets.Inc()
+    return p.pool.Get().(T)
+}
+
+func (p *objectPool[T]) put(v T) {
+    p.pool.Put(v)
+}
+
+// prewarm fills the pool with n objects ahead of the first requests.
+func (p *objectPool[T]) prewarm(n int) {
+    for i := 0; i < n; i++ {
+        p.pool.Put(p.pool.New())
+    }
+}
+
+var bufferPool = newObjectPool("buffer", func() *bytes.Buffer {
+    return new(bytes.Buffer)
+})
+
+func getBuffer() *bytes.Buffer {
+    return bufferPool.get()
+}
+
+func putBuffer(buf *bytes.Buffer) {
+    if buf.Cap() > maxPooledBufferSize {
+        return
+    }
+    buf.Reset()
+    bufferPool.put(buf)
+}
+
+var statusRecorderPool = newObjectPool("status_recorder", func() *statusRecorder {
+    return new(statusRecorder)
+})
+
+func getStatusRecorder(w http.ResponseWriter) *statusRecorder {
+    rec := statusRecorderPool.get()
+    rec.ResponseWriter, rec.status, rec.wroteHeader, rec.written = w, http.StatusOK, false, 0
+    return rec
+}
+
+func putStatusRecorder(rec *statusRecorder) {
+    rec.ResponseWriter = nil
+    statusRecorderPool.put(rec)
+}
+
+var cacheRecorderPool = newObjectPool("cache_recorder", func() *cacheRecorder {
+    return new(cacheRecorder)
+})
+
+func getCacheRecorder(w http.ResponseWriter) *cacheRecorder {
+    rec := cacheRecorderPool.get()
+    rec.ResponseWriter, rec.status = w, http.StatusOK
+    return rec
+}
+
+func putCacheRecorder(rec *cacheRecorder) {
+    if rec.body.Cap() > maxPooledBufferSize {
+        rec.body = bytes.Buffer{}
+    }
+    rec.ResponseWriter = nil
+    rec.body.Reset()
+    cacheRecorderPool.put(rec)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    _ "github.com/jackc/pgx/v5/stdlib"
+)
+
+var postgresDialect = sqlDialect{
+    name: "postgres",
+    schema: []string{
+        `CREATE TABLE IF NOT EXISTS users (
+            id            BIGSERIAL PRIMARY KEY,
+            name          TEXT NOT NULL,
+            email         TEXT NOT NULL,
+            password_hash TEXT NOT NULL DEFAULT '',
+            roles         TEXT NOT NULL DEFAULT '',
+            verified      BOOLEAN NOT NULL DEFAULT FALSE,
+            created_at    TIMESTAMPTZ NOT NULL,
+            version       INTEGER NOT NULL DEFAULT 1
+        )`,
+        `CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email))`,
+        `CREATE TABLE IF NOT EXISTS user_events (
+            id          BIGSERIAL PRIMARY KEY,
+            type        TEXT NOT NULL,
+            user_id     BIGINT NOT NULL,
+            payload     TEXT NOT NULL DEFAULT '',
+            occurred_at TIMESTAMPTZ NOT NULL,
+            sent_at     TIMESTAMPTZ
+        )`,
+        `CREATE INDEX IF NOT EXISTS user_events_pending ON user_events (id) WHERE sent_at IS NULL`,
+        `CREATE TABLE IF NOT EXISTS api_keys (
+            id         TEXT PRIMARY KEY,
+            name       TEXT NOT NULL,
+            key_hash   TEXT NOT NULL,
+            roles      TEXT NOT NULL DEFAULT '',
+            rate_limit INTEGER NOT NULL DEFAULT 0,
+            created_at TIMESTAMPTZ NOT NULL,
+            revoked_at TIMESTAMPTZ
+        )`,
+    },
+    numbered:   true,
+    returning:  true,
+    dayExpr:    `to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')`,
+    domainExpr: `lower(split_part(email, '@', 2))`,
+    isUniqueViolation: func(err error) bool {
+        var pgErr *pgconn.PgError
+        return errors.As(err, &pgErr) && pgErr.Code == "23505"
+    },
+    constraintViolation: func(err error) *ConstraintError {
+        var pgErr *pgconn.PgError
+        if !errors.As(err, &pgErr) {
+            return nil
+        }
+        field := pgErr.ColumnName
+        if field == "" {
+            field = pgErr.ConstraintName
+        }
+        switch pgErr.Code {
+        case "23503":
+            return foreignKeyViolation(field)
+        case "23514":
+            return checkViolation(field, "value is not allowed")
+        case "23502":
+            return checkViolation(field, "value is required")
+        case "22001":
+            return checkViolation(field, "value is too long")
+        }
+        return nil
+    },
+    // The time since the last replayed transaction, or 0 once the replica
+    // has replayed all it received, so an idle primary does not look like
+    // lag.
+    replicaLag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+        var seconds float64
+        err := db.QueryRowContext(ctx, `SELECT CASE
+            WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+            ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+        END`).Scan(&seconds)
+        return time.Duration(seconds * float64(time.Second)), err
+    },
+}
+
+// openPostgresUserRepository connects through the pgx driver and fails
+// fast if the database is unreachable.
+func openPostgresUserRepository(ctx context.Context, dsn string) (UserRepository, error) {
+    if dsn == "" {
+        return nil, errors.New("DATABASE_URL is required for the postgres backend")
+    }
+    db, err := openPostgresDB(dsn)
+    if err != nil {
+        return nil, err
+    }
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    if err := db.PingContext(ctx); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("connect to postgres: %w", err)
+    }
+    repo, err := newSQLUserRepository(ctx, db, postgresDialect)
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("create postgres schema: %w", err)
+    }
+    if err := repo.openReplicas(ctx, replicaURLs(), openPostgresDB); err != nil {
+        repo.Close()
+        return nil, err
+    }
+    return repo, nil
+}
+
+func openPostgresDB(dsn string) (*sql.DB, error) {
+    return sql.Open("pgx", dsn)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "log/slog"
+    "net/http/pprof"
+
+    "github.com/gorilla/mux"
+)
+
+// With PPROF_ENABLED, the net/http/pprof profiles of the running process are
+// served under /debug/pprof/ to admins, or on METRICS_PORT, e.g.
+//
+//	curl -H "Authorization: Bearer $TOKEN" -o heap.pprof http://localhost:8080/debug/pprof/heap
+//	go tool pprof -http :8000 heap.pprof
+//
+// Profiles expose memory contents and command lines, so they are off by
+// default.
+
+// registerPprof mounts the profiles on r behind guard.
+func registerPprof(r *mux.Router, guard mux.MiddlewareFunc) {
+    if !config.PprofEnabled {
+        return
+    }
+    debug := r.PathPrefix("/debug/pprof").Subrouter()
+    debug.Use(guard)
+    debug.HandleFunc("/cmdline", pprof.Cmdline).Methods("GET")
+    debug.HandleFunc("/profile", pprof.Profile).Methods("GET")
+    debug.HandleFunc("/symbol", pprof.Symbol).Methods("GET", "POST")
+    debug.HandleFunc("/trace", pprof.Trace).Methods("GET")
+    // Index also serves the named profiles: heap, goroutine, allocs, etc.
+    debug.PathPrefix("/").HandlerFunc(pprof.Index).Methods("GET")
+    slog.Info("Serving pprof profiles", "path", "/debug/pprof/")
+}
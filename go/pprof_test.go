@@ -0,0 +1,73 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gorilla/mux"
+)
+
+func TestPprof(t *testing.T) {
+    defer func(old bool) { config.PprofEnabled = old }(config.PprofEnabled)
+    config.PprofEnabled = true
+    r := mux.NewRouter()
+    registerPprof(r, adminMiddleware)
+
+    serve := func(path string, roles ...string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, path, nil)
+        if roles != nil {
+            req = req.WithContext(context.WithValue(req.Context(), principalKey, Principal{Subject: "1", Roles: roles}))
+        }
+        w := httptest.NewRecorder()
+        r.ServeHTTP(w, req)
+        return w
+    }
+    if w := serve("/debug/pprof/heap"); w.Code != http.StatusUnauthorized {
+        t.Fatalf("anonymous: status %d", w.Code)
+    }
+    if w := serve("/debug/pprof/heap", roleUser); w.Code != http.StatusForbidden {
+        t.Fatalf("user: status %d", w.Code)
+    }
+    if w := serve("/debug/pprof/", roleAdmin); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine") {
+        t.Fatalf("admin index: status %d", w.Code)
+    }
+    if w := serve("/debug/pprof/goroutine?debug=1", roleAdmin); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine profile") {
+        t.Fatalf("admin goroutine profile: status %d", w.Code)
+    }
+}
+
+func TestExpvar(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    repo.Insert(context.Background(), User{Name: "Ann", Email: "ann@example.com"})
+    r := mux.NewRouter()
+    registerOpsRoutes(r, adminMiddleware)
+
+    req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, req)
+    if w.Code != http.StatusUnauthorized {
+        t.Fatalf("anonymous: status %d", w.Code)
+    }
+
+    req = req.WithContext(context.WithValue(req.Context(), principalKey, Principal{Subject: "1", Roles: []string{roleAdmin}}))
+    w = httptest.NewRecorder()
+    r.ServeHTTP(w, req)
+    var vars struct {
+        Memstats       struct{ HeapAlloc uint64 } `json:"memstats"`
+        UsersStored    int                        `json:"users_stored"`
+        StorageBackend string                     `json:"storage_backend"`
+        CacheRequests  map[string]float64         `json:"cache_requests"`
+    }
+    if err := json.NewDecoder(w.Body).Decode(&vars); err != nil {
+        t.Fatal(err)
+    }
+    if vars.Memstats.HeapAlloc == 0 || vars.UsersStored != 1 || vars.StorageBackend == "" || vars.CacheRequests == nil {
+        t.Fatalf("admin: %+v", vars)
+    }
+}
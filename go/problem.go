@@ -0,0 +1,65 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+)
+
+const problemMediaType = "application/problem+json"
+
+// Problem type URIs. Errors without a more specific type use about:blank,
+// whose title is the HTTP status text.
+const (
+    problemTypeBlank      = "about:blank"
+    problemTypeValidation = "urn:problem-type:user-api:validation-error"
+    problemTypeConstraint = "urn:problem-type:user-api:constraint-violation"
+)
+
+// Problem is an RFC 7807 problem details document. Errors is an extension
+// member listing the offending fields, when there are any; RequestID is one
+// quoting the request's X-Request-ID, to find it in the logs.
+type Problem struct {
+    Type      string       `json:"type"`
+    Title     string       `json:"title"`
+    Status    int          `json:"status"`
+    Detail    string       `json:"detail,omitempty"`
+    Instance  string       `json:"instance,omitempty"`
+    Errors    []FieldError `json:"errors,omitempty"`
+    RequestID string       `json:"request_id,omitempty"`
+}
+
+func newProblem(status int, detail string) Problem {
+    return Problem{
+        Type:   problemTypeBlank,
+        Title:  http.StatusText(status),
+        Status: status,
+        Detail: detail,
+    }
+}
+
+// writeProblem sends p as application/problem+json, or as JSON:API errors
+// when the client negotiated JSON:API.
+func writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+    if p.Instance == "" {
+        p.Instance = r.URL.RequestURI()
+    }
+    if p.RequestID == "" {
+        p.RequestID = requestIDFromContext(r.Context())
+    }
+    if wantsJSONAPI(r) {
+        message := p.Detail
+        if message == "" || len(p.Errors) > 0 {
+            message = p.Title
+        }
+        writeJSONAPI(w, p.Status, APIResponse{Status: "error", Message: message, Errors: p.Errors})
+        return
+    }
+
+    buf := getBuffer()
+    defer putBuffer(buf)
+    json.NewEncoder(buf).Encode(p)
+
+    w.Header().Set("Content-Type", problemMediaType)
+    w.WriteHeader(p.Status)
+    w.Write(buf.Bytes())
+}
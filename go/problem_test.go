@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gorilla/mux"
+)
+
+func TestWriteProblem(t *testing.T) {
+    r := mux.NewRouter()
+    r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+    r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+    r.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+        verr := &ValidationError{}
+        verr.add("email", "email is invalid")
+        writeError(w, r, verr)
+    }).Methods("POST")
+    r.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) {
+        writeError(w, r, errors.New("pq: password authentication failed"))
+    })
+    serve := func(method, target string) (*httptest.ResponseRecorder, Problem) {
+        req := httptest.NewRequest(method, target, nil)
+        req = req.WithContext(context.WithValue(req.Context(), requestIDKey, "req-1"))
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, req)
+        var p Problem
+        if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+            t.Fatalf("%s %s: %v: %s", method, target, err, rec.Body)
+        }
+        if rec.Header().Get("Content-Type") != problemMediaType {
+            t.Errorf("%s %s: Content-Type %q", method, target, rec.Header().Get("Content-Type"))
+        }
+        if p.Status != rec.Code || p.Instance != target || p.RequestID != "req-1" {
+            t.Errorf("%s %s: %d %+v", method, target, rec.Code, p)
+        }
+        return rec, p
+    }
+
+    if _, p := serve(http.MethodPost, "/users?dry_run=1"); p.Status != http.StatusUnprocessableEntity || p.Type != problemTypeValidation || len(p.Errors) != 1 || p.Errors[0].Field != "email" {
+        t.Errorf("validation: %+v", p)
+    }
+    if _, p := serve(http.MethodGet, "/nowhere"); p.Status != http.StatusNotFound || p.Type != problemTypeBlank || p.Title != "Not Found" || p.Detail != "No route for /nowhere" {
+        t.Errorf("no route: %+v", p)
+    }
+    if _, p := serve(http.MethodDelete, "/users"); p.Status != http.StatusMethodNotAllowed {
+        t.Errorf("method not allowed: %+v", p)
+    }
+    if rec, p := serve(http.MethodGet, "/boom"); p.Status != http.StatusInternalServerError || p.Detail != "" || p.Title != "Internal Server Error" {
+        t.Errorf("internal error: %+v %s", p, rec.Body)
+    }
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "os"
+    "sort"
+    "strings"
+)
+
+// APP_ENV picks a profile of defaults for the kind of deployment, so each
+// environment differs from the others only where it has to: development
+// logs debug text for a terminal, serves the pprof profiles and lets any
+// origin call the API, staging keeps production's JSON logs but serves the
+// profiles, and production is the built-in defaults spelt out. A setting
+// given in the environment, CONFIG_FILE or a secret file wins over the
+// profile. Without APP_ENV, the built-in defaults apply.
+var profiles = map[string]map[string]string{
+    "development": {
+        "LOG_FORMAT":           "text",
+        "LOG_LEVEL":            "debug",
+        "PPROF_ENABLED":        "true",
+        "CORS_ALLOWED_ORIGINS": "*",
+    },
+    "staging": {
+        "LOG_FORMAT":    "json",
+        "LOG_LEVEL":     "info",
+        "PPROF_ENABLED": "true",
+    },
+    "production": {
+        "LOG_FORMAT":    "json",
+        "LOG_LEVEL":     "info",
+        "PPROF_ENABLED": "false",
+    },
+}
+
+var profileAliases = map[string]string{"dev": "development", "stage": "staging", "prod": "production"}
+
+// profileName returns the profile APP_ENV names, "" for none.
+func profileName() string {
+    name := strings.ToLower(strings.TrimSpace(getenv("APP_ENV")))
+    if alias, ok := profileAliases[name]; ok {
+        return alias
+    }
+    return name
+}
+
+// profileDefault returns the default the APP_ENV profile gives key, for
+// lookupEnv.
+func profileDefault(key string) (string, bool) {
+    if key == "APP_ENV" {
+        return "", false
+    }
+    value, ok := profiles[profileName()][key]
+    return value, ok
+}
+
+// checkProfile fails on an APP_ENV that names no profile, which would
+// otherwise silently run with the built-in defaults, and logs the settings
+// the profile supplied.
+func checkProfile() error {
+    name := profileName()
+    if name == "" {
+        return nil
+    }
+    profile, ok := profiles[name]
+    if !ok {
+        names := make([]string, 0, len(profiles))
+        for n := range profiles {
+            names = append(names, n)
+        }
+        sort.Strings(names)
+        return fmt.Errorf("unknown APP_ENV %q (want one of %s)", os.Getenv("APP_ENV"), strings.Join(names, ", "))
+    }
+    var applied []string
+    for key := range profile {
+        if _, set := os.LookupEnv(key); !set {
+            if _, secret := configFile.secrets[key]; !secret {
+                applied = append(applied, key)
+            }
+        }
+    }
+    sort.Strings(applied)
+    slog.Info("Using APP_ENV profile", "profile", name, "defaults", applied)
+    return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/netip"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var proxyProtocolErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+    Name: "proxy_protocol_errors_total",
+    Help: "Total number of connections closed for an invalid PROXY protocol header",
+})
+
+func init() {
+    prometheus.MustRegister(proxyProtocolErrorsTotal)
+}
+
+// Behind a load balancer the connection comes from the balancer, so the
+// client address logged, traced and rate limited is taken from what it
+// passes along instead:
+//
+//   - with PROXY_PROTOCOL=true, the HAProxy PROXY protocol header (v1 or
+//     v2) at the start of each connection, as sent by HAProxy, AWS NLB or
+//     Traefik; connections without one are served as they are;
+//   - X-Forwarded-For, or X-Real-IP without it, on requests whose peer is
+//     in TRUSTED_PROXIES. The client is the last address in the chain that
+//     is not a trusted proxy itself, as anything before it could be forged.
+//
+// TRUSTED_PROXIES is a comma-separated list of CIDRs or addresses, e.g.
+// 10.0.0.0/8,fd00::/8. Headers are ignored without it, so clients cannot
+// pick their own address; PROXY protocol headers are accepted from anyone
+// then, as turning it on says the balancer is the only way in.
+
+// parsePrefixes parses value, a comma-separated list of CIDRs or
+// addresses. The invalid entries are skipped and returned as an error.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+    var prefixes []netip.Prefix
+    var errs []error
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item == "" {
+            continue
+        }
+        if prefix, err := parsePrefix(item); err == nil {
+            prefixes = append(prefixes, prefix)
+        } else {
+            errs = append(errs, err)
+        }
+    }
+    return prefixes, errors.Join(errs...)
+}
+
+// parsePrefix parses a CIDR, or an address as the prefix of that address
+// alone.
+func parsePrefix(item string) (netip.Prefix, error) {
+    if prefix, err := netip.ParsePrefix(item); err == nil {
+        return prefix.Masked(), nil
+    }
+    addr, err := netip.ParseAddr(item)
+    if err != nil {
+        return netip.Prefix{}, fmt.Errorf("%q is neither a CIDR nor an address", item)
+    }
+    return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// trustedProxy reports whether the host in addr, with or without a port, is
+// one of TRUSTED_PROXIES.
+func trustedProxy(addr string) bool {
+    ip, err := netip.ParseAddr(remoteHost(addr))
+    if err != nil {
+        return false
+    }
+    ip = ip.Unmap()
+    for _, prefix := range config.TrustedProxies {
+        if prefix.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// clientIPMiddleware replaces the RemoteAddr of requests from a trusted
+// proxy, or over a unix socket, with the client address it forwarded.
+func clientIPMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if viaUnixSocket(r) || len(config.TrustedProxies) > 0 && trustedProxy(r.RemoteAddr) {
+            if client := forwardedClient(r.Header); client != "" {
+                r.RemoteAddr = client
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func forwardedClient(h http.Header) string {
+    var chain []string
+    for _, value := range h.Values("X-Forwarded-For") {
+        for _, hop := range strings.Split(value, ",") {
+            chain = append(chain, strings.TrimSpace(hop))
+        }
+    }
+    if len(chain) == 0 {
+        if real := strings.TrimSpace(h.Get("X-Real-IP")); real != "" {
+            chain = []string{real}
+        }
+    }
+    for i := len(chain) - 1; i >= 0; i-- {
+        ip, err := netip.ParseAddr(remoteHost(chain[i]))
+        if err != nil {
+            return ""
+        }
+        if i == 0 || !trustedProxy(ip.String()) {
+            return ip.Unmap().String()
+        }
+    }
+    return ""
+}
+
+// proxyListener reads the PROXY protocol header of the connections it
+// accepts. It unwraps to the socket for restarts.
+type proxyListener struct {
+    net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+    c, err := l.Listener.Accept()
+    if err != nil {
+        return nil, err
+    }
+    return &proxyConn{Conn: c}, nil
+}
+
+func (l proxyListener) Unwrap() net.Listener {
+    return l.Listener
+}
+
+// proxyConn reads the header on first use rather than in Accept, so a slow
+// client does not hold up the others.
+type proxyConn struct {
+    net.Conn
+    once   sync.Once
+    r      *bufio.Reader
+    remote net.Addr
+    err    error
+}
+
+func (c *proxyConn) init() {
+    c.once.Do(func() {
+        c.r = bufio.NewReader(c.Conn)
+        c.remote = c.Conn.RemoteAddr()
+        if len(config.TrustedProxies) > 0 && !trustedProxy(c.remote.String()) {
+            return
+        }
+        c.Conn.SetReadDeadline(time.Now().Add(config.HTTPReadHeaderTimeout))
+        addr, err := readProxyHeader(c.r)
+        c.Conn.SetReadDeadline(time.Time{})
+        if err != nil {
+            proxyProtocolErrorsTotal.Inc()
+            c.err = fmt.Errorf("PROXY protocol header from %s: %w", c.remote, err)
+            return
+        }
+        if addr != nil {
+            c.remote = addr
+        }
+    })
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+    c.init()
+    if c.err != nil {
+        return 0, c.err
+    }
+    return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+    c.init()
+    return c.remote
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader consumes the PROXY protocol header at the start of r and
+// returns the client address in it, nil when there is no header or it
+// names no client, e.g. for the balancer's own health checks.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+    first, err := r.Peek(1)
+    if err != nil {
+        return nil, nil
+    }
+    switch first[0] {
+    case 'P':
+        if sig, _ := r.Peek(6); string(sig) == "PROXY " {
+            return readProxyV1(r)
+        }
+    case '\r':
+        if sig, _ := r.Peek(len(proxyV2Signature)); bytes.Equal(sig, proxyV2Signature) {
+            return readProxyV2(r)
+        }
+    }
+    return nil, nil
+}
+
+// readProxyV1 reads "PROXY TCP4 <src> <dst> <sport> <dport>\r\n", at most
+// 107 bytes.
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+    var line []byte
+    for len(line) < 107 {
+        b, err := r.ReadByte()
+        if err != nil {
+            return nil, err
+        }
+        line = append(line, b)
+        if b == '\n' {
+            break
+        }
+    }
+    if !bytes.HasSuffix(line, []byte("\r\n")) {
+        return nil, errors.New("v1 header too long")
+    }
+    fields := strings.Fields(string(line))
+    if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+        return nil, nil
+    }
+    if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+        return nil, fmt.Errorf("invalid v1 header %q", strings.TrimSpace(string(line)))
+    }
+    ip, err := netip.ParseAddr(fields[2])
+    if err != nil {
+        return nil, err
+    }
+    port, err := strconv.ParseUint(fields[4], 10, 16)
+    if err != nil {
+        return nil, err
+    }
+    return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 reads the binary header: the signature, version and command,
+// address family, length, then the addresses and TLVs, which are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+    header := make([]byte, 16)
+    if _, err := io.ReadFull(r, header); err != nil {
+        return nil, err
+    }
+    if header[12]>>4 != 2 {
+        return nil, fmt.Errorf("unsupported version %d", header[12]>>4)
+    }
+    body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+    if _, err := io.ReadFull(r, body); err != nil {
+        return nil, err
+    }
+    if header[12]&0x0f == 0 {
+        // LOCAL: the balancer talking for itself.
+        return nil, nil
+    }
+    var ip netip.Addr
+    var port []byte
+    switch header[13] >> 4 {
+    case 1:
+        if len(body) < 12 {
+            return nil, errors.New("short v2 IPv4 addresses")
+        }
+        ip, port = netip.AddrFrom4([4]byte(body[0:4])), body[8:10]
+    case 2:
+        if len(body) < 36 {
+            return nil, errors.New("short v2 IPv6 addresses")
+        }
+        ip, port = netip.AddrFrom16([16]byte(body[0:16])), body[32:34]
+    default:
+        return nil, nil
+    }
+    return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(port))), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/netip"
+    "strings"
+    "testing"
+)
+
+func TestClientIP(t *testing.T) {
+    defer func(old []netip.Prefix) { config.TrustedProxies = old }(config.TrustedProxies)
+    var err error
+    config.TrustedProxies, err = parsePrefixes("10.0.0.0/8, 192.168.1.1, bogus")
+    if len(config.TrustedProxies) != 2 || err == nil || !strings.Contains(err.Error(), "bogus") {
+        t.Fatalf("parsed %v, %v; want the 2 valid entries and bogus as an error", config.TrustedProxies, err)
+    }
+
+    var got string
+    handler := clientIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got = r.RemoteAddr
+    }))
+    for _, c := range []struct {
+        peer, xff, real, want string
+    }{
+        {"203.0.113.9:5000", "1.2.3.4", "", "203.0.113.9:5000"},
+        {"10.1.2.3:5000", "", "", "10.1.2.3:5000"},
+        {"10.1.2.3:5000", "1.2.3.4", "", "1.2.3.4"},
+        {"10.1.2.3:5000", "6.6.6.6, 1.2.3.4, 10.9.9.9", "", "1.2.3.4"},
+        {"192.168.1.1:80", "", "2001:db8::1", "2001:db8::1"},
+        {"10.1.2.3:5000", "10.2.2.2, 10.3.3.3", "", "10.2.2.2"},
+        {"10.1.2.3:5000", "not-an-ip", "", "10.1.2.3:5000"},
+    } {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.RemoteAddr = c.peer
+        if c.xff != "" {
+            req.Header.Set("X-Forwarded-For", c.xff)
+        }
+        if c.real != "" {
+            req.Header.Set("X-Real-IP", c.real)
+        }
+        handler.ServeHTTP(httptest.NewRecorder(), req)
+        if got != c.want {
+            t.Errorf("peer %s, X-Forwarded-For %q, X-Real-IP %q: client %s, want %s", c.peer, c.xff, c.real, got, c.want)
+        }
+    }
+}
+
+func TestProxyProtocolHeader(t *testing.T) {
+    v2 := func(command, family byte, addrs []byte) string {
+        header := append([]byte{}, proxyV2Signature...)
+        header = append(header, 0x20|command, family, 0, 0)
+        binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+        return string(append(header, addrs...))
+    }
+    ipv4 := []byte{198, 51, 100, 7, 10, 0, 0, 1, 0x30, 0x39, 0, 80}
+    for _, c := range []struct {
+        name, input, want, rest string
+    }{
+        {"v1", "PROXY TCP4 198.51.100.7 10.0.0.1 12345 80\r\nGET /", "198.51.100.7:12345", "GET /"},
+        {"v1 IPv6", "PROXY TCP6 2001:db8::7 2001:db8::1 12345 80\r\nGET /", "[2001:db8::7]:12345", "GET /"},
+        {"v1 unknown", "PROXY UNKNOWN\r\nGET /", "", "GET /"},
+        {"v2", v2(1, 0x11, ipv4) + "GET /", "198.51.100.7:12345", "GET /"},
+        {"v2 local", v2(0, 0, nil) + "GET /", "", "GET /"},
+        {"none", "POST /users HTTP/1.1\r\n", "", "POST /users"},
+    } {
+        r := bufio.NewReader(strings.NewReader(c.input))
+        addr, err := readProxyHeader(r)
+        if err != nil {
+            t.Errorf("%s: %v", c.name, err)
+            continue
+        }
+        got := ""
+        if addr != nil {
+            got = addr.String()
+        }
+        if got != c.want {
+            t.Errorf("%s: client %q, want %q", c.name, got, c.want)
+        }
+        rest := make([]byte, len(c.rest))
+        io.ReadFull(r, rest)
+        if string(rest) != c.rest {
+            t.Errorf("%s: left %q, want %q", c.name, rest, c.rest)
+        }
+    }
+    for _, input := range []string{"PROXY TCP4 nonsense\r\n", "PROXY " + strings.Repeat("x", 200)} {
+        if _, err := readProxyHeader(bufio.NewReader(strings.NewReader(input))); err == nil {
+            t.Errorf("%q accepted", input)
+        }
+    }
+}
+
+func TestProxyListener(t *testing.T) {
+    defer func(old []netip.Prefix) { config.TrustedProxies = old }(config.TrustedProxies)
+    config.TrustedProxies = nil
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := newHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        io.WriteString(w, r.RemoteAddr)
+    }))
+    go srv.Serve(proxyListener{ln})
+    defer srv.Close()
+
+    conn, err := net.Dial("tcp", ln.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    io.WriteString(conn, "PROXY TCP4 198.51.100.7 10.0.0.1 12345 80\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n")
+    resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "198.51.100.7:12345" {
+        t.Errorf("served %q", body)
+    }
+}
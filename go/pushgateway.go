@@ -0,0 +1,56 @@
+package main
+
+import (
+    "log/slog"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/push"
+)
+
+// With PUSHGATEWAY_URL set, one-shot commands such as "user-api seed" push
+// their metrics to that Prometheus Pushgateway when they finish, as a
+// scrape would miss a container that exits within seconds. The group is
+// job=<command>; along with everything the command registered, e.g. storage
+// pool stats, it gets:
+//
+//   - batch_job_duration_seconds and batch_job_exit_code of the last run;
+//   - batch_job_last_success_timestamp_seconds, kept by failed runs, so
+//     alerts can fire when a job has not succeeded for too long.
+
+// runJob runs a command and pushes its metrics.
+func runJob(name string, run func(args []string) int, args []string) int {
+    start := time.Now()
+    code := run(args)
+    if config.PushgatewayURL != "" {
+        if err := pushJobMetrics(config.PushgatewayURL, name, code, time.Since(start), prometheus.DefaultGatherer); err != nil {
+            slog.Warn("Failed to push metrics", "job", name, "url", redactConfigValue("PUSHGATEWAY_URL", config.PushgatewayURL), "error", err)
+        }
+    }
+    return code
+}
+
+func pushJobMetrics(url, job string, code int, elapsed time.Duration, gatherer prometheus.Gatherer) error {
+    duration := prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "batch_job_duration_seconds",
+        Help: "Duration of the last run of the job",
+    })
+    duration.Set(elapsed.Seconds())
+    exitCode := prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "batch_job_exit_code",
+        Help: "Exit code of the last run of the job, 0 on success",
+    })
+    exitCode.Set(float64(code))
+    pusher := push.New(url, job).Client(newHTTPClient(30 * time.Second)).Gatherer(gatherer).Collector(duration).Collector(exitCode)
+    if code == 0 {
+        lastSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+            Name: "batch_job_last_success_timestamp_seconds",
+            Help: "Time the job last succeeded, as a Unix timestamp",
+        })
+        lastSuccess.SetToCurrentTime()
+        pusher = pusher.Collector(lastSuccess)
+    }
+    // Add, unlike Push, leaves the metrics this run does not send, such as
+    // the last success time after a failure.
+    return pusher.Add()
+}
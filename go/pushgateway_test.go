@@ -0,0 +1,44 @@
+package main
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushJobMetrics(t *testing.T) {
+    var method, path, body string
+    gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        data, _ := io.ReadAll(r.Body)
+        method, path, body = r.Method, r.URL.Path, string(data)
+        w.WriteHeader(http.StatusAccepted)
+    }))
+    defer gateway.Close()
+
+    registry := prometheus.NewRegistry()
+    registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "seeded_total", Help: "Seeded"}))
+
+    if err := pushJobMetrics(gateway.URL, "seed", 0, 2*time.Second, registry); err != nil {
+        t.Fatal(err)
+    }
+    if method != http.MethodPost || path != "/metrics/job/seed" {
+        t.Fatalf("pushed with %s %s", method, path)
+    }
+    for _, name := range []string{"seeded_total", "batch_job_duration_seconds", "batch_job_exit_code", "batch_job_last_success_timestamp_seconds"} {
+        if !strings.Contains(body, name) {
+            t.Errorf("%s not pushed", name)
+        }
+    }
+
+    if err := pushJobMetrics(gateway.URL, "seed", 1, time.Second, registry); err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(body, "batch_job_last_success_timestamp_seconds") {
+        t.Error("a failed run pushed a last success time")
+    }
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// RATE_LIMIT caps each client at that many requests a second on average,
+// with bursts of up to RATE_LIMIT_BURST (default twice RATE_LIMIT, at least
+// 1); 0, the default, turns it off. Clients are told apart by address, as
+// clientIPMiddleware resolves it, so set TRUSTED_PROXIES behind a proxy.
+// Requests with an API key are limited by the key's own limit instead, and
+// the probes are never limited. Both settings change with a reload.
+//
+// Limited responses carry RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset, the seconds until the bucket is full again; a 429 adds
+// Retry-After. The limits are enforced per process, so with several
+// replicas a client gets up to that many from each.
+var rateLimitPolicy atomic.Pointer[ratePolicy]
+
+// ratePolicy is a refill rate, in tokens a second, and a bucket size.
+type ratePolicy struct {
+    rate  float64
+    burst int
+}
+
+var rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Name: "http_requests_rate_limited_total",
+    Help: "Requests answered 429 by the rate limiter, by what they were limited by (ip or api_key)",
+}, []string{"by"})
+
+func init() {
+    prometheus.MustRegister(rateLimitedTotal)
+    reloadableSetting(loadRateLimitPolicy, "RATE_LIMIT", "RATE_LIMIT_BURST")
+}
+
+func loadRateLimitPolicy() error {
+    var env envReader
+    rate := 0.0
+    if value := getenv("RATE_LIMIT"); value != "" {
+        n, err := strconv.ParseFloat(value, 64)
+        if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+            env.invalid("RATE_LIMIT", value, "not a number of requests a second")
+        } else {
+            rate = n
+        }
+    }
+    burst := env.intAtLeast("RATE_LIMIT_BURST", max(1, int(math.Ceil(2*rate))), 1)
+    if err := env.err(); err != nil {
+        return err
+    }
+    if rate == 0 {
+        rateLimitPolicy.Store(nil)
+        return nil
+    }
+    rateLimitPolicy.Store(&ratePolicy{rate: rate, burst: burst})
+    return nil
+}
+
+// rateLimiter is a set of token buckets by key. Each bucket holds up to
+// burst tokens, refilled at rate a second, and a request takes one.
+type rateLimiter struct {
+    mu      sync.Mutex
+    buckets map[string]*tokenBucket
+    // swept is when full buckets were last dropped.
+    swept time.Time
+}
+
+type tokenBucket struct {
+    tokens float64
+    last   time.Time
+    // full is when the bucket will be full again.
+    full time.Time
+}
+
+// rateLimitResult is the outcome of taking a token.
+type rateLimitResult struct {
+    allowed   bool
+    remaining int
+    // retryAfter is how long until the next token, when there was none.
+    retryAfter time.Duration
+    // reset is how long until the bucket is full again.
+    reset time.Duration
+}
+
+func newRateLimiter() *rateLimiter {
+    return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+var rateLimits = newRateLimiter()
+
+// allow takes a token from the bucket of key.
+func (l *rateLimiter) allow(key string, p ratePolicy, now time.Time) rateLimitResult {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.sweep(now)
+
+    b := l.buckets[key]
+    if b == nil {
+        b = &tokenBucket{tokens: float64(p.burst), last: now}
+        l.buckets[key] = b
+    }
+    b.tokens = math.Min(float64(p.burst), b.tokens+now.Sub(b.last).Seconds()*p.rate)
+    b.last = now
+    result := rateLimitResult{allowed: b.tokens >= 1}
+    if result.allowed {
+        b.tokens--
+        result.remaining = int(b.tokens)
+    } else {
+        result.retryAfter = seconds((1 - b.tokens) / p.rate)
+    }
+    result.reset = seconds((float64(p.burst) - b.tokens) / p.rate)
+    b.full = now.Add(result.reset)
+    return result
+}
+
+func seconds(s float64) time.Duration {
+    return time.Duration(s * float64(time.Second))
+}
+
+// sweep drops the buckets that are full again, and so no different from a
+// new one, at most once a minute.
+func (l *rateLimiter) sweep(now time.Time) {
+    if now.Sub(l.swept) < time.Minute {
+        return
+    }
+    l.swept = now
+    for key, b := range l.buckets {
+        if now.After(b.full) {
+            delete(l.buckets, key)
+        }
+    }
+}
+
+// rateLimitMiddleware enforces the limit of the API key the caller
+// authenticated with, or else RATE_LIMIT by client address.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var key, by string
+        var policy ratePolicy
+        if p, _ := principalFromContext(r.Context()); p.apiKey != nil {
+            if p.apiKey.RateLimit == 0 {
+                next.ServeHTTP(w, r)
+                return
+            }
+            key, by = "api_key:"+p.apiKey.ID, "api_key"
+            policy = ratePolicy{rate: float64(p.apiKey.RateLimit) / 60, burst: p.apiKey.RateLimit}
+        } else if p := rateLimitPolicy.Load(); p != nil && !probeRoutes[routeTemplate(r)] {
+            key, by = "ip:"+remoteHost(r.RemoteAddr), "ip"
+            policy = *p
+        } else {
+            next.ServeHTTP(w, r)
+            return
+        }
+        result := rateLimits.allow(key, policy, time.Now())
+        h := w.Header()
+        h.Set("RateLimit-Limit", strconv.Itoa(policy.burst))
+        h.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+        h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.reset.Seconds()))))
+        if !result.allowed {
+            rateLimitedTotal.WithLabelValues(by).Inc()
+            writeRateLimited(w, r, result.retryAfter)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// writeRateLimited answers 429 with a Retry-After of whole seconds.
+func writeRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+    w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+    writeError(w, r, httpError(http.StatusTooManyRequests, "Rate limit exceeded, retry later"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+func TestRateLimiter(t *testing.T) {
+    l := newRateLimiter()
+    now := time.Now()
+    policy := ratePolicy{rate: 0.05, burst: 3}
+    for i := 0; i < 3; i++ {
+        if result := l.allow("k", policy, now); !result.allowed || result.remaining != 2-i {
+            t.Fatalf("request %d: %+v", i, result)
+        }
+    }
+    result := l.allow("k", policy, now)
+    if result.allowed || result.retryAfter != 20*time.Second || result.reset != time.Minute {
+        t.Fatalf("over the limit: %+v", result)
+    }
+    if result := l.allow("other", policy, now); !result.allowed {
+        t.Fatal("another key was limited")
+    }
+    if result := l.allow("k", policy, now.Add(20*time.Second)); !result.allowed {
+        t.Fatal("not allowed once a token was refilled")
+    }
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+    t.Setenv("RATE_LIMIT", "1")
+    t.Setenv("RATE_LIMIT_BURST", "2")
+    loadRateLimitPolicy()
+    defer loadRateLimitPolicy()
+    defer func(l *rateLimiter) { rateLimits = l }(rateLimits)
+    rateLimits = newRateLimiter()
+
+    r := mux.NewRouter()
+    r.Use(rateLimitMiddleware)
+    r.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {})
+    r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+    call := func(path, addr string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, path, nil)
+        req.RemoteAddr = addr
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, req)
+        return rec
+    }
+    for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+        rec := call("/users", "192.0.2.1:1234")
+        if rec.Code != want {
+            t.Fatalf("request %d: %d, want %d", i, rec.Code, want)
+        }
+        if rec.Header().Get("RateLimit-Limit") != "2" {
+            t.Errorf("request %d: RateLimit-Limit %q", i, rec.Header().Get("RateLimit-Limit"))
+        }
+    }
+    if rec := call("/users", "192.0.2.1:5678"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+        t.Errorf("same client, another port: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+    }
+    if rec := call("/users", "192.0.2.2:1234"); rec.Code != http.StatusOK {
+        t.Errorf("another client: %d", rec.Code)
+    }
+    if rec := call("/healthz", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+        t.Errorf("probe: %d", rec.Code)
+    }
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "runtime/debug"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var httpPanicsTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "http_panics_total",
+        Help: "Total number of panics recovered from while serving HTTP requests by route",
+    },
+    []string{"method", "endpoint"},
+)
+
+func init() {
+    prometheus.MustRegister(httpPanicsTotal)
+}
+
+// recoveryMiddleware turns a panic in a handler into a 500 problem response
+// and an error log with the stack, instead of net/http dropping the
+// connection with only a line on stderr. It runs inside the logging and
+// metrics middleware, so the request is still logged and counted as a 500.
+// Panics with http.ErrAbortHandler, which handlers use to abort a response
+// on purpose, are passed on.
+func recoveryMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        rec := getStatusRecorder(w)
+        defer putStatusRecorder(rec)
+        defer func() {
+            v := recover()
+            if v == nil {
+                return
+            }
+            if v == http.ErrAbortHandler {
+                panic(v)
+            }
+            endpoint := routeTemplate(r)
+            httpPanicsTotal.WithLabelValues(r.Method, endpoint).Inc()
+            stack := debug.Stack()
+            slog.ErrorContext(r.Context(), "Panic serving request",
+                "panic", fmt.Sprint(v), "method", r.Method, "path", r.URL.Path, "route", endpoint, "stack", string(stack))
+            notePanic(r, v, stack)
+            if rec.wroteHeader {
+                // Too late for a problem document; cut the response short so
+                // the client does not take it for a complete one.
+                panic(http.ErrAbortHandler)
+            }
+            writeProblem(rec, r, newProblem(http.StatusInternalServerError, ""))
+        }()
+        next.ServeHTTP(rec, r)
+    })
+}
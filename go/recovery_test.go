@@ -0,0 +1,58 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gorilla/mux"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecovery(t *testing.T) {
+    var logged bytes.Buffer
+    defer func(old *slog.Logger) { slog.SetDefault(old) }(slog.Default())
+    slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(&logged, nil)}))
+
+    r := mux.NewRouter()
+    r.Use(recoveryMiddleware)
+    r.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) {
+        var m map[string]int
+        m["boom"]++
+    })
+    r.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) {
+        panic(http.ErrAbortHandler)
+    })
+    handler := requestIDMiddleware(r)
+    counter := httpPanicsTotal.WithLabelValues("GET", "/boom")
+    before := testutil.ToFloat64(counter)
+
+    w := httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+    req.Header.Set(requestIDHeader, "panic-1")
+    handler.ServeHTTP(w, req)
+    var p Problem
+    json.Unmarshal(w.Body.Bytes(), &p)
+    if w.Code != http.StatusInternalServerError || p.Status != http.StatusInternalServerError || p.RequestID != "panic-1" {
+        t.Fatalf("status %d, problem %+v", w.Code, p)
+    }
+    if testutil.ToFloat64(counter) != before+1 {
+        t.Error("panic not counted")
+    }
+    var entry map[string]interface{}
+    json.Unmarshal(logged.Bytes(), &entry)
+    if stack, _ := entry["stack"].(string); entry["request_id"] != "panic-1" || !strings.Contains(stack, "recovery_test.go") {
+        t.Errorf("log entry without the request ID or stack: %v", entry)
+    }
+
+    defer func() {
+        if v := recover(); v != http.ErrAbortHandler {
+            t.Fatalf("ErrAbortHandler not passed on: %v", v)
+        }
+    }()
+    handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "strconv"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/redis/go-redis/v9"
+)
+
+var redisCacheRequestsTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "redis_cache_requests_total",
+        Help: "Total number of user lookups served through the Redis cache by result",
+    },
+    []string{"result"},
+)
+
+func init() {
+    prometheus.MustRegister(redisCacheRequestsTotal)
+}
+
+// REDIS_CACHE_TTL (default 1m) bounds how stale a cached user can get when a
+// write bypasses this process, e.g. from another replica sharing the
+// database.
+
+// redisCachedRepository is a read-through cache in front of another
+// repository: Get is served from Redis when possible and every write drops
+// the affected key. Redis errors are logged and fall through to the backend,
+// so an unavailable cache only costs latency. Give Redis a maxmemory with an
+// LRU policy to see how the cache size trades off against backend load.
+type redisCachedRepository struct {
+    UserRepository
+    client *redis.Client
+    ttl    time.Duration
+}
+
+// openRedisCachedRepository wraps repo with a cache at the Redis URL, e.g.
+// "redis://redis:6379/0", and fails fast if Redis is unreachable.
+func openRedisCachedRepository(ctx context.Context, repo UserRepository, url string) (UserRepository, error) {
+    opts, err := redis.ParseURL(url)
+    if err != nil {
+        return nil, fmt.Errorf("parse REDIS_URL: %w", err)
+    }
+    client := redis.NewClient(opts)
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    if err := client.Ping(ctx).Err(); err != nil {
+        client.Close()
+        return nil, fmt.Errorf("connect to redis: %w", err)
+    }
+    return &redisCachedRepository{UserRepository: repo, client: client, ttl: config.RedisCacheTTL}, nil
+}
+
+func userCacheKey(id int) string {
+    return "user-api:user:" + strconv.Itoa(id)
+}
+
+// Unwrap returns the repository behind the cache.
+func (c *redisCachedRepository) Unwrap() UserRepository {
+    return c.UserRepository
+}
+
+func (c *redisCachedRepository) Get(ctx context.Context, id int) (User, error) {
+    key := userCacheKey(id)
+    data, err := c.client.Get(ctx, key).Bytes()
+    switch {
+    case err == nil:
+        if user, err := decodeStoredUser(data); err == nil {
+            redisCacheRequestsTotal.WithLabelValues("hit").Inc()
+            return user, nil
+        }
+        redisCacheRequestsTotal.WithLabelValues("error").Inc()
+    case errors.Is(err, redis.Nil):
+        redisCacheRequestsTotal.WithLabelValues("miss").Inc()
+    default:
+        redisCacheRequestsTotal.WithLabelValues("error").Inc()
+        slog.Warn("Redis cache read failed", "key", key, "error", err)
+    }
+
+    user, err := c.UserRepository.Get(ctx, id)
+    if err != nil {
+        return User{}, err
+    }
+    data, _ = encodeStoredUser(user)
+    if err := c.client.Set(ctx, key, data, c.ttl).Err(); err != nil {
+        slog.Warn("Redis cache write failed", "key", key, "error", err)
+    }
+    return user, nil
+}
+
+func (c *redisCachedRepository) Update(ctx context.Context, user User) (User, error) {
+    updated, err := c.UserRepository.Update(ctx, user)
+    c.invalidate(ctx, user.ID)
+    return updated, err
+}
+
+func (c *redisCachedRepository) Delete(ctx context.Context, id int) error {
+    err := c.UserRepository.Delete(ctx, id)
+    c.invalidate(ctx, id)
+    return err
+}
+
+// WithTx runs fn against the backend's transaction directly, so reads see
+// uncommitted writes and nothing uncommitted is cached, then invalidates the
+// users fn wrote once the transaction has finished.
+func (c *redisCachedRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    var written []int
+    err := c.UserRepository.WithTx(ctx, func(tx UserRepository) error {
+        return fn(&redisTxRepository{UserRepository: tx, written: &written})
+    })
+    for _, id := range written {
+        c.invalidate(ctx, id)
+    }
+    return err
+}
+
+func (c *redisCachedRepository) Close() error {
+    c.client.Close()
+    return c.UserRepository.Close()
+}
+
+// invalidate drops the cached user. It runs even when the write failed, as
+// the backend may have applied it before reporting the error.
+func (c *redisCachedRepository) invalidate(ctx context.Context, id int) {
+    if err := c.client.Del(ctx, userCacheKey(id)).Err(); err != nil {
+        slog.Warn("Redis cache invalidation failed", "user_id", id, "error", err)
+    }
+}
+
+// redisTxRepository records the users written inside a transaction.
+type redisTxRepository struct {
+    UserRepository
+    written *[]int
+}
+
+func (c *redisTxRepository) Update(ctx context.Context, user User) (User, error) {
+    *c.written = append(*c.written, user.ID)
+    return c.UserRepository.Update(ctx, user)
+}
+
+func (c *redisTxRepository) Delete(ctx context.Context, id int) error {
+    *c.written = append(*c.written, id)
+    return c.UserRepository.Delete(ctx, id)
+}
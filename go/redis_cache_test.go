@@ -0,0 +1,75 @@
+package main
+
+import (
+    "context"
+    "os"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// The Redis cache tests run against the server in REDIS_TEST_URL and are
+// skipped without it, e.g.
+//
+//	docker run -d --name redis-test -p 6379:6379 redis:7
+//	REDIS_TEST_URL=redis://localhost:6379/15 go test -run Redis
+//
+// Each test flushes the database first, so point it at a throwaway one.
+
+func openRedisTestRepository(t *testing.T) *redisCachedRepository {
+    t.Helper()
+    url := os.Getenv("REDIS_TEST_URL")
+    if url == "" {
+        t.Skip("REDIS_TEST_URL is not set")
+    }
+    ctx := context.Background()
+    repo, err := openRedisCachedRepository(ctx, newMemoryUserRepository(), url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    c := repo.(*redisCachedRepository)
+    t.Cleanup(func() { c.Close() })
+    if err := c.client.FlushDB(ctx).Err(); err != nil {
+        t.Fatal(err)
+    }
+    return c
+}
+
+func TestRedisCacheReadThrough(t *testing.T) {
+    repo := openRedisTestRepository(t)
+    ctx := context.Background()
+    hits := redisCacheRequestsTotal.WithLabelValues("hit")
+    misses := redisCacheRequestsTotal.WithLabelValues("miss")
+
+    user, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@example.com", PasswordHash: "hash"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    before := testutil.ToFloat64(misses)
+    if _, err := repo.Get(ctx, user.ID); err != nil {
+        t.Fatal(err)
+    }
+    if testutil.ToFloat64(misses) != before+1 {
+        t.Errorf("first Get was not a miss")
+    }
+
+    before = testutil.ToFloat64(hits)
+    got, err := repo.Get(ctx, user.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if testutil.ToFloat64(hits) != before+1 {
+        t.Errorf("second Get was not a hit")
+    }
+    if got.PasswordHash != "hash" || got.Email != user.Email {
+        t.Errorf("cached user = %+v, want %+v", got, user)
+    }
+
+    got.Name = "Ada Lovelace"
+    if _, err := repo.Update(ctx, got); err != nil {
+        t.Fatal(err)
+    }
+    if got, err = repo.Get(ctx, user.ID); err != nil || got.Name != "Ada Lovelace" {
+        t.Errorf("Get after Update = %+v, %v, want the new name", got, err)
+    }
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+// Keys of the Redis backend. They differ from the cache's user-api:user:
+// keys, so the two can share a database. redisCommitScript spells them out
+// too, along with user-api:users:email-of, a hash of ID to lowercased email.
+const (
+    redisUsersPrefix   = "user-api:users:"
+    redisUsersNextID   = redisUsersPrefix + "next-id"
+    redisUsersIDs      = redisUsersPrefix + "ids"    // sorted set of IDs
+    redisUsersEmailKey = redisUsersPrefix + "email:" // + lowercased email, holds the ID
+)
+
+// redisUserRepository stores users in Redis, each as a JSON string under
+// user-api:users:<id>, with an index from lowercased email to ID. Writes are
+// buffered by a redisStoreTx and applied by one script, so they are atomic
+// and email uniqueness holds across replicas. Reads are not isolated from
+// other writers, as in a read-committed database.
+type redisUserRepository struct {
+    client *redis.Client
+}
+
+// redisCommitScript applies a batch of writes: each op carries a user ID,
+// the user's JSON and lowercased email (absent for a delete), whether the
+// user must already exist and, for updates, the version it must still have.
+// Nothing is written if an email is taken, a user is missing or a version
+// moved on.
+var redisCommitScript = redis.NewScript(`
+local p = ARGV[1]
+local ops = cjson.decode(ARGV[2])
+local touched, claimed = {}, {}
+for _, op in ipairs(ops) do
+    touched[op.id] = true
+end
+for _, op in ipairs(ops) do
+    if op.must_exist then
+        local cur = redis.call('GET', p .. op.id)
+        if not cur then
+            return redis.error_reply('NOTFOUND')
+        end
+        if op.version and (cjson.decode(cur).version or 1) ~= op.version then
+            return redis.error_reply('CONFLICT')
+        end
+    end
+    if op.email then
+        if claimed[op.email] then
+            return redis.error_reply('EMAILTAKEN')
+        end
+        claimed[op.email] = true
+        local owner = redis.call('GET', p .. 'email:' .. op.email)
+        if owner and not touched[tonumber(owner)] then
+            return redis.error_reply('EMAILTAKEN')
+        end
+    end
+end
+for _, op in ipairs(ops) do
+    local old = redis.call('HGET', p .. 'email-of', op.id)
+    if old and redis.call('GET', p .. 'email:' .. old) == tostring(op.id) then
+        redis.call('DEL', p .. 'email:' .. old)
+    end
+end
+for _, op in ipairs(ops) do
+    if op.user then
+        redis.call('SET', p .. op.id, op.user)
+        redis.call('SET', p .. 'email:' .. op.email, op.id)
+        redis.call('HSET', p .. 'email-of', op.id, op.email)
+        redis.call('ZADD', p .. 'ids', op.id, op.id)
+    else
+        redis.call('DEL', p .. op.id)
+        redis.call('HDEL', p .. 'email-of', op.id)
+        redis.call('ZREM', p .. 'ids', op.id)
+    end
+end
+return #ops
+`)
+
+// openRedisUserRepository connects to the Redis URL, e.g.
+// "redis://redis:6379/0". Give the server persistence (AOF or RDB), or the
+// users are lost with it.
+func openRedisUserRepository(ctx context.Context, url string) (UserRepository, error) {
+    if url == "" {
+        return nil, errors.New("REDIS_URL is required for the redis backend")
+    }
+    opts, err := redis.ParseURL(url)
+    if err != nil {
+        return nil, fmt.Errorf("parse REDIS_URL: %w", err)
+    }
+    client := redis.NewClient(opts)
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    if err := client.Ping(ctx).Err(); err != nil {
+        client.Close()
+        return nil, fmt.Errorf("connect to redis: %w", err)
+    }
+    return &redisUserRepository{client: client}, nil
+}
+
+func (s *redisUserRepository) List(ctx context.Context) ([]User, error) {
+    ids, err := s.client.ZRange(ctx, redisUsersIDs, 0, -1).Result()
+    if err != nil || len(ids) == 0 {
+        return nil, err
+    }
+    keys := make([]string, len(ids))
+    for i, id := range ids {
+        keys[i] = redisUsersPrefix + id
+    }
+    values, err := s.client.MGet(ctx, keys...).Result()
+    if err != nil {
+        return nil, err
+    }
+    users := make([]User, 0, len(values))
+    for _, v := range values {
+        // A user deleted between the two calls reads as nil.
+        data, ok := v.(string)
+        if !ok {
+            continue
+        }
+        user, err := decodeStoredUser([]byte(data))
+        if err != nil {
+            return nil, err
+        }
+        users = append(users, user)
+    }
+    return users, nil
+}
+
+func (s *redisUserRepository) Get(ctx context.Context, id int) (User, error) {
+    data, err := s.client.Get(ctx, redisUsersPrefix+strconv.Itoa(id)).Bytes()
+    if errors.Is(err, redis.Nil) {
+        return User{}, ErrNotFound
+    }
+    if err != nil {
+        return User{}, err
+    }
+    return decodeStoredUser(data)
+}
+
+func (s *redisUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+    id, err := s.client.Get(ctx, redisUsersEmailKey+strings.ToLower(email)).Int()
+    if errors.Is(err, redis.Nil) {
+        return User{}, ErrNotFound
+    }
+    if err != nil {
+        return User{}, err
+    }
+    return s.Get(ctx, id)
+}
+
+func (s *redisUserRepository) Insert(ctx context.Context, user User) (inserted User, err error) {
+    err = s.WithTx(ctx, func(tx UserRepository) error {
+        inserted, err = tx.Insert(ctx, user)
+        return err
+    })
+    return inserted, err
+}
+
+func (s *redisUserRepository) Update(ctx context.Context, user User) (updated User, err error) {
+    err = s.WithTx(ctx, func(tx UserRepository) error {
+        updated, err = tx.Update(ctx, user)
+        return err
+    })
+    return updated, err
+}
+
+func (s *redisUserRepository) rewriteEmail(ctx context.Context, id int, from, to string) (rewritten bool, err error) {
+    err = s.WithTx(ctx, func(repo UserRepository) error {
+        tx := repo.(*redisStoreTx)
+        user, err := tx.Get(ctx, id)
+        if errors.Is(err, ErrNotFound) {
+            return nil
+        }
+        if err != nil || user.Email != from {
+            return err
+        }
+        if err := tx.checkEmail(ctx, to, id); err != nil {
+            return err
+        }
+        // The version is checked on commit but not bumped.
+        tx.versions = map[int]int{id: user.Version}
+        user.Email = to
+        tx.write(id, &user)
+        rewritten = true
+        return nil
+    })
+    return rewritten && err == nil, err
+}
+
+func (s *redisUserRepository) Delete(ctx context.Context, id int) error {
+    return s.WithTx(ctx, func(tx UserRepository) error {
+        return tx.Delete(ctx, id)
+    })
+}
+
+// Stats loads every user and aggregates them like the memory backend.
+func (s *redisUserRepository) Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error) {
+    users, err := s.List(ctx)
+    if err != nil {
+        return UserStats{}, err
+    }
+    return (&memoryUserRepository{users: users}).Stats(ctx, days, top, now)
+}
+
+// WithTx buffers the writes fn makes and applies them in one script if fn
+// returns nil. IDs taken by a rolled back insert are not reused.
+func (s *redisUserRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    tx := &redisStoreTx{redisUserRepository: s, writes: make(map[int]*User)}
+    if err := fn(tx); err != nil {
+        return err
+    }
+    return tx.commit(ctx)
+}
+
+func (s *redisUserRepository) Ping(ctx context.Context) error {
+    return s.client.Ping(ctx).Err()
+}
+
+func (s *redisUserRepository) Close() error {
+    return s.client.Close()
+}
+
+// redisStoreTx is a transaction of the Redis backend. Reads see its own
+// buffered writes on top of what is stored.
+type redisStoreTx struct {
+    *redisUserRepository
+    // writes holds the new state of each written user, nil once deleted.
+    writes map[int]*User
+    // inserted marks users created in this transaction, which need not exist
+    // in Redis when it commits.
+    inserted map[int]bool
+    // versions holds the stored version of each updated user, which it must
+    // still have when the transaction commits.
+    versions map[int]int
+    order    []int
+}
+
+func (tx *redisStoreTx) List(ctx context.Context) ([]User, error) {
+    stored, err := tx.redisUserRepository.List(ctx)
+    if err != nil {
+        return nil, err
+    }
+    users := stored[:0]
+    for _, user := range stored {
+        if w, ok := tx.writes[user.ID]; !ok {
+            users = append(users, user)
+        } else if w != nil {
+            users = append(users, *w)
+        }
+    }
+    for _, id := range tx.order {
+        if tx.inserted[id] && tx.writes[id] != nil {
+            users = append(users, *tx.writes[id])
+        }
+    }
+    return users, nil
+}
+
+func (tx *redisStoreTx) Get(ctx context.Context, id int) (User, error) {
+    if w, ok := tx.writes[id]; ok {
+        if w == nil {
+            return User{}, ErrNotFound
+        }
+        return *w, nil
+    }
+    return tx.redisUserRepository.Get(ctx, id)
+}
+
+func (tx *redisStoreTx) GetByEmail(ctx context.Context, email string) (User, error) {
+    for _, id := range tx.order {
+        if w := tx.writes[id]; w != nil && strings.EqualFold(w.Email, email) {
+            return *w, nil
+        }
+    }
+    user, err := tx.redisUserRepository.GetByEmail(ctx, email)
+    if err != nil {
+        return User{}, err
+    }
+    if _, ok := tx.writes[user.ID]; ok {
+        // Deleted, or renamed to another email, in this transaction.
+        return User{}, ErrNotFound
+    }
+    return user, nil
+}
+
+// Insert fails with a unique ConstraintError if the email is already taken.
+func (tx *redisStoreTx) Insert(ctx context.Context, user User) (User, error) {
+    if err := tx.checkEmail(ctx, user.Email, 0); err != nil {
+        return User{}, err
+    }
+    id, err := tx.client.Incr(ctx, redisUsersNextID).Result()
+    if err != nil {
+        return User{}, err
+    }
+    user.ID = int(id)
+    user.CreatedAt = time.Now()
+    user.Verified = false
+    user.Version = 1
+    if len(user.Roles) == 0 {
+        user.Roles = []string{roleUser}
+    }
+    if tx.inserted == nil {
+        tx.inserted = make(map[int]bool)
+    }
+    tx.inserted[user.ID] = true
+    tx.write(user.ID, &user)
+    return user, nil
+}
+
+func (tx *redisStoreTx) Update(ctx context.Context, user User) (User, error) {
+    current, err := tx.Get(ctx, user.ID)
+    if err != nil {
+        return User{}, err
+    }
+    if current.Version != user.Version {
+        return User{}, ErrVersionConflict
+    }
+    if err := tx.checkEmail(ctx, user.Email, user.ID); err != nil {
+        return User{}, err
+    }
+    if _, ok := tx.writes[user.ID]; !ok {
+        if tx.versions == nil {
+            tx.versions = make(map[int]int)
+        }
+        tx.versions[user.ID] = current.Version
+    }
+    user.Version++
+    tx.write(user.ID, &user)
+    return user, nil
+}
+
+func (tx *redisStoreTx) Delete(ctx context.Context, id int) error {
+    if _, err := tx.Get(ctx, id); err != nil {
+        return err
+    }
+    tx.write(id, nil)
+    return nil
+}
+
+func (tx *redisStoreTx) Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error) {
+    users, err := tx.List(ctx)
+    if err != nil {
+        return UserStats{}, err
+    }
+    return (&memoryUserRepository{users: users}).Stats(ctx, days, top, now)
+}
+
+// WithTx joins the outer transaction.
+func (tx *redisStoreTx) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    return fn(tx)
+}
+
+// checkEmail fails if email belongs to a user other than id. The commit
+// script checks again, as another writer may claim it in the meantime.
+func (tx *redisStoreTx) checkEmail(ctx context.Context, email string, id int) error {
+    owner, err := tx.GetByEmail(ctx, email)
+    if errors.Is(err, ErrNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if owner.ID != id {
+        return errEmailTaken
+    }
+    return nil
+}
+
+func (tx *redisStoreTx) write(id int, user *User) {
+    if _, ok := tx.writes[id]; !ok {
+        tx.order = append(tx.order, id)
+    }
+    tx.writes[id] = user
+}
+
+type redisCommitOp struct {
+    ID        int    `json:"id"`
+    User      string `json:"user,omitempty"`
+    Email     string `json:"email,omitempty"`
+    MustExist bool   `json:"must_exist"`
+    Version   *int   `json:"version,omitempty"`
+}
+
+func (tx *redisStoreTx) commit(ctx context.Context) error {
+    if len(tx.order) == 0 {
+        return nil
+    }
+    ops := make([]redisCommitOp, 0, len(tx.order))
+    for _, id := range tx.order {
+        op := redisCommitOp{ID: id, MustExist: !tx.inserted[id]}
+        if version, ok := tx.versions[id]; ok {
+            op.Version = &version
+        }
+        if user := tx.writes[id]; user != nil {
+            data, err := encodeStoredUser(*user)
+            if err != nil {
+                return err
+            }
+            op.User, op.Email = string(data), strings.ToLower(user.Email)
+        } else if tx.inserted[id] {
+            continue
+        }
+        ops = append(ops, op)
+    }
+    batch, err := json.Marshal(ops)
+    if err != nil {
+        return err
+    }
+    err = redisCommitScript.Run(ctx, tx.client, nil, redisUsersPrefix, batch).Err()
+    switch {
+    case err == nil:
+        return nil
+    case strings.HasPrefix(err.Error(), "EMAILTAKEN"):
+        return errEmailTaken
+    case strings.HasPrefix(err.Error(), "NOTFOUND"):
+        return ErrNotFound
+    case strings.HasPrefix(err.Error(), "CONFLICT"):
+        return ErrVersionConflict
+    default:
+        return err
+    }
+}
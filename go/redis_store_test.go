@@ -0,0 +1,53 @@
+package main
+
+import (
+    "context"
+    "os"
+    "testing"
+)
+
+// The Redis backend tests use REDIS_TEST_URL like the cache tests in
+// redis_cache_test.go, and flush the database first too.
+
+func openRedisStoreTestRepository(t *testing.T) *redisUserRepository {
+    t.Helper()
+    url := os.Getenv("REDIS_TEST_URL")
+    if url == "" {
+        t.Skip("REDIS_TEST_URL is not set")
+    }
+    ctx := context.Background()
+    repo, err := openRedisUserRepository(ctx, url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := repo.(*redisUserRepository)
+    t.Cleanup(func() { s.Close() })
+    if err := s.client.FlushDB(ctx).Err(); err != nil {
+        t.Fatal(err)
+    }
+    return s
+}
+
+func TestRedisStoreUserLifecycle(t *testing.T) {
+    testUserLifecycle(t, openRedisStoreTestRepository(t))
+}
+
+func TestRedisStoreEmailTaken(t *testing.T) {
+    testEmailTaken(t, openRedisStoreTestRepository(t))
+}
+
+func TestRedisStoreEmailEncryption(t *testing.T) {
+    testEmailEncryption(t, openRedisStoreTestRepository(t))
+}
+
+func TestRedisStoreUserStats(t *testing.T) {
+    testUserStats(t, openRedisStoreTestRepository(t))
+}
+
+func TestRedisStoreWithTx(t *testing.T) {
+    testWithTx(t, openRedisStoreTestRepository(t))
+}
+
+func TestRedisStoreVersionConflict(t *testing.T) {
+    testVersionConflict(t, openRedisStoreTestRepository(t))
+}
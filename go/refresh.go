@@ -0,0 +1,166 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// REFRESH_TOKEN_TTL (default 7 days) is the lifetime of refresh tokens
+// issued by /login.
+
+var (
+    errRefreshTokenInvalid = errors.New("invalid refresh token")
+    errRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+type refreshToken struct {
+    userID  int
+    family  string
+    expires time.Time
+    used    bool
+}
+
+// refreshTokenStore tracks issued refresh tokens by their SHA-256 hash. Each
+// token is single-use: redeeming it rotates it, and presenting a token that
+// was already redeemed revokes its whole family, since that means it leaked.
+type refreshTokenStore struct {
+    mu     sync.Mutex
+    tokens map[string]*refreshToken
+}
+
+var refreshTokens = &refreshTokenStore{tokens: make(map[string]*refreshToken)}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// issue creates a refresh token. An empty family starts a new one.
+func (s *refreshTokenStore) issue(userID int, family string) (string, error) {
+    token, err := randomToken(32)
+    if err != nil {
+        return "", err
+    }
+    if family == "" {
+        if family, err = randomToken(16); err != nil {
+            return "", err
+        }
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.tokens[hashToken(token)] = &refreshToken{
+        userID:  userID,
+        family:  family,
+        expires: time.Now().Add(config.RefreshTokenTTL),
+    }
+    return token, nil
+}
+
+// redeem marks token used and returns its owner and family.
+func (s *refreshTokenStore) redeem(token string) (int, string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.sweep()
+
+    rt, ok := s.tokens[hashToken(token)]
+    if !ok {
+        return 0, "", errRefreshTokenInvalid
+    }
+    if rt.used {
+        s.revokeFamily(rt.family)
+        return 0, "", errRefreshTokenReused
+    }
+    rt.used = true
+    return rt.userID, rt.family, nil
+}
+
+// revokeFamily must be called with s.mu held.
+func (s *refreshTokenStore) revokeFamily(family string) {
+    for hash, rt := range s.tokens {
+        if rt.family == family {
+            delete(s.tokens, hash)
+        }
+    }
+}
+
+// revokeUser drops every token issued to userID, e.g. because their
+// password was reset.
+func (s *refreshTokenStore) revokeUser(userID int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for hash, rt := range s.tokens {
+        if rt.userID == userID {
+            delete(s.tokens, hash)
+        }
+    }
+}
+
+// sweep drops expired tokens; it must be called with s.mu held.
+func (s *refreshTokenStore) sweep() {
+    now := time.Now()
+    for hash, rt := range s.tokens {
+        if now.After(rt.expires) {
+            delete(s.tokens, hash)
+        }
+    }
+}
+
+type RefreshRequest struct {
+    RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens returns an access token plus a refresh token in family.
+func issueTokens(user User, family string) (TokenResponse, error) {
+    tokens, err := issueAccessToken(user)
+    if err != nil {
+        return TokenResponse{}, err
+    }
+    if tokens.RefreshToken, err = refreshTokens.issue(user.ID, family); err != nil {
+        return TokenResponse{}, err
+    }
+    return tokens, nil
+}
+
+func refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+    var req RefreshRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+        writeError(w, r, httpError(http.StatusBadRequest, "refresh_token is required"))
+        return
+    }
+
+    userID, family, err := refreshTokens.redeem(req.RefreshToken)
+    if err != nil {
+        if errors.Is(err, errRefreshTokenReused) {
+            slog.WarnContext(r.Context(), "Refresh token reuse detected, session revoked", "remote_addr", r.RemoteAddr)
+        }
+        writeError(w, r, httpError(http.StatusUnauthorized, "Invalid refresh token"))
+        return
+    }
+    user, err := userRepo.Get(r.Context(), userID)
+    if errors.Is(err, ErrNotFound) {
+        writeError(w, r, httpError(http.StatusUnauthorized, "Invalid refresh token"))
+        return
+    }
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    tokens, err := issueTokens(user, family)
+    if err != nil {
+        writeError(w, r, httpError(http.StatusInternalServerError, "Could not issue token"))
+        return
+    }
+    setSessionCookie(w, r, tokens)
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   tokens,
+    })
+}
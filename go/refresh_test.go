@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    user, err := repo.Insert(context.Background(), User{Name: "Ada", Email: "ada@example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    refresh := func(token string) (*httptest.ResponseRecorder, string) {
+        rec := httptest.NewRecorder()
+        refreshTokenHandler(rec, httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`)))
+        var resp struct {
+            Data TokenResponse `json:"data"`
+        }
+        json.Unmarshal(rec.Body.Bytes(), &resp)
+        return rec, resp.Data.RefreshToken
+    }
+
+    first, err := refreshTokens.issue(user.ID, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    other, err := refreshTokens.issue(user.ID, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := refreshTokens.tokens[first]; ok {
+        t.Fatal("token stored in the clear")
+    }
+
+    rec, second := refresh(first)
+    if rec.Code != http.StatusOK || second == "" || second == first {
+        t.Fatalf("refresh: %d %s", rec.Code, rec.Body)
+    }
+    rec, third := refresh(second)
+    if rec.Code != http.StatusOK || third == "" || third == second {
+        t.Fatalf("second refresh: %d %s", rec.Code, rec.Body)
+    }
+
+    // Replaying a redeemed token revokes every token of its family,
+    // including the current one, but not the user's other sessions.
+    if rec, _ := refresh(first); rec.Code != http.StatusUnauthorized {
+        t.Errorf("reused token: %d %s", rec.Code, rec.Body)
+    }
+    if rec, _ := refresh(third); rec.Code != http.StatusUnauthorized {
+        t.Errorf("token of the revoked family: %d %s", rec.Code, rec.Body)
+    }
+    if rec, _ := refresh(other); rec.Code != http.StatusOK {
+        t.Errorf("token of another family: %d %s", rec.Code, rec.Body)
+    }
+
+    for name, token := range map[string]string{"unknown": "0123abcd", "empty": ""} {
+        if rec, _ := refresh(token); rec.Code == http.StatusOK {
+            t.Errorf("%s token: %d", name, rec.Code)
+        }
+    }
+
+    defer func(old time.Duration) { config.RefreshTokenTTL = old }(config.RefreshTokenTTL)
+    config.RefreshTokenTTL = -time.Second
+    expired, err := refreshTokens.issue(user.ID, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rec, _ := refresh(expired); rec.Code != http.StatusUnauthorized {
+        t.Errorf("expired token: %d %s", rec.Code, rec.Body)
+    }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "os/signal"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var configReloadsTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "config_reloads_total",
+        Help: "Total number of CONFIG_FILE reloads by result",
+    },
+    []string{"result"},
+)
+
+func init() {
+    prometheus.MustRegister(configReloadsTotal)
+}
+
+// Settings that only shape how requests are served change without a
+// restart: on SIGHUP, and with CONFIG_WATCH_INTERVAL (default 0, off) when a
+// CONFIG_FILE changes, e.g. a Kubernetes ConfigMap mounted as a volume, the
+// files are read again and the settings registered with onReload applied:
+// LOG_LEVEL, ACCESS_LOG_FORMAT, ACCESS_LOG_SAMPLE, SLOW_REQUEST_THRESHOLD,
+// HEALTH_CHECK_TIMEOUT, SHUTDOWN_DELAY, SHUTDOWN_TIMEOUT, RATE_LIMIT,
+// RATE_LIMIT_BURST, MAX_IN_FLIGHT, LOAD_SHED_RETRY_AFTER,
+// CORS_ALLOWED_ORIGINS, CORS_MAX_AGE, IP_ALLOWLIST, IP_DENYLIST, the
+// FEATURE_* flags and the TLS_CERT_FILE and TLS_KEY_FILE pair. Other changes,
+// such as the port or the storage backend, are logged as waiting for a
+// restart (SIGUSR2 restarts in place). The environment of a running process
+// cannot change, so only settings from the files do. An invalid value keeps
+// the setting as it was and fails the reload.
+var (
+    reloadable = make(map[string]func() error)
+    reloadMu   sync.Mutex
+)
+
+// onReload registers apply to re-read the setting key after it changed.
+// apply leaves the setting as it was when it returns an error.
+func onReload(key string, apply func() error) {
+    reloadable[key] = apply
+}
+
+// reloadableSetting applies a setting read by apply now, failing startup
+// when it is invalid, and again whenever a reload changes one of keys.
+func reloadableSetting(apply func() error, keys ...string) {
+    if err := apply(); err != nil {
+        startupEnv.errs = append(startupEnv.errs, err)
+    }
+    for _, key := range keys {
+        onReload(key, apply)
+    }
+}
+
+// reloadConfig applies the changes to CONFIG_FILE since it was last read.
+// The settings that are invalid are returned as an error, and the rest
+// applied.
+func reloadConfig() error {
+    reloadMu.Lock()
+    defer reloadMu.Unlock()
+    changed, err := reloadConfigFiles()
+    if err != nil {
+        configReloadsTotal.WithLabelValues("failed").Inc()
+        return err
+    }
+    var applied, pending []string
+    var invalid []error
+    for _, key := range changed {
+        if apply, ok := reloadable[key]; !ok {
+            pending = append(pending, key)
+        } else if err := apply(); err != nil {
+            invalid = append(invalid, err)
+        } else {
+            applied = append(applied, key)
+        }
+    }
+    slog.Info("Reloaded configuration", "applied", applied)
+    if len(pending) > 0 {
+        slog.Warn("Changed settings take effect after a restart", "keys", pending)
+    }
+    if len(invalid) > 0 {
+        configReloadsTotal.WithLabelValues("failed").Inc()
+        return errors.Join(invalid...)
+    }
+    configReloadsTotal.WithLabelValues("success").Inc()
+    return nil
+}
+
+// watchConfig reloads the configuration on each reload signal and when the
+// files change. The signals are subscribed to before it returns.
+func watchConfig() {
+    reload := make(chan os.Signal, 1)
+    if len(reloadSignals) > 0 {
+        signal.Notify(reload, reloadSignals...)
+    }
+    var changed <-chan time.Time
+    if config.ConfigWatchInterval > 0 && len(configFilePaths()) > 0 {
+        changed = time.NewTicker(config.ConfigWatchInterval).C
+    }
+    go func() {
+        last := configFilesVersion()
+        for {
+            select {
+            case sig := <-reload:
+                slog.Info("Reload requested", "signal", sig.String())
+            case <-changed:
+                version := configFilesVersion()
+                if version == last {
+                    continue
+                }
+                slog.Info("CONFIG_FILE changed, reloading")
+            }
+            last = configFilesVersion()
+            if err := reloadConfig(); err != nil {
+                slog.Error("Reload failed, keeping the settings it could not apply", "error", err)
+            }
+        }
+    }()
+}
+
+func configFilesVersion() string {
+    return filesVersion(configFilePaths()...)
+}
+
+// filesVersion identifies the current contents of paths by their
+// modification times and sizes. It follows symlinks, so it sees the swap of
+// the ..data link Kubernetes updates ConfigMap and Secret volumes with.
+func filesVersion(paths ...string) string {
+    var version strings.Builder
+    for _, path := range paths {
+        if info, err := os.Stat(path); err == nil {
+            fmt.Fprintf(&version, "%s %d %d;", path, info.ModTime().UnixNano(), info.Size())
+        }
+    }
+    return version.String()
+}
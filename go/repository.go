@@ -0,0 +1,256 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "sort"
+    "strings"
+    "time"
+)
+
+// UserRepository is the storage behind the user routes. Implementations
+// return ErrNotFound for missing users and ConstraintError for writes that
+// violate a constraint, so handlers do not depend on the backend.
+type UserRepository interface {
+    List(ctx context.Context) ([]User, error)
+    Get(ctx context.Context, id int) (User, error)
+    GetByEmail(ctx context.Context, email string) (User, error)
+    // Insert assigns the ID and creation time, sets Version to 1 and clears
+    // Verified; users without roles get the user role.
+    Insert(ctx context.Context, user User) (User, error)
+    // Update fails with ErrVersionConflict unless user.Version is the stored
+    // version, and returns the user with the next one.
+    Update(ctx context.Context, user User) (User, error)
+    Delete(ctx context.Context, id int) error
+    // Stats aggregates the table; see UserStats.
+    Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error)
+    // WithTx runs fn against a repository whose changes are applied only if
+    // fn returns nil, so multi-step operations such as read-modify-write
+    // updates commit or roll back as a whole.
+    WithTx(ctx context.Context, fn func(tx UserRepository) error) error
+    Close() error
+}
+
+// userRepo is the configured repository, opened in main.
+var userRepo UserRepository
+
+// openUserRepository opens the backend named by STORAGE_BACKEND (see
+// storageDrivers). Only users are stored there; teams stay in memory (see
+// team_store.go). With REDIS_URL set, user lookups go through a Redis cache
+// in front of it, unless Redis is the backend itself. Calls to a networked
+// or file backend are retried on transient errors (see retry.go), with
+// OUTBOX_WEBHOOK_URL set every write records an event (see outbox.go), and
+// with EMAIL_ENCRYPTION_KEY set emails are stored encrypted (see
+// email_encryption.go).
+func openUserRepository(ctx context.Context) (UserRepository, error) {
+    backend, err := openStorageBackend(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if emailKeys, err = openEmailKeyring(ctx); err != nil {
+        backend.Close()
+        return nil, err
+    }
+    repo := backend
+    if url := getenv("OUTBOX_WEBHOOK_URL"); url != "" {
+        if repo, err = newOutboxRepository(backend, url); err != nil {
+            backend.Close()
+            return nil, err
+        }
+    }
+    repo = encryptEmails(repo, emailKeys)
+    switch backend.(type) {
+    case *memoryUserRepository:
+    case *redisUserRepository:
+        return newTracingRepository(newRetryingRepository(repo), storageBackendName()), nil
+    default:
+        repo = newRetryingRepository(repo)
+    }
+    repo = newTracingRepository(repo, storageBackendName())
+    if url := getenv("REDIS_URL"); url != "" {
+        cached, err := openRedisCachedRepository(ctx, repo, url)
+        if err != nil {
+            repo.Close()
+            return nil, err
+        }
+        slog.Info("Caching users in Redis", "url", redactConfigValue("REDIS_URL", url))
+        return cached, nil
+    }
+    return repo, nil
+}
+
+// storageDriver opens one kind of backend from its environment variables.
+type storageDriver struct {
+    // target describes where the users are kept, for the startup log, with
+    // any password redacted.
+    target func() string
+    // open connects and fails if the backend is unreachable, so a
+    // misconfigured deployment stops at startup rather than on the first
+    // request.
+    open func(ctx context.Context) (UserRepository, error)
+}
+
+// storageDrivers are the values of STORAGE_BACKEND. "memory" is the default
+// and is snapshotted to MEMORY_SNAPSHOT_PATH if set; "sqlite" and "bolt" keep
+// a file at SQLITE_PATH and BOLT_PATH; "postgres" and "mysql" (alias
+// "mariadb") connect to DATABASE_URL, "mongodb" (alias "mongo") to
+// MONGODB_URI and "redis" to REDIS_URL.
+var storageDrivers = map[string]storageDriver{
+    "memory": {
+        target: func() string {
+            if path := getenv("MEMORY_SNAPSHOT_PATH"); path != "" {
+                return "process memory, snapshotted to " + path
+            }
+            return "process memory"
+        },
+        open: func(ctx context.Context) (UserRepository, error) {
+            repo := newMemoryUserRepository()
+            if path := getenv("MEMORY_SNAPSHOT_PATH"); path != "" {
+                if err := openMemorySnapshots(repo, path); err != nil {
+                    return nil, err
+                }
+            }
+            return repo, nil
+        },
+    },
+    "sqlite": {
+        target: func() string {
+            if path := getenv("SQLITE_PATH"); path != "" {
+                return path
+            }
+            return defaultSQLitePath
+        },
+        open: func(ctx context.Context) (UserRepository, error) {
+            return openSQLiteUserRepository(ctx, getenv("SQLITE_PATH"))
+        },
+    },
+    "bolt": {
+        target: func() string {
+            if path := getenv("BOLT_PATH"); path != "" {
+                return path
+            }
+            return defaultBoltPath
+        },
+        open: func(ctx context.Context) (UserRepository, error) {
+            return openBoltUserRepository(ctx, getenv("BOLT_PATH"))
+        },
+    },
+    "postgres": {
+        target: databaseURLTarget,
+        open: func(ctx context.Context) (UserRepository, error) {
+            return openPostgresUserRepository(ctx, getenv("DATABASE_URL"))
+        },
+    },
+    "mysql":   mysqlDriver,
+    "mariadb": mysqlDriver,
+    "mongodb": mongoDriver,
+    "mongo":   mongoDriver,
+    "redis": {
+        target: func() string {
+            return redactConfigValue("REDIS_URL", getenv("REDIS_URL"))
+        },
+        open: func(ctx context.Context) (UserRepository, error) {
+            return openRedisUserRepository(ctx, getenv("REDIS_URL"))
+        },
+    },
+}
+
+var mysqlDriver = storageDriver{
+    target: databaseURLTarget,
+    open: func(ctx context.Context) (UserRepository, error) {
+        return openMySQLUserRepository(ctx, getenv("DATABASE_URL"))
+    },
+}
+
+var mongoDriver = storageDriver{
+    target: func() string {
+        return redactConfigValue("MONGODB_URI", getenv("MONGODB_URI"))
+    },
+    open: func(ctx context.Context) (UserRepository, error) {
+        return openMongoUserRepository(ctx, getenv("MONGODB_URI"))
+    },
+}
+
+func databaseURLTarget() string {
+    return redactConfigValue("DATABASE_URL", getenv("DATABASE_URL"))
+}
+
+// openStorageBackend opens the backend named by STORAGE_BACKEND and logs
+// which one it is using.
+func openStorageBackend(ctx context.Context) (UserRepository, error) {
+    name := storageBackendName()
+    driver, ok := storageDrivers[name]
+    if !ok {
+        names := make([]string, 0, len(storageDrivers))
+        for n := range storageDrivers {
+            names = append(names, n)
+        }
+        sort.Strings(names)
+        return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (want one of %s)", name, strings.Join(names, ", "))
+    }
+    target := driver.target()
+    slog.Info("Opening storage", "backend", name, "target", target)
+    start := time.Now()
+    repo, err := driver.open(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("%s storage at %s: %w", name, target, err)
+    }
+    slog.Info("Storage ready", "backend", name, "duration", time.Since(start).Round(time.Millisecond).String())
+    return repo, nil
+}
+
+func storageBackendName() string {
+    if name := getenv("STORAGE_BACKEND"); name != "" {
+        return name
+    }
+    return "memory"
+}
+
+// storedUser is a user as the Redis and Bolt backends and the Redis cache
+// keep it, as JSON. User hides the password hash from JSON, but updates read
+// the stored user back, so it has to be kept too.
+type storedUser struct {
+    User
+    PasswordHash string `json:"password_hash"`
+}
+
+func encodeStoredUser(user User) ([]byte, error) {
+    return json.Marshal(storedUser{User: user, PasswordHash: user.PasswordHash})
+}
+
+func decodeStoredUser(data []byte) (User, error) {
+    var stored storedUser
+    if err := json.Unmarshal(data, &stored); err != nil {
+        return User{}, err
+    }
+    stored.User.PasswordHash = stored.PasswordHash
+    if stored.Version == 0 {
+        // Stored before users had versions.
+        stored.Version = 1
+    }
+    return stored.User, nil
+}
+
+// storageBackend returns the repository at the bottom of any wrappers, such
+// as the Redis cache, around userRepo.
+func storageBackend() UserRepository {
+    repo := userRepo
+    for {
+        w, ok := repo.(interface{ Unwrap() UserRepository })
+        if !ok {
+            return repo
+        }
+        repo = w.Unwrap()
+    }
+}
+
+// removeUser deletes a user and their team memberships.
+func removeUser(ctx context.Context, id int) error {
+    if err := userRepo.Delete(ctx, id); err != nil {
+        return err
+    }
+    removeMemberships(func(m Membership) bool { return m.UserID == id })
+    return nil
+}
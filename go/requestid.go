@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+
+    "go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+const requestIDKey contextKey = "request_id"
+
+// requestIDMiddleware takes the request ID from X-Request-ID, so a proxy or
+// calling service can correlate its logs with ours, or generates one. The
+// ID is echoed in the response, added to every log entry made with the
+// request's context and to problem documents.
+func requestIDMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get(requestIDHeader)
+        if !validRequestID(id) {
+            id, _ = randomToken(16)
+        }
+        w.Header().Set(requestIDHeader, id)
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+    })
+}
+
+// validRequestID accepts up to 128 letters, digits and -_.:, enough for
+// UUIDs and the IDs proxies generate, and keeps anything that could forge
+// log fields or headers out.
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 128 {
+        return false
+    }
+    for _, c := range id {
+        switch {
+        case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+        case c == '-' || c == '_' || c == '.' || c == ':':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+func requestIDFromContext(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey).(string)
+    return id
+}
+
+// requestIDHandler adds the request ID to entries logged with a request's
+// context, and the trace and span IDs when it is traced, so logs can be
+// found from a trace and the other way round.
+type requestIDHandler struct {
+    slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+    if id := requestIDFromContext(ctx); id != "" {
+        record.AddAttrs(slog.String("request_id", id))
+    }
+    if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+        record.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+    }
+    return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+    return requestIDHandler{h.Handler.WithGroup(name)}
+}
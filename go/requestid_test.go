@@ -0,0 +1,51 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestRequestID(t *testing.T) {
+    var logged bytes.Buffer
+    defer func(old *slog.Logger) { slog.SetDefault(old) }(slog.Default())
+    slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(&logged, nil)}))
+
+    handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        slog.InfoContext(r.Context(), "handling")
+        writeError(w, r, ErrNotFound)
+    }))
+    serve := func(id string) (*httptest.ResponseRecorder, Problem, map[string]interface{}) {
+        logged.Reset()
+        r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+        if id != "" {
+            r.Header.Set(requestIDHeader, id)
+        }
+        w := httptest.NewRecorder()
+        handler.ServeHTTP(w, r)
+        var p Problem
+        json.Unmarshal(w.Body.Bytes(), &p)
+        var entry map[string]interface{}
+        json.Unmarshal(logged.Bytes(), &entry)
+        return w, p, entry
+    }
+
+    w, p, entry := serve("client-id-1")
+    if got := w.Header().Get(requestIDHeader); got != "client-id-1" || p.RequestID != got || entry["request_id"] != got {
+        t.Fatalf("honoured ID: header %q, problem %q, log %v", got, p.RequestID, entry["request_id"])
+    }
+
+    w, p, entry = serve("bad\nid")
+    got := w.Header().Get(requestIDHeader)
+    if got == "" || got == "bad\nid" || p.RequestID != got || entry["request_id"] != got {
+        t.Fatalf("generated ID: header %q, problem %q, log %v", got, p.RequestID, entry["request_id"])
+    }
+
+    if id := requestIDFromContext(context.Background()); id != "" {
+        t.Fatalf("request ID %q outside a request", id)
+    }
+}
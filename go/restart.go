@@ -0,0 +1,414 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "os/exec"
+    "os/signal"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// A restart hands the listening sockets to a new copy of the binary: the
+// child inherits them from fd 3 on and reports readiness on the pipe after
+// them, then
+// the parent stops accepting and drains its in-flight requests and bulk jobs.
+// Clients see no refused connections, since the socket never closes. Inside a
+// container the old process is usually PID 1, so this is meant for VMs and
+// bare hosts.
+//
+// Only the socket is handed over. Anything held in process memory, such as
+// the memory backend, refresh tokens and a generated token secret, starts
+// empty in the child, so restarts that would lose users or sign every client
+// out are refused.
+const (
+    listenerFDEnv = "USER_API_LISTENER_FD"
+    readyFDEnv    = "USER_API_READY_FD"
+)
+
+// REUSE_PORT=true opens the listeners with SO_REUSEPORT, so that a new
+// release started beside this one, by a deploy script or a systemd unit
+// rather than by a restart signal, can bind the same port. The kernel then
+// spreads connections over both until this one is stopped with SIGTERM and
+// drains as usual. Unlike a handover, the processes share no state and
+// need not be the same binary or configuration.
+
+// listenTCP opens a listener on addr, sharing the port if REUSE_PORT is
+// set.
+func listenTCP(addr string) (net.Listener, error) {
+    var lc net.ListenConfig
+    if config.ReusePort {
+        lc.Control = reusePortControl
+    }
+    return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// RESTART_TIMEOUT (default 30s) bounds both the wait for the new process to
+// become ready and the drain of the old one.
+
+// On SIGTERM or SIGINT, which docker stop and Kubernetes send before
+// SIGKILL, the server stops gracefully: /readyz turns 503 at once, then,
+// after SHUTDOWN_DELAY (default 0) to let load balancers notice, it stops
+// accepting and waits up to SHUTDOWN_TIMEOUT (default 25s, within the 30s
+// Kubernetes and Docker allow) for in-flight requests and bulk jobs, before
+// storage connections are closed and traces and metrics flushed. A second
+// signal exits at once.
+var (
+    shutdownDelay   = reloadableDuration("SHUTDOWN_DELAY", 0)
+    shutdownTimeout = reloadableDuration("SHUTDOWN_TIMEOUT", 25*time.Second)
+)
+
+// LISTEN_ADDR lists the addresses to serve the API on, comma separated,
+// each a host:port, just a port or a unix socket (see unix_socket.go), e.g.
+// 0.0.0.0:8080,[::1]:8081,unix:/run/user-api/api.sock. It defaults to every
+// interface on PORT (default 8080).
+func listenAddrs() ([]string, error) {
+    value := getenv("LISTEN_ADDR")
+    if value == "" {
+        port := getenv("PORT")
+        if port == "" {
+            port = "8080"
+        }
+        return []string{":" + port}, nil
+    }
+    var addrs []string
+    for _, addr := range strings.Split(value, ",") {
+        if addr = strings.TrimSpace(addr); addr == "" {
+            continue
+        }
+        addr = bindAddr(addr)
+        if isUnixAddr(addr) {
+            if addr == unixPrefix {
+                return nil, errors.New("invalid LISTEN_ADDR: unix: needs a socket path")
+            }
+        } else if _, _, err := net.SplitHostPort(addr); err != nil {
+            return nil, fmt.Errorf("invalid LISTEN_ADDR: %w", err)
+        }
+        addrs = append(addrs, addr)
+    }
+    if len(addrs) == 0 {
+        return nil, errors.New("LISTEN_ADDR has no addresses")
+    }
+    return addrs, nil
+}
+
+// bindAddr turns a setting that is a port or a host:port into an address to
+// listen on.
+func bindAddr(value string) string {
+    if _, err := strconv.Atoi(value); err == nil {
+        return ":" + value
+    }
+    return value
+}
+
+// localAddr returns the address that reaches a listener on addr from the
+// same host, for the subcommands talking to a running server. For a unix
+// socket, that is a placeholder host for the URL; localDial connects.
+func localAddr(addr string) string {
+    if isUnixAddr(addr) {
+        return "localhost"
+    }
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return addr
+    }
+    if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+        host = "127.0.0.1"
+    }
+    return net.JoinHostPort(host, port)
+}
+
+// listen returns the sockets handed over by a parent process or passed by
+// systemd, if any, or opens one on each of addrs.
+func listen(addrs []string) ([]net.Listener, error) {
+    fds := os.Getenv(listenerFDEnv)
+    if fds == "" {
+        if lns, err := activatedListeners(); lns != nil || err != nil {
+            return lns, err
+        }
+        var lns []net.Listener
+        for _, addr := range addrs {
+            ln, err := listenAddr(addr)
+            if err != nil {
+                for _, ln := range lns {
+                    ln.Close()
+                }
+                return nil, err
+            }
+            lns = append(lns, ln)
+        }
+        return lns, nil
+    }
+    var lns []net.Listener
+    for _, fd := range strings.Split(fds, ",") {
+        n, err := strconv.Atoi(fd)
+        if err != nil {
+            return nil, fmt.Errorf("invalid %s %q", listenerFDEnv, fds)
+        }
+        f := os.NewFile(uintptr(n), "listener")
+        ln, err := net.FileListener(f)
+        f.Close()
+        if err != nil {
+            return nil, fmt.Errorf("inherited listener: %w", err)
+        }
+        slog.Info("Inherited listener", "addr", ln.Addr().String(), "parent_pid", os.Getppid())
+        lns = append(lns, ln)
+    }
+    // The sockets stay open across restarts, so changes to LISTEN_ADDR need
+    // a full one.
+    if len(lns) != len(addrs) {
+        slog.Warn("LISTEN_ADDR changed; serving on the inherited listeners until the next full restart", "inherited", len(lns), "configured", len(addrs))
+    }
+    return lns, nil
+}
+
+// listenBeside opens the listener of a server running beside the API, such
+// as the metrics one. After a restart the old process keeps its port until
+// it has drained, so a process that inherited the API listeners retries for
+// up to RESTART_TIMEOUT instead of failing at once.
+func listenBeside(addr string, inherited bool) (net.Listener, error) {
+    deadline := time.Now().Add(config.RestartTimeout)
+    ln, err := listenTCP(addr)
+    for err != nil && inherited && time.Now().Before(deadline) {
+        time.Sleep(100 * time.Millisecond)
+        ln, err = listenTCP(addr)
+    }
+    return ln, err
+}
+
+// signalReady tells the parent that handed over the listener that this
+// process is serving, so it can start draining.
+func signalReady() {
+    fd := os.Getenv(readyFDEnv)
+    os.Unsetenv(listenerFDEnv)
+    os.Unsetenv(readyFDEnv)
+    if fd == "" {
+        return
+    }
+    n, err := strconv.Atoi(fd)
+    if err != nil {
+        slog.Warn("Invalid ready file descriptor", "key", readyFDEnv, "value", fd)
+        return
+    }
+    f := os.NewFile(uintptr(n), "ready")
+    f.Write([]byte{1})
+    f.Close()
+}
+
+// restarter serves on listeners that can be handed over to a new process
+// on a restart signal, and shuts down gracefully on a stop signal.
+type restarter struct {
+    srv *http.Server
+    lns []net.Listener
+    tls bool // serve HTTPS with srv.TLSConfig
+
+    mu       sync.Mutex
+    fresh    map[net.Conn]struct{} // accepted, first request not yet read
+    stopping chan struct{}         // closed once a child is serving or a stop was signalled
+    drained  chan struct{}         // closed once in-flight requests finished
+}
+
+func newRestarter(srv *http.Server, lns ...net.Listener) *restarter {
+    rs := &restarter{
+        srv:      srv,
+        lns:      lns,
+        fresh:    make(map[net.Conn]struct{}),
+        stopping: make(chan struct{}),
+        drained:  make(chan struct{}),
+    }
+    srv.ConnState = rs.trackConn
+    return rs
+}
+
+func (rs *restarter) trackConn(c net.Conn, state http.ConnState) {
+    trackConnState(c, state)
+    rs.mu.Lock()
+    defer rs.mu.Unlock()
+    if state == http.StateNew {
+        rs.fresh[c] = struct{}{}
+    } else {
+        delete(rs.fresh, c)
+    }
+}
+
+// serve runs the server until it fails or, after a handover or a stop
+// signal, until the in-flight requests have drained.
+func (rs *restarter) serve() error {
+    // Signals are subscribed to before serving, so none is missed.
+    restart := make(chan os.Signal, 1)
+    if len(restartSignals) > 0 {
+        signal.Notify(restart, restartSignals...)
+    }
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, stopSignals...)
+    go rs.watch(restart, stop)
+    errs := make(chan error, len(rs.lns))
+    for _, ln := range rs.lns {
+        go func() {
+            if rs.tls {
+                errs <- rs.srv.ServeTLS(ln, "", "")
+            } else {
+                errs <- rs.srv.Serve(ln)
+            }
+        }()
+    }
+    err := <-errs
+    select {
+    case <-rs.stopping:
+        <-rs.drained
+        return nil
+    default:
+        return err
+    }
+}
+
+// watch hands the listeners over on each restart signal, and shuts down on
+// the first stop signal. A failed handover leaves this process serving as
+// before.
+func (rs *restarter) watch(restart, stop chan os.Signal) {
+    for {
+        select {
+        case sig := <-stop:
+            signal.Stop(restart)
+            close(rs.stopping)
+            slog.Info("Shutting down, draining in-flight requests", "signal", sig.String(), "timeout", shutdownTimeout.get().String())
+            go func() {
+                sig := <-stop
+                slog.Warn("Second stop signal, exiting without draining", "signal", sig.String())
+                os.Exit(1)
+            }()
+            draining.Store(true)
+            time.Sleep(shutdownDelay.get())
+            rs.drain(shutdownTimeout.get())
+            signal.Stop(stop)
+            close(rs.drained)
+            return
+        case <-restart:
+            slog.Info("Restart requested, handing over listeners")
+            if err := checkHandover(); err != nil {
+                slog.Warn("Restart refused", "error", err)
+                continue
+            }
+            slog.Warn("Refresh tokens are kept in memory and will not carry over; clients must log in again once their access token expires")
+            pid, err := handOver(rs.lns)
+            if err != nil {
+                slog.Error("Restart aborted", "error", err)
+                continue
+            }
+            signal.Stop(restart)
+            signal.Stop(stop)
+            close(rs.stopping)
+            slog.Info("New process is serving, draining in-flight requests", "pid", pid)
+            rs.drain(config.RestartTimeout)
+            close(rs.drained)
+            return
+        }
+    }
+}
+
+// drain stops accepting, lets connections accepted just before that send
+// their first request, then shuts down within timeout. http.Server drops
+// requests it reads after Shutdown has begun, so shutting down straight
+// away would reset clients whose connection this process had already
+// accepted.
+func (rs *restarter) drain(timeout time.Duration) {
+    draining.Store(true)
+    for _, ln := range rs.lns {
+        ln.Close()
+    }
+    deadline := time.Now().Add(time.Second)
+    for time.Now().Before(deadline) {
+        rs.mu.Lock()
+        n := len(rs.fresh)
+        rs.mu.Unlock()
+        if n == 0 {
+            break
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    if err := rs.srv.Shutdown(ctx); err != nil {
+        slog.Warn("Drain incomplete", "error", err)
+    }
+    if err := jobs.drain(ctx); err != nil {
+        slog.Warn("Bulk jobs still running at exit", "error", err)
+    }
+}
+
+// checkHandover reports why a new process could not take over without
+// losing state that only this process holds.
+func checkHandover() error {
+    if _, ok := storageBackend().(*memoryUserRepository); ok {
+        return errors.New("the memory storage backend does not survive a restart; use a persistent STORAGE_BACKEND")
+    }
+    if getenv("TOKEN_SECRET") == "" {
+        return errors.New("TOKEN_SECRET is not set, so the new process would reject every issued token")
+    }
+    return nil
+}
+
+// handOver starts a copy of the running binary with the listeners and a
+// readiness pipe, and waits for the child to report that it is serving.
+func handOver(lns []net.Listener) (int, error) {
+    var files []*os.File
+    defer func() {
+        for _, f := range files {
+            f.Close()
+        }
+    }()
+    fds := make([]string, len(lns))
+    for i, ln := range lns {
+        if u, ok := ln.(interface{ Unwrap() net.Listener }); ok {
+            ln = u.Unwrap()
+        }
+        fl, ok := ln.(interface{ File() (*os.File, error) })
+        if !ok {
+            return 0, errors.New("listener cannot be shared")
+        }
+        f, err := fl.File()
+        if err != nil {
+            return 0, err
+        }
+        files = append(files, f)
+        fds[i] = strconv.Itoa(3 + i)
+    }
+
+    readyR, readyW, err := os.Pipe()
+    if err != nil {
+        return 0, err
+    }
+    defer readyR.Close()
+
+    exe, err := os.Executable()
+    if err != nil {
+        readyW.Close()
+        return 0, err
+    }
+    cmd := exec.Command(exe, os.Args[1:]...)
+    cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+    cmd.Env = append(os.Environ(), listenerFDEnv+"="+strings.Join(fds, ","), readyFDEnv+"="+strconv.Itoa(3+len(lns)))
+    cmd.ExtraFiles = append(files, readyW)
+    err = cmd.Start()
+    // Only the child may hold the write end, so its exit shows up as EOF.
+    readyW.Close()
+    if err != nil {
+        return 0, err
+    }
+
+    readyR.SetReadDeadline(time.Now().Add(config.RestartTimeout))
+    if _, err := readyR.Read(make([]byte, 1)); err != nil {
+        cmd.Process.Kill()
+        cmd.Wait()
+        return 0, fmt.Errorf("new process %d did not become ready: %w", cmd.Process.Pid, err)
+    }
+    return cmd.Process.Pid, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestListenAddrs(t *testing.T) {
+    for _, c := range []struct {
+        listen, port string
+        want         string
+    }{
+        {"", "", "[:8080]"},
+        {"", "9000", "[:9000]"},
+        {"127.0.0.1:8080, [::1]:8081,9000", "7000", "[127.0.0.1:8080 [::1]:8081 :9000]"},
+        {"unix:/run/api.sock,8080", "", "[unix:/run/api.sock :8080]"},
+    } {
+        t.Setenv("LISTEN_ADDR", c.listen)
+        t.Setenv("PORT", c.port)
+        addrs, err := listenAddrs()
+        if err != nil || fmt.Sprint(addrs) != c.want {
+            t.Errorf("LISTEN_ADDR=%q PORT=%q: %v, %v; want %s", c.listen, c.port, addrs, err, c.want)
+        }
+    }
+    for _, value := range []string{"localhost", " , ", "unix:"} {
+        t.Setenv("LISTEN_ADDR", value)
+        if addrs, err := listenAddrs(); err == nil {
+            t.Errorf("LISTEN_ADDR=%q accepted as %v", value, addrs)
+        }
+    }
+    for addr, want := range map[string]string{":8080": "127.0.0.1:8080", "0.0.0.0:80": "127.0.0.1:80", "[::]:80": "127.0.0.1:80", "[::1]:80": "[::1]:80"} {
+        if got := localAddr(addr); got != want {
+            t.Errorf("localAddr(%q) = %q, want %q", addr, got, want)
+        }
+    }
+}
+
+func TestListenMultiple(t *testing.T) {
+    t.Setenv(listenerFDEnv, "")
+    lns, err := listen([]string{"127.0.0.1:0", "127.0.0.1:0"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+    for _, ln := range lns {
+        go srv.Serve(ln)
+    }
+    defer srv.Close()
+
+    for _, ln := range lns {
+        resp, err := http.Get("http://" + ln.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+    }
+}
+
+func TestListenUnix(t *testing.T) {
+    t.Setenv(listenerFDEnv, "")
+    addr := "unix:" + filepath.Join(t.TempDir(), "api.sock")
+    for i := 0; i < 2; i++ {
+        // The second round finds the socket the first left behind.
+        lns, err := listen([]string{addr})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if info, err := os.Stat(addr[len(unixPrefix):]); err != nil || info.Mode().Perm() != config.UnixSocketMode {
+            t.Fatalf("socket: %v, %v", info, err)
+        }
+        if _, err := listen([]string{addr}); err == nil {
+            t.Error("socket in use replaced")
+        }
+        unix := make(chan bool, 1)
+        srv := &http.Server{Handler: clientIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            unix <- viaUnixSocket(r) && r.RemoteAddr == "203.0.113.9"
+        }))}
+        go srv.Serve(lns[0])
+
+        client := &http.Client{Transport: &http.Transport{DialContext: localDial(addr)}}
+        req, _ := http.NewRequest(http.MethodGet, "http://"+localAddr(addr)+"/", nil)
+        req.Header.Set("X-Forwarded-For", "203.0.113.9")
+        resp, err := client.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        if !<-unix {
+            t.Error("request over the socket not trusted to forward the client address")
+        }
+        srv.Close()
+    }
+}
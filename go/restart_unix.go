@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+var (
+    restartSignals = []os.Signal{syscall.SIGUSR2}
+    stopSignals    = []os.Signal{syscall.SIGTERM, os.Interrupt}
+)
+
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+    "io"
+    "net"
+    "net/http"
+    "syscall"
+    "testing"
+    "time"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+    defer func(old bool) { draining.Store(old) }(draining.Load())
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    inFlight := make(chan struct{})
+    srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        close(inFlight)
+        time.Sleep(200 * time.Millisecond)
+        w.Write([]byte("done"))
+    })}
+    served := make(chan error, 1)
+    go func() { served <- newRestarter(srv, ln).serve() }()
+
+    type result struct {
+        body string
+        err  error
+    }
+    response := make(chan result, 1)
+    go func() {
+        resp, err := http.Get("http://" + ln.Addr().String())
+        if err != nil {
+            response <- result{err: err}
+            return
+        }
+        defer resp.Body.Close()
+        body, err := io.ReadAll(resp.Body)
+        response <- result{string(body), err}
+    }()
+    <-inFlight
+    syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+
+    if res := <-response; res.err != nil || res.body != "done" {
+        t.Fatalf("in-flight request: %q, %v", res.body, res.err)
+    }
+    select {
+    case err := <-served:
+        if err != nil {
+            t.Fatalf("serve returned %v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("server still running after SIGTERM")
+    }
+    if !draining.Load() {
+        t.Error("not marked as draining")
+    }
+    if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+        t.Error("still accepting connections")
+    }
+}
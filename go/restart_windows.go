@@ -0,0 +1,12 @@
+package main
+
+import "os"
+
+// Windows has no SIGUSR2, so in-place restarts are not available.
+var (
+    restartSignals []os.Signal
+    stopSignals    = []os.Signal{os.Interrupt}
+)
+
+// Nor SIGHUP, so only CONFIG_WATCH_INTERVAL reloads the configuration.
+var reloadSignals []os.Signal
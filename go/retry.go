@@ -0,0 +1,162 @@
+package main
+
+import (
+    "context"
+    "database/sql/driver"
+    "errors"
+    "io"
+    "log/slog"
+    "math/rand"
+    "net"
+    "syscall"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/prometheus/client_golang/prometheus"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+var storageRetriesTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "storage_retries_total",
+        Help: "Total number of storage calls retried after a transient error by operation",
+    },
+    []string{"operation"},
+)
+
+func init() {
+    prometheus.MustRegister(storageRetriesTotal)
+}
+
+// A call failing with a transient error is tried up to
+// STORAGE_RETRY_ATTEMPTS (default 3) times in all, sleeping a random time up
+// to STORAGE_RETRY_BACKOFF (default 50ms) doubled on each retry and capped
+// at STORAGE_RETRY_MAX_BACKOFF (default 1s), so clients retrying together
+// after a blip do not all hit the database at once.
+// STORAGE_RETRY_ATTEMPTS=1 turns retries off.
+
+// retryingRepository retries the calls to a backend that fail with a
+// transient error, e.g. a connection reset while a database restarts or
+// fails over. Reads are retried on any network error. Writes are retried
+// only when the driver knows the statement was never sent, as a write that
+// was applied before the connection dropped would otherwise be repeated and
+// come back as a spurious conflict.
+type retryingRepository struct {
+    UserRepository
+    attempts int
+}
+
+func newRetryingRepository(repo UserRepository) UserRepository {
+    if config.StorageRetryAttempts <= 1 {
+        return repo
+    }
+    return &retryingRepository{UserRepository: repo, attempts: config.StorageRetryAttempts}
+}
+
+// Unwrap returns the repository whose calls are retried.
+func (r *retryingRepository) Unwrap() UserRepository {
+    return r.UserRepository
+}
+
+func (r *retryingRepository) List(ctx context.Context) (users []User, err error) {
+    err = r.retry(ctx, "list", false, func() error {
+        users, err = r.UserRepository.List(ctx)
+        return err
+    })
+    return users, err
+}
+
+func (r *retryingRepository) Get(ctx context.Context, id int) (user User, err error) {
+    err = r.retry(ctx, "get", false, func() error {
+        user, err = r.UserRepository.Get(ctx, id)
+        return err
+    })
+    return user, err
+}
+
+func (r *retryingRepository) GetByEmail(ctx context.Context, email string) (user User, err error) {
+    err = r.retry(ctx, "get_by_email", false, func() error {
+        user, err = r.UserRepository.GetByEmail(ctx, email)
+        return err
+    })
+    return user, err
+}
+
+func (r *retryingRepository) Insert(ctx context.Context, user User) (inserted User, err error) {
+    err = r.retry(ctx, "insert", true, func() error {
+        inserted, err = r.UserRepository.Insert(ctx, user)
+        return err
+    })
+    return inserted, err
+}
+
+func (r *retryingRepository) Update(ctx context.Context, user User) (updated User, err error) {
+    err = r.retry(ctx, "update", true, func() error {
+        updated, err = r.UserRepository.Update(ctx, user)
+        return err
+    })
+    return updated, err
+}
+
+func (r *retryingRepository) Delete(ctx context.Context, id int) error {
+    return r.retry(ctx, "delete", true, func() error {
+        return r.UserRepository.Delete(ctx, id)
+    })
+}
+
+func (r *retryingRepository) Stats(ctx context.Context, days, top int, now time.Time) (stats UserStats, err error) {
+    err = r.retry(ctx, "stats", false, func() error {
+        stats, err = r.UserRepository.Stats(ctx, days, top, now)
+        return err
+    })
+    return stats, err
+}
+
+// WithTx retries the whole transaction when it could not start. Calls made
+// by fn go straight to the transaction, as once it has failed it has to be
+// rolled back rather than retried statement by statement.
+func (r *retryingRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    return r.retry(ctx, "tx", true, func() error {
+        return r.UserRepository.WithTx(ctx, fn)
+    })
+}
+
+func (r *retryingRepository) retry(ctx context.Context, operation string, write bool, call func() error) error {
+    backoff := config.StorageRetryBackoff
+    for attempt := 1; ; attempt++ {
+        err := call()
+        if err == nil || attempt == r.attempts || !retryableError(err, write) {
+            return err
+        }
+        storageRetriesTotal.WithLabelValues(operation).Inc()
+        slog.Debug("Retrying storage call", "operation", operation, "attempt", attempt, "error", err)
+        timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff) + 1)))
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            timer.Stop()
+            return err
+        }
+        if backoff *= 2; backoff > config.StorageRetryMaxBackoff {
+            backoff = config.StorageRetryMaxBackoff
+        }
+    }
+}
+
+// retryableError reports whether err is transient. For writes it must also
+// be certain that the backend did not apply the call.
+func retryableError(err error, write bool) bool {
+    if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return false
+    }
+    if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) || pgconn.SafeToRetry(err) {
+        return true
+    }
+    if write {
+        return false
+    }
+    var netErr net.Error
+    return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+        errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+        errors.As(err, &netErr) || mongo.IsNetworkError(err)
+}
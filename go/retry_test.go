@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "syscall"
+    "testing"
+    "time"
+)
+
+// flakyRepository fails the next calls with err.
+type flakyRepository struct {
+    UserRepository
+    failures int
+    err      error
+    calls    int
+}
+
+func (f *flakyRepository) fail() error {
+    f.calls++
+    if f.failures > 0 {
+        f.failures--
+        return f.err
+    }
+    return nil
+}
+
+func (f *flakyRepository) Get(ctx context.Context, id int) (User, error) {
+    if err := f.fail(); err != nil {
+        return User{}, err
+    }
+    return f.UserRepository.Get(ctx, id)
+}
+
+func (f *flakyRepository) Insert(ctx context.Context, user User) (User, error) {
+    if err := f.fail(); err != nil {
+        return User{}, err
+    }
+    return f.UserRepository.Insert(ctx, user)
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+    defer func(old time.Duration) { config.StorageRetryBackoff = old }(config.StorageRetryBackoff)
+    config.StorageRetryBackoff = time.Millisecond
+    ctx := context.Background()
+    reset := fmt.Errorf("read tcp: %w", syscall.ECONNRESET)
+
+    flaky := &flakyRepository{UserRepository: newMemoryUserRepository()}
+    repo := &retryingRepository{UserRepository: flaky, attempts: 3}
+
+    flaky.failures, flaky.err, flaky.calls = 2, reset, 0
+    if _, err := repo.Get(ctx, 1); err != nil || flaky.calls != 3 {
+        t.Fatalf("Get after 2 resets: err %v after %d calls, want success after 3", err, flaky.calls)
+    }
+    flaky.failures, flaky.calls = 3, 0
+    if _, err := repo.Get(ctx, 1); !errors.Is(err, syscall.ECONNRESET) || flaky.calls != 3 {
+        t.Fatalf("Get after 3 resets: err %v after %d calls, want the reset after 3", err, flaky.calls)
+    }
+
+    // A reset may come after the insert was applied, so it is not retried.
+    flaky.failures, flaky.calls = 1, 0
+    if _, err := repo.Insert(ctx, User{Name: "Retry", Email: "retry@example.com"}); !errors.Is(err, syscall.ECONNRESET) || flaky.calls != 1 {
+        t.Fatalf("Insert after a reset: err %v after %d calls, want the reset after 1", err, flaky.calls)
+    }
+    flaky.failures, flaky.err, flaky.calls = 1, driver.ErrBadConn, 0
+    if _, err := repo.Insert(ctx, User{Name: "Retry", Email: "retry@example.com"}); err != nil || flaky.calls != 2 {
+        t.Fatalf("Insert after a bad connection: err %v after %d calls, want success after 2", err, flaky.calls)
+    }
+
+    flaky.failures, flaky.calls = 1, 0
+    if _, err := repo.Get(ctx, 999); !errors.Is(err, ErrNotFound) || flaky.calls != 2 {
+        t.Fatalf("Get of a missing user: err %v after %d calls, want not found after 2", err, flaky.calls)
+    }
+}
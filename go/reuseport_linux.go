@@ -0,0 +1,19 @@
+package main
+
+import (
+    "syscall"
+
+    "golang.org/x/sys/unix"
+)
+
+// reusePortControl sets SO_REUSEPORT on a socket before it is bound.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+    var sockErr error
+    err := c.Control(func(fd uintptr) {
+        sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
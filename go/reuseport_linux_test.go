@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestReusePort(t *testing.T) {
+    defer func(old bool) { config.ReusePort = old }(config.ReusePort)
+    config.ReusePort = true
+    first, err := listenTCP("127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer first.Close()
+    second, err := listenTCP(first.Addr().String())
+    if err != nil {
+        t.Fatalf("second listener on %s: %v", first.Addr(), err)
+    }
+    second.Close()
+
+    config.ReusePort = false
+    if ln, err := listenTCP(first.Addr().String()); err == nil {
+        ln.Close()
+        t.Error("port shared without REUSE_PORT")
+    }
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+    "errors"
+    "syscall"
+)
+
+// reusePortControl fails: only Linux balances connections across sockets
+// sharing a port, which is what REUSE_PORT relies on.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+    return errors.New("REUSE_PORT is only supported on Linux")
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// The default registry comes with the process collector (CPU, RSS, open
+// files) and a Go collector limited to the classic memstats, goroutines and
+// GC pause summary, plus GOGC, GOMEMLIMIT and GOMAXPROCS as
+// go_gc_gogc_percent, go_gc_gomemlimit_bytes and go_sched_gomaxprocs_threads.
+// The Go collector is replaced by one that also exports the runtime/metrics
+// GC, memory and scheduler classes, e.g. go_gc_heap_goal_bytes,
+// go_memory_classes_heap_released_bytes and go_sched_latencies_seconds, which
+// show how close the heap runs to the container's memory limit and how long
+// goroutines wait for a CPU under a CPU quota.
+func init() {
+    prometheus.Unregister(collectors.NewGoCollector())
+    prometheus.MustRegister(collectors.NewGoCollector(
+        collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsMemory, collectors.MetricsScheduler),
+    ))
+}
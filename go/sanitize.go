@@ -0,0 +1,67 @@
+package main
+
+import (
+    "strings"
+    "unicode"
+
+    "golang.org/x/text/unicode/norm"
+)
+
+// User names and emails end up in mail headers, logs, spreadsheets and
+// other services' pages, so they are cleaned before validation: names are
+// normalized to NFC, so the same name is always stored the same way, lose
+// control and format characters, which include the line breaks of header
+// injection and the bidi overrides and zero-width characters that disguise
+// text, and have runs of whitespace collapsed. Validation then only accepts
+// names of letters, digits, spaces and . , ' - and emails of printable
+// ASCII. Responses are JSON with <, > and & escaped and sent with
+// X-Content-Type-Options: nosniff, so a name that looks like markup is
+// never rendered as such.
+
+// sanitizeText cleans a free-text field as described above.
+func sanitizeText(s string) string {
+    s = norm.NFC.String(s)
+    var b strings.Builder
+    space := false
+    for _, c := range s {
+        switch {
+        case unicode.IsSpace(c):
+            space = b.Len() > 0
+            continue
+        case unicode.IsControl(c), unicode.Is(unicode.Cf, c):
+            continue
+        }
+        if space {
+            b.WriteByte(' ')
+            space = false
+        }
+        b.WriteRune(c)
+    }
+    return b.String()
+}
+
+// sanitizeUser cleans the fields of a user a client sent.
+func sanitizeUser(user *User) {
+    user.Name = sanitizeText(user.Name)
+    user.Email = strings.TrimSpace(user.Email)
+}
+
+// validName reports whether name only has the characters names may have.
+func validName(name string) bool {
+    for _, c := range name {
+        if !unicode.IsLetter(c) && !unicode.IsMark(c) && !unicode.IsDigit(c) && !strings.ContainsRune(" .,'-’", c) {
+            return false
+        }
+    }
+    return true
+}
+
+// printableASCII reports whether s only has printable ASCII characters.
+func printableASCII(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] < 0x21 || s[i] > 0x7e {
+            return false
+        }
+    }
+    return true
+}
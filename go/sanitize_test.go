@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestSanitizeText(t *testing.T) {
+    for _, tt := range []struct{ in, want string }{
+        {"  Ada   Lovelace ", "Ada Lovelace"},
+        {"Ada\r\nBcc: x", "Ada Bcc: x"},
+        {"Ada\x00\x1b[31m", "Ada[31m"},
+        {"Ada\u202eecalevoL", "AdaecalevoL"},
+        {"Jo\u200bsé", "José"},
+        {"Jose\u0301", "Jos\u00e9"},
+    } {
+        if got := sanitizeText(tt.in); got != tt.want {
+            t.Errorf("sanitizeText(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestValidateUserCharset(t *testing.T) {
+    for _, user := range []User{
+        {Name: "Seán O'Brien-Smith", Email: "sean@example.com"},
+        {Name: "José Müller Jr.", Email: "jose@example.com"},
+        {Name: "Ada", Email: strings.Repeat("a", 242) + "@example.com"},
+    } {
+        if err := validateUser(user); err != nil {
+            t.Errorf("validateUser(%+v): %v", user, err)
+        }
+    }
+    for _, user := range []User{
+        {Name: "<script>alert(1)</script>", Email: "a@example.com"},
+        {Name: "=HYPERLINK(\"x\")", Email: "a@example.com"},
+        {Name: "Ada", Email: "ada@exämple.com"},
+        {Name: "Ada", Email: "ada@example.com\r\nBcc: x@example.com"},
+        {Name: "Ada", Email: strings.Repeat("a", 243) + "@example.com"},
+    } {
+        var verr *ValidationError
+        if err := validateUser(user); !errors.As(err, &verr) {
+            t.Errorf("validateUser(%+v) = %v, want a validation error", user, err)
+        }
+    }
+}
+
+func TestCreateUserSanitizes(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    userRepo = &memoryUserRepository{nextID: 1}
+
+    body := `{"name":"  Ada\u200b \t Lovelace\u0000","email":" ada@example.com "}`
+    rec := httptest.NewRecorder()
+    createUserHandler(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body)))
+    if rec.Code != http.StatusCreated {
+        t.Fatalf("create: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct{ Data User }
+    if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+        t.Fatal(err)
+    }
+    if resp.Data.Name != "Ada Lovelace" || resp.Data.Email != "ada@example.com" {
+        t.Errorf("stored %q <%s>", resp.Data.Name, resp.Data.Email)
+    }
+}
+
+func TestWriteJSONEscapesMarkup(t *testing.T) {
+    rec := httptest.NewRecorder()
+    writeJSON(rec, httptest.NewRequest(http.MethodGet, "/users/1", nil), http.StatusOK,
+        APIResponse{Status: "success", Data: User{Name: "<script>alert(1)</script>"}})
+    if body := rec.Body.String(); strings.Contains(body, "<script>") {
+        t.Errorf("markup written unescaped: %s", body)
+    }
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "sync"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// The saturation metrics show how close the server is to its CPU limit
+// before latency does: requests piling up in flight, connections waiting in
+// the kernel's accept queue (see listenqueue_linux.go) and keep-alive
+// connections held open.
+var (
+    httpRequestsInFlight = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "http_requests_in_flight",
+            Help: "Number of HTTP requests being served by route",
+        },
+        []string{"method", "endpoint"},
+    )
+    httpConnections = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "http_connections",
+            Help: "Number of open connections to the API port by state (new, active or idle)",
+        },
+        []string{"state"},
+    )
+    httpConnectionsAcceptedTotal = prometheus.NewCounter(
+        prometheus.CounterOpts{
+            Name: "http_connections_accepted_total",
+            Help: "Total number of connections accepted on the API port",
+        },
+    )
+)
+
+func init() {
+    prometheus.MustRegister(httpRequestsInFlight, httpConnections, httpConnectionsAcceptedTotal)
+}
+
+// connStates tracks the state of each open connection, so http_connections
+// can move a connection from one state to the next.
+var connStates = struct {
+    sync.Mutex
+    m map[net.Conn]http.ConnState
+}{m: make(map[net.Conn]http.ConnState)}
+
+// trackConnState is called by http.Server on every connection state change.
+func trackConnState(c net.Conn, state http.ConnState) {
+    connStates.Lock()
+    defer connStates.Unlock()
+    if prev, ok := connStates.m[c]; ok {
+        httpConnections.WithLabelValues(prev.String()).Dec()
+    } else if state == http.StateNew {
+        httpConnectionsAcceptedTotal.Inc()
+    }
+    switch state {
+    case http.StateClosed, http.StateHijacked:
+        delete(connStates.m, c)
+    default:
+        connStates.m[c] = state
+        httpConnections.WithLabelValues(state.String()).Inc()
+    }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Every response carries headers that keep browsers from misusing it:
+// X-Content-Type-Options: nosniff, X-Frame-Options: DENY, Referrer-Policy:
+// no-referrer and a Content-Security-Policy that allows nothing, as JSON
+// needs nothing, except under /ui/, where the dashboard may run its own
+// inline script and style and call the API. CONTENT_SECURITY_POLICY
+// replaces the policy of the API responses. Over HTTPS,
+// Strict-Transport-Security tells browsers to keep to HTTPS for HSTS_MAX_AGE
+// (default 180 days; 0 leaves it out). SECURITY_HEADERS=false turns them
+// all off, e.g. behind a proxy that sets its own.
+
+const defaultContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+
+// uiContentSecurityPolicy allows the inline script and style of the
+// dashboard by their hashes.
+var uiContentSecurityPolicy = func() string {
+    page, err := uiFiles.ReadFile("ui/index.html")
+    if err != nil {
+        panic(err)
+    }
+    return "default-src 'none'; connect-src 'self'; img-src 'self' data:; frame-ancestors 'none'" +
+        "; script-src " + inlineHashes(page, "script") +
+        "; style-src " + inlineHashes(page, "style")
+}()
+
+// inlineHashes returns the CSP hash sources of the inline elements of tag
+// in page, or 'none' if there are none.
+func inlineHashes(page []byte, tag string) string {
+    re := regexp.MustCompile(`(?s)<` + tag + `>(.*?)</` + tag + `>`)
+    var sources []string
+    for _, m := range re.FindAllSubmatch(page, -1) {
+        sum := sha256.Sum256(m[1])
+        sources = append(sources, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+    }
+    if len(sources) == 0 {
+        return "'none'"
+    }
+    return strings.Join(sources, " ")
+}
+
+// securityHeadersMiddleware sets the headers before the response is
+// written, so they are on errors and 404s too.
+func securityHeadersMiddleware(next http.Handler) http.Handler {
+    if !config.SecurityHeaders {
+        return next
+    }
+    policy := config.ContentSecurityPolicy
+    if policy == "" {
+        policy = defaultContentSecurityPolicy
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        h := w.Header()
+        h.Set("X-Content-Type-Options", "nosniff")
+        h.Set("X-Frame-Options", "DENY")
+        h.Set("Referrer-Policy", "no-referrer")
+        if strings.HasPrefix(r.URL.Path, "/ui/") {
+            h.Set("Content-Security-Policy", uiContentSecurityPolicy)
+        } else {
+            h.Set("Content-Security-Policy", policy)
+        }
+        if r.TLS != nil && config.HSTSMaxAge > 0 {
+            h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(config.HSTSMaxAge.Seconds())))
+        }
+        next.ServeHTTP(w, r)
+    })
+}
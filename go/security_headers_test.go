@@ -0,0 +1,36 @@
+package main
+
+import (
+    "crypto/tls"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+    h := securityHeadersMiddleware(http.HandlerFunc(notFoundHandler))
+
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+    if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+        t.Errorf("X-Content-Type-Options %q", got)
+    }
+    if got := rec.Header().Get("Content-Security-Policy"); got != defaultContentSecurityPolicy {
+        t.Errorf("Content-Security-Policy %q", got)
+    }
+    if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+        t.Errorf("Strict-Transport-Security %q over HTTP", got)
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+    req.TLS = &tls.ConnectionState{}
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if got := rec.Header().Get("Content-Security-Policy"); !strings.Contains(got, "script-src 'sha256-") || !strings.Contains(got, "style-src 'sha256-") {
+        t.Errorf("dashboard Content-Security-Policy %q", got)
+    }
+    if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=15552000" {
+        t.Errorf("Strict-Transport-Security %q", got)
+    }
+}
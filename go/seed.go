@@ -0,0 +1,139 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log/slog"
+    "math/rand"
+    "os"
+    "strings"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+var (
+    seedFirstNames = []string{"Ada", "Alan", "Barbara", "Claude", "Dennis", "Edsger", "Frances", "Grace", "Ken", "Linus", "Margaret", "Niklaus", "Radia", "Rob", "Sophie", "Tim"}
+    seedLastNames  = []string{"Allen", "Backus", "Cerf", "Dijkstra", "Hopper", "Kernighan", "Knuth", "Lamport", "Liskov", "Lovelace", "Perlman", "Pike", "Ritchie", "Thompson", "Turing", "Wirth"}
+    seedDomains    = []string{"example.com", "example.org", "example.net", "mail.example.com"}
+)
+
+// fakeUsers generates n users from seed. The same seed always gives the
+// same users, and every email is unique, so seeding is repeatable.
+func fakeUsers(n int, seed int64) []User {
+    rng := rand.New(rand.NewSource(seed))
+    users := make([]User, n)
+    for i := range users {
+        first := seedFirstNames[rng.Intn(len(seedFirstNames))]
+        last := seedLastNames[rng.Intn(len(seedLastNames))]
+        domain := seedDomains[rng.Intn(len(seedDomains))]
+        role := roleUser
+        if rng.Intn(10) == 0 {
+            role = roleAdmin
+        }
+        users[i] = User{
+            Name:  first + " " + last,
+            Email: fmt.Sprintf("%s.%s%d@%s", strings.ToLower(first), strings.ToLower(last), i+1, domain),
+            Roles: []string{role},
+        }
+    }
+    return users
+}
+
+// seedUsers inserts the users that are not stored yet, in one transaction,
+// and returns how many it added.
+func seedUsers(ctx context.Context, repo UserRepository, users []User) (int, error) {
+    added := 0
+    err := repo.WithTx(ctx, func(tx UserRepository) error {
+        added = 0
+        for _, user := range users {
+            _, err := tx.GetByEmail(ctx, user.Email)
+            if err == nil {
+                continue
+            }
+            if !errors.Is(err, ErrNotFound) {
+                return err
+            }
+            if _, err := tx.Insert(ctx, user); err != nil {
+                return err
+            }
+            added++
+        }
+        return nil
+    })
+    return added, err
+}
+
+// seedOnStartup seeds userRepo with SEED_USERS users generated from
+// SEED_VALUE. It is the way to seed the memory backend, which lives inside
+// the server process.
+func seedOnStartup(ctx context.Context) {
+    n := config.SeedUsers
+    if n == 0 {
+        return
+    }
+    added, err := seedUsers(ctx, userRepo, fakeUsers(n, int64(config.SeedValue)))
+    if err != nil {
+        fatal("Failed to seed users", "error", err)
+    }
+    slog.Info("Seeded demo users", "added", added, "requested", n)
+}
+
+// runSeed implements "user-api seed", which fills the configured database
+// with fake users for load tests and dashboards.
+func runSeed(args []string) int {
+    fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+    n := fs.Int("n", 100, "number of users to generate")
+    seed := fs.Int64("seed", 1, "random seed; the same seed generates the same users")
+    password := fs.String("password", "", "password given to every generated user (default none)")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+    if *n < 1 {
+        fmt.Fprintln(os.Stderr, "seed: -n must be at least 1")
+        return 2
+    }
+
+    users := fakeUsers(*n, *seed)
+    if *password != "" {
+        if err := validatePassword(*password); err != nil {
+            fmt.Fprintf(os.Stderr, "seed: -password: %v\n", err)
+            return 2
+        }
+        // One hash for everyone; bcrypt per user would dominate the run.
+        hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+            return 1
+        }
+        for i := range users {
+            users[i].PasswordHash = string(hash)
+        }
+    }
+
+    ctx := context.Background()
+    repo, err := openStorageBackend(ctx)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+        return 1
+    }
+    defer repo.Close()
+    if _, ok := repo.(*memoryUserRepository); ok {
+        fmt.Fprintln(os.Stderr, "seed: the memory backend lives in the server process; start the server with SEED_USERS instead")
+        return 1
+    }
+    keys, err := openEmailKeyring(ctx)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+        return 1
+    }
+
+    added, err := seedUsers(ctx, encryptEmails(repo, keys), users)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+        return 1
+    }
+    fmt.Printf("Added %d users (%d already present)\n", added, *n-added)
+    return 0
+}
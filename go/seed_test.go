@@ -0,0 +1,34 @@
+package main
+
+import (
+    "context"
+    "reflect"
+    "testing"
+)
+
+func TestFakeUsersDeterministic(t *testing.T) {
+    a, b := fakeUsers(50, 7), fakeUsers(50, 7)
+    if !reflect.DeepEqual(a, b) {
+        t.Fatal("the same seed generated different users")
+    }
+    if reflect.DeepEqual(a, fakeUsers(50, 8)) {
+        t.Error("different seeds generated the same users")
+    }
+    for _, user := range a {
+        if err := validateUser(user); err != nil {
+            t.Errorf("generated user %+v is invalid: %v", user, err)
+        }
+    }
+}
+
+func TestSeedUsersSkipsExisting(t *testing.T) {
+    ctx := context.Background()
+    repo := &memoryUserRepository{nextID: 1}
+    users := fakeUsers(20, 1)
+    if added, err := seedUsers(ctx, repo, users); err != nil || added != 20 {
+        t.Fatalf("first seed added %d, %v; want 20", added, err)
+    }
+    if added, err := seedUsers(ctx, repo, users); err != nil || added != 0 {
+        t.Fatalf("second seed added %d, %v; want 0", added, err)
+    }
+}
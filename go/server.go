@@ -0,0 +1,36 @@
+package main
+
+import (
+    "net/http"
+)
+
+// The servers bound how long a client may take, so slow or stalled clients
+// (slowloris) cannot hold every connection of a small container:
+//
+//   - HTTP_READ_HEADER_TIMEOUT (default 10s) to send the request headers;
+//   - HTTP_READ_TIMEOUT (default 60s) to send the whole request, e.g. a
+//     large import;
+//   - HTTP_WRITE_TIMEOUT (default 60s) from the end of the headers to the
+//     end of the response, e.g. a large export or a support bundle;
+//   - HTTP_IDLE_TIMEOUT (default 120s) between requests on a keep-alive
+//     connection;
+//   - HTTP_MAX_HEADER_BYTES (default 1 MiB, Go's) for the request line and
+//     headers, answered with 431 beyond that.
+//
+// 0 turns a timeout off; an idle timeout of 0 falls back to the read
+// timeout.
+
+// newHTTPServer returns a server for handler with the limits above, set up
+// for HTTP/2 (see http2.go).
+func newHTTPServer(handler http.Handler) *http.Server {
+    srv := &http.Server{
+        Handler:           handler,
+        ReadHeaderTimeout: config.HTTPReadHeaderTimeout,
+        ReadTimeout:       config.HTTPReadTimeout,
+        WriteTimeout:      config.HTTPWriteTimeout,
+        IdleTimeout:       config.HTTPIdleTimeout,
+        MaxHeaderBytes:    config.HTTPMaxHeaderBytes,
+    }
+    configureHTTP2(srv)
+    return srv
+}
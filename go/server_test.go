@@ -0,0 +1,91 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/tls"
+    "net"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "golang.org/x/net/http2"
+)
+
+func TestServerTimeouts(t *testing.T) {
+    defer func(old time.Duration) { config.HTTPReadHeaderTimeout = old }(config.HTTPReadHeaderTimeout)
+    defer func(old int) { config.HTTPMaxHeaderBytes = old }(config.HTTPMaxHeaderBytes)
+    config.HTTPReadHeaderTimeout = 100 * time.Millisecond
+    config.HTTPMaxHeaderBytes = 4096
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := newHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    go srv.Serve(ln)
+    defer srv.Close()
+
+    // A client that never finishes its headers is cut off.
+    conn, err := net.Dial("tcp", ln.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n"))
+    conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+    if _, err := conn.Read(make([]byte, 1)); err == nil || strings.Contains(err.Error(), "timeout") {
+        t.Errorf("stalled client not disconnected: %v", err)
+    }
+
+    conn, err = net.Dial("tcp", ln.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 8192) + "\r\n\r\n"))
+    resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+        t.Errorf("oversized headers answered %d", resp.StatusCode)
+    }
+}
+
+func TestH2C(t *testing.T) {
+    defer func(old bool) { config.H2CEnabled = old }(config.H2CEnabled)
+    config.H2CEnabled = true
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := newHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(requestProtocol(r)))
+    }))
+    go srv.Serve(ln)
+    defer srv.Close()
+
+    h2 := &http2.Transport{
+        AllowHTTP: true,
+        DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+            return (&net.Dialer{}).DialContext(ctx, network, addr)
+        },
+    }
+    for _, c := range []struct {
+        client *http.Client
+        want   string
+    }{{&http.Client{Transport: h2}, "h2c"}, {http.DefaultClient, "http/1.1"}} {
+        resp, err := c.client.Get("http://" + ln.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        buf := make([]byte, 16)
+        n, _ := resp.Body.Read(buf)
+        resp.Body.Close()
+        if got := string(buf[:n]); got != c.want {
+            t.Errorf("served over %s, want %s", got, c.want)
+        }
+    }
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "time"
+)
+
+// With SESSION_COOKIES=true, /login and /token/refresh also set the access
+// token as an HttpOnly session cookie, which authenticates the requests of
+// a browser that does not keep the token itself, and POST /logout clears
+// it. The cookie is Secure over HTTPS, or always with
+// SESSION_COOKIE_SECURE=true behind a proxy terminating TLS.
+//
+// Since browsers send the cookie with requests other sites make, mutations
+// authenticated by it are protected from cross-site request forgery twice
+// over: the cookie is SameSite=Lax, and they must echo the csrf_token
+// cookie, which safe requests are issued, in an X-CSRF-Token header, which
+// scripts of other origins can neither read nor set. Requests with a bearer
+// token or an API key are not affected.
+
+const (
+    sessionCookieName = "session"
+    csrfCookieName    = "csrf_token"
+    csrfHeader        = "X-CSRF-Token"
+)
+
+// secureCookies reports whether cookies set in reply to r are Secure.
+func secureCookies(r *http.Request) bool {
+    return r.TLS != nil || config.SessionCookieSecure
+}
+
+// setSessionCookie stores the access token of tokens in the session
+// cookie, if SESSION_COOKIES is on.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, tokens TokenResponse) {
+    if !config.SessionCookies {
+        return
+    }
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookieName,
+        Value:    tokens.AccessToken,
+        Path:     "/",
+        MaxAge:   tokens.ExpiresIn,
+        HttpOnly: true,
+        Secure:   secureCookies(r),
+        SameSite: http.SameSiteLaxMode,
+    })
+}
+
+// sessionToken returns the access token in the session cookie of r.
+func sessionToken(r *http.Request) string {
+    if !config.SessionCookies {
+        return ""
+    }
+    c, err := r.Cookie(sessionCookieName)
+    if err != nil {
+        return ""
+    }
+    return c.Value
+}
+
+// logoutHandler serves POST /logout, clearing the session cookie. The
+// access token itself stays valid until it expires.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+    for _, name := range []string{sessionCookieName, csrfCookieName} {
+        http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1, Secure: secureCookies(r)})
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// csrfMiddleware issues the csrf_token cookie on safe requests that lack
+// one, and rejects mutations authenticated by the session cookie whose
+// X-CSRF-Token header does not match it.
+func csrfMiddleware(next http.Handler) http.Handler {
+    if !config.SessionCookies {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        cookie, err := r.Cookie(csrfCookieName)
+        if !isMutating(r.Method) {
+            if err != nil || cookie.Value == "" {
+                if err := issueCSRFToken(w, r); err != nil {
+                    writeError(w, r, err)
+                    return
+                }
+            }
+            next.ServeHTTP(w, r)
+            return
+        }
+        if p, ok := principalFromContext(r.Context()); ok && p.session {
+            header := r.Header.Get(csrfHeader)
+            if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+                writeError(w, r, httpError(http.StatusForbidden, "Missing or invalid CSRF token"))
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func issueCSRFToken(w http.ResponseWriter, r *http.Request) error {
+    token, err := randomToken(32)
+    if err != nil {
+        return err
+    }
+    // Scripts of the page read it to send it back, so it is not HttpOnly.
+    http.SetCookie(w, &http.Cookie{
+        Name:     csrfCookieName,
+        Value:    token,
+        Path:     "/",
+        Secure:   secureCookies(r),
+        SameSite: http.SameSiteStrictMode,
+        Expires:  time.Now().Add(24 * time.Hour),
+    })
+    return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+func TestSessionCookieCSRF(t *testing.T) {
+    defer func(old bool) { config.SessionCookies = old }(config.SessionCookies)
+    config.SessionCookies = true
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+    if _, err := repo.Insert(context.Background(), User{Name: "Ada", Email: "ada@example.com", PasswordHash: string(hash), Roles: []string{roleAdmin}}); err != nil {
+        t.Fatal(err)
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("POST /login", loginHandler)
+    mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+        if p, ok := principalFromContext(r.Context()); !ok || !p.session {
+            w.WriteHeader(http.StatusUnauthorized)
+        }
+    })
+    h := authMiddleware(csrfMiddleware(mux))
+    do := func(method, path, body string, cookies []*http.Cookie, csrf string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, strings.NewReader(body))
+        for _, c := range cookies {
+            req.AddCookie(c)
+        }
+        if csrf != "" {
+            req.Header.Set(csrfHeader, csrf)
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        return rec
+    }
+
+    rec := do(http.MethodPost, "/login", `{"email":"ada@example.com","password":"correct horse"}`, nil, "")
+    session := rec.Result().Cookies()
+    if rec.Code != http.StatusOK || len(session) != 1 || session[0].Name != sessionCookieName || !session[0].HttpOnly {
+        t.Fatalf("login: %d, cookies %v", rec.Code, session)
+    }
+    rec = do(http.MethodGet, "/users", "", session, "")
+    csrf := rec.Result().Cookies()
+    if rec.Code != http.StatusOK || len(csrf) != 1 || csrf[0].Name != csrfCookieName {
+        t.Fatalf("GET with the session: %d, cookies %v", rec.Code, csrf)
+    }
+
+    cookies := append(session, csrf...)
+    if rec := do(http.MethodPost, "/users", "", cookies, ""); rec.Code != http.StatusForbidden {
+        t.Errorf("POST without the CSRF header: %d, want 403", rec.Code)
+    }
+    if rec := do(http.MethodPost, "/users", "", cookies, "forged"); rec.Code != http.StatusForbidden {
+        t.Errorf("POST with a wrong CSRF header: %d, want 403", rec.Code)
+    }
+    if rec := do(http.MethodPost, "/users", "", cookies, csrf[0].Value); rec.Code != http.StatusOK {
+        t.Errorf("POST with the CSRF header: %d", rec.Code)
+    }
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "math"
+    "net/http"
+    "net/netip"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Config is the settings read once at startup. Each is documented next to
+// the code that uses it. An invalid value, such as RATE_LIMIT=10O or
+// HTTP_READ_TIMEOUT=60, stops the server at startup with every invalid
+// setting listed, rather than leaving it running with a default nobody
+// asked for. Settings a reload can change (see reload.go) are kept by the
+// code that uses them instead, and checked the same way.
+type Config struct {
+    // The HTTP server: server.go, http2.go, restart.go, unix_socket.go and
+    // proxy.go.
+    HTTPReadHeaderTimeout time.Duration
+    HTTPReadTimeout       time.Duration
+    HTTPWriteTimeout      time.Duration
+    HTTPIdleTimeout       time.Duration
+    HTTPMaxHeaderBytes    int
+    HTTP2Enabled          bool
+    H2CEnabled            bool
+    HTTP2MaxStreams       int
+    ReusePort             bool
+    RestartTimeout        time.Duration
+    UnixSocketMode        fs.FileMode
+    ProxyProtocol         bool
+    TrustedProxies        []netip.Prefix
+    AdminPort             string
+    MetricsPort           string
+    MetricsUsername       string
+    MetricsPassword       string
+
+    // TLS: tls.go, mtls.go and acme.go.
+    TLSReloadInterval time.Duration
+    TLSClientCAFile   string
+    TLSClientAuth     string
+    ACMECacheDir      string
+    ACMEDirectory     string
+    ACMEEmail         string
+    ACMEHTTPAddr      string
+
+    // Browsers: security_headers.go and session.go.
+    SecurityHeaders       bool
+    ContentSecurityPolicy string
+    HSTSMaxAge            time.Duration
+    SessionCookies        bool
+    SessionCookieSecure   bool
+
+    // Authentication: auth.go, token.go, refresh.go, password_reset.go,
+    // verify.go, jwt.go, oidc.go, login_guard.go and apikeys.go.
+    AdminToken            string
+    AccessTokenTTL        time.Duration
+    RefreshTokenTTL       time.Duration
+    PasswordResetTTL      time.Duration
+    VerificationTTL       time.Duration
+    JWTIssuer             string
+    JWTAudience           string
+    JWTRolesClaim         string
+    JWTLeeway             time.Duration
+    JWTJWKSURL            string
+    JWTJWKSRefresh        time.Duration
+    JWTRoleMap            map[string]string
+    AuthRequired          bool
+    OIDCIssuerURL         string
+    OIDCClientID          string
+    LoginMaxFailures      int
+    LoginMaxFailuresPerIP int
+    LoginLockout          time.Duration
+    LoginLockoutMax       time.Duration
+    APIKeyRateLimit       int
+
+    // Responses: cache.go, envelope.go and jsonapi.go.
+    ResponseCacheTTL time.Duration
+    DefaultEnvelope  bool
+    DefaultJSONAPI   bool
+
+    // Bulk jobs: jobs.go.
+    AsyncBulkThreshold int
+    JobRetention       time.Duration
+    JobWorkers         int
+    JobQueueSize       int
+
+    // Storage: retry.go, sql_store.go, sql_replicas.go, mongo_store.go,
+    // redis_cache.go, memory_snapshot.go, outbox.go, email_encryption.go,
+    // sweeper.go and seed.go.
+    StorageRetryAttempts   int
+    StorageRetryBackoff    time.Duration
+    StorageRetryMaxBackoff time.Duration
+    DBMaxOpenConns         int
+    DBMaxIdleConns         int
+    DBConnMaxLifetime      time.Duration
+    DBConnMaxIdleTime      time.Duration
+    DBPreparedStatements   bool
+    DBReplicaMaxLag        time.Duration
+    DBReplicaCheckInterval time.Duration
+    MongoTimeout           time.Duration
+    RedisCacheTTL          time.Duration
+    MemorySnapshotInterval time.Duration
+    OutboxPollInterval     time.Duration
+    OutboxBatchSize        int
+    EmailEncryptionKey     string
+    EmailDataKeys          string
+    UserTTL                time.Duration
+    UserTTLInterval        time.Duration
+    UserTTLDryRun          bool
+    SeedUsers              int
+    SeedValue              int
+
+    // Observability: buckets.go, statsd.go, pushgateway.go, errorreport.go,
+    // pprof.go and logring.go.
+    HTTPDurationBuckets []float64
+    MetricsBackend      string
+    StatsdFlushInterval time.Duration
+    PushgatewayURL      string
+    SentryDSN           string
+    SentryEnvironment   string
+    ErrorWebhookURL     string
+    PprofEnabled        bool
+    SupportLogLines     int
+
+    // The Go runtime: lowlatency.go and memory.go.
+    LowLatency            bool
+    LowLatencyGCPercent   int
+    PreallocUsers         int
+    PreallocCache         int
+    PreallocPool          int
+    MemoryHeadroomPercent int
+
+    // Configuration itself: reload.go and features.go.
+    ConfigWatchInterval time.Duration
+    FeatureFlagsURL     string
+    FeatureFlagsRefresh time.Duration
+}
+
+// config is read while the package initializes, with its invalid settings
+// collected in startupEnv for checkConfigFile to report.
+var config = readConfig(&startupEnv)
+
+func readConfig(env *envReader) Config {
+    c := Config{
+        HTTPReadHeaderTimeout: env.duration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+        HTTPReadTimeout:       env.duration("HTTP_READ_TIMEOUT", 60*time.Second),
+        HTTPWriteTimeout:      env.duration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+        HTTPIdleTimeout:       env.duration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+        HTTPMaxHeaderBytes:    env.intAtLeast("HTTP_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes, 4096),
+        HTTP2Enabled:          env.bool("HTTP2", true),
+        H2CEnabled:            env.bool("H2C", false),
+        HTTP2MaxStreams:       env.intAtLeast("HTTP2_MAX_CONCURRENT_STREAMS", 250, 1),
+        ReusePort:             env.bool("REUSE_PORT", false),
+        RestartTimeout:        env.duration("RESTART_TIMEOUT", 30*time.Second),
+        UnixSocketMode:        env.fileMode("UNIX_SOCKET_MODE", 0o660),
+        ProxyProtocol:         env.bool("PROXY_PROTOCOL", false),
+        TrustedProxies:        env.prefixes("TRUSTED_PROXIES"),
+        AdminPort:             getenv("ADMIN_PORT"),
+        MetricsPort:           getenv("METRICS_PORT"),
+        MetricsUsername:       getenv("METRICS_USERNAME"),
+        MetricsPassword:       getenv("METRICS_PASSWORD"),
+
+        TLSReloadInterval: env.duration("TLS_RELOAD_INTERVAL", time.Minute),
+        TLSClientCAFile:   getenv("TLS_CLIENT_CA_FILE"),
+        TLSClientAuth:     getenv("TLS_CLIENT_AUTH"),
+        ACMECacheDir:      getenv("ACME_CACHE_DIR"),
+        ACMEDirectory:     getenv("ACME_DIRECTORY_URL"),
+        ACMEEmail:         getenv("ACME_EMAIL"),
+        ACMEHTTPAddr:      getenv("ACME_HTTP_ADDR"),
+
+        SecurityHeaders:       env.bool("SECURITY_HEADERS", true),
+        ContentSecurityPolicy: getenv("CONTENT_SECURITY_POLICY"),
+        HSTSMaxAge:            env.duration("HSTS_MAX_AGE", 180*24*time.Hour),
+        SessionCookies:        env.bool("SESSION_COOKIES", false),
+        SessionCookieSecure:   env.bool("SESSION_COOKIE_SECURE", false),
+
+        AdminToken:            getenv("ADMIN_TOKEN"),
+        AccessTokenTTL:        env.duration("ACCESS_TOKEN_TTL", 15*time.Minute),
+        RefreshTokenTTL:       env.duration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+        PasswordResetTTL:      env.duration("PASSWORD_RESET_TTL", time.Hour),
+        VerificationTTL:       env.duration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+        JWTIssuer:             getenv("JWT_ISSUER"),
+        JWTAudience:           getenv("JWT_AUDIENCE"),
+        JWTRolesClaim:         getenv("JWT_ROLES_CLAIM"),
+        JWTLeeway:             env.duration("JWT_LEEWAY", 30*time.Second),
+        JWTJWKSURL:            getenv("JWT_JWKS_URL"),
+        JWTJWKSRefresh:        env.duration("JWT_JWKS_REFRESH", time.Hour),
+        JWTRoleMap:            env.roleMap("JWT_ROLE_MAP"),
+        AuthRequired:          env.bool("AUTH_REQUIRED", false),
+        OIDCIssuerURL:         getenv("OIDC_ISSUER_URL"),
+        OIDCClientID:          getenv("OIDC_CLIENT_ID"),
+        LoginMaxFailures:      env.intAtLeast("LOGIN_MAX_FAILURES", 5, 1),
+        LoginMaxFailuresPerIP: env.intAtLeast("LOGIN_MAX_FAILURES_PER_IP", 20, 1),
+        LoginLockout:          env.duration("LOGIN_LOCKOUT", 30*time.Second),
+        LoginLockoutMax:       env.duration("LOGIN_LOCKOUT_MAX", 15*time.Minute),
+        APIKeyRateLimit:       env.intAtLeast("API_KEY_RATE_LIMIT", 600, 0),
+
+        ResponseCacheTTL: env.duration("RESPONSE_CACHE_TTL", 5*time.Second),
+        DefaultEnvelope:  env.oneOf("RESPONSE_ENVELOPE", "wrapped", "raw") == "wrapped",
+        DefaultJSONAPI:   env.oneOf("RESPONSE_FORMAT", "json", "jsonapi") == "jsonapi",
+
+        AsyncBulkThreshold: env.int("ASYNC_BULK_THRESHOLD", 500),
+        JobRetention:       env.duration("JOB_RETENTION", time.Hour),
+        JobWorkers:         env.intAtLeast("JOB_WORKERS", cpuLimit.procs(), 1),
+
+        StorageRetryAttempts:   env.intAtLeast("STORAGE_RETRY_ATTEMPTS", 3, 1),
+        StorageRetryBackoff:    env.duration("STORAGE_RETRY_BACKOFF", 50*time.Millisecond),
+        StorageRetryMaxBackoff: env.duration("STORAGE_RETRY_MAX_BACKOFF", time.Second),
+        DBMaxOpenConns:         env.intAtLeast("DB_MAX_OPEN_CONNS", 4*cpuLimit.procs(), 0),
+        DBConnMaxLifetime:      env.duration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+        DBConnMaxIdleTime:      env.duration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+        DBPreparedStatements:   env.bool("DB_PREPARED_STATEMENTS", true),
+        DBReplicaMaxLag:        env.duration("DB_REPLICA_MAX_LAG", 5*time.Second),
+        DBReplicaCheckInterval: env.duration("DB_REPLICA_CHECK_INTERVAL", 5*time.Second),
+        MongoTimeout:           env.duration("MONGODB_TIMEOUT", 5*time.Second),
+        RedisCacheTTL:          env.duration("REDIS_CACHE_TTL", time.Minute),
+        MemorySnapshotInterval: env.duration("MEMORY_SNAPSHOT_INTERVAL", 30*time.Second),
+        OutboxPollInterval:     env.duration("OUTBOX_POLL_INTERVAL", time.Second),
+        OutboxBatchSize:        env.intAtLeast("OUTBOX_BATCH_SIZE", 100, 1),
+        EmailEncryptionKey:     getenv("EMAIL_ENCRYPTION_KEY"),
+        EmailDataKeys:          getenv("EMAIL_DATA_KEYS"),
+        UserTTL:                env.duration("USER_TTL", 0),
+        UserTTLInterval:        env.duration("USER_TTL_INTERVAL", time.Hour),
+        UserTTLDryRun:          env.bool("USER_TTL_DRY_RUN", false),
+        SeedUsers:              env.intAtLeast("SEED_USERS", 0, 0),
+        SeedValue:              env.int("SEED_VALUE", 1),
+
+        HTTPDurationBuckets: env.buckets("HTTP_DURATION_BUCKETS", durationBucketPresets["default"]),
+        MetricsBackend:      strings.ToLower(getenv("METRICS_BACKEND")),
+        StatsdFlushInterval: env.duration("STATSD_FLUSH_INTERVAL", 10*time.Second),
+        PushgatewayURL:      getenv("PUSHGATEWAY_URL"),
+        SentryDSN:           getenv("SENTRY_DSN"),
+        SentryEnvironment:   getenv("SENTRY_ENVIRONMENT"),
+        ErrorWebhookURL:     getenv("ERROR_WEBHOOK_URL"),
+        PprofEnabled:        env.bool("PPROF_ENABLED", false),
+        SupportLogLines:     env.int("SUPPORT_LOG_LINES", 1000),
+
+        LowLatency:            env.bool("LOW_LATENCY", false),
+        LowLatencyGCPercent:   env.int("LOW_LATENCY_GC_PERCENT", 400),
+        PreallocUsers:         env.int("LOW_LATENCY_PREALLOC_USERS", 10000),
+        PreallocCache:         env.int("LOW_LATENCY_PREALLOC_CACHE", 1024),
+        PreallocPool:          env.int("LOW_LATENCY_PREALLOC_POOL", 256),
+        MemoryHeadroomPercent: env.intBetween("GOMEMLIMIT_HEADROOM_PERCENT", 10, 0, 90),
+
+        ConfigWatchInterval: env.duration("CONFIG_WATCH_INTERVAL", 0),
+        FeatureFlagsURL:     getenv("FEATURE_FLAGS_URL"),
+        FeatureFlagsRefresh: env.duration("FEATURE_FLAGS_REFRESH", 30*time.Second),
+    }
+    // Defaults that follow other settings.
+    c.JobQueueSize = env.intAtLeast("JOB_QUEUE_SIZE", 4*c.JobWorkers, 0)
+    c.DBMaxIdleConns = env.intAtLeast("DB_MAX_IDLE_CONNS", c.DBMaxOpenConns, 0)
+    return c
+}
+
+// envReader reads typed settings from the environment (see getenv),
+// collecting the invalid ones instead of falling back to their defaults,
+// so that all of them can be reported at once. An unset or empty setting
+// takes its default.
+type envReader struct {
+    errs []error
+}
+
+// startupEnv collects the invalid settings read while the package
+// initializes.
+var startupEnv envReader
+
+// invalidSetting is the error for the setting key that cannot be value, for
+// reason.
+func invalidSetting(key, value string, reason any) error {
+    return fmt.Errorf("invalid %s %q: %v", key, value, reason)
+}
+
+func (e *envReader) invalid(key, value string, reason any) {
+    e.errs = append(e.errs, invalidSetting(key, value, reason))
+}
+
+// err returns the invalid settings read so far, or nil.
+func (e *envReader) err() error {
+    return errors.Join(e.errs...)
+}
+
+// duration reads a Go duration string such as "5s".
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+    value := getenv(key)
+    if value == "" {
+        return def
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil {
+        e.invalid(key, value, "not a duration such as 30s or 5m")
+        return def
+    }
+    return d
+}
+
+func (e *envReader) int(key string, def int) int {
+    value := getenv(key)
+    if value == "" {
+        return def
+    }
+    n, err := strconv.Atoi(value)
+    if err != nil {
+        e.invalid(key, value, "not an integer")
+        return def
+    }
+    return n
+}
+
+// intAtLeast is int for settings with a lower bound.
+func (e *envReader) intAtLeast(key string, def, min int) int {
+    return e.intBetween(key, def, min, math.MaxInt)
+}
+
+// intBetween is int for settings with both bounds, inclusive.
+func (e *envReader) intBetween(key string, def, min, max int) int {
+    n := e.int(key, def)
+    switch {
+    case n < min:
+        e.invalid(key, strconv.Itoa(n), fmt.Sprintf("must be at least %d", min))
+        return def
+    case n > max:
+        e.invalid(key, strconv.Itoa(n), fmt.Sprintf("must be at most %d", max))
+        return def
+    }
+    return n
+}
+
+// bool reads a boolean such as "true", "false", "1" or "0".
+func (e *envReader) bool(key string, def bool) bool {
+    value := getenv(key)
+    if value == "" {
+        return def
+    }
+    b, err := strconv.ParseBool(value)
+    if err != nil {
+        e.invalid(key, value, "not true or false")
+        return def
+    }
+    return b
+}
+
+// oneOf reads a setting that takes one of a few values, in any case; def
+// is the first of them.
+func (e *envReader) oneOf(key, def string, others ...string) string {
+    value := strings.ToLower(getenv(key))
+    if value == "" {
+        return def
+    }
+    if value != def && !slices.Contains(others, value) {
+        e.invalid(key, value, fmt.Sprintf("must be one of %s", strings.Join(append([]string{def}, others...), ", ")))
+        return def
+    }
+    return value
+}
+
+// fileMode reads octal permissions such as 0660.
+func (e *envReader) fileMode(key string, def fs.FileMode) fs.FileMode {
+    value := getenv(key)
+    if value == "" {
+        return def
+    }
+    n, err := strconv.ParseUint(value, 8, 32)
+    if err != nil || n > 0o777 {
+        e.invalid(key, value, "not octal permissions such as 0660")
+        return def
+    }
+    return fs.FileMode(n)
+}
+
+// prefixes reads a comma-separated list of CIDRs or addresses; see
+// parsePrefixes.
+func (e *envReader) prefixes(key string) []netip.Prefix {
+    value := getenv(key)
+    prefixes, err := parsePrefixes(value)
+    if err != nil {
+        e.invalid(key, value, err)
+    }
+    return prefixes
+}
+
+// roleMap reads JWT_ROLE_MAP; see parseRoleMap.
+func (e *envReader) roleMap(key string) map[string]string {
+    value := getenv(key)
+    roles, err := parseRoleMap(value)
+    if err != nil {
+        e.invalid(key, value, err)
+    }
+    return roles
+}
+
+// buckets reads histogram buckets; see parseBuckets.
+func (e *envReader) buckets(key string, def []float64) []float64 {
+    value := getenv(key)
+    if value == "" {
+        return def
+    }
+    buckets, err := parseBuckets(value)
+    if err != nil {
+        e.invalid(key, value, err)
+        return def
+    }
+    return buckets
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReadConfig(t *testing.T) {
+    for key, value := range map[string]string{
+        "HTTP_READ_TIMEOUT":           "60",
+        "HTTP2":                       "maybe",
+        "JOB_WORKERS":                 "0",
+        "RESPONSE_ENVELOPE":           "bare",
+        "TRUSTED_PROXIES":             "10.0.0.0/8, bogus",
+        "GOMEMLIMIT_HEADROOM_PERCENT": "95",
+        "UNIX_SOCKET_MODE":            "0999",
+        "HTTP_WRITE_TIMEOUT":          "5s",
+        "RESPONSE_FORMAT":             "JSONAPI",
+        "DB_MAX_OPEN_CONNS":           "8",
+    } {
+        t.Setenv(key, value)
+    }
+    var env envReader
+    c := readConfig(&env)
+    err := env.err()
+    if err == nil {
+        t.Fatal("invalid settings accepted")
+    }
+    for _, key := range []string{"HTTP_READ_TIMEOUT", "HTTP2", "JOB_WORKERS", "RESPONSE_ENVELOPE", "TRUSTED_PROXIES", "GOMEMLIMIT_HEADROOM_PERCENT", "UNIX_SOCKET_MODE"} {
+        if !strings.Contains(err.Error(), "invalid "+key+" ") {
+            t.Errorf("%s not reported: %v", key, err)
+        }
+    }
+    if strings.Contains(err.Error(), "HTTP_WRITE_TIMEOUT") || strings.Contains(err.Error(), "RESPONSE_FORMAT") {
+        t.Errorf("valid settings reported: %v", err)
+    }
+    if c.HTTPWriteTimeout != 5*time.Second || !c.DefaultJSONAPI || c.DBMaxOpenConns != 8 || c.DBMaxIdleConns != 8 {
+        t.Errorf("valid settings not read: %+v", c)
+    }
+}
+
+func TestInvalidSettingsFailStartup(t *testing.T) {
+    defer func(errs []error) { startupEnv.errs = errs }(startupEnv.errs)
+    t.Setenv("RATE_LIMIT", "10O")
+    reloadableSetting(loadRateLimitPolicy)
+    err := checkConfigFile()
+    if err == nil || !strings.Contains(err.Error(), `invalid RATE_LIMIT "10O"`) {
+        t.Errorf("checkConfigFile: %v", err)
+    }
+}
+
+func TestInvalidSettingKeepsValue(t *testing.T) {
+    // Registered first, so it runs once the settings are restored.
+    t.Cleanup(func() { loadRateLimitPolicy() })
+    t.Setenv("RATE_LIMIT", "5")
+    t.Setenv("RATE_LIMIT_BURST", "")
+    if err := loadRateLimitPolicy(); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("RATE_LIMIT", "10O")
+    if err := loadRateLimitPolicy(); err == nil {
+        t.Error("RATE_LIMIT=10O accepted")
+    }
+    if policy := rateLimitPolicy.Load(); policy == nil || policy.rate != 5 || policy.burst != 10 {
+        t.Errorf("policy %+v, want the one before", policy)
+    }
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var slowRequestsTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "http_slow_requests_total",
+        Help: "Total number of HTTP requests that took longer than SLOW_REQUEST_THRESHOLD",
+    },
+    []string{"method", "endpoint"},
+)
+
+func init() {
+    prometheus.MustRegister(slowRequestsTotal)
+}
+
+// Requests taking longer than slowRequestThreshold are logged as warnings
+// with everything known about them, whatever the log level and access log
+// format, so tail latency shows up in the logs without tracing.
+// SLOW_REQUEST_THRESHOLD=0 turns this off.
+var slowRequestThreshold = reloadableDuration("SLOW_REQUEST_THRESHOLD", 500*time.Millisecond)
+
+// logSlowRequest warns about e if it took longer than the threshold. route
+// is the template of the matched route, for the counter.
+func logSlowRequest(ctx context.Context, e *AccessLogEntry, route string, contentLength int64, elapsed time.Duration) {
+    threshold := slowRequestThreshold.get()
+    if threshold <= 0 || elapsed <= threshold {
+        return
+    }
+    slowRequestsTotal.WithLabelValues(e.Method, route).Inc()
+    slog.LogAttrs(ctx, slog.LevelWarn, "Slow request",
+        slog.String("method", e.Method),
+        slog.String("path", e.Path),
+        slog.String("route", route),
+        slog.String("proto", e.Proto),
+        slog.Int("status", e.Status),
+        slog.Float64("latency_ms", e.LatencyMS),
+        slog.String("threshold", threshold.String()),
+        slog.Int64("request_bytes", contentLength),
+        slog.Int64("response_bytes", e.Bytes),
+        slog.String("remote_addr", e.RemoteAddr),
+        slog.String("user_agent", e.UserAgent),
+        slog.String("referer", e.Referer),
+    )
+}
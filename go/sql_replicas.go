@@ -0,0 +1,185 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log/slog"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+var (
+    dbReplicaLagSeconds = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "db_replica_lag_seconds",
+            Help: "Replication lag of each read replica at the last check",
+        },
+        []string{"replica"},
+    )
+    dbReadsTotal = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "db_reads_total",
+            Help: "Total number of user reads outside transactions by target (primary or replica)",
+        },
+        []string{"target"},
+    )
+)
+
+func init() {
+    prometheus.MustRegister(dbReplicaLagSeconds, dbReadsTotal)
+}
+
+// Replicas are checked every DB_REPLICA_CHECK_INTERVAL (default 5s) and
+// skipped while they lag more than DB_REPLICA_MAX_LAG (default 5s) or fail
+// the check, so reads fall back to the primary rather than serving data
+// that old.
+
+// replicaURLs returns the DSNs in DATABASE_REPLICA_URLS, comma-separated.
+func replicaURLs() []string {
+    var urls []string
+    for _, u := range strings.Split(getenv("DATABASE_REPLICA_URLS"), ",") {
+        if u = strings.TrimSpace(u); u != "" {
+            urls = append(urls, u)
+        }
+    }
+    return urls
+}
+
+type sqlReplica struct {
+    name    string
+    db      *sql.DB
+    q       sqlConn
+    stats   prometheus.Collector
+    healthy atomic.Bool
+}
+
+// sqlReplicas routes reads round-robin over the replicas that are currently
+// healthy.
+type sqlReplicas struct {
+    replicas []*sqlReplica
+    lag      func(ctx context.Context, db *sql.DB) (time.Duration, error)
+    next     atomic.Uint32
+    quit     chan struct{}
+    done     sync.WaitGroup
+}
+
+// openReplicas connects to each DSN with open and starts checking their lag.
+// A replica that is down at startup is an error, like the primary.
+func (s *sqlUserRepository) openReplicas(ctx context.Context, dsns []string, open func(dsn string) (*sql.DB, error)) error {
+    if len(dsns) == 0 {
+        return nil
+    }
+    if s.dialect.replicaLag == nil {
+        return fmt.Errorf("%s does not support read replicas", s.dialect.name)
+    }
+    rs := &sqlReplicas{lag: s.dialect.replicaLag, quit: make(chan struct{})}
+    for i, dsn := range dsns {
+        db, err := open(dsn)
+        if err == nil {
+            err = db.PingContext(ctx)
+            if err != nil {
+                db.Close()
+            }
+        }
+        if err != nil {
+            rs.close()
+            return fmt.Errorf("connect to replica %d: %w", i, err)
+        }
+        configurePool(db)
+        r := &sqlReplica{name: strconv.Itoa(i), db: db}
+        if r.q, err = s.prepare(ctx, db); err != nil {
+            db.Close()
+            rs.close()
+            return fmt.Errorf("replica %d: %w", i, err)
+        }
+        r.stats = collectors.NewDBStatsCollector(db, s.dialect.name+"-replica-"+r.name)
+        prometheus.MustRegister(r.stats)
+        rs.replicas = append(rs.replicas, r)
+    }
+    rs.check(ctx)
+    rs.done.Add(1)
+    go rs.run()
+    s.replicas = rs
+    slog.Info("Routing reads to replicas", "replicas", len(rs.replicas), "backend", s.dialect.name)
+    return nil
+}
+
+func (rs *sqlReplicas) run() {
+    defer rs.done.Done()
+    ticker := time.NewTicker(config.DBReplicaCheckInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            ctx, cancel := context.WithTimeout(context.Background(), config.DBReplicaCheckInterval)
+            rs.check(ctx)
+            cancel()
+        case <-rs.quit:
+            return
+        }
+    }
+}
+
+// check measures every replica and logs when one is taken out of or put
+// back into rotation.
+func (rs *sqlReplicas) check(ctx context.Context) {
+    for _, r := range rs.replicas {
+        lag, err := rs.lag(ctx, r.db)
+        healthy := err == nil && lag <= config.DBReplicaMaxLag
+        if err == nil {
+            dbReplicaLagSeconds.WithLabelValues(r.name).Set(lag.Seconds())
+        }
+        if was := r.healthy.Swap(healthy); was != healthy {
+            switch {
+            case healthy:
+                slog.Info("Replica is in rotation", "replica", r.name, "lag", lag.String())
+            case err != nil:
+                slog.Warn("Replica is out of rotation", "replica", r.name, "error", err)
+            default:
+                slog.Warn("Replica is out of rotation, lagging", "replica", r.name, "lag", lag.String(), "max_lag", config.DBReplicaMaxLag.String())
+            }
+        }
+    }
+}
+
+// pick returns a healthy replica, or nil if there is none.
+func (rs *sqlReplicas) pick() *sqlReplica {
+    n := uint32(len(rs.replicas))
+    start := rs.next.Add(1)
+    for i := uint32(0); i < n; i++ {
+        if r := rs.replicas[(start+i)%n]; r.healthy.Load() {
+            return r
+        }
+    }
+    return nil
+}
+
+func (rs *sqlReplicas) close() {
+    close(rs.quit)
+    rs.done.Wait()
+    for _, r := range rs.replicas {
+        prometheus.Unregister(r.stats)
+        r.db.Close()
+    }
+}
+
+// reader returns where reads outside a transaction go: a healthy replica,
+// or else the primary. Inside WithTx everything stays on the transaction.
+func (s *sqlUserRepository) reader() sqlQueryer {
+    if s.replicas == nil {
+        return s.q
+    }
+    if r := s.replicas.pick(); r != nil {
+        dbReadsTotal.WithLabelValues("replica").Inc()
+        return r.q
+    }
+    dbReadsTotal.WithLabelValues("primary").Inc()
+    return s.q
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "path/filepath"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// TestReplicaRouting uses a second SQLite file as the replica, with a fake
+// lag, to check reads move off a lagging replica and back.
+func TestReplicaRouting(t *testing.T) {
+    ctx := context.Background()
+    repo := openSQLiteTestRepository(t).(*sqlUserRepository)
+    var lag atomic.Int64
+    repo.dialect.replicaLag = func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+        return time.Duration(lag.Load()), nil
+    }
+    replicaPath := filepath.Join(t.TempDir(), "replica.db")
+    err := repo.openReplicas(ctx, []string{replicaPath}, func(dsn string) (*sql.DB, error) {
+        return sql.Open("sqlite", "file:"+dsn)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    replica := repo.replicas.replicas[0].db
+    // reader returns the database wrapped with its prepared statements.
+    readerDB := func() sqlQueryer { return repo.reader().(sqlConn).sqlQueryer }
+
+    if q := readerDB(); q != replica {
+        t.Fatalf("reader() = %v, want the replica", q)
+    }
+    // The read-back after a write must not hit the empty replica.
+    created, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    created.Name = "Ada Lovelace"
+    if _, err := repo.Update(ctx, created); err != nil {
+        t.Fatalf("Update read back from the replica: %v", err)
+    }
+
+    lag.Store(int64(config.DBReplicaMaxLag + time.Second))
+    repo.replicas.check(ctx)
+    if q := readerDB(); q != repo.db {
+        t.Errorf("reader() with a lagging replica = %v, want the primary", q)
+    }
+    if _, err := repo.Get(ctx, created.ID); err != nil {
+        t.Errorf("Get fell back to the primary but failed: %v", err)
+    }
+
+    lag.Store(0)
+    repo.replicas.check(ctx)
+    if q := readerDB(); q != replica {
+        t.Errorf("reader() after the replica caught up = %v, want the replica", q)
+    }
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// sqlDialect holds what differs between the SQL backends.
+type sqlDialect struct {
+    name string
+    // schema creates the users table and its indexes if missing.
+    schema []string
+    // numbered placeholders ($1, $2, ...) instead of ?.
+    numbered bool
+    // returning reports whether INSERT ... RETURNING id is supported.
+    returning bool
+    // dayExpr and domainExpr extract the UTC signup day (YYYY-MM-DD) and the
+    // lower-cased email domain for the stats queries.
+    dayExpr    string
+    domainExpr string
+    // isUniqueViolation recognises the driver's unique constraint error.
+    isUniqueViolation func(error) bool
+    // constraintViolation translates the driver's foreign key, check and
+    // value length errors, returning nil for anything else.
+    constraintViolation func(error) *ConstraintError
+    // replicaLag measures how far a read replica is behind its primary; nil
+    // if the backend has no replicas.
+    replicaLag func(ctx context.Context, db *sql.DB) (time.Duration, error)
+}
+
+// sqlUserRepository implements UserRepository on database/sql. Roles are
+// stored as a comma-separated string so the schema is portable.
+type sqlUserRepository struct {
+    db *sql.DB
+    // q runs the queries: db itself, or the transaction inside WithTx, with
+    // the hot queries prepared on db.
+    q       sqlConn
+    dialect sqlDialect
+    // stats exports the connection pool as go_sql_* metrics labelled with
+    // the dialect name while the repository is open.
+    stats prometheus.Collector
+    // replicas serve reads outside transactions if DATABASE_REPLICA_URLS
+    // is set; see sql_replicas.go.
+    replicas *sqlReplicas
+}
+
+// sqlQueryer is implemented by both *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// configurePool applies the connection pool settings DB_MAX_OPEN_CONNS,
+// DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME (default 30m) and
+// DB_CONN_MAX_IDLE_TIME (default 5m); 0 means unlimited as in database/sql.
+// Each open connection is served by the database, so by default the pool
+// grows with the CPUs this container may use rather than without bound,
+// and keeps as many idle connections as it may open.
+func configurePool(db *sql.DB) {
+    db.SetMaxOpenConns(config.DBMaxOpenConns)
+    db.SetMaxIdleConns(config.DBMaxIdleConns)
+    db.SetConnMaxLifetime(config.DBConnMaxLifetime)
+    db.SetConnMaxIdleTime(config.DBConnMaxIdleTime)
+}
+
+const userColumns = "id, name, email, password_hash, roles, verified, created_at, version"
+
+// The hot queries, which are prepared once per database unless
+// DB_PREPARED_STATEMENTS is false. Compare http_request_duration_seconds
+// with it on and off to see what preparing saves; turn it off behind
+// poolers that do not support prepared statements, such as PgBouncer in
+// transaction mode.
+const (
+    getUserQuery        = "SELECT " + userColumns + " FROM users WHERE id = ?"
+    getUserByEmailQuery = "SELECT " + userColumns + " FROM users WHERE lower(email) = lower(?)"
+    listUsersQuery      = "SELECT " + userColumns + " FROM users ORDER BY id"
+    insertUserQuery     = "INSERT INTO users (name, email, password_hash, roles, verified, created_at, version) VALUES (?, ?, ?, ?, ?, ?, ?)"
+)
+
+// sqlConn runs queries through a database or transaction, using the
+// statement prepared for a query text if there is one.
+type sqlConn struct {
+    sqlQueryer
+    // stmts maps rebound query text to its statement on the database.
+    stmts map[string]*sql.Stmt
+}
+
+// stmt returns the prepared statement for query, bound to the transaction
+// if there is one, or nil.
+func (c sqlConn) stmt(ctx context.Context, query string) *sql.Stmt {
+    stmt := c.stmts[query]
+    if stmt == nil {
+        return nil
+    }
+    if tx, ok := c.sqlQueryer.(*sql.Tx); ok {
+        return tx.StmtContext(ctx, stmt)
+    }
+    return stmt
+}
+
+func (c sqlConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+    if stmt := c.stmt(ctx, query); stmt != nil {
+        return stmt.ExecContext(ctx, args...)
+    }
+    return c.sqlQueryer.ExecContext(ctx, query, args...)
+}
+
+func (c sqlConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+    if stmt := c.stmt(ctx, query); stmt != nil {
+        return stmt.QueryContext(ctx, args...)
+    }
+    return c.sqlQueryer.QueryContext(ctx, query, args...)
+}
+
+func (c sqlConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+    if stmt := c.stmt(ctx, query); stmt != nil {
+        return stmt.QueryRowContext(ctx, args...)
+    }
+    return c.sqlQueryer.QueryRowContext(ctx, query, args...)
+}
+
+// prepare returns db with the hot queries prepared on it.
+func (s *sqlUserRepository) prepare(ctx context.Context, db *sql.DB) (sqlConn, error) {
+    conn := sqlConn{sqlQueryer: db}
+    if !config.DBPreparedStatements {
+        return conn, nil
+    }
+    insert := insertUserQuery
+    if s.dialect.returning {
+        insert += " RETURNING id"
+    }
+    conn.stmts = make(map[string]*sql.Stmt)
+    for _, query := range []string{getUserQuery, getUserByEmailQuery, listUsersQuery, insert} {
+        query = s.rebind(query)
+        stmt, err := db.PrepareContext(ctx, query)
+        if err != nil {
+            return sqlConn{}, fmt.Errorf("prepare %q: %w", query, err)
+        }
+        conn.stmts[query] = stmt
+    }
+    return conn, nil
+}
+
+// newSQLUserRepository configures the pool, creates the schema and returns
+// the repository.
+func newSQLUserRepository(ctx context.Context, db *sql.DB, dialect sqlDialect) (*sqlUserRepository, error) {
+    configurePool(db)
+    repo := &sqlUserRepository{db: db, dialect: dialect}
+    for _, stmt := range dialect.schema {
+        if _, err := db.ExecContext(ctx, stmt); err != nil {
+            return nil, err
+        }
+    }
+    // Tables created before users had a version get the column added.
+    if _, err := db.ExecContext(ctx, "SELECT version FROM users WHERE 1 = 0"); err != nil {
+        if _, err := db.ExecContext(ctx, "ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1"); err != nil {
+            return nil, err
+        }
+    }
+    q, err := repo.prepare(ctx, db)
+    if err != nil {
+        return nil, err
+    }
+    repo.q = q
+    repo.stats = collectors.NewDBStatsCollector(db, dialect.name)
+    if err := prometheus.Register(repo.stats); err != nil {
+        return nil, err
+    }
+    return repo, nil
+}
+
+// rebind rewrites ? placeholders for dialects that number them.
+func (s *sqlUserRepository) rebind(query string) string {
+    if !s.dialect.numbered {
+        return query
+    }
+    var b strings.Builder
+    n := 0
+    for _, c := range query {
+        if c == '?' {
+            n++
+            b.WriteString("$" + strconv.Itoa(n))
+            continue
+        }
+        b.WriteRune(c)
+    }
+    return b.String()
+}
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (User, error) {
+    var user User
+    var roles string
+    if err := row.Scan(&user.ID, &user.Name, &user.Email, &user.PasswordHash, &roles, &user.Verified, &user.CreatedAt, &user.Version); err != nil {
+        return User{}, err
+    }
+    if roles != "" {
+        user.Roles = strings.Split(roles, ",")
+    }
+    return user, nil
+}
+
+// translate maps driver errors onto the repository error contract.
+func (s *sqlUserRepository) translate(err error) error {
+    switch {
+    case err == nil:
+        return nil
+    case errors.Is(err, sql.ErrNoRows):
+        return ErrNotFound
+    case s.dialect.isUniqueViolation(err):
+        return errEmailTaken
+    }
+    if c := s.dialect.constraintViolation(err); c != nil {
+        return c
+    }
+    return err
+}
+
+// foreignKeyViolation and checkViolation build the errors dialects report
+// for their native violations. field is the column, or the constraint name
+// when the driver does not say which column was involved.
+func foreignKeyViolation(field string) *ConstraintError {
+    return &ConstraintError{Kind: constraintForeignKey, Field: field, Message: "referenced record does not exist"}
+}
+
+func checkViolation(field, message string) *ConstraintError {
+    return &ConstraintError{Kind: constraintCheck, Field: field, Message: message}
+}
+
+func (s *sqlUserRepository) List(ctx context.Context) ([]User, error) {
+    rows, err := s.reader().QueryContext(ctx, s.rebind(listUsersQuery))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    result := []User{}
+    for rows.Next() {
+        user, err := scanUser(rows)
+        if err != nil {
+            return nil, err
+        }
+        result = append(result, user)
+    }
+    return result, rows.Err()
+}
+
+func (s *sqlUserRepository) Get(ctx context.Context, id int) (User, error) {
+    return s.get(ctx, s.reader(), id)
+}
+
+// get reads a user through q; writes read back from the primary, which a
+// replica may not have caught up with.
+func (s *sqlUserRepository) get(ctx context.Context, q sqlQueryer, id int) (User, error) {
+    row := q.QueryRowContext(ctx, s.rebind(getUserQuery), id)
+    user, err := scanUser(row)
+    return user, s.translate(err)
+}
+
+func (s *sqlUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+    row := s.reader().QueryRowContext(ctx, s.rebind(getUserByEmailQuery), email)
+    user, err := scanUser(row)
+    return user, s.translate(err)
+}
+
+func (s *sqlUserRepository) Insert(ctx context.Context, user User) (User, error) {
+    user.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+    user.Verified = false
+    user.Version = 1
+    if len(user.Roles) == 0 {
+        user.Roles = []string{roleUser}
+    }
+    args := []interface{}{user.Name, user.Email, user.PasswordHash, strings.Join(user.Roles, ","), user.Verified, user.CreatedAt, user.Version}
+
+    if s.dialect.returning {
+        err := s.q.QueryRowContext(ctx, s.rebind(insertUserQuery+" RETURNING id"), args...).Scan(&user.ID)
+        return user, s.translate(err)
+    }
+    res, err := s.q.ExecContext(ctx, s.rebind(insertUserQuery), args...)
+    if err != nil {
+        return User{}, s.translate(err)
+    }
+    id, err := res.LastInsertId()
+    if err != nil {
+        return User{}, err
+    }
+    user.ID = int(id)
+    return user, nil
+}
+
+func (s *sqlUserRepository) Update(ctx context.Context, user User) (User, error) {
+    res, err := s.q.ExecContext(ctx, s.rebind("UPDATE users SET name = ?, email = ?, password_hash = ?, roles = ?, verified = ?, version = version + 1 WHERE id = ? AND version = ?"),
+        user.Name, user.Email, user.PasswordHash, strings.Join(user.Roles, ","), user.Verified, user.ID, user.Version)
+    if err != nil {
+        return User{}, s.translate(err)
+    }
+    if n, err := res.RowsAffected(); err == nil && n == 0 {
+        // Either the user is gone or its version moved on.
+        if _, err := s.get(ctx, s.q, user.ID); err != nil {
+            return User{}, err
+        }
+        return User{}, ErrVersionConflict
+    }
+    return s.get(ctx, s.q, user.ID)
+}
+
+func (s *sqlUserRepository) rewriteEmail(ctx context.Context, id int, from, to string) (bool, error) {
+    res, err := s.q.ExecContext(ctx, s.rebind("UPDATE users SET email = ? WHERE id = ? AND email = ?"), to, id, from)
+    if err != nil {
+        return false, s.translate(err)
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
+}
+
+func (s *sqlUserRepository) Delete(ctx context.Context, id int) error {
+    res, err := s.q.ExecContext(ctx, s.rebind("DELETE FROM users WHERE id = ?"), id)
+    if err != nil {
+        return s.translate(err)
+    }
+    if n, err := res.RowsAffected(); err == nil && n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// WithTx runs fn in a database transaction, committing if it returns nil.
+// Calls nested inside fn join the outer transaction.
+func (s *sqlUserRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    if _, ok := s.q.sqlQueryer.(*sql.Tx); ok {
+        return fn(s)
+    }
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    // Rollback is a no-op once committed, and covers fn panicking.
+    defer tx.Rollback()
+    if err := fn(&sqlUserRepository{db: s.db, q: sqlConn{tx, s.q.stmts}, dialect: s.dialect}); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// Delivered events keep their row with sent_at set, for inspection; delete
+// old ones with e.g. DELETE FROM user_events WHERE sent_at < ....
+func (s *sqlUserRepository) appendEvent(ctx context.Context, event UserEvent) error {
+    payload, err := encodeEventUser(event.User)
+    if err != nil {
+        return err
+    }
+    _, err = s.q.ExecContext(ctx, s.rebind("INSERT INTO user_events (type, user_id, payload, occurred_at) VALUES (?, ?, ?, ?)"),
+        event.Type, event.UserID, payload, event.OccurredAt)
+    return err
+}
+
+// pendingEvents reads from the primary, as a lagging replica would return
+// events already delivered.
+func (s *sqlUserRepository) pendingEvents(ctx context.Context, limit int) ([]UserEvent, error) {
+    rows, err := s.q.QueryContext(ctx, s.rebind("SELECT id, type, user_id, payload, occurred_at FROM user_events WHERE sent_at IS NULL ORDER BY id LIMIT ?"), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var events []UserEvent
+    for rows.Next() {
+        var event UserEvent
+        var payload string
+        if err := rows.Scan(&event.ID, &event.Type, &event.UserID, &payload, &event.OccurredAt); err != nil {
+            return nil, err
+        }
+        if event.User, err = decodeEventUser(payload); err != nil {
+            return nil, fmt.Errorf("event %d: %w", event.ID, err)
+        }
+        events = append(events, event)
+    }
+    return events, rows.Err()
+}
+
+func (s *sqlUserRepository) markEventsSent(ctx context.Context, ids []int64) error {
+    args := []interface{}{time.Now().UTC()}
+    for _, id := range ids {
+        args = append(args, id)
+    }
+    _, err := s.q.ExecContext(ctx, s.rebind("UPDATE user_events SET sent_at = ? WHERE id IN (?"+strings.Repeat(", ?", len(ids)-1)+")"), args...)
+    return err
+}
+
+const apiKeyColumns = "id, name, key_hash, roles, rate_limit, created_at, revoked_at"
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+    var key APIKey
+    var roles string
+    var revoked sql.NullTime
+    if err := row.Scan(&key.ID, &key.Name, &key.hash, &roles, &key.RateLimit, &key.CreatedAt, &revoked); err != nil {
+        return APIKey{}, err
+    }
+    if roles != "" {
+        key.Roles = strings.Split(roles, ",")
+    }
+    if revoked.Valid {
+        key.RevokedAt = &revoked.Time
+    }
+    return key, nil
+}
+
+func (s *sqlUserRepository) insertAPIKey(ctx context.Context, key APIKey) error {
+    _, err := s.q.ExecContext(ctx, s.rebind("INSERT INTO api_keys (id, name, key_hash, roles, rate_limit, created_at) VALUES (?, ?, ?, ?, ?, ?)"),
+        key.ID, key.Name, key.hash, strings.Join(key.Roles, ","), key.RateLimit, key.CreatedAt)
+    return err
+}
+
+// getAPIKey reads from the primary, so a revoked key stops working at once
+// rather than once the replicas catch up.
+func (s *sqlUserRepository) getAPIKey(ctx context.Context, id string) (APIKey, error) {
+    key, err := scanAPIKey(s.q.QueryRowContext(ctx, s.rebind("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?"), id))
+    return key, s.translate(err)
+}
+
+func (s *sqlUserRepository) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+    rows, err := s.q.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at, id")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var keys []APIKey
+    for rows.Next() {
+        key, err := scanAPIKey(rows)
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, key)
+    }
+    return keys, rows.Err()
+}
+
+func (s *sqlUserRepository) revokeAPIKey(ctx context.Context, id string, at time.Time) error {
+    if _, err := s.getAPIKey(ctx, id); err != nil {
+        return err
+    }
+    _, err := s.q.ExecContext(ctx, s.rebind("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"), at, id)
+    return err
+}
+
+func (s *sqlUserRepository) Ping(ctx context.Context) error {
+    return s.db.PingContext(ctx)
+}
+
+func (s *sqlUserRepository) Close() error {
+    if s.replicas != nil {
+        s.replicas.close()
+    }
+    prometheus.Unregister(s.stats)
+    return s.db.Close()
+}
+
+// Stats runs the aggregates in the database; only the per-role counts are
+// split in Go, from one row per distinct role combination.
+func (s *sqlUserRepository) Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error) {
+    stats := newUserStats(days, now)
+    q := s.reader()
+
+    err := q.QueryRowContext(ctx,
+        "SELECT COUNT(*), COALESCE(SUM(CASE WHEN verified THEN 1 ELSE 0 END), 0) FROM users",
+    ).Scan(&stats.Total, &stats.Verified)
+    if err != nil {
+        return UserStats{}, err
+    }
+
+    rows, err := q.QueryContext(ctx, "SELECT roles, COUNT(*) FROM users GROUP BY roles")
+    if err != nil {
+        return UserStats{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var roles string
+        var count int
+        if err := rows.Scan(&roles, &count); err != nil {
+            return UserStats{}, err
+        }
+        for _, role := range strings.Split(roles, ",") {
+            if role != "" {
+                stats.ByRole[role] += count
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return UserStats{}, err
+    }
+
+    index := make(map[string]int, days)
+    for i, d := range stats.SignupsPerDay {
+        index[d.Date] = i
+    }
+    rows, err = q.QueryContext(ctx, s.rebind("SELECT "+s.dialect.dayExpr+", COUNT(*) FROM users WHERE created_at >= ? GROUP BY 1"), stats.firstDay())
+    if err != nil {
+        return UserStats{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var day string
+        var count int
+        if err := rows.Scan(&day, &count); err != nil {
+            return UserStats{}, err
+        }
+        if i, ok := index[day]; ok {
+            stats.SignupsPerDay[i].Count = count
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return UserStats{}, err
+    }
+
+    rows, err = q.QueryContext(ctx, s.rebind("SELECT "+s.dialect.domainExpr+" AS domain, COUNT(*) FROM users GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT ?"), top)
+    if err != nil {
+        return UserStats{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var d DomainCount
+        if err := rows.Scan(&d.Domain, &d.Count); err != nil {
+            return UserStats{}, err
+        }
+        stats.Domains = append(stats.Domains, d)
+    }
+    return stats, rows.Err()
+}
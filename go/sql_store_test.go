@@ -0,0 +1,430 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// The checks below are shared by the backend tests: each backend opens an
+// empty repository and runs them against it.
+
+func testUserLifecycle(t *testing.T, repo UserRepository) {
+    ctx := context.Background()
+
+    created, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@example.com", PasswordHash: "hash"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if created.ID == 0 || created.Verified || len(created.Roles) != 1 || created.Roles[0] != roleUser {
+        t.Fatalf("Insert returned %+v", created)
+    }
+
+    got, err := repo.Get(ctx, created.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Email != created.Email || !got.CreatedAt.Equal(created.CreatedAt) {
+        t.Fatalf("Get returned %+v, want %+v", got, created)
+    }
+    if _, err := repo.GetByEmail(ctx, "ADA@example.com"); err != nil {
+        t.Fatalf("GetByEmail ignoring case: %v", err)
+    }
+
+    got.Name = "Ada Lovelace"
+    updated, err := repo.Update(ctx, got)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.Name != "Ada Lovelace" {
+        t.Fatalf("Update returned %+v", updated)
+    }
+
+    users, err := repo.List(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(users) != 1 {
+        t.Fatalf("List returned %d users, want 1", len(users))
+    }
+
+    if err := repo.Delete(ctx, created.ID); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := repo.Get(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+        t.Fatalf("Get after Delete: got %v, want ErrNotFound", err)
+    }
+    if err := repo.Delete(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+        t.Fatalf("Delete twice: got %v, want ErrNotFound", err)
+    }
+}
+
+func testEmailTaken(t *testing.T, repo UserRepository) {
+    ctx := context.Background()
+
+    if _, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@example.com"}); err != nil {
+        t.Fatal(err)
+    }
+    for _, email := range []string{"ada@example.com", "Ada@Example.com"} {
+        if _, err := repo.Insert(ctx, User{Name: "Other", Email: email}); !errors.Is(err, errEmailTaken) {
+            t.Errorf("Insert %s: got %v, want errEmailTaken", email, err)
+        }
+    }
+
+    other, err := repo.Insert(ctx, User{Name: "Grace", Email: "grace@example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    other.Email = "ada@example.com"
+    if _, err := repo.Update(ctx, other); !errors.Is(err, errEmailTaken) {
+        t.Errorf("Update to a taken email: got %v, want errEmailTaken", err)
+    }
+}
+
+func testUserStats(t *testing.T, repo UserRepository) {
+    ctx := context.Background()
+
+    for _, u := range []User{
+        {Name: "Ada", Email: "ada@example.com", Roles: []string{roleAdmin, roleUser}},
+        {Name: "Grace", Email: "grace@Example.com"},
+        {Name: "Linus", Email: "linus@kernel.org"},
+    } {
+        if _, err := repo.Insert(ctx, u); err != nil {
+            t.Fatal(err)
+        }
+    }
+    grace, err := repo.GetByEmail(ctx, "grace@example.com")
+    if err != nil {
+        t.Fatal(err)
+    }
+    grace.Verified = true
+    if _, err := repo.Update(ctx, grace); err != nil {
+        t.Fatal(err)
+    }
+
+    stats, err := repo.Stats(ctx, 7, 1, time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stats.Total != 3 || stats.Verified != 1 {
+        t.Errorf("Total, Verified = %d, %d, want 3, 1", stats.Total, stats.Verified)
+    }
+    if stats.ByRole[roleAdmin] != 1 || stats.ByRole[roleUser] != 3 {
+        t.Errorf("ByRole = %v, want 1 admin and 3 users", stats.ByRole)
+    }
+    if len(stats.SignupsPerDay) != 7 || stats.SignupsPerDay[6].Count != 3 {
+        t.Errorf("SignupsPerDay = %+v, want 7 days ending with 3 signups", stats.SignupsPerDay)
+    }
+    if len(stats.Domains) != 1 || stats.Domains[0] != (DomainCount{Domain: "example.com", Count: 2}) {
+        t.Errorf("Domains = %+v, want only example.com with 2", stats.Domains)
+    }
+}
+
+func testWithTx(t *testing.T, repo UserRepository) {
+    ctx := context.Background()
+    errAbort := errors.New("abort")
+
+    err := repo.WithTx(ctx, func(tx UserRepository) error {
+        if _, err := tx.Insert(ctx, User{Name: "Ada", Email: "ada@example.com"}); err != nil {
+            return err
+        }
+        if _, err := tx.GetByEmail(ctx, "ada@example.com"); err != nil {
+            t.Errorf("GetByEmail inside the transaction: %v", err)
+        }
+        return errAbort
+    })
+    if !errors.Is(err, errAbort) {
+        t.Fatalf("WithTx returned %v, want the error from fn", err)
+    }
+    if _, err := repo.GetByEmail(ctx, "ada@example.com"); !errors.Is(err, ErrNotFound) {
+        t.Fatalf("GetByEmail after rollback: got %v, want ErrNotFound", err)
+    }
+
+    var created User
+    err = repo.WithTx(ctx, func(tx UserRepository) error {
+        var err error
+        if created, err = tx.Insert(ctx, User{Name: "Ada", Email: "ada@example.com"}); err != nil {
+            return err
+        }
+        created.Verified = true
+        created, err = tx.Update(ctx, created)
+        return err
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := repo.Get(ctx, created.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !got.Verified {
+        t.Errorf("Get after commit returned %+v, want the update applied", got)
+    }
+}
+
+func testVersionConflict(t *testing.T, repo UserRepository) {
+    ctx := context.Background()
+    created, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if created.Version != 1 {
+        t.Fatalf("Insert returned version %d, want 1", created.Version)
+    }
+
+    first := created
+    first.Name = "Ada Lovelace"
+    updated, err := repo.Update(ctx, first)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if updated.Version != 2 {
+        t.Errorf("Update returned version %d, want 2", updated.Version)
+    }
+
+    stale := created
+    stale.Name = "Ada King"
+    if _, err := repo.Update(ctx, stale); !errors.Is(err, ErrVersionConflict) {
+        t.Errorf("Update with a stale version: got %v, want ErrVersionConflict", err)
+    }
+    got, err := repo.Get(ctx, created.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Name != "Ada Lovelace" || got.Version != 2 {
+        t.Errorf("Get after the conflict returned %+v", got)
+    }
+
+    stale.ID = created.ID + 1000
+    if _, err := repo.Update(ctx, stale); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Update of a missing user: got %v, want ErrNotFound", err)
+    }
+}
+
+func testOutbox(t *testing.T, backend UserRepository) {
+    defer func(old time.Duration) { config.OutboxPollInterval = old }(config.OutboxPollInterval)
+    config.OutboxPollInterval = 10 * time.Millisecond
+    ctx := context.Background()
+
+    var mu sync.Mutex
+    var received []UserEvent
+    failures := 1
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        defer mu.Unlock()
+        if failures > 0 {
+            // The first delivery fails and has to be retried.
+            failures--
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        var event UserEvent
+        if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+            t.Errorf("decode event: %v", err)
+        }
+        if got := r.Header.Get("X-Event-ID"); got != strconv.FormatInt(event.ID, 10) {
+            t.Errorf("X-Event-ID %q for event %d", got, event.ID)
+        }
+        received = append(received, event)
+    }))
+    defer srv.Close()
+
+    wrapped, err := newOutboxRepository(backend, srv.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    repo := wrapped.(*outboxRepository)
+    defer repo.dispatcher.stop()
+
+    created, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@example.com", Password: "secret"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    created.Name = "Ada Lovelace"
+    if _, err := repo.Update(ctx, created); err != nil {
+        t.Fatal(err)
+    }
+    errAbort := errors.New("abort")
+    err = repo.WithTx(ctx, func(tx UserRepository) error {
+        if _, err := tx.Insert(ctx, User{Name: "Grace", Email: "grace@example.com"}); err != nil {
+            return err
+        }
+        return errAbort
+    })
+    if !errors.Is(err, errAbort) {
+        t.Fatalf("WithTx returned %v, want the error from fn", err)
+    }
+    if err := repo.Delete(ctx, created.ID); err != nil {
+        t.Fatal(err)
+    }
+
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        mu.Lock()
+        n := len(received)
+        mu.Unlock()
+        if n >= 3 || time.Now().After(deadline) {
+            break
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    repo.dispatcher.stop()
+
+    mu.Lock()
+    defer mu.Unlock()
+    want := []string{eventUserCreated, eventUserUpdated, eventUserDeleted}
+    if len(received) != len(want) {
+        t.Fatalf("received %d events %+v, want %v", len(received), received, want)
+    }
+    for i, event := range received {
+        if event.Type != want[i] || event.UserID != created.ID {
+            t.Errorf("event %d is %s of user %d, want %s of user %d", i, event.Type, event.UserID, want[i], created.ID)
+        }
+        if i > 0 && event.ID <= received[i-1].ID {
+            t.Errorf("event IDs out of order: %d after %d", event.ID, received[i-1].ID)
+        }
+    }
+    if u := received[1].User; u == nil || u.Name != "Ada Lovelace" || u.Password != "" || u.PasswordHash != "" {
+        t.Errorf("updated event carries user %+v", u)
+    }
+    if received[2].User != nil {
+        t.Errorf("deleted event carries user %+v", received[2].User)
+    }
+    if pending, err := backend.(outboxStore).pendingEvents(ctx, 10); err != nil || len(pending) != 0 {
+        t.Errorf("pendingEvents after delivery: %d events, %v", len(pending), err)
+    }
+}
+
+func testAPIKeys(t *testing.T, backend UserRepository) {
+    ctx := context.Background()
+    store := backend.(apiKeyStore)
+
+    limit := 5
+    issued, err := issueAPIKey(ctx, store, apiKeyInput{Name: "ci", Roles: []string{roleAdmin}, RateLimit: &limit})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := issueAPIKey(ctx, store, apiKeyInput{Name: "reporting"}); err != nil {
+        t.Fatal(err)
+    }
+    got, err := store.getAPIKey(ctx, issued.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Name != "ci" || got.RateLimit != 5 || len(got.Roles) != 1 || got.Roles[0] != roleAdmin || got.RevokedAt != nil {
+        t.Fatalf("getAPIKey returned %+v", got)
+    }
+    if got.Key != "" || got.hash != hashToken(issued.Key) {
+        t.Fatalf("getAPIKey returned key %q, hash %q", got.Key, got.hash)
+    }
+
+    if err := store.revokeAPIKey(ctx, issued.ID, time.Now().UTC()); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.revokeAPIKey(ctx, "missing", time.Now().UTC()); !errors.Is(err, ErrNotFound) {
+        t.Fatalf("revokeAPIKey of an unknown key returned %v, want ErrNotFound", err)
+    }
+    keys, err := store.listAPIKeys(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(keys) != 2 || keys[0].ID != issued.ID || keys[0].RevokedAt == nil || keys[1].RevokedAt != nil || keys[1].Roles[0] != roleUser {
+        t.Fatalf("listAPIKeys returned %+v", keys)
+    }
+    if _, err := store.getAPIKey(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+        t.Fatalf("getAPIKey of an unknown key returned %v, want ErrNotFound", err)
+    }
+}
+
+func testEmailEncryption(t *testing.T, backend UserRepository) {
+    ctx := context.Background()
+    old := testEmailKeyring(t, "old")
+    repo := encryptEmails(backend, old)
+
+    ada, err := repo.Insert(ctx, User{Name: "Ada", Email: "Ada@Example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ada.Email != "ada@example.com" {
+        t.Errorf("inserted email %q", ada.Email)
+    }
+    stored, err := backend.Get(ctx, ada.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasPrefix(stored.Email, "enc:old:") || strings.Contains(stored.Email, "example") {
+        t.Fatalf("stored email %q", stored.Email)
+    }
+    if got, err := repo.GetByEmail(ctx, "ADA@example.com"); err != nil || got.ID != ada.ID {
+        t.Fatalf("GetByEmail: %+v, %v", got, err)
+    }
+    if _, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@EXAMPLE.com"}); !errors.Is(err, errEmailTaken) {
+        t.Fatalf("duplicate email: %v", err)
+    }
+    // Stored before encryption was turned on.
+    grace, err := backend.Insert(ctx, User{Name: "Grace", Email: "grace@example.org"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Rotate: a new key first, the old one kept to read.
+    current := testEmailKeyring(t, "new")
+    rotated := &emailKeyring{keys: append(current.keys, old.keys...), byID: map[string]*dataKey{}}
+    for _, key := range rotated.keys {
+        rotated.byID[key.id] = key
+    }
+    repo = encryptEmails(backend, rotated)
+    for _, email := range []string{"ada@example.com", "grace@example.org"} {
+        if _, err := repo.GetByEmail(ctx, email); err != nil {
+            t.Errorf("GetByEmail(%s) after rotation: %v", email, err)
+        }
+        if _, err := repo.Insert(ctx, User{Name: "Copy", Email: email}); !errors.Is(err, errEmailTaken) {
+            t.Errorf("duplicate %s under another key: %v", email, err)
+        }
+    }
+    stats, err := repo.Stats(ctx, 1, 10, time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(stats.Domains) != 2 || stats.Domains[0].Domain != "example.com" {
+        t.Errorf("domains %+v", stats.Domains)
+    }
+
+    versions := make(map[int]int)
+    for _, id := range []int{ada.ID, grace.ID} {
+        user, err := backend.Get(ctx, id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        versions[id] = user.Version
+    }
+    changed, err := repo.(*encryptingRepository).reencrypt(ctx)
+    if err != nil || changed != 2 {
+        t.Fatalf("reencrypt: %d, %v", changed, err)
+    }
+    for _, id := range []int{ada.ID, grace.ID} {
+        stored, err := backend.Get(ctx, id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !strings.HasPrefix(stored.Email, "enc:new:") {
+            t.Errorf("user %d stored as %q after reencrypt", id, stored.Email)
+        }
+        if stored.Version != versions[id] {
+            t.Errorf("user %d version %d after reencrypt, was %d", id, stored.Version, versions[id])
+        }
+    }
+    if changed, err := repo.(*encryptingRepository).reencrypt(ctx); err != nil || changed != 0 {
+        t.Errorf("second reencrypt: %d, %v", changed, err)
+    }
+    if got, err := encryptEmails(backend, current).GetByEmail(ctx, "grace@example.org"); err != nil || got.ID != grace.ID {
+        t.Errorf("GetByEmail without the old key: %+v, %v", got, err)
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "modernc.org/sqlite"
+    sqlite3 "modernc.org/sqlite/lib"
+)
+
+// defaultSQLitePath is relative to the working directory; in the container
+// mount a volume and point SQLITE_PATH into it.
+const defaultSQLitePath = "data/users.db"
+
+var sqliteDialect = sqlDialect{
+    name: "sqlite",
+    schema: []string{
+        `CREATE TABLE IF NOT EXISTS users (
+            id            INTEGER PRIMARY KEY AUTOINCREMENT,
+            name          TEXT NOT NULL,
+            email         TEXT NOT NULL,
+            password_hash TEXT NOT NULL DEFAULT '',
+            roles         TEXT NOT NULL DEFAULT '',
+            verified      BOOLEAN NOT NULL DEFAULT FALSE,
+            created_at    DATETIME NOT NULL,
+            version       INTEGER NOT NULL DEFAULT 1
+        )`,
+        `CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email))`,
+        `CREATE TABLE IF NOT EXISTS user_events (
+            id          INTEGER PRIMARY KEY AUTOINCREMENT,
+            type        TEXT NOT NULL,
+            user_id     INTEGER NOT NULL,
+            payload     TEXT NOT NULL DEFAULT '',
+            occurred_at DATETIME NOT NULL,
+            sent_at     DATETIME
+        )`,
+        `CREATE INDEX IF NOT EXISTS user_events_pending ON user_events (sent_at, id)`,
+        `CREATE TABLE IF NOT EXISTS api_keys (
+            id         TEXT PRIMARY KEY,
+            name       TEXT NOT NULL,
+            key_hash   TEXT NOT NULL,
+            roles      TEXT NOT NULL DEFAULT '',
+            rate_limit INTEGER NOT NULL DEFAULT 0,
+            created_at DATETIME NOT NULL,
+            revoked_at DATETIME
+        )`,
+    },
+    // The driver stores times as text in a fixed UTC layout, so strftime
+    // and plain string comparison both work on created_at.
+    dayExpr:    `strftime('%Y-%m-%d', created_at)`,
+    domainExpr: `lower(substr(email, instr(email, '@') + 1))`,
+    isUniqueViolation: func(err error) bool {
+        var sqliteErr *sqlite.Error
+        return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+    },
+    constraintViolation: func(err error) *ConstraintError {
+        var sqliteErr *sqlite.Error
+        if !errors.As(err, &sqliteErr) {
+            return nil
+        }
+        // Messages name the column or constraint before the code, e.g.
+        // "NOT NULL constraint failed: users.name (1299)"; foreign key
+        // errors name nothing.
+        field := ""
+        msg := sqliteErr.Error()
+        if i := strings.LastIndex(msg, "failed: "); i >= 0 {
+            field, _, _ = strings.Cut(msg[i+len("failed: "):], " (")
+            field = field[strings.LastIndex(field, ".")+1:]
+        }
+        switch sqliteErr.Code() {
+        case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+            return foreignKeyViolation(field)
+        case sqlite3.SQLITE_CONSTRAINT_CHECK:
+            return checkViolation(field, "value is not allowed")
+        case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+            return checkViolation(field, "value is required")
+        case sqlite3.SQLITE_TOOBIG:
+            return checkViolation(field, "value is too long")
+        }
+        return nil
+    },
+}
+
+// openSQLiteUserRepository opens (creating if needed) the database file at
+// path. WAL mode and a busy timeout let readers run alongside the single
+// writer SQLite allows.
+func openSQLiteUserRepository(ctx context.Context, path string) (UserRepository, error) {
+    if path == "" {
+        path = defaultSQLitePath
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return nil, fmt.Errorf("create sqlite directory: %w", err)
+    }
+    params := url.Values{}
+    params.Add("_pragma", "busy_timeout(5000)")
+    params.Add("_pragma", "journal_mode(WAL)")
+    params.Add("_pragma", "synchronous(NORMAL)")
+    params.Set("_time_format", "sqlite")
+    db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+    if err != nil {
+        return nil, err
+    }
+    repo, err := newSQLUserRepository(ctx, db, sqliteDialect)
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("open sqlite database %s: %w", path, err)
+    }
+    return repo, nil
+}
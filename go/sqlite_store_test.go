@@ -0,0 +1,63 @@
+package main
+
+import (
+    "context"
+    "path/filepath"
+    "testing"
+)
+
+// The SQLite driver is pure Go, so these tests always run, each against a
+// fresh database file in a temporary directory.
+
+func openSQLiteTestRepository(t *testing.T) UserRepository {
+    t.Helper()
+    repo, err := openSQLiteUserRepository(context.Background(), filepath.Join(t.TempDir(), "users.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { repo.Close() })
+    return repo
+}
+
+func TestSQLiteUserLifecycle(t *testing.T) {
+    testUserLifecycle(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteEmailTaken(t *testing.T) {
+    testEmailTaken(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteEmailEncryption(t *testing.T) {
+    testEmailEncryption(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteUserStats(t *testing.T) {
+    testUserStats(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteWithTx(t *testing.T) {
+    testWithTx(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteVersionConflict(t *testing.T) {
+    testVersionConflict(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteOutbox(t *testing.T) {
+    testOutbox(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteAPIKeys(t *testing.T) {
+    testAPIKeys(t, openSQLiteTestRepository(t))
+}
+
+func TestSQLiteWithoutPreparedStatements(t *testing.T) {
+    config.DBPreparedStatements = false
+    defer func() { config.DBPreparedStatements = true }()
+    repo := openSQLiteTestRepository(t)
+    if stmts := repo.(*sqlUserRepository).q.stmts; stmts != nil {
+        t.Fatalf("prepared %d statements with DB_PREPARED_STATEMENTS off", len(stmts))
+    }
+    testUserLifecycle(t, repo)
+    testWithTx(t, repo)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "net/http"
+    "sync"
+    "time"
+)
+
+// statsWindow is the number of one-second buckets kept for /stats.
+const statsWindow = 60
+
+type statsBucket struct {
+    second   int64
+    requests int
+    latency  time.Duration
+}
+
+// statsRecorder keeps a rolling per-second view of traffic so the UI can draw
+// request-rate and latency sparklines without a Prometheus server.
+type statsRecorder struct {
+    mu      sync.Mutex
+    started time.Time
+    total   int64
+    buckets [statsWindow]statsBucket
+}
+
+var requestStats = &statsRecorder{started: time.Now()}
+
+func (s *statsRecorder) record(duration time.Duration) {
+    now := time.Now().Unix()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    b := &s.buckets[now%statsWindow]
+    if b.second != now {
+        *b = statsBucket{second: now}
+    }
+    b.requests++
+    b.latency += duration
+    s.total++
+}
+
+type StatsPoint struct {
+    Time         time.Time `json:"time"`
+    Requests     int       `json:"requests"`
+    AvgLatencyMs float64   `json:"avg_latency_ms"`
+}
+
+type StatsSnapshot struct {
+    UptimeSeconds  float64      `json:"uptime_seconds"`
+    RequestsTotal  int64        `json:"requests_total"`
+    RequestsPerSec float64      `json:"requests_per_sec"`
+    AvgLatencyMs   float64      `json:"avg_latency_ms"`
+    Series         []StatsPoint `json:"series"`
+}
+
+// snapshot returns the last statsWindow seconds, oldest first. The current,
+// still-filling second is left out so the rate is not under-reported.
+func (s *statsRecorder) snapshot() StatsSnapshot {
+    now := time.Now().Unix()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    snap := StatsSnapshot{
+        UptimeSeconds: time.Since(s.started).Seconds(),
+        RequestsTotal: s.total,
+        Series:        make([]StatsPoint, 0, statsWindow),
+    }
+    var requests int
+    var latency time.Duration
+    for sec := now - statsWindow; sec < now; sec++ {
+        point := StatsPoint{Time: time.Unix(sec, 0).UTC()}
+        if b := s.buckets[sec%statsWindow]; b.second == sec {
+            point.Requests = b.requests
+            point.AvgLatencyMs = averageMs(b.latency, b.requests)
+            requests += b.requests
+            latency += b.latency
+        }
+        snap.Series = append(snap.Series, point)
+    }
+    snap.RequestsPerSec = float64(requests) / statsWindow
+    snap.AvgLatencyMs = averageMs(latency, requests)
+    return snap
+}
+
+func averageMs(total time.Duration, n int) float64 {
+    if n == 0 {
+        return 0
+    }
+    return float64(total) / float64(n) / float64(time.Millisecond)
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   requestStats.snapshot(),
+    })
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "log/slog"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    dto "github.com/prometheus/client_model/go"
+)
+
+// METRICS_BACKEND=statsd or dogstatsd sends the metrics over UDP to
+// STATSD_ADDR (default 127.0.0.1:8125, the Datadog agent's port) instead,
+// for stacks that do not scrape. Each request is sent as it is served:
+// http_requests_total and http_request_errors_total as counters and
+// http_request_duration as a timer in milliseconds. Everything else in the
+// Prometheus registry, such as the cache and storage metrics, is forwarded
+// every STATSD_FLUSH_INTERVAL, counters as the increase since the last flush
+// and gauges as they are; histograms are left out.
+//
+// DogStatsD gets labels as tags; plain StatsD has no tags, so their values
+// are appended to the name instead, e.g.
+// user_api.http_requests_total.GET._users_{id_[0-9]+}.200 with the default
+// STATSD_PREFIX of "user_api.".
+
+// setupMetricsBackend switches the request metrics to METRICS_BACKEND.
+func setupMetricsBackend() error {
+    switch config.MetricsBackend {
+    case "", "prometheus":
+        return nil
+    case "statsd", "dogstatsd":
+    default:
+        return fmt.Errorf("unknown METRICS_BACKEND %q (want prometheus, statsd or dogstatsd)", config.MetricsBackend)
+    }
+    addr := getenv("STATSD_ADDR")
+    if addr == "" {
+        addr = "127.0.0.1:8125"
+    }
+    prefix, ok := lookupEnv("STATSD_PREFIX")
+    if !ok {
+        prefix = "user_api."
+    }
+    client, err := newStatsdClient(addr, prefix, config.MetricsBackend == "dogstatsd")
+    if err != nil {
+        return err
+    }
+    requestMetrics = statsdRequestMetrics{client}
+    if config.StatsdFlushInterval > 0 {
+        go client.forward(prometheus.DefaultGatherer, config.StatsdFlushInterval)
+    }
+    slog.Info("Sending metrics to StatsD", "addr", addr, "flavor", config.MetricsBackend, "prefix", prefix)
+    return nil
+}
+
+// statsdMaxPacket keeps packets within a typical MTU, so they are not
+// fragmented.
+const statsdMaxPacket = 1432
+
+// statsdClient buffers metric lines into packets, sent when full and at
+// least every second.
+type statsdClient struct {
+    conn   net.Conn
+    prefix string
+    tags   bool
+
+    mu  sync.Mutex
+    buf bytes.Buffer
+    // last holds the counter values sent by the previous forward, by name
+    // and labels. Only the forward goroutine uses it.
+    last map[string]float64
+}
+
+func newStatsdClient(addr, prefix string, tags bool) (*statsdClient, error) {
+    conn, err := net.Dial("udp", addr)
+    if err != nil {
+        return nil, err
+    }
+    c := &statsdClient{conn: conn, prefix: prefix, tags: tags, last: make(map[string]float64)}
+    go func() {
+        for range time.Tick(time.Second) {
+            c.flush()
+        }
+    }()
+    return c, nil
+}
+
+func (c *statsdClient) count(name string, value float64, labels ...string) {
+    c.send(name, strconv.FormatFloat(value, 'f', -1, 64), "c", labels)
+}
+
+func (c *statsdClient) gauge(name string, value float64, labels ...string) {
+    c.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", labels)
+}
+
+func (c *statsdClient) timing(name string, d time.Duration, labels ...string) {
+    c.send(name, strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64), "ms", labels)
+}
+
+// send buffers one line; labels alternate names and values.
+func (c *statsdClient) send(name, value, kind string, labels []string) {
+    var line strings.Builder
+    line.WriteString(c.prefix)
+    line.WriteString(statsdSanitize(name))
+    if !c.tags {
+        for i := 1; i < len(labels); i += 2 {
+            line.WriteByte('.')
+            line.WriteString(statsdSanitize(labels[i]))
+        }
+    }
+    line.WriteByte(':')
+    line.WriteString(value)
+    line.WriteByte('|')
+    line.WriteString(kind)
+    if c.tags && len(labels) > 1 {
+        line.WriteString("|#")
+        for i := 0; i+1 < len(labels); i += 2 {
+            if i > 0 {
+                line.WriteByte(',')
+            }
+            line.WriteString(statsdSanitize(labels[i]))
+            line.WriteByte(':')
+            line.WriteString(statsdSanitizeTag(labels[i+1]))
+        }
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.buf.Len() > 0 && c.buf.Len()+1+line.Len() > statsdMaxPacket {
+        c.writeLocked()
+    }
+    if c.buf.Len() > 0 {
+        c.buf.WriteByte('\n')
+    }
+    c.buf.WriteString(line.String())
+}
+
+func (c *statsdClient) flush() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.writeLocked()
+}
+
+// writeLocked sends the buffered lines. UDP errors, e.g. while the agent
+// restarts, only lose those lines, so they are not reported.
+func (c *statsdClient) writeLocked() {
+    if c.buf.Len() == 0 {
+        return
+    }
+    c.conn.Write(c.buf.Bytes())
+    c.buf.Reset()
+}
+
+// statsdSanitize replaces the characters the StatsD line protocol reserves
+// in names; statsdSanitizeTag those it reserves in DogStatsD tags.
+func statsdSanitize(s string) string {
+    return replaceRunes(s, ":|,#@/ \n")
+}
+
+func statsdSanitizeTag(s string) string {
+    return replaceRunes(s, "|,\n")
+}
+
+func replaceRunes(s, reserved string) string {
+    return strings.Map(func(r rune) rune {
+        if strings.ContainsRune(reserved, r) {
+            return '_'
+        }
+        return r
+    }, s)
+}
+
+// forward sends the counters and gauges of gatherer every interval.
+func (c *statsdClient) forward(gatherer prometheus.Gatherer, interval time.Duration) {
+    for range time.Tick(interval) {
+        if err := c.forwardOnce(gatherer); err != nil {
+            slog.Warn("Failed to gather metrics for StatsD", "error", err)
+        }
+    }
+}
+
+func (c *statsdClient) forwardOnce(gatherer prometheus.Gatherer) error {
+    families, err := gatherer.Gather()
+    for _, family := range families {
+        name := family.GetName()
+        if strings.HasPrefix(name, "http_request") {
+            // Sent per request by statsdRequestMetrics.
+            continue
+        }
+        for _, m := range family.GetMetric() {
+            labels := statsdLabels(m)
+            switch family.GetType() {
+            case dto.MetricType_COUNTER:
+                key := name + "\xff" + strings.Join(labels, "\xff")
+                value := m.GetCounter().GetValue()
+                if delta := value - c.last[key]; delta > 0 {
+                    c.count(name, delta, labels...)
+                }
+                c.last[key] = value
+            case dto.MetricType_GAUGE:
+                c.gauge(name, m.GetGauge().GetValue(), labels...)
+            case dto.MetricType_UNTYPED:
+                c.gauge(name, m.GetUntyped().GetValue(), labels...)
+            }
+        }
+    }
+    c.flush()
+    return err
+}
+
+// statsdLabels returns the labels of m, which Gather sorts by name.
+func statsdLabels(m *dto.Metric) []string {
+    pairs := m.GetLabel()
+    labels := make([]string, 0, 2*len(pairs))
+    for _, p := range pairs {
+        labels = append(labels, p.GetName(), p.GetValue())
+    }
+    return labels
+}
+
+type statsdRequestMetrics struct {
+    client *statsdClient
+}
+
+func (s statsdRequestMetrics) requestServed(ctx context.Context, method, endpoint string, status int, elapsed time.Duration) {
+    s.client.count("http_requests_total", 1, "method", method, "endpoint", endpoint, "status", strconv.Itoa(status))
+    s.client.timing("http_request_duration", elapsed, "method", method, "endpoint", endpoint)
+    if class := errorClass(status); class != "" {
+        s.client.count("http_request_errors_total", 1, "method", method, "endpoint", endpoint, "class", class)
+    }
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "context"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStatsd(t *testing.T) {
+    agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer agent.Close()
+    receive := func() string {
+        agent.SetReadDeadline(time.Now().Add(2 * time.Second))
+        buf := make([]byte, statsdMaxPacket)
+        n, _, err := agent.ReadFrom(buf)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return string(buf[:n])
+    }
+
+    dog, err := newStatsdClient(agent.LocalAddr().String(), "user_api.", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    statsdRequestMetrics{dog}.requestServed(context.Background(), "GET", "/users/{id:[0-9]+}", 404, 1500*time.Microsecond)
+    dog.flush()
+    want := strings.Join([]string{
+        "user_api.http_requests_total:1|c|#method:GET,endpoint:/users/{id:[0-9]+},status:404",
+        "user_api.http_request_duration:1.5|ms|#method:GET,endpoint:/users/{id:[0-9]+}",
+        "user_api.http_request_errors_total:1|c|#method:GET,endpoint:/users/{id:[0-9]+},class:4xx",
+    }, "\n")
+    if got := receive(); got != want {
+        t.Errorf("dogstatsd packet:\n%s\nwant:\n%s", got, want)
+    }
+
+    plain, err := newStatsdClient(agent.LocalAddr().String(), "", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    registry := prometheus.NewRegistry()
+    hits := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "hits_total", Help: "Hits"}, []string{"result"})
+    queue := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_length", Help: "Queue"})
+    registry.MustRegister(hits, queue)
+    hits.WithLabelValues("hit").Add(3)
+    queue.Set(7)
+    if err := plain.forwardOnce(registry); err != nil {
+        t.Fatal(err)
+    }
+    if got, want := receive(), "hits_total.hit:3|c\nqueue_length:7|g"; got != want {
+        t.Errorf("first forward: %q, want %q", got, want)
+    }
+    hits.WithLabelValues("hit").Add(2)
+    plain.forwardOnce(registry)
+    if got, want := receive(), "hits_total.hit:2|c\nqueue_length:7|g"; got != want {
+        t.Errorf("second forward: %q, want %q", got, want)
+    }
+}
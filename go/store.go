@@ -0,0 +1,307 @@
+package main
+
+import (
+    "context"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// memoryUserRepository keeps users in a slice. It is the default backend
+// and needs no external service, but loses its data on restart unless
+// MEMORY_SNAPSHOT_PATH is set. mu guards users, nextID and version, since
+// handlers and bulk jobs use it concurrently; find and findByEmail expect the
+// caller to hold it.
+type memoryUserRepository struct {
+    mu    sync.RWMutex
+    users []User
+    // nextID is the ID given to the next inserted user. IDs are never
+    // reused, even after a delete.
+    nextID int
+    // version counts changes, so snapshots can skip an unchanged store.
+    version uint64
+    // snapshots, if set, persists the users to disk; see
+    // memory_snapshot.go.
+    snapshots *memorySnapshotter
+    // events are the undelivered outbox events; see outbox.go. Delivered
+    // ones are dropped.
+    events      []UserEvent
+    lastEventID int64
+    // apiKeys are the issued API keys; see apikeys.go. They are not part of
+    // snapshots.
+    apiKeys []APIKey
+}
+
+// newMemoryUserRepository returns a repository holding the demo users.
+func newMemoryUserRepository() *memoryUserRepository {
+    users := []User{
+        {ID: 1, Name: "Alice", Email: "alice@example.com", Roles: []string{roleAdmin}, CreatedAt: time.Now(), Version: 1},
+        {ID: 2, Name: "Bob", Email: "bob@example.com", Roles: []string{roleUser}, CreatedAt: time.Now(), Version: 1},
+    }
+    return &memoryUserRepository{users: users, nextID: len(users) + 1}
+}
+
+// reserve grows the backing slice so n users fit without reallocating.
+func (m *memoryUserRepository) reserve(n int) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if cap(m.users) < n {
+        grown := make([]User, len(m.users), n)
+        copy(grown, m.users)
+        m.users = grown
+    }
+}
+
+func (m *memoryUserRepository) List(ctx context.Context) ([]User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return append([]User(nil), m.users...), nil
+}
+
+func (m *memoryUserRepository) Get(ctx context.Context, id int) (User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    i, ok := m.find(id)
+    if !ok {
+        return User{}, ErrNotFound
+    }
+    return m.users[i], nil
+}
+
+func (m *memoryUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    i, ok := m.findByEmail(email)
+    if !ok {
+        return User{}, ErrNotFound
+    }
+    return m.users[i], nil
+}
+
+// Insert fails with a unique ConstraintError if the email is already taken.
+func (m *memoryUserRepository) Insert(ctx context.Context, user User) (User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.findByEmail(user.Email); ok {
+        return User{}, errEmailTaken
+    }
+    user.ID = m.nextID
+    m.nextID++
+    user.CreatedAt = time.Now()
+    user.Verified = false
+    user.Version = 1
+    if len(user.Roles) == 0 {
+        user.Roles = []string{roleUser}
+    }
+    m.users = append(m.users, user)
+    m.version++
+    return user, nil
+}
+
+// Update replaces the stored user with the same ID.
+func (m *memoryUserRepository) Update(ctx context.Context, user User) (User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    i, ok := m.find(user.ID)
+    if !ok {
+        return User{}, ErrNotFound
+    }
+    if m.users[i].Version != user.Version {
+        return User{}, ErrVersionConflict
+    }
+    if j, ok := m.findByEmail(user.Email); ok && j != i {
+        return User{}, errEmailTaken
+    }
+    user.Version++
+    m.users[i] = user
+    m.version++
+    return user, nil
+}
+
+func (m *memoryUserRepository) rewriteEmail(ctx context.Context, id int, from, to string) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    i, ok := m.find(id)
+    if !ok || m.users[i].Email != from {
+        return false, nil
+    }
+    m.users[i].Email = to
+    m.version++
+    return true, nil
+}
+
+func (m *memoryUserRepository) Delete(ctx context.Context, id int) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    i, ok := m.find(id)
+    if !ok {
+        return ErrNotFound
+    }
+    m.users = append(m.users[:i], m.users[i+1:]...)
+    m.version++
+    return nil
+}
+
+// WithTx runs fn against a copy of the users and swaps it in if fn succeeds.
+// The write lock is held throughout, so transactions are serialised with
+// every other access; fn must only use tx, not m.
+func (m *memoryUserRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    tx := &memoryUserRepository{users: append([]User(nil), m.users...), nextID: m.nextID, lastEventID: m.lastEventID}
+    if err := fn(tx); err != nil {
+        return err
+    }
+    m.users, m.nextID = tx.users, tx.nextID
+    m.events, m.lastEventID = append(m.events, tx.events...), tx.lastEventID
+    m.version += tx.version
+    return nil
+}
+
+func (m *memoryUserRepository) appendEvent(ctx context.Context, event UserEvent) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.lastEventID++
+    event.ID = m.lastEventID
+    m.events = append(m.events, event)
+    m.version++
+    return nil
+}
+
+func (m *memoryUserRepository) pendingEvents(ctx context.Context, limit int) ([]UserEvent, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    if len(m.events) < limit {
+        limit = len(m.events)
+    }
+    return append([]UserEvent(nil), m.events[:limit]...), nil
+}
+
+func (m *memoryUserRepository) markEventsSent(ctx context.Context, ids []int64) error {
+    sent := make(map[int64]bool, len(ids))
+    for _, id := range ids {
+        sent[id] = true
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    pending := m.events[:0]
+    for _, event := range m.events {
+        if !sent[event.ID] {
+            pending = append(pending, event)
+        }
+    }
+    m.events = pending
+    m.version++
+    return nil
+}
+
+func (m *memoryUserRepository) insertAPIKey(ctx context.Context, key APIKey) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.apiKeys = append(m.apiKeys, key)
+    return nil
+}
+
+func (m *memoryUserRepository) getAPIKey(ctx context.Context, id string) (APIKey, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    for _, key := range m.apiKeys {
+        if key.ID == id {
+            return key, nil
+        }
+    }
+    return APIKey{}, ErrNotFound
+}
+
+func (m *memoryUserRepository) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return append([]APIKey(nil), m.apiKeys...), nil
+}
+
+func (m *memoryUserRepository) revokeAPIKey(ctx context.Context, id string, at time.Time) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := range m.apiKeys {
+        if m.apiKeys[i].ID == id {
+            if m.apiKeys[i].RevokedAt == nil {
+                m.apiKeys[i].RevokedAt = &at
+            }
+            return nil
+        }
+    }
+    return ErrNotFound
+}
+
+// Close writes a final snapshot if snapshots are enabled.
+func (m *memoryUserRepository) Close() error {
+    if m.snapshots != nil {
+        return m.snapshots.stop()
+    }
+    return nil
+}
+
+// find returns the index of the user with the given ID.
+func (m *memoryUserRepository) find(id int) (int, bool) {
+    for i, user := range m.users {
+        if user.ID == id {
+            return i, true
+        }
+    }
+    return -1, false
+}
+
+// findByEmail returns the index of the user with the given email,
+// compared case-insensitively.
+func (m *memoryUserRepository) findByEmail(email string) (int, bool) {
+    for i, user := range m.users {
+        if strings.EqualFold(user.Email, email) {
+            return i, true
+        }
+    }
+    return -1, false
+}
+
+// Stats counts users overall, by role, per signup day over the last days
+// days (UTC, oldest first, including empty days) and by email domain (the
+// top entries, most common first).
+func (m *memoryUserRepository) Stats(ctx context.Context, days, top int, now time.Time) (UserStats, error) {
+    stats := newUserStats(days, now)
+    first := stats.firstDay()
+    domains := make(map[string]int)
+
+    m.mu.RLock()
+    for _, user := range m.users {
+        stats.Total++
+        if user.Verified {
+            stats.Verified++
+        }
+        for _, role := range user.Roles {
+            stats.ByRole[role]++
+        }
+        if created := user.CreatedAt.UTC(); !created.Before(first) {
+            if day := int(created.Sub(first) / (24 * time.Hour)); day < days {
+                stats.SignupsPerDay[day].Count++
+            }
+        }
+        if at := strings.LastIndex(user.Email, "@"); at >= 0 {
+            domains[strings.ToLower(user.Email[at+1:])]++
+        }
+    }
+    m.mu.RUnlock()
+
+    for domain, count := range domains {
+        stats.Domains = append(stats.Domains, DomainCount{Domain: domain, Count: count})
+    }
+    sort.Slice(stats.Domains, func(i, j int) bool {
+        if stats.Domains[i].Count != stats.Domains[j].Count {
+            return stats.Domains[i].Count > stats.Domains[j].Count
+        }
+        return stats.Domains[i].Domain < stats.Domains[j].Domain
+    })
+    if len(stats.Domains) > top {
+        stats.Domains = stats.Domains[:top]
+    }
+    return stats, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+    "sync"
+    "testing"
+)
+
+func TestMemoryUserLifecycle(t *testing.T) {
+    testUserLifecycle(t, &memoryUserRepository{nextID: 1})
+}
+
+func TestMemoryEmailTaken(t *testing.T) {
+    testEmailTaken(t, &memoryUserRepository{nextID: 1})
+}
+
+func TestMemoryEmailEncryption(t *testing.T) {
+    testEmailEncryption(t, &memoryUserRepository{nextID: 1})
+}
+
+func TestMemoryUserStats(t *testing.T) {
+    testUserStats(t, &memoryUserRepository{nextID: 1})
+}
+
+func TestMemoryWithTx(t *testing.T) {
+    testWithTx(t, &memoryUserRepository{nextID: 1})
+}
+
+func TestMemoryVersionConflict(t *testing.T) {
+    testVersionConflict(t, &memoryUserRepository{nextID: 1})
+}
+
+func TestMemoryOutbox(t *testing.T) {
+    testOutbox(t, &memoryUserRepository{nextID: 1})
+}
+
+func TestMemoryAPIKeys(t *testing.T) {
+    testAPIKeys(t, &memoryUserRepository{nextID: 1})
+}
+
+// TestMemoryConcurrentWrites inserts and reads from many goroutines, as
+// concurrent requests and bulk jobs do. Run it with -race.
+func TestMemoryConcurrentWrites(t *testing.T) {
+    repo := &memoryUserRepository{nextID: 1}
+    ctx := context.Background()
+    const writers, perWriter = 8, 50
+
+    var wg sync.WaitGroup
+    for w := 0; w < writers; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for i := 0; i < perWriter; i++ {
+                user, err := repo.Insert(ctx, User{Name: "Load", Email: fmt.Sprintf("load-%d-%d@example.com", w, i)})
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                if _, err := repo.Get(ctx, user.ID); err != nil {
+                    t.Error(err)
+                }
+                repo.List(ctx)
+            }
+        }(w)
+    }
+    wg.Wait()
+
+    users, _ := repo.List(ctx)
+    if len(users) != writers*perWriter {
+        t.Fatalf("List returned %d users, want %d", len(users), writers*perWriter)
+    }
+    seen := make(map[int]bool)
+    for _, u := range users {
+        if seen[u.ID] {
+            t.Fatalf("ID %d was assigned twice", u.ID)
+        }
+        seen[u.ID] = true
+    }
+}
+
+func TestMemorySnapshotRestore(t *testing.T) {
+    ctx := context.Background()
+    path := filepath.Join(t.TempDir(), "users.snapshot")
+
+    repo := &memoryUserRepository{nextID: 1}
+    if err := openMemorySnapshots(repo, path); err != nil {
+        t.Fatal(err)
+    }
+    created, err := repo.Insert(ctx, User{Name: "Ada", Email: "ada@example.com", PasswordHash: "hash"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := repo.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    restored := &memoryUserRepository{nextID: 1}
+    if err := openMemorySnapshots(restored, path); err != nil {
+        t.Fatal(err)
+    }
+    defer restored.Close()
+    got, err := restored.Get(ctx, created.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Email != created.Email || got.PasswordHash != "hash" {
+        t.Errorf("restored %+v, want %+v", got, created)
+    }
+    next, err := restored.Insert(ctx, User{Name: "Grace", Email: "grace@example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if next.ID <= created.ID {
+        t.Errorf("restored store reused ID %d", next.ID)
+    }
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log/slog"
+    "mime"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "regexp"
+    "runtime"
+    "runtime/debug"
+    "runtime/pprof"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// supportBundleHandler streams a tar.gz with everything needed to look into
+// a bug report: redacted configuration, recent logs, goroutine and heap
+// profiles, health, request stats and a metrics snapshot.
+func supportBundleHandler(w http.ResponseWriter, r *http.Request) {
+    files, err := collectSupportBundle()
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    name := "support-bundle-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+    w.Header().Set("Content-Type", "application/gzip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+    if err := writeTarGz(w, files); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to write support bundle", "error", err)
+    }
+}
+
+type bundleFile struct {
+    name string
+    data []byte
+}
+
+func collectSupportBundle() ([]bundleFile, error) {
+    data, err := json.MarshalIndent(bundleConfig(), "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    files := []bundleFile{
+        {"config.json", data},
+        {"logs.txt", []byte(strings.Join(redactLogLines(recentLogs.snapshot()), "\n") + "\n")},
+    }
+
+    var goroutines, heap bytes.Buffer
+    if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+        return nil, err
+    }
+    if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+        return nil, err
+    }
+    files = append(files,
+        bundleFile{"goroutines.txt", goroutines.Bytes()},
+        bundleFile{"heap.pprof", heap.Bytes()},
+        bundleFile{"health.json", captureResponse(http.HandlerFunc(healthHandler), "/health")},
+        bundleFile{"stats.json", captureResponse(http.HandlerFunc(statsHandler), "/stats")},
+        bundleFile{"metrics.txt", captureResponse(promhttp.Handler(), "/metrics")},
+    )
+    return files, nil
+}
+
+// captureResponse runs handler in-process and returns the body it writes.
+func captureResponse(handler http.Handler, path string) []byte {
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?envelope=true", nil))
+    return rec.Body.Bytes()
+}
+
+// bundleConfig describes the running binary and its environment, with
+// secrets and credentials embedded in URLs redacted.
+func bundleConfig() map[string]interface{} {
+    env := make(map[string]string)
+    for _, kv := range os.Environ() {
+        k, v, _ := strings.Cut(kv, "=")
+        env[k] = redactConfigValue(k, v)
+    }
+    summary := map[string]interface{}{
+        "generated_at": time.Now().UTC(),
+        "go_version":   runtime.Version(),
+        "os_arch":      runtime.GOOS + "/" + runtime.GOARCH,
+        "gomaxprocs":   runtime.GOMAXPROCS(0),
+        "cpu_limit":    cpuLimit.CPUs,
+        "cpu_source":   cpuLimit.Source,
+        "memory_limit": memoryLimit.Bytes,
+        "gomemlimit":   debug.SetMemoryLimit(-1),
+        "low_latency":  config.LowLatency,
+        "app_env":      profileName(),
+        "env":          env,
+    }
+    if info, ok := debug.ReadBuildInfo(); ok {
+        settings := make(map[string]string)
+        for _, s := range info.Settings {
+            settings[s.Key] = s.Value
+        }
+        summary["build"] = settings
+    }
+    return summary
+}
+
+// dsnPassword matches the password in a go-sql-driver/mysql DSN such as
+// user:pass@tcp(host:3306)/db, which url.Parse does not see as userinfo.
+var dsnPassword = regexp.MustCompile(`^([^:@/]*):.*@(\w*\()`)
+
+func redactConfigValue(key, value string) string {
+    if value == "" {
+        return value
+    }
+    if isSensitiveKey(key) {
+        return "[REDACTED]"
+    }
+    if u, err := url.Parse(value); err == nil && u.User != nil {
+        if _, ok := u.User.Password(); ok {
+            u.User = url.UserPassword(u.User.Username(), "REDACTED")
+            return u.String()
+        }
+        // A Sentry DSN carries its key as the user name.
+        if strings.HasSuffix(strings.ToUpper(key), "_DSN") {
+            u.User = url.User("REDACTED")
+            return u.String()
+        }
+    }
+    if dsnPassword.MatchString(value) {
+        return dsnPassword.ReplaceAllString(value, "${1}:REDACTED@${2}")
+    }
+    return value
+}
+
+// Log entries carry verification tokens (in the logged verification links,
+// ending at the closing quote of the JSON string) and user emails, neither
+// of which belongs in a bundle attached to a bug report.
+var (
+    logToken = regexp.MustCompile(`(token=)[^&\s"]+`)
+    logEmail = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+func redactLogLines(lines []string) []string {
+    for i, line := range lines {
+        line = logToken.ReplaceAllString(line, "${1}[REDACTED]")
+        lines[i] = logEmail.ReplaceAllString(line, "[REDACTED EMAIL]")
+    }
+    return lines
+}
+
+func writeTarGz(w io.Writer, files []bundleFile) error {
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+    now := time.Now()
+    sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+    for _, f := range files {
+        hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: now}
+        if err := tw.WriteHeader(hdr); err != nil {
+            return err
+        }
+        if _, err := tw.Write(f.data); err != nil {
+            return err
+        }
+    }
+    if err := tw.Close(); err != nil {
+        return err
+    }
+    return gz.Close()
+}
+
+// runSupportBundle implements the support-bundle subcommand, which asks a
+// running server for its bundle and saves it. The data lives in the server
+// process, so the subcommand is only a client of the admin endpoint.
+func runSupportBundle(args []string) int {
+    fs := flag.NewFlagSet("support-bundle", flag.ContinueOnError)
+    addrs, err := listenAddrs()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+        return 2
+    }
+    addr, scheme := addrs[0], "http"
+    switch {
+    case config.AdminPort != "":
+        addr = bindAddr(config.AdminPort)
+    case tlsConfigured():
+        scheme = "https"
+    }
+    base := scheme + "://" + localAddr(addr)
+    server := fs.String("url", base, "base URL of the running server")
+    token := fs.String("token", getenv("ADMIN_TOKEN"), "admin bearer token (defaults to $ADMIN_TOKEN)")
+    out := fs.String("out", "", "output file (defaults to the name suggested by the server)")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*server, "/")+"/admin/support-bundle", nil)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+        return 1
+    }
+    if *token != "" {
+        req.Header.Set("Authorization", "Bearer "+*token)
+    }
+    client := newHTTPClient(time.Minute)
+    if dial := localDial(addr); dial != nil && *server == base {
+        client.Transport = &outboundTransport{base: &http.Transport{DialContext: dial}}
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+        return 1
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        var p Problem
+        json.NewDecoder(resp.Body).Decode(&p)
+        fmt.Fprintf(os.Stderr, "support-bundle: server returned %d: %s\n", resp.StatusCode, p.Detail)
+        return 1
+    }
+
+    name := *out
+    if name == "" {
+        name = "support-bundle.tar.gz"
+        if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+            name = filepath.Base(params["filename"])
+        }
+    }
+    f, err := os.Create(name)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+        return 1
+    }
+    if _, err := io.Copy(f, resp.Body); err != nil {
+        f.Close()
+        fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+        return 1
+    }
+    if err := f.Close(); err != nil {
+        fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+        return 1
+    }
+    fmt.Fprintf(os.Stderr, "Wrote support bundle to %s\n", name)
+    return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// readBundle unpacks a tar.gz into a map of file names to contents.
+func readBundle(t *testing.T, r io.Reader) map[string]string {
+    t.Helper()
+    gz, err := gzip.NewReader(r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    files := make(map[string]string)
+    tr := tar.NewReader(gz)
+    for {
+        hdr, err := tr.Next()
+        if err == io.EOF {
+            return files
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, _ := io.ReadAll(tr)
+        files[hdr.Name] = string(data)
+    }
+}
+
+func TestSupportBundle(t *testing.T) {
+    defer func(old *logRing) { recentLogs = old }(recentLogs)
+    recentLogs = newLogRing(10)
+    recentLogs.Write([]byte(`{"msg":"Verification link","email":"ada@example.com","link":"/verify?token=abc123"}` + "\n"))
+    t.Setenv("DATABASE_URL", "postgres://app:hunter2@db:5432/users")
+    t.Setenv("MYSQL_DSN", "app:hunter2@tcp(db:3306)/users")
+    t.Setenv("JWT_HMAC_SECRET", "hunter2")
+
+    rec := httptest.NewRecorder()
+    supportBundleHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/support-bundle", nil))
+    if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" || !strings.Contains(rec.Header().Get("Content-Disposition"), ".tar.gz") {
+        t.Fatalf("%d %v", rec.Code, rec.Header())
+    }
+    files := readBundle(t, rec.Body)
+    for _, name := range []string{"config.json", "logs.txt", "goroutines.txt", "heap.pprof", "health.json", "stats.json", "metrics.txt"} {
+        if files[name] == "" {
+            t.Errorf("%s missing or empty", name)
+        }
+    }
+
+    var summary struct {
+        Env map[string]string `json:"env"`
+    }
+    if err := json.Unmarshal([]byte(files["config.json"]), &summary); err != nil {
+        t.Fatal(err)
+    }
+    for key, want := range map[string]string{
+        "DATABASE_URL":    "postgres://app:REDACTED@db:5432/users",
+        "MYSQL_DSN":       "app:REDACTED@tcp(db:3306)/users",
+        "JWT_HMAC_SECRET": "[REDACTED]",
+    } {
+        if got := summary.Env[key]; got != want {
+            t.Errorf("%s = %q, want %q", key, got, want)
+        }
+    }
+    for name, data := range files {
+        if strings.Contains(data, "hunter2") || strings.Contains(data, "ada@example.com") || strings.Contains(data, "abc123") {
+            t.Errorf("%s leaks a secret", name)
+        }
+    }
+    if !strings.Contains(files["logs.txt"], "token=[REDACTED]") {
+        t.Errorf("logs.txt: %s", files["logs.txt"])
+    }
+}
+
+func TestRunSupportBundle(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost || r.URL.Path != "/admin/support-bundle" || r.Header.Get("Authorization") != "Bearer s3cret" {
+            writeError(w, r, httpError(http.StatusUnauthorized, "Authentication required"))
+            return
+        }
+        w.Header().Set("Content-Disposition", `attachment; filename="../bundle.tar.gz"`)
+        w.Write([]byte("bundle"))
+    }))
+    defer srv.Close()
+
+    dir := t.TempDir()
+    wd, _ := os.Getwd()
+    if err := os.Chdir(dir); err != nil {
+        t.Fatal(err)
+    }
+    defer os.Chdir(wd)
+
+    if code := runSupportBundle([]string{"-url", srv.URL, "-token", "s3cret"}); code != 0 {
+        t.Fatalf("exit code %d", code)
+    }
+    // The suggested name is reduced to its base so it stays in the directory.
+    if data, err := os.ReadFile(filepath.Join(dir, "bundle.tar.gz")); err != nil || string(data) != "bundle" {
+        t.Errorf("bundle: %q, %v", data, err)
+    }
+    out := filepath.Join(dir, "out.tar.gz")
+    if code := runSupportBundle([]string{"-url", srv.URL, "-token", "s3cret", "-out", out}); code != 0 {
+        t.Fatalf("-out: exit code %d", code)
+    }
+    if _, err := os.Stat(out); err != nil {
+        t.Error(err)
+    }
+    if code := runSupportBundle([]string{"-url", srv.URL, "-token", "wrong"}); code != 1 {
+        t.Errorf("rejected token: exit code %d", code)
+    }
+}
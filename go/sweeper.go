@@ -0,0 +1,140 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "os"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var usersSweptTotal = prometheus.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "users_swept_total",
+        Help: "Total number of users past USER_TTL by action (deleted, archived, or dry_run for those only logged)",
+    },
+    []string{"action"},
+)
+
+func init() {
+    prometheus.MustRegister(usersSweptTotal)
+}
+
+// With USER_TTL set, users created longer ago than that are swept every
+// USER_TTL_INTERVAL, e.g. to clear out what visitors add to a public demo.
+// USER_TTL_ARCHIVE_PATH, if set, gets each swept user as a JSON line before
+// it is deleted; USER_TTL_DRY_RUN only logs who would be swept. Admins are
+// never swept, so the demo cannot lose its last one.
+
+// ArchivedUser is one line of USER_TTL_ARCHIVE_PATH. The password hash is
+// left out, as in the API.
+type ArchivedUser struct {
+    User
+    ArchivedAt time.Time `json:"archived_at"`
+}
+
+type userSweeper struct {
+    ttl     time.Duration
+    dryRun  bool
+    archive string
+}
+
+// startUserSweeper starts sweeping if USER_TTL is set.
+func startUserSweeper() {
+    if config.UserTTL <= 0 {
+        return
+    }
+    if config.UserTTLInterval <= 0 {
+        slog.Warn("Invalid USER_TTL_INTERVAL, not sweeping users", "value", config.UserTTLInterval.String())
+        return
+    }
+    s := &userSweeper{ttl: config.UserTTL, dryRun: config.UserTTLDryRun, archive: getenv("USER_TTL_ARCHIVE_PATH")}
+    mode := "deleting"
+    switch {
+    case s.dryRun:
+        mode = "dry run, logging"
+    case s.archive != "":
+        mode = "archiving to " + s.archive + " and deleting"
+    }
+    slog.Info("Sweeping old users", "ttl", s.ttl.String(), "interval", config.UserTTLInterval.String(), "mode", mode)
+    go func() {
+        ticker := time.NewTicker(config.UserTTLInterval)
+        defer ticker.Stop()
+        for {
+            ctx, cancel := context.WithTimeout(context.Background(), config.UserTTLInterval)
+            if n, err := s.sweep(ctx, time.Now()); err != nil {
+                slog.Error("User sweep failed", "swept", n, "error", err)
+            } else if n > 0 {
+                slog.Info("Swept old users", "swept", n, "ttl", s.ttl.String())
+            }
+            cancel()
+            <-ticker.C
+        }
+    }()
+}
+
+// sweep removes the users created before now minus the TTL and returns how
+// many it swept. A user whose delete fails after archiving stays and is
+// archived again by the next sweep.
+func (s *userSweeper) sweep(ctx context.Context, now time.Time) (int, error) {
+    users, err := userRepo.List(ctx)
+    if err != nil {
+        return 0, err
+    }
+    cutoff := now.Add(-s.ttl)
+    swept := 0
+    for _, user := range users {
+        if !user.CreatedAt.Before(cutoff) || (Principal{Roles: user.Roles}).HasRole(roleAdmin) {
+            continue
+        }
+        if s.dryRun {
+            slog.Info("Dry run: would sweep user", "user_id", user.ID, "created_at", user.CreatedAt)
+            usersSweptTotal.WithLabelValues("dry_run").Inc()
+            swept++
+            continue
+        }
+        action := "deleted"
+        if s.archive != "" {
+            if err := s.archiveUser(user, now); err != nil {
+                return swept, err
+            }
+            action = "archived"
+        }
+        if err := removeUser(ctx, user.ID); err != nil {
+            if errors.Is(err, ErrNotFound) {
+                continue
+            }
+            return swept, err
+        }
+        activities.add(ActivityEvent{UserID: user.ID, Type: activityDeleted, Actor: "ttl-sweeper", Timestamp: now.UTC()})
+        usersSweptTotal.WithLabelValues(action).Inc()
+        swept++
+    }
+    return swept, nil
+}
+
+// archiveUser appends user to the archive file and syncs it, so a user is
+// never deleted without having been archived.
+func (s *userSweeper) archiveUser(user User, now time.Time) error {
+    user.Password = ""
+    line, err := json.Marshal(ArchivedUser{User: user, ArchivedAt: now.UTC()})
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(s.archive, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestUserSweeper(t *testing.T) {
+    ctx := context.Background()
+    now := time.Now()
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    old, _ := repo.Insert(ctx, User{Name: "Old", Email: "old@example.com"})
+    admin, _ := repo.Insert(ctx, User{Name: "Admin", Email: "admin@example.com", Roles: []string{roleAdmin}})
+    fresh, _ := repo.Insert(ctx, User{Name: "Fresh", Email: "fresh@example.com"})
+    for i := range repo.users {
+        if repo.users[i].ID != fresh.ID {
+            repo.users[i].CreatedAt = now.Add(-48 * time.Hour)
+        }
+    }
+
+    dryRun := &userSweeper{ttl: 24 * time.Hour, dryRun: true}
+    if n, err := dryRun.sweep(ctx, now); err != nil || n != 1 {
+        t.Fatalf("dry run swept %d users, %v; want 1", n, err)
+    }
+    if users, _ := repo.List(ctx); len(users) != 3 {
+        t.Fatalf("dry run left %d users, want all 3", len(users))
+    }
+
+    archive := filepath.Join(t.TempDir(), "archive.jsonl")
+    sweeper := &userSweeper{ttl: 24 * time.Hour, archive: archive}
+    if n, err := sweeper.sweep(ctx, now); err != nil || n != 1 {
+        t.Fatalf("sweep swept %d users, %v; want 1", n, err)
+    }
+    if _, err := repo.Get(ctx, old.ID); err != ErrNotFound {
+        t.Errorf("old user still there: %v", err)
+    }
+    for _, id := range []int{admin.ID, fresh.ID} {
+        if _, err := repo.Get(ctx, id); err != nil {
+            t.Errorf("user %d swept: %v", id, err)
+        }
+    }
+
+    f, err := os.Open(archive)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    var lines []ArchivedUser
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        var a ArchivedUser
+        if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+            t.Fatal(err)
+        }
+        lines = append(lines, a)
+    }
+    if len(lines) != 1 || lines[0].ID != old.ID || lines[0].Email != "old@example.com" {
+        t.Errorf("archive holds %+v, want the old user", lines)
+    }
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Errors returned by the store when a team, or a user's membership in it,
+// does not exist.
+var (
+    ErrTeamNotFound       = fmt.Errorf("team %w", ErrNotFound)
+    ErrMembershipNotFound = fmt.Errorf("membership %w", ErrNotFound)
+)
+
+// teamsMu guards teams, memberships and nextTeamID. Bulk jobs remove
+// memberships from worker goroutines while handlers read them. lookupTeam,
+// dropMemberships, countMembers and the find helpers expect the caller to
+// hold it.
+var teamsMu sync.RWMutex
+
+// Teams and memberships are kept in process memory whatever STORAGE_BACKEND
+// is, so with a SQL backend users survive a restart but teams do not.
+var teams = []Team{}
+
+// Membership links a user to a team.
+type Membership struct {
+    TeamID   int
+    UserID   int
+    JoinedAt time.Time
+}
+
+var memberships []Membership
+
+// nextTeamID is the ID given to the next inserted team. IDs are never
+// reused, even after a delete.
+var nextTeamID = 1
+
+// seedDemoTeams adds the demo team with the first demo user in it. It is
+// only called for the memory backend: in a real database user 1 is whoever
+// registered first.
+func seedDemoTeams() {
+    teamsMu.Lock()
+    defer teamsMu.Unlock()
+    now := time.Now()
+    teams = append(teams, Team{ID: nextTeamID, Name: "Platform", Description: "Builds and runs the container platform", CreatedAt: now})
+    memberships = append(memberships, Membership{TeamID: nextTeamID, UserID: 1, JoinedAt: now})
+    nextTeamID++
+}
+
+// listTeams returns every team with its member count filled in.
+func listTeams() []Team {
+    teamsMu.RLock()
+    defer teamsMu.RUnlock()
+    result := make([]Team, len(teams))
+    for i, team := range teams {
+        team.MemberCount = countMembers(team.ID)
+        result[i] = team
+    }
+    return result
+}
+
+func getTeam(id int) (Team, error) {
+    teamsMu.RLock()
+    defer teamsMu.RUnlock()
+    return lookupTeam(id)
+}
+
+func lookupTeam(id int) (Team, error) {
+    i, ok := findTeam(id)
+    if !ok {
+        return Team{}, ErrTeamNotFound
+    }
+    team := teams[i]
+    team.MemberCount = countMembers(id)
+    return team, nil
+}
+
+// insertTeam assigns the next ID and creation time and appends the team.
+// It fails with a unique ConstraintError if the name is already taken.
+func insertTeam(team Team) (Team, error) {
+    teamsMu.Lock()
+    defer teamsMu.Unlock()
+    if _, ok := findTeamByName(team.Name); ok {
+        return Team{}, &ConstraintError{Kind: constraintUnique, Field: "name", Message: "team name is already taken"}
+    }
+    team.ID = nextTeamID
+    nextTeamID++
+    team.CreatedAt = time.Now()
+    team.MemberCount = 0
+    teams = append(teams, team)
+    return team, nil
+}
+
+// updateTeam replaces the name and description of the team with the same ID.
+func updateTeam(team Team) (Team, error) {
+    teamsMu.Lock()
+    defer teamsMu.Unlock()
+    i, ok := findTeam(team.ID)
+    if !ok {
+        return Team{}, ErrTeamNotFound
+    }
+    if j, ok := findTeamByName(team.Name); ok && j != i {
+        return Team{}, &ConstraintError{Kind: constraintUnique, Field: "name", Message: "team name is already taken"}
+    }
+    teams[i].Name = team.Name
+    teams[i].Description = team.Description
+    return lookupTeam(team.ID)
+}
+
+// deleteTeam removes a team together with its memberships.
+func deleteTeam(id int) error {
+    teamsMu.Lock()
+    defer teamsMu.Unlock()
+    i, ok := findTeam(id)
+    if !ok {
+        return ErrTeamNotFound
+    }
+    teams = append(teams[:i], teams[i+1:]...)
+    dropMemberships(func(m Membership) bool { return m.TeamID == id })
+    return nil
+}
+
+// teamMembers returns the users in a team, in the order they joined.
+func teamMembers(ctx context.Context, teamID int) ([]User, error) {
+    // The users are looked up after releasing the lock, so a slow backend
+    // does not hold up writers.
+    teamsMu.RLock()
+    if _, ok := findTeam(teamID); !ok {
+        teamsMu.RUnlock()
+        return nil, ErrTeamNotFound
+    }
+    var userIDs []int
+    for _, m := range memberships {
+        if m.TeamID == teamID {
+            userIDs = append(userIDs, m.UserID)
+        }
+    }
+    teamsMu.RUnlock()
+
+    members := []User{}
+    for _, userID := range userIDs {
+        user, err := userRepo.Get(ctx, userID)
+        if errors.Is(err, ErrNotFound) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        members = append(members, user)
+    }
+    return members, nil
+}
+
+// addTeamMember links a user to a team. The user must exist, which is
+// reported as a foreign key ConstraintError.
+func addTeamMember(ctx context.Context, teamID, userID int) error {
+    if _, err := getTeam(teamID); err != nil {
+        return err
+    }
+    if _, err := userRepo.Get(ctx, userID); errors.Is(err, ErrNotFound) {
+        return &ConstraintError{Kind: constraintForeignKey, Field: "user_id", Message: "user does not exist"}
+    } else if err != nil {
+        return err
+    }
+
+    teamsMu.Lock()
+    defer teamsMu.Unlock()
+    // The team may have been deleted while the user was looked up.
+    if _, ok := findTeam(teamID); !ok {
+        return ErrTeamNotFound
+    }
+    for _, m := range memberships {
+        if m.TeamID == teamID && m.UserID == userID {
+            return nil
+        }
+    }
+    memberships = append(memberships, Membership{TeamID: teamID, UserID: userID, JoinedAt: time.Now()})
+    return nil
+}
+
+func removeTeamMember(teamID, userID int) error {
+    teamsMu.Lock()
+    defer teamsMu.Unlock()
+    if _, ok := findTeam(teamID); !ok {
+        return ErrTeamNotFound
+    }
+    removed := dropMemberships(func(m Membership) bool { return m.TeamID == teamID && m.UserID == userID })
+    if removed == 0 {
+        return ErrMembershipNotFound
+    }
+    return nil
+}
+
+// removeMemberships drops every membership matching fn and reports how
+// many were removed.
+func removeMemberships(fn func(Membership) bool) int {
+    teamsMu.Lock()
+    defer teamsMu.Unlock()
+    return dropMemberships(fn)
+}
+
+func dropMemberships(fn func(Membership) bool) int {
+    kept := memberships[:0]
+    for _, m := range memberships {
+        if !fn(m) {
+            kept = append(kept, m)
+        }
+    }
+    removed := len(memberships) - len(kept)
+    memberships = kept
+    return removed
+}
+
+func countMembers(teamID int) int {
+    n := 0
+    for _, m := range memberships {
+        if m.TeamID == teamID {
+            n++
+        }
+    }
+    return n
+}
+
+// findTeam returns the index of the team with the given ID.
+func findTeam(id int) (int, bool) {
+    for i, team := range teams {
+        if team.ID == id {
+            return i, true
+        }
+    }
+    return -1, false
+}
+
+// findTeamByName returns the index of the team with the given name,
+// compared case-insensitively.
+func findTeamByName(name string) (int, bool) {
+    for i, team := range teams {
+        if strings.EqualFold(team.Name, name) {
+            return i, true
+        }
+    }
+    return -1, false
+}
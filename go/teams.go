@@ -0,0 +1,150 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// maxTeamNameLength bounds team names so they fit in list views.
+const maxTeamNameLength = 100
+
+type Team struct {
+    ID          int       `json:"id"`
+    Name        string    `json:"name"`
+    Description string    `json:"description,omitempty"`
+    MemberCount int       `json:"member_count"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+func (t Team) jsonAPIType() string { return "teams" }
+func (t Team) jsonAPIID() string   { return strconv.Itoa(t.ID) }
+
+// validateTeam checks the fields a client is required to supply.
+func validateTeam(team Team) error {
+    verr := &ValidationError{}
+    name := strings.TrimSpace(team.Name)
+    if name == "" {
+        verr.add("name", "name is required")
+    } else if len(name) > maxTeamNameLength {
+        verr.add("name", "name must be at most 100 characters")
+    }
+    if len(verr.Fields) > 0 {
+        return verr
+    }
+    return nil
+}
+
+func getTeamsHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   listTeams(),
+    })
+}
+
+func getTeamHandler(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+    team, err := getTeam(id)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   team,
+    })
+}
+
+func createTeamHandler(w http.ResponseWriter, r *http.Request) {
+    var team Team
+    if err := json.NewDecoder(r.Body).Decode(&team); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    if err := validateTeam(team); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    team, err := insertTeam(Team{Name: strings.TrimSpace(team.Name), Description: team.Description})
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    writeJSON(w, r, http.StatusCreated, APIResponse{
+        Status: "success",
+        Data:   team,
+    })
+}
+
+func updateTeamHandler(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+    var input Team
+    if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+        writeError(w, r, httpError(http.StatusBadRequest, "Invalid JSON"))
+        return
+    }
+    if err := validateTeam(input); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    team, err := updateTeam(Team{ID: id, Name: strings.TrimSpace(input.Name), Description: input.Description})
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   team,
+    })
+}
+
+func deleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+    if err := deleteTeam(id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// getTeamMembersHandler lists the users that belong to a team.
+func getTeamMembersHandler(w http.ResponseWriter, r *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(r)["id"])
+    members, err := teamMembers(r.Context(), id)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   members,
+    })
+}
+
+// addTeamMemberHandler adds a user to a team. Adding an existing member
+// is a no-op, so the request can be retried safely.
+func addTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, _ := strconv.Atoi(vars["id"])
+    userID, _ := strconv.Atoi(vars["user_id"])
+    if err := addTeamMember(r.Context(), id, userID); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func removeTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, _ := strconv.Atoi(vars["id"])
+    userID, _ := strconv.Atoi(vars["user_id"])
+    if err := removeTeamMember(id, userID); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
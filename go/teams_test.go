@@ -0,0 +1,112 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/gorilla/mux"
+)
+
+func TestTeams(t *testing.T) {
+    defer func(oldTeams []Team, oldMemberships []Membership, oldNext int) {
+        teams, memberships, nextTeamID = oldTeams, oldMemberships, oldNext
+    }(teams, memberships, nextTeamID)
+    teams, memberships, nextTeamID = []Team{}, nil, 1
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    ada, _ := repo.Insert(context.Background(), User{Name: "Ada", Email: "ada@example.com"})
+    bob, _ := repo.Insert(context.Background(), User{Name: "Bob", Email: "bob@example.com"})
+
+    r := mux.NewRouter()
+    r.HandleFunc("/teams", getTeamsHandler).Methods("GET")
+    r.HandleFunc("/teams", createTeamHandler).Methods("POST")
+    r.HandleFunc("/teams/{id:[0-9]+}", getTeamHandler).Methods("GET")
+    r.HandleFunc("/teams/{id:[0-9]+}", updateTeamHandler).Methods("PUT")
+    r.HandleFunc("/teams/{id:[0-9]+}", deleteTeamHandler).Methods("DELETE")
+    r.HandleFunc("/teams/{id:[0-9]+}/members", getTeamMembersHandler).Methods("GET")
+    r.HandleFunc("/teams/{id:[0-9]+}/members/{user_id:[0-9]+}", addTeamMemberHandler).Methods("PUT")
+    r.HandleFunc("/teams/{id:[0-9]+}/members/{user_id:[0-9]+}", removeTeamMemberHandler).Methods("DELETE")
+    do := func(method, path, body string, data interface{}) int {
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+        if data != nil {
+            json.Unmarshal(rec.Body.Bytes(), &APIResponse{Data: data})
+        }
+        return rec.Code
+    }
+
+    var team Team
+    if code := do(http.MethodPost, "/teams", `{"name":" Platform ","description":"Runs the cluster"}`, &team); code != http.StatusCreated || team.ID != 1 || team.Name != "Platform" {
+        t.Fatalf("create: %d %+v", code, team)
+    }
+    for _, tc := range []struct {
+        body string
+        want int
+    }{
+        {`{"name":"Platform"}`, http.StatusConflict},
+        {`{"name":"  "}`, http.StatusUnprocessableEntity},
+        {`{"name":"` + strings.Repeat("x", 101) + `"}`, http.StatusUnprocessableEntity},
+        {`{"name":`, http.StatusBadRequest},
+    } {
+        if code := do(http.MethodPost, "/teams", tc.body, nil); code != tc.want {
+            t.Errorf("create %.30s: %d, want %d", tc.body, code, tc.want)
+        }
+    }
+    do(http.MethodPost, "/teams", `{"name":"Data"}`, nil)
+    if code := do(http.MethodPut, "/teams/1", `{"name":"Data"}`, nil); code != http.StatusConflict {
+        t.Errorf("rename to a taken name: %d", code)
+    }
+    if code := do(http.MethodPut, "/teams/1", `{"name":"Platform","description":"Builds the cluster"}`, &team); code != http.StatusOK || team.Description != "Builds the cluster" {
+        t.Errorf("update: %d %+v", code, team)
+    }
+
+    // Adding a member twice is a no-op.
+    for _, path := range []string{"/teams/1/members/1", "/teams/1/members/1", "/teams/1/members/2"} {
+        if code := do(http.MethodPut, path, "", nil); code != http.StatusNoContent {
+            t.Errorf("add %s: %d", path, code)
+        }
+    }
+    if code := do(http.MethodPut, "/teams/1/members/9", "", nil); code != http.StatusUnprocessableEntity {
+        t.Errorf("add unknown user: %d", code)
+    }
+    if code := do(http.MethodPut, "/teams/9/members/1", "", nil); code != http.StatusNotFound {
+        t.Errorf("add to unknown team: %d", code)
+    }
+    var members []User
+    if code := do(http.MethodGet, "/teams/1/members", "", &members); code != http.StatusOK || len(members) != 2 || members[0].ID != ada.ID || members[1].ID != bob.ID {
+        t.Errorf("members: %d %+v", code, members)
+    }
+    if code := do(http.MethodGet, "/teams/1", "", &team); code != http.StatusOK || team.MemberCount != 2 {
+        t.Errorf("get: %d %+v", code, team)
+    }
+
+    if code := do(http.MethodDelete, "/teams/1/members/1", "", nil); code != http.StatusNoContent {
+        t.Errorf("remove member: %d", code)
+    }
+    if code := do(http.MethodDelete, "/teams/1/members/1", "", nil); code != http.StatusNotFound {
+        t.Errorf("remove a non-member: %d", code)
+    }
+    // Deleting a user drops their memberships.
+    if err := removeUser(context.Background(), bob.ID); err != nil {
+        t.Fatal(err)
+    }
+    var list []Team
+    if code := do(http.MethodGet, "/teams", "", &list); code != http.StatusOK || len(list) != 2 || list[0].MemberCount != 0 {
+        t.Errorf("list: %d %+v", code, list)
+    }
+
+    if code := do(http.MethodDelete, "/teams/1", "", nil); code != http.StatusNoContent {
+        t.Errorf("delete: %d", code)
+    }
+    if code := do(http.MethodGet, "/teams/1", "", nil); code != http.StatusNotFound {
+        t.Errorf("get deleted team: %d", code)
+    }
+    if code := do(http.MethodDelete, "/teams/1", "", nil); code != http.StatusNotFound {
+        t.Errorf("delete again: %d", code)
+    }
+}
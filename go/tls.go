@@ -0,0 +1,115 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "log/slog"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var tlsCertificateExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+    Name: "tls_certificate_expiry_timestamp_seconds",
+    Help: "Time the TLS certificate being served expires, as a Unix timestamp",
+})
+
+func init() {
+    prometheus.MustRegister(tlsCertificateExpiry)
+}
+
+// With TLS_CERT_FILE and TLS_KEY_FILE set, the API is served over HTTPS
+// (TLS 1.2 and later) on every LISTEN_ADDR, for deployments without a
+// proxy to terminate TLS. The files are checked every TLS_RELOAD_INTERVAL
+// (default 1m; 0 turns this off) and when a reload changes their paths,
+// and a changed pair is used for new handshakes, so cert-manager rotations
+// take effect without a restart; connections already open keep theirs. A
+// pair that does not load, e.g. read halfway through a rotation, is logged
+// and the previous one kept.
+
+// tlsConfigured reports whether the API is served over HTTPS, with the
+// certificate in files or one from ACME (see acme.go).
+func tlsConfigured() bool {
+    return getenv("TLS_CERT_FILE") != "" || getenv("TLS_KEY_FILE") != "" || getenv("ACME_DOMAINS") != ""
+}
+
+// certStore holds the certificate being served.
+type certStore struct {
+    cert atomic.Pointer[tls.Certificate]
+
+    mu      sync.Mutex // serializes loads
+    version string
+}
+
+var serverCert certStore
+
+// setupTLS makes srv serve the configured certificate.
+func setupTLS(srv *http.Server) error {
+    if getenv("ACME_DOMAINS") != "" {
+        return setupAutocert(srv)
+    }
+    if getenv("TLS_CERT_FILE") == "" || getenv("TLS_KEY_FILE") == "" {
+        return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+    }
+    if err := serverCert.load(); err != nil {
+        return err
+    }
+    // configureHTTP2 has already added h2 to the protocols offered, unless
+    // HTTP/2 is off.
+    if srv.TLSConfig == nil {
+        srv.TLSConfig = &tls.Config{}
+    }
+    srv.TLSConfig.MinVersion = tls.VersionTLS12
+    srv.TLSConfig.GetCertificate = serverCert.get
+    onReload("TLS_CERT_FILE", serverCert.load)
+    onReload("TLS_KEY_FILE", serverCert.load)
+    if config.TLSReloadInterval > 0 {
+        go func() {
+            for range time.Tick(config.TLSReloadInterval) {
+                serverCert.reload()
+            }
+        }()
+    }
+    return nil
+}
+
+func (s *certStore) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    return s.cert.Load(), nil
+}
+
+// load reads the certificate and key, unless they are unchanged since the
+// last load.
+func (s *certStore) load() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    certFile, keyFile := getenv("TLS_CERT_FILE"), getenv("TLS_KEY_FILE")
+    version := filesVersion(certFile, keyFile)
+    if version == s.version {
+        return nil
+    }
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return err
+    }
+    if cert.Leaf == nil {
+        // Left out with GODEBUG=x509keypairleaf=0.
+        if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+            return err
+        }
+    }
+    s.cert.Store(&cert)
+    s.version = version
+    tlsCertificateExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+    slog.Info("Loaded TLS certificate", "file", certFile, "dns_names", cert.Leaf.DNSNames, "not_after", cert.Leaf.NotAfter)
+    return nil
+}
+
+func (s *certStore) reload() {
+    if err := s.load(); err != nil {
+        slog.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+    }
+}
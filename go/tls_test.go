@@ -0,0 +1,181 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io"
+    "math/big"
+    "os"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "slices"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// writeTestCert writes a self-signed certificate for name and its key.
+func writeTestCert(t *testing.T, certFile, keyFile, name string) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(time.Now().UnixNano()),
+        Subject:      pkix.Name{CommonName: name},
+        DNSNames:     []string{name},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCertReload(t *testing.T) {
+    dir := t.TempDir()
+    certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+    t.Setenv("TLS_CERT_FILE", certFile)
+    t.Setenv("TLS_KEY_FILE", keyFile)
+    writeTestCert(t, certFile, keyFile, "old.example")
+
+    var store certStore
+    if err := store.load(); err != nil {
+        t.Fatal(err)
+    }
+    ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: store.get})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            conn.(*tls.Conn).Handshake()
+            conn.Close()
+        }
+    }()
+    served := func() string {
+        conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+    }
+
+    if name := served(); name != "old.example" {
+        t.Fatalf("served %s", name)
+    }
+    // A rotation halfway through keeps the old pair.
+    os.WriteFile(keyFile, []byte("garbage"), 0o600)
+    store.reload()
+    if name := served(); name != "old.example" {
+        t.Fatalf("served %s after a broken rotation", name)
+    }
+    writeTestCert(t, certFile, keyFile, "new.example")
+    store.reload()
+    if name := served(); name != "new.example" {
+        t.Fatalf("served %s after the rotation", name)
+    }
+}
+
+
+func TestAutocertSetup(t *testing.T) {
+    defer func(old string) { config.ACMECacheDir = old }(config.ACMECacheDir)
+    config.ACMECacheDir = t.TempDir()
+    t.Setenv("ACME_DOMAINS", "api.example.com")
+    t.Setenv("TLS_CERT_FILE", "")
+    t.Setenv("TLS_KEY_FILE", "")
+    srv := newHTTPServer(http.NotFoundHandler())
+    if err := setupTLS(srv); err != nil {
+        t.Fatal(err)
+    }
+    if protos := srv.TLSConfig.NextProtos; !slices.Contains(protos, "acme-tls/1") || !slices.Contains(protos, "h2") {
+        t.Errorf("offering %v", protos)
+    }
+    // Names outside ACME_DOMAINS are refused without asking the CA.
+    if _, err := srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+        t.Error("certificate requested for a name outside ACME_DOMAINS")
+    }
+
+    t.Setenv("TLS_CERT_FILE", "/etc/tls.crt")
+    if err := setupTLS(newHTTPServer(http.NotFoundHandler())); err == nil {
+        t.Error("ACME_DOMAINS accepted along with TLS_CERT_FILE")
+    }
+}
+
+func TestClientCertAuth(t *testing.T) {
+    dir := t.TempDir()
+    certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+    writeTestCert(t, certFile, keyFile, "billing")
+    clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer func(file, mode string) { config.TLSClientCAFile, config.TLSClientAuth = file, mode }(config.TLSClientCAFile, config.TLSClientAuth)
+    config.TLSClientCAFile, config.TLSClientAuth = certFile, clientAuthMutations
+    srv := &http.Server{TLSConfig: &tls.Config{}}
+    if err := setupClientAuth(srv, true); err != nil {
+        t.Fatal(err)
+    }
+
+    r := mux.NewRouter()
+    r.Use(clientCertMiddleware)
+    r.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+        id, _ := clientIdentityFromContext(r.Context())
+        w.Write([]byte(id.Subject))
+    }).Methods("GET", "POST")
+    ts := httptest.NewUnstartedServer(r)
+    ts.TLS = srv.TLSConfig
+    ts.StartTLS()
+    defer ts.Close()
+
+    call := func(method string, cert bool) (int, string) {
+        client := ts.Client()
+        if cert {
+            client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+            defer func() { client.Transport.(*http.Transport).TLSClientConfig.Certificates = nil }()
+        }
+        client.Transport.(*http.Transport).CloseIdleConnections()
+        req, _ := http.NewRequest(method, ts.URL+"/users", nil)
+        resp, err := client.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer resp.Body.Close()
+        body, _ := io.ReadAll(resp.Body)
+        return resp.StatusCode, string(body)
+    }
+    if code, _ := call(http.MethodGet, false); code != http.StatusOK {
+        t.Errorf("GET without a certificate: %d", code)
+    }
+    if code, _ := call(http.MethodPost, false); code != http.StatusUnauthorized {
+        t.Errorf("POST without a certificate: %d, want 401", code)
+    }
+    if code, subject := call(http.MethodPost, true); code != http.StatusOK || subject != "billing" {
+        t.Errorf("POST with a certificate: %d, subject %q", code, subject)
+    }
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "strings"
+    "time"
+)
+
+var (
+    errInvalidToken = errors.New("invalid token")
+    errExpiredToken = errors.New("token expired")
+)
+
+// ACCESS_TOKEN_TTL (default 15m) is the lifetime of tokens issued by
+// /login.
+
+// tokenSecret signs access tokens. Without TOKEN_SECRET a random key is
+// generated, which invalidates every token when the container restarts.
+var tokenSecret []byte
+
+func loadTokenSecret() []byte {
+    if secret := getenv("TOKEN_SECRET"); secret != "" {
+        return []byte(secret)
+    }
+    secret, err := randomToken(32)
+    if err != nil {
+        fatal("Failed to generate token secret", "error", err)
+    }
+    slog.Warn("TOKEN_SECRET not set, using a random key; tokens will not survive restarts")
+    return []byte(secret)
+}
+
+// TokenClaims is the payload of an access token.
+type TokenClaims struct {
+    Subject   string   `json:"sub"`
+    Email     string   `json:"email,omitempty"`
+    Roles     []string `json:"roles,omitempty"`
+    IssuedAt  int64    `json:"iat"`
+    ExpiresAt int64    `json:"exp"`
+}
+
+// claims returns c as the claims handlers get from claimsFromContext.
+func (c TokenClaims) claims() Claims {
+    data, _ := json.Marshal(c)
+    var claims Claims
+    json.Unmarshal(data, &claims)
+    return claims
+}
+
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// signToken encodes claims as an HS256 JWT.
+func signToken(claims TokenClaims) (string, error) {
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+    unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+    return unsigned + "." + tokenSignature(unsigned), nil
+}
+
+// parseToken verifies the signature and expiry of an HS256 JWT.
+func parseToken(token string) (TokenClaims, error) {
+    var claims TokenClaims
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 || parts[0] != tokenHeader {
+        return claims, errInvalidToken
+    }
+    expected := tokenSignature(parts[0] + "." + parts[1])
+    if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+        return claims, errInvalidToken
+    }
+    payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil {
+        return claims, errInvalidToken
+    }
+    if err := json.Unmarshal(payload, &claims); err != nil {
+        return claims, errInvalidToken
+    }
+    if time.Now().Unix() >= claims.ExpiresAt {
+        return claims, errExpiredToken
+    }
+    return claims, nil
+}
+
+func tokenSignature(unsigned string) string {
+    mac := hmac.New(sha256.New, tokenSecret)
+    mac.Write([]byte(unsigned))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the API. It goes through the global provider,
+// so it records nothing until setupTracing installs an exporting one.
+var tracer = otel.Tracer("user-api")
+
+// tracingEnabled is set by setupTracing when spans are exported; storage
+// calls are only wrapped in spans then.
+var tracingEnabled bool
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. Everything else comes from
+// the standard OTEL_* variables: OTEL_EXPORTER_OTLP_HEADERS for credentials,
+// OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG for sampling,
+// OTEL_SERVICE_NAME (default user-api) and OTEL_RESOURCE_ATTRIBUTES.
+// W3C trace context and baggage are propagated either way, so a request
+// passing through keeps its trace. The returned function flushes the spans
+// still buffered.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+    otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+    endpoint := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+    if endpoint == "" {
+        endpoint = getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+    }
+    if endpoint == "" || strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+        return func(context.Context) error { return nil }, nil
+    }
+    exporter, err := otlptracehttp.New(ctx)
+    if err != nil {
+        return nil, err
+    }
+    res, err := otelResource(ctx)
+    if err != nil {
+        return nil, err
+    }
+    provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+    otel.SetTracerProvider(provider)
+    otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+        slog.Warn("Tracing error", "error", err)
+    }))
+    tracingEnabled = true
+    slog.Info("Exporting traces", "endpoint", endpoint)
+    return provider.Shutdown, nil
+}
+
+// otelResource describes this process to OTLP backends. Attributes from the
+// environment are detected last and so win over the default service name.
+func otelResource(ctx context.Context) (*resource.Resource, error) {
+    res, err := resource.New(ctx,
+        resource.WithAttributes(semconv.ServiceName("user-api")),
+        resource.WithTelemetrySDK(),
+        resource.WithHost(),
+        resource.WithFromEnv(),
+    )
+    if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+        return nil, err
+    }
+    return res, nil
+}
+
+// tracingMiddleware starts a server span for each request, continuing the
+// trace of the caller if it sent a traceparent header. The span is named
+// after the route template rather than the path so that requests for
+// different users group together.
+func tracingMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+        route := routeTemplate(r)
+        ctx, span := tracer.Start(ctx, r.Method+" "+route,
+            trace.WithSpanKind(trace.SpanKindServer),
+            trace.WithAttributes(
+                semconv.HTTPRequestMethodKey.String(r.Method),
+                semconv.HTTPRoute(route),
+                semconv.URLPath(r.URL.Path),
+                semconv.ClientAddress(remoteHost(r.RemoteAddr)),
+                semconv.UserAgentOriginal(r.UserAgent()),
+            ),
+        )
+        defer span.End()
+
+        rec := getStatusRecorder(w)
+        defer putStatusRecorder(rec)
+        next.ServeHTTP(rec, r.WithContext(ctx))
+        span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+        if rec.status >= 500 {
+            span.SetStatus(codes.Error, http.StatusText(rec.status))
+        }
+    })
+}
+
+// traceExemplar returns the trace ID of ctx as exemplar labels, or nil when
+// the request is not sampled and so has no trace to link to.
+func traceExemplar(ctx context.Context) prometheus.Labels {
+    sc := trace.SpanContextFromContext(ctx)
+    if !sc.IsSampled() {
+        return nil
+    }
+    return prometheus.Labels{"trace_id": sc.TraceID().String()}
+}
+
+// tracingRepository adds a client span for each storage call. Calls the
+// Redis cache answers never reach it, so the spans show what the database
+// itself was asked.
+type tracingRepository struct {
+    UserRepository
+    system string
+}
+
+func newTracingRepository(repo UserRepository, backend string) UserRepository {
+    if !tracingEnabled {
+        return repo
+    }
+    system := backend
+    switch backend {
+    case "postgres":
+        system = "postgresql"
+    case "mongo":
+        system = "mongodb"
+    }
+    return &tracingRepository{UserRepository: repo, system: system}
+}
+
+// Unwrap returns the repository whose calls are traced.
+func (t *tracingRepository) Unwrap() UserRepository {
+    return t.UserRepository
+}
+
+func (t *tracingRepository) List(ctx context.Context) (users []User, err error) {
+    ctx, span := t.start(ctx, "list")
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.List(ctx)
+}
+
+func (t *tracingRepository) Get(ctx context.Context, id int) (user User, err error) {
+    ctx, span := t.start(ctx, "get", attribute.Int("user.id", id))
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.Get(ctx, id)
+}
+
+func (t *tracingRepository) GetByEmail(ctx context.Context, email string) (user User, err error) {
+    ctx, span := t.start(ctx, "get_by_email")
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.GetByEmail(ctx, email)
+}
+
+func (t *tracingRepository) Insert(ctx context.Context, user User) (inserted User, err error) {
+    ctx, span := t.start(ctx, "insert")
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.Insert(ctx, user)
+}
+
+func (t *tracingRepository) Update(ctx context.Context, user User) (updated User, err error) {
+    ctx, span := t.start(ctx, "update", attribute.Int("user.id", user.ID))
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.Update(ctx, user)
+}
+
+func (t *tracingRepository) Delete(ctx context.Context, id int) (err error) {
+    ctx, span := t.start(ctx, "delete", attribute.Int("user.id", id))
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.Delete(ctx, id)
+}
+
+func (t *tracingRepository) Stats(ctx context.Context, days, top int, now time.Time) (stats UserStats, err error) {
+    ctx, span := t.start(ctx, "stats")
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.Stats(ctx, days, top, now)
+}
+
+// WithTx spans the whole transaction. The calls fn makes use the context it
+// was given, so their spans are siblings of the transaction's rather than
+// children.
+func (t *tracingRepository) WithTx(ctx context.Context, fn func(tx UserRepository) error) (err error) {
+    ctx, span := t.start(ctx, "tx")
+    defer func() { endSpan(span, err) }()
+    return t.UserRepository.WithTx(ctx, func(tx UserRepository) error {
+        return fn(&tracingRepository{UserRepository: tx, system: t.system})
+    })
+}
+
+func (t *tracingRepository) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+    attrs = append(attrs, semconv.DBSystemNameKey.String(t.system), semconv.DBOperationName(operation))
+    return tracer.Start(ctx, "storage."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a storage span, marking it failed unless the error is one the
+// API expects, such as a missing user, a version conflict or a taken email.
+func endSpan(span trace.Span, err error) {
+    var constraint *ConstraintError
+    if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrVersionConflict) && !errors.As(err, &constraint) {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
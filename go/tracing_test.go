@@ -0,0 +1,138 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+    "go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+    spans := tracetest.NewSpanRecorder()
+    defer func(old bool) { tracingEnabled = old }(tracingEnabled)
+    otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+    defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    tracingEnabled = true
+
+    var logged bytes.Buffer
+    defer func(old *slog.Logger) { slog.SetDefault(old) }(slog.Default())
+    slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(&logged, nil)}))
+
+    repo := newTracingRepository(&memoryUserRepository{nextID: 1}, "memory")
+    r := mux.NewRouter()
+    r.Use(tracingMiddleware)
+    r.HandleFunc("/users/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+        slog.InfoContext(r.Context(), "handling")
+        _, err := repo.Get(r.Context(), 42)
+        writeError(w, r, err)
+    })
+
+    req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+    req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+    r.ServeHTTP(httptest.NewRecorder(), req)
+
+    ended := spans.Ended()
+    if len(ended) != 2 {
+        t.Fatalf("got %d spans, want the storage and server spans", len(ended))
+    }
+    storage, server := ended[0], ended[1]
+    if server.Name() != "GET /users/{id:[0-9]+}" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+        t.Fatalf("server span %q with parent %s", server.Name(), server.Parent().SpanID())
+    }
+    if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+        t.Fatalf("server span not in the caller's trace: %s", server.SpanContext().TraceID())
+    }
+    if storage.Name() != "storage.get" || storage.Parent().SpanID() != server.SpanContext().SpanID() {
+        t.Fatalf("storage span %q not a child of the server span", storage.Name())
+    }
+    if storage.Status().Code == codes.Error || server.Status().Code == codes.Error {
+        t.Fatal("a 404 marked the spans failed")
+    }
+    var status int64
+    for _, attr := range server.Attributes() {
+        if attr.Key == "http.response.status_code" {
+            status = attr.Value.AsInt64()
+        }
+    }
+    if status != http.StatusNotFound {
+        t.Fatalf("status attribute %d, want 404", status)
+    }
+
+    var entry map[string]interface{}
+    json.Unmarshal(logged.Bytes(), &entry)
+    if entry["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || entry["span_id"] != server.SpanContext().SpanID().String() {
+        t.Fatalf("log entry without the trace: %v", entry)
+    }
+}
+
+func TestTraceExemplar(t *testing.T) {
+    traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+    spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+    r := mux.NewRouter()
+    r.Use(metricsMiddleware)
+    r.HandleFunc("/exemplar", func(w http.ResponseWriter, r *http.Request) {})
+    req := httptest.NewRequest(http.MethodGet, "/exemplar", nil)
+    sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+    r.ServeHTTP(httptest.NewRecorder(), req.WithContext(trace.ContextWithSpanContext(req.Context(), sc)))
+
+    scrape := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+    scrape.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+    w := httptest.NewRecorder()
+    metricsHandler().ServeHTTP(w, scrape)
+    want := `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`
+    for _, line := range strings.Split(w.Body.String(), "\n") {
+        if strings.HasPrefix(line, "http_request_duration_seconds_bucket") && strings.Contains(line, `endpoint="/exemplar"`) && strings.Contains(line, want) {
+            return
+        }
+    }
+    t.Fatalf("no bucket of /exemplar has the exemplar %s", want)
+}
+
+func TestOutboundPropagation(t *testing.T) {
+    defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    headers := make(chan http.Header, 1)
+    target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        headers <- r.Header
+        w.WriteHeader(http.StatusAccepted)
+    }))
+    defer target.Close()
+
+    traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+    spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+    sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+    ctx := trace.ContextWithSpanContext(context.WithValue(context.Background(), requestIDKey, "req-9"), sc)
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, target.URL+"/hook?token=secret", nil)
+    resp, err := newHTTPClient(time.Second).Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+
+    h := <-headers
+    if h.Get(requestIDHeader) != "req-9" || !strings.Contains(h.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736") {
+        t.Fatalf("outbound headers %v", h)
+    }
+    if req.Header.Get("traceparent") != "" {
+        t.Error("the caller's request was modified")
+    }
+    host := strings.TrimPrefix(target.URL, "http://")
+    if n := testutil.ToFloat64(httpClientRequestsTotal.WithLabelValues(host, "POST", "202")); n != 1 {
+        t.Errorf("counted %v outbound requests, want 1", n)
+    }
+}
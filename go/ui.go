@@ -0,0 +1,20 @@
+package main
+
+import (
+    "embed"
+    "io/fs"
+    "net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the embedded dashboard under /ui/. It only talks to the
+// public JSON endpoints, so it needs no extra server-side support.
+func uiHandler() http.Handler {
+    sub, err := fs.Sub(uiFiles, "ui")
+    if err != nil {
+        panic(err)
+    }
+    return http.StripPrefix("/ui/", http.FileServer(http.FS(sub)))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// A LISTEN_ADDR entry of unix:/path serves on a unix socket instead of a
+// TCP port, e.g. for a sidecar proxy sharing a volume with the container.
+// The socket gets the permissions of UNIX_SOCKET_MODE (default 0660), so
+// only the owner and group can connect, and whoever can connect is trusted
+// like TRUSTED_PROXIES to forward the client address. A socket left behind
+// by a process that did not remove it is replaced; one that still accepts
+// connections is an error. The socket is kept when the listener closes, so
+// that it survives handing it over on a restart.
+//
+// Under systemd socket activation, the sockets of the socket unit, passed
+// as LISTEN_FDS, are served instead of LISTEN_ADDR.
+const unixPrefix = "unix:"
+
+func isUnixAddr(addr string) bool {
+    return strings.HasPrefix(addr, unixPrefix)
+}
+
+// listenAddr opens a listener on a LISTEN_ADDR entry.
+func listenAddr(addr string) (net.Listener, error) {
+    if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+        return listenUnix(path)
+    }
+    return listenTCP(addr)
+}
+
+func listenUnix(path string) (net.Listener, error) {
+    if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+        if c, err := net.Dial("unix", path); err == nil {
+            c.Close()
+            return nil, fmt.Errorf("%s is in use by another process", path)
+        }
+        if err := os.Remove(path); err != nil {
+            return nil, err
+        }
+    }
+    ln, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    ln.(*net.UnixListener).SetUnlinkOnClose(false)
+    if err := os.Chmod(path, config.UnixSocketMode); err != nil {
+        ln.Close()
+        return nil, err
+    }
+    return ln, nil
+}
+
+// viaUnixSocket reports whether r arrived on a unix socket.
+func viaUnixSocket(r *http.Request) bool {
+    addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+    return ok && addr.Network() == "unix"
+}
+
+// activatedListeners returns the sockets systemd passed to this process,
+// or none when it was not socket activated.
+func activatedListeners() ([]net.Listener, error) {
+    pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+    if fds == "" || pid != strconv.Itoa(os.Getpid()) {
+        return nil, nil
+    }
+    // Child processes, such as the one taking over on a restart, must not
+    // take the sockets for theirs.
+    os.Unsetenv("LISTEN_PID")
+    os.Unsetenv("LISTEN_FDS")
+    os.Unsetenv("LISTEN_FDNAMES")
+    n, err := strconv.Atoi(fds)
+    if err != nil || n < 1 {
+        return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+    }
+    var lns []net.Listener
+    for fd := 3; fd < 3+n; fd++ {
+        f := os.NewFile(uintptr(fd), "activated")
+        ln, err := net.FileListener(f)
+        f.Close()
+        if err != nil {
+            for _, ln := range lns {
+                ln.Close()
+            }
+            return nil, fmt.Errorf("socket activation: %w", err)
+        }
+        slog.Info("Serving socket passed by systemd", "addr", ln.Addr().String())
+        lns = append(lns, ln)
+    }
+    return lns, nil
+}
+
+// localDial returns a dial function that reaches the listener on addr from
+// the same host when that is a unix socket, nil for TCP.
+func localDial(addr string) func(ctx context.Context, network, address string) (net.Conn, error) {
+    path, ok := strings.CutPrefix(addr, unixPrefix)
+    if !ok {
+        return nil
+    }
+    return func(ctx context.Context, network, address string) (net.Conn, error) {
+        var d net.Dialer
+        return d.DialContext(ctx, "unix", path)
+    }
+}
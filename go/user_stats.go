@@ -0,0 +1,84 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// UserStats aggregates the user table for dashboards.
+type UserStats struct {
+    Total         int            `json:"total"`
+    Verified      int            `json:"verified"`
+    ByRole        map[string]int `json:"by_role"`
+    SignupsPerDay []DailyCount   `json:"signups_per_day"`
+    Domains       []DomainCount  `json:"domains"`
+}
+
+// newUserStats returns empty stats with one zero SignupsPerDay entry for
+// each of the last days days, oldest first.
+func newUserStats(days int, now time.Time) UserStats {
+    stats := UserStats{
+        ByRole:        make(map[string]int),
+        SignupsPerDay: make([]DailyCount, days),
+        Domains:       []DomainCount{},
+    }
+    first := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+    for i := range stats.SignupsPerDay {
+        stats.SignupsPerDay[i].Date = first.AddDate(0, 0, i).Format("2006-01-02")
+    }
+    return stats
+}
+
+// firstDay returns the start of the first day in SignupsPerDay.
+func (s UserStats) firstDay() time.Time {
+    first, _ := time.Parse("2006-01-02", s.SignupsPerDay[0].Date)
+    return first
+}
+
+type DailyCount struct {
+    Date  string `json:"date"`
+    Count int    `json:"count"`
+}
+
+type DomainCount struct {
+    Domain string `json:"domain"`
+    Count  int    `json:"count"`
+}
+
+// userStatsHandler serves GET /users/stats. ?days= sets the signup window
+// (default 30, at most 365) and ?top= the number of domains (default 10).
+func userStatsHandler(w http.ResponseWriter, r *http.Request) {
+    days, err := boundedIntParam(r, "days", 30, 365)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    top, err := boundedIntParam(r, "top", 10, 100)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    stats, err := userRepo.Stats(r.Context(), days, top, time.Now())
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    writeJSON(w, r, http.StatusOK, APIResponse{
+        Status: "success",
+        Data:   stats,
+    })
+}
+
+// boundedIntParam reads an optional query parameter between 1 and max.
+func boundedIntParam(r *http.Request, name string, def, max int) (int, error) {
+    v := r.URL.Query().Get(name)
+    if v == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 1 || n > max {
+        return 0, httpError(http.StatusBadRequest, name+" must be between 1 and "+strconv.Itoa(max))
+    }
+    return n, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestNewUserStats(t *testing.T) {
+    now := time.Date(2024, 3, 1, 15, 4, 5, 0, time.UTC)
+    stats := newUserStats(3, now)
+    var dates []string
+    for _, d := range stats.SignupsPerDay {
+        dates = append(dates, d.Date)
+    }
+    if len(dates) != 3 || dates[0] != "2024-02-28" || dates[2] != "2024-03-01" {
+        t.Errorf("dates %v", dates)
+    }
+    if !stats.firstDay().Equal(time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC)) {
+        t.Errorf("first day %v", stats.firstDay())
+    }
+}
+
+func TestUserStatsHandler(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    now := time.Now().UTC()
+    today := now.Truncate(24 * time.Hour)
+    userRepo = &memoryUserRepository{users: []User{
+        {ID: 1, Email: "ada@example.com", CreatedAt: now},
+        {ID: 2, Email: "bob@example.com", CreatedAt: today.Add(-time.Nanosecond)},
+        {ID: 3, Email: "cy@example.org", CreatedAt: today.AddDate(0, 0, -1)},
+        // Just outside a two-day window.
+        {ID: 4, Email: "dee@example.net", CreatedAt: today.AddDate(0, 0, -1).Add(-time.Nanosecond)},
+    }}
+    get := func(target string) (*httptest.ResponseRecorder, UserStats) {
+        rec := httptest.NewRecorder()
+        userStatsHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+        var stats UserStats
+        json.Unmarshal(rec.Body.Bytes(), &APIResponse{Data: &stats})
+        return rec, stats
+    }
+
+    rec, stats := get("/users/stats?days=2&top=2")
+    if rec.Code != http.StatusOK {
+        t.Fatalf("%d %s", rec.Code, rec.Body)
+    }
+    if stats.Total != 4 || len(stats.SignupsPerDay) != 2 || stats.SignupsPerDay[0].Count != 2 || stats.SignupsPerDay[1].Count != 1 {
+        t.Errorf("signups %+v of %d", stats.SignupsPerDay, stats.Total)
+    }
+    if len(stats.Domains) != 2 || stats.Domains[0] != (DomainCount{Domain: "example.com", Count: 2}) || stats.Domains[1].Domain != "example.net" {
+        t.Errorf("domains %+v", stats.Domains)
+    }
+
+    if _, stats := get("/users/stats"); len(stats.SignupsPerDay) != 30 || len(stats.Domains) != 3 {
+        t.Errorf("defaults: %d days, %d domains", len(stats.SignupsPerDay), len(stats.Domains))
+    }
+    for _, target := range []string{"/users/stats?days=0", "/users/stats?days=366", "/users/stats?top=x", "/users/stats?top=101"} {
+        if rec, _ := get(target); rec.Code != http.StatusBadRequest {
+            t.Errorf("%s: %d", target, rec.Code)
+        }
+    }
+}
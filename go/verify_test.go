@@ -0,0 +1,70 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "regexp"
+    "strconv"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+func TestEmailVerification(t *testing.T) {
+    defer func(old UserRepository) { userRepo = old }(userRepo)
+    repo := &memoryUserRepository{nextID: 1}
+    userRepo = repo
+    user, err := repo.Insert(context.Background(), User{Name: "Ada", Email: "ada@example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var logged bytes.Buffer
+    defer func(old *slog.Logger) { slog.SetDefault(old) }(slog.Default())
+    slog.SetDefault(slog.New(slog.NewJSONHandler(&logged, nil)))
+
+    r := mux.NewRouter()
+    r.HandleFunc("/users/{id:[0-9]+}/verify/send", sendVerificationHandler).Methods("POST")
+    r.HandleFunc("/verify", verifyEmailHandler).Methods("GET")
+    do := func(method, path string) *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        r.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+        return rec
+    }
+
+    if rec := do(http.MethodPost, "/users/"+strconv.Itoa(user.ID)+"/verify/send"); rec.Code != http.StatusAccepted {
+        t.Fatalf("send: %d %s", rec.Code, rec.Body)
+    }
+    m := regexp.MustCompile(`token=([0-9a-f]+)`).FindStringSubmatch(logged.String())
+    if m == nil {
+        t.Fatalf("no verification link logged: %s", logged.String())
+    }
+    token := m[1]
+    if _, ok := verifications.tokens[token]; ok {
+        t.Fatal("token stored in the clear")
+    }
+
+    if rec := do(http.MethodGet, "/verify?token=0123abcd"); rec.Code != http.StatusBadRequest {
+        t.Errorf("unknown token: %d %s", rec.Code, rec.Body)
+    }
+    if rec := do(http.MethodGet, "/verify?token="+token); rec.Code != http.StatusOK {
+        t.Fatalf("verify: %d %s", rec.Code, rec.Body)
+    }
+    if got, _ := repo.Get(context.Background(), user.ID); !got.Verified {
+        t.Error("user not verified")
+    }
+    if rec := do(http.MethodGet, "/verify?token="+token); rec.Code != http.StatusBadRequest {
+        t.Errorf("reused token: %d %s", rec.Code, rec.Body)
+    }
+
+    expired, _, err := verifications.issue(user.ID, -time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rec := do(http.MethodGet, "/verify?token="+expired); rec.Code != http.StatusBadRequest {
+        t.Errorf("expired token: %d %s", rec.Code, rec.Body)
+    }
+}